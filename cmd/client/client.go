@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"flag"
@@ -36,19 +37,38 @@ type AuthResponse struct {
 }
 
 type InitResponse struct {
-	Status         string `json:"status"`
-	ContentID      uint   `json:"content_id"`
-	UploadedChunks []int  `json:"uploaded_chunks"`
-	Error          string `json:"error"`
+	Status         string     `json:"status"`
+	ContentID      uint       `json:"content_id"`
+	UploadedChunks []int      `json:"uploaded_chunks"`
+	Challenge      *Challenge `json:"challenge"`
+	SessionID      string     `json:"session_id"`
+	ChunkSize      int64      `json:"chunk_size"`
+	TotalChunks    int        `json:"total_chunks"`
+	Error          string     `json:"error"`
+}
+
+type Challenge struct {
+	ChallengeID string `json:"challenge_id"`
+	Ranges      []struct {
+		Start int64 `json:"start"`
+		End   int64 `json:"end"`
+	} `json:"ranges"`
 }
 
 type FileInfo struct {
-	ID        uint   `json:"id"`
-	FileName  string `json:"file_name"`
-	FileHash  string `json:"file_hash"`
-	FileSize  int64  `json:"file_size"`
-	Status    int    `json:"status"`
-	CreatedAt string `json:"created_at"`
+	ID         uint   `json:"id"`
+	FileName   string `json:"file_name"`
+	FileHash   string `json:"file_hash"`
+	FileSize   int64  `json:"file_size"`
+	Format     string `json:"format"`
+	VideoCodec string `json:"video_codec"`
+	AudioCodec string `json:"audio_codec"`
+	Bitrate    int64  `json:"bitrate"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Duration   int    `json:"duration"`
+	Status     int    `json:"status"`
+	CreatedAt  string `json:"created_at"`
 }
 
 type ListResponse struct {
@@ -191,22 +211,21 @@ func cmdUpload(filePath string) {
 		return
 	}
 
-	fmt.Println("正在计算文件 MD5...")
+	fmt.Println("正在计算文件 SHA-256...")
 	fileContent, err := os.ReadFile(filePath)
 	if err != nil {
 		fmt.Printf("读取文件失败: %v\n", err)
 		return
 	}
 
-	hasher := md5.New()
-	hasher.Write(fileContent)
-	fileHash := hex.EncodeToString(hasher.Sum(nil))
+	sum := sha256.Sum256(fileContent)
+	fileHash := "sha256:" + hex.EncodeToString(sum[:])
 	fileName := filepath.Base(filePath)
 	fileSize := int64(len(fileContent))
 
 	fmt.Printf("文件: %s\n", fileName)
 	fmt.Printf("大小: %s\n", formatSize(fileSize))
-	fmt.Printf("MD5:  %s\n", fileHash)
+	fmt.Printf("Hash: %s\n", fileHash)
 
 	initResp, err := initUpload(fileHash, fileName, fileSize)
 	fmt.Println("初始化上传...",initResp)
@@ -220,6 +239,15 @@ func cmdUpload(filePath string) {
 		return
 	}
 
+	if initResp.Status == "fast_upload_challenge" && initResp.Challenge != nil {
+		if err := fastUpload(fileHash, fileName, initResp.ContentID, initResp.Challenge, fileContent); err == nil {
+			fmt.Printf("✅ 秒传成功！ContentID: %d\n", initResp.ContentID)
+			return
+		} else {
+			fmt.Printf("秒传校验未通过（%v），改为普通上传\n", err)
+		}
+	}
+
 	file, err := os.Open(filePath)
 	if err != nil {
 		fmt.Printf("打开文件失败: %v\n", err)
@@ -228,7 +256,16 @@ func cmdUpload(filePath string) {
 	defer file.Close()
 
 	fi, _ := file.Stat()
-	totalChunks := int(fi.Size()+ChunkSize-1) / ChunkSize
+	// 分片大小与总数以服务端会话为准
+	chunkSize := initResp.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = ChunkSize
+	}
+	totalChunks := initResp.TotalChunks
+	if totalChunks <= 0 {
+		totalChunks = int((fi.Size() + chunkSize - 1) / chunkSize)
+	}
+	sessionID := initResp.SessionID
 
 	uploadedSet := make(map[int]bool)
 	for _, idx := range initResp.UploadedChunks {
@@ -247,7 +284,7 @@ func cmdUpload(filePath string) {
 
 	if needUpload == 0 {
 		fmt.Println("所有分片已上传，请求合并...")
-		if mergeChunks(fileHash, initResp.ContentID, totalChunks, fileName, fi.Size()) {
+		if mergeChunks(sessionID, fileHash, initResp.ContentID, totalChunks, fileName, fi.Size()) {
 			fmt.Println("🎉 上传成功！")
 		}
 		return
@@ -269,8 +306,8 @@ func cmdUpload(filePath string) {
 			semaphore <- struct{}{}
 			defer func() { <-semaphore }()
 
-			partBuffer := make([]byte, ChunkSize)
-			n, err := file.ReadAt(partBuffer, int64(idx)*int64(ChunkSize))
+			partBuffer := make([]byte, chunkSize)
+			n, err := file.ReadAt(partBuffer, int64(idx)*chunkSize)
 			if n <= 0 {
 				if err != nil && err != io.EOF {
 					fmt.Printf("\n读取分片 %d 失败: %v\n", idx, err)
@@ -280,7 +317,7 @@ func cmdUpload(filePath string) {
 
 			success := false
 			for retry := 0; retry < 3; retry++ {
-				if uploadChunk(fileHash, initResp.ContentID, idx, totalChunks, partBuffer[:n]) {
+				if uploadChunk(sessionID, fileHash, initResp.ContentID, idx, totalChunks, partBuffer[:n]) {
 					success = true
 					break
 				}
@@ -304,7 +341,7 @@ func cmdUpload(filePath string) {
 	}
 
 	fmt.Println("请求合并分片...")
-	if mergeChunks(fileHash, initResp.ContentID, totalChunks, fileName, fi.Size()) {
+	if mergeChunks(sessionID, fileHash, initResp.ContentID, totalChunks, fileName, fi.Size()) {
 		fmt.Println("🎉 上传成功！")
 	}
 }
@@ -438,6 +475,11 @@ func cmdInfo(fileHash string) {
 	json.NewDecoder(resp.Body).Decode(&result)
 	fmt.Printf("\n文件信息:\n  ID: %d\n  文件名: %s\n  Hash: %s\n  大小: %s\n  创建时间: %s\n",
 		result.ID, result.FileName, result.FileHash, formatSize(result.FileSize), result.CreatedAt)
+	if result.Format != "" {
+		fmt.Printf("  格式: %s\n  视频: %s %dx%d\n  音频: %s\n  时长: %ds\n  码率: %d kbps\n",
+			result.Format, result.VideoCodec, result.Width, result.Height,
+			result.AudioCodec, result.Duration, result.Bitrate/1000)
+	}
 }
 
 func register(user, pass string) error {
@@ -499,9 +541,43 @@ func initUpload(fileHash, fileName string, fileSize int64) (*InitResponse, error
 	return &result, nil
 }
 
-func uploadChunk(fileHash string, contentID uint, index, totalChunks int, data []byte) bool {
+// fastUpload 回答服务器的持有性证明挑战：对每个区间计算 MD5
+func fastUpload(fileHash, fileName string, contentID uint, ch *Challenge, content []byte) error {
+	proofs := make([]string, 0, len(ch.Ranges))
+	for _, r := range ch.Ranges {
+		if r.Start < 0 || r.End >= int64(len(content)) || r.Start > r.End {
+			return fmt.Errorf("挑战区间越界")
+		}
+		sum := md5.Sum(content[r.Start : r.End+1])
+		proofs = append(proofs, hex.EncodeToString(sum[:]))
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"content_id": contentID, "file_name": fileName, "file_hash": fileHash,
+		"challenge_id": ch.ChallengeID, "proofs": proofs,
+	})
+	req, _ := authRequest("POST", ServerURL+"/upload/fast", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s", string(data))
+	}
+	return nil
+}
+
+func uploadChunk(sessionID, fileHash string, contentID uint, index, totalChunks int, data []byte) bool {
+	if sessionID != "" {
+		return uploadChunkRaw(sessionID, index, data)
+	}
+
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
+	writer.WriteField("session_id", sessionID)
 	writer.WriteField("file_hash", fileHash)
 	writer.WriteField("content_id", strconv.Itoa(int(contentID)))
 	writer.WriteField("chunk_index", strconv.Itoa(index))
@@ -510,8 +586,25 @@ func uploadChunk(fileHash string, contentID uint, index, totalChunks int, data [
 	io.Copy(part, bytes.NewReader(data))
 	writer.Close()
 
+	sum := md5.Sum(data)
 	req, _ := authRequest("POST", ServerURL+"/upload/chunk", body)
 	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-Chunk-Checksum", "md5:"+hex.EncodeToString(sum[:]))
+	resp, err := (&http.Client{Timeout: 60 * time.Second}).Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// uploadChunkRaw 以原始请求体上传分片（PUT /upload/chunk/{index}）
+func uploadChunkRaw(sessionID string, index int, data []byte) bool {
+	sum := md5.Sum(data)
+	req, _ := authRequest("PUT", fmt.Sprintf("%s/upload/chunk/%d", ServerURL, index), bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Upload-Session", sessionID)
+	req.Header.Set("X-Chunk-Checksum", "md5:"+hex.EncodeToString(sum[:]))
 	resp, err := (&http.Client{Timeout: 60 * time.Second}).Do(req)
 	if err != nil {
 		return false
@@ -520,9 +613,9 @@ func uploadChunk(fileHash string, contentID uint, index, totalChunks int, data [
 	return resp.StatusCode == http.StatusOK
 }
 
-func mergeChunks(fileHash string, contentID uint, totalChunks int, fileName string, fileSize int64) bool {
+func mergeChunks(sessionID, fileHash string, contentID uint, totalChunks int, fileName string, fileSize int64) bool {
 	body, _ := json.Marshal(map[string]interface{}{
-		"file_hash": fileHash, "content_id": contentID,
+		"session_id": sessionID, "file_hash": fileHash, "content_id": contentID,
 		"total_chunks": totalChunks, "file_name": fileName, "file_size": fileSize,
 	})
 	req, _ := authRequest("POST", ServerURL+"/upload/merge", bytes.NewReader(body))
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"video-platform/internal/logic"
+)
+
+// runCommand 执行维护命令，返回进程退出码
+func runCommand(args []string) int {
+	switch args[0] {
+	case "fsck":
+		return runFsck(args[1:])
+	case "rotate-keys":
+		return runRotateKeys(args[1:])
+	case "migrate-layout":
+		return runMigrateLayout(args[1:])
+	case "repair-replicas":
+		return runRepairReplicas(args[1:])
+	case "backfill-hashes":
+		return runBackfillHashes(args[1:])
+	case "dedup-stats":
+		return runDedupStats(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\nusage: server [fsck [-repair] [-timeout 30m] | rotate-keys [-timeout 1h] | "+
+			"migrate-layout [-dry-run] [-timeout 6h] | repair-replicas [-verify] [-timeout 24h] | "+
+			"backfill-hashes [-dry-run] [-rate 0] [-timeout 24h] | dedup-stats [-timeout 10m]]\n", args[0])
+		return 2
+	}
+}
+
+// runFsck 核对引用计数、存储文件与墓碑，以 JSON 输出报告。
+// 退出码：0 无问题或已全部修复，1 有未修复的问题，2 执行出错
+func runFsck(args []string) int {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	repair := fs.Bool("repair", false, "repair inconsistencies that can be fixed automatically")
+	timeout := fs.Duration("timeout", 30*time.Minute, "abort after this duration")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	report, err := logic.RunFsck(ctx, *repair)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fsck failed: %v\n", err)
+		return 2
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(report)
+
+	switch {
+	case len(report.Errors) > 0:
+		return 2
+	case report.Unresolved() > 0:
+		return 1
+	}
+	return 0
+}
+
+// runRotateKeys 用 STORAGE_ENCRYPTION_KEYS 中的第一个主密钥重新包装所有数据密钥。
+// 退出码：0 全部完成，1 部分文件失败，2 执行出错
+func runRotateKeys(args []string) int {
+	fs := flag.NewFlagSet("rotate-keys", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", time.Hour, "abort after this duration")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	result, err := logic.RotateStorageKeys(ctx)
+	if result != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(result)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rotate-keys failed: %v\n", err)
+		return 2
+	}
+	if len(result.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runMigrateLayout 把存储文件移动到 STORAGE_LAYOUT 指定的目录布局，可与服务同时运行。
+// 退出码：0 全部完成，1 部分文件失败，2 执行出错
+func runMigrateLayout(args []string) int {
+	fs := flag.NewFlagSet("migrate-layout", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only report files that would be moved")
+	timeout := fs.Duration("timeout", 6*time.Hour, "abort after this duration")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	result, err := logic.MigrateStorageLayout(ctx, *dryRun)
+	if result != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(result)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate-layout failed: %v\n", err)
+		return 2
+	}
+	if len(result.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runRepairReplicas 为多磁盘存储补齐缺失（-verify 时含损坏）的副本，可与服务同时运行。
+// 退出码：0 全部完成，1 部分文件失败，2 执行出错
+func runRepairReplicas(args []string) int {
+	fs := flag.NewFlagSet("repair-replicas", flag.ContinueOnError)
+	verify := fs.Bool("verify", false, "read every replica and replace the ones whose content does not match")
+	timeout := fs.Duration("timeout", 24*time.Hour, "abort after this duration")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	report, err := logic.RepairReplicas(ctx, *verify)
+	if report != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "repair-replicas failed: %v\n", err)
+		return 2
+	}
+	if len(report.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runBackfillHashes 为升级前上传的文件计算并记录 SHA-256，并把不带前缀的旧 md5 标识
+// 迁移为 "md5:<hex>"，可与服务同时运行。退出码：0 全部完成，1 部分文件失败，2 执行出错
+func runBackfillHashes(args []string) int {
+	fs := flag.NewFlagSet("backfill-hashes", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only report files without a strong hash and the identifiers to migrate")
+	rate := fs.Int64("rate", 0, "read rate limit in bytes per second, 0 for unlimited")
+	timeout := fs.Duration("timeout", 24*time.Hour, "abort after this duration")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	result, err := logic.BackfillStrongHashes(ctx, *dryRun, *rate)
+	if result != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(result)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backfill-hashes failed: %v\n", err)
+		return 2
+	}
+	if len(result.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runDedupStats 输出块级去重的全局与各用户统计。退出码：0 成功，2 未开启去重或执行出错
+func runDedupStats(args []string) int {
+	fs := flag.NewFlagSet("dedup-stats", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", 10*time.Minute, "abort after this duration")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	report, err := logic.GetDedupReport(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dedup-stats failed: %v\n", err)
+		return 2
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(report)
+	return 0
+}
@@ -7,6 +7,9 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -15,6 +18,8 @@ import (
 	"video-platform/internal/logic"
 	"video-platform/internal/middleware"
 	"video-platform/internal/redis"
+	"video-platform/internal/store"
+	"video-platform/internal/transcode"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -45,8 +50,36 @@ func main() {
 	log.Println("Redis initialized")
 
 	// 初始化存储
-	logic.InitStore(config.StoragePath, config.TempPath)
-	log.Printf("Storage initialized: base=%s, temp=%s", config.StoragePath, config.TempPath)
+	if err := logic.InitStore(config.storeConfig()); err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	log.Printf("Storage initialized: backend=%s, base=%s, temp=%s, encrypted=%v",
+		config.StorageBackend, config.StoragePath, config.TempPath, config.EncryptionKeys != "")
+	if config.EncryptionKeys != "" && config.UploadPreallocate {
+		log.Println("Warning: UPLOAD_PREALLOCATE has no effect when storage encryption is enabled")
+	}
+	if config.Dedup {
+		log.Printf("Block-level dedup enabled: average block size=%d", config.DedupBlockSize)
+	}
+	if len(config.StorageDisks) > 0 {
+		log.Printf("Multi-disk storage: disks=%v, replicas=%d, placement=%s", config.StorageDisks, config.StorageReplicas, config.StoragePlacement)
+		if config.UploadPreallocate {
+			log.Println("Warning: UPLOAD_PREALLOCATE has no effect with STORAGE_DISKS")
+		}
+	}
+
+	logic.ConfigureUploads(logic.UploadConfig{
+		ChunkSize:  config.UploadChunkSize,
+		SessionTTL: config.UploadSessionTTL,
+		TusDir:     filepath.Join(config.TempPath, "tus"),
+	})
+
+	// 维护命令（如 fsck）执行完直接退出，不启动服务
+	if len(os.Args) > 1 {
+		code := runCommand(os.Args[1:])
+		redis.Close()
+		os.Exit(code)
+	}
 
 	// 启动时从数据库加载墓碑到 Redis
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
@@ -55,6 +88,44 @@ func main() {
 	}
 	cancel()
 
+	// 启动转码 worker
+	executor, err := transcode.NewExecutor(config.TranscodeExecutor, config.FFmpegPath)
+	if err != nil {
+		log.Fatalf("Failed to initialize transcoder: %v", err)
+	}
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	workers, err := logic.StartTranscodeWorkers(workerCtx, logic.TranscodeConfig{
+		Executor: executor,
+		Workers:  config.TranscodeWorkers,
+		WorkDir:  config.TranscodeWorkDir,
+	})
+	if err != nil {
+		log.Fatalf("Failed to start transcode workers: %v", err)
+	}
+	if executor == nil {
+		log.Println("Transcoding disabled (no executor)")
+	}
+
+	// 启动垃圾回收
+	gcWorkers := logic.StartGC(workerCtx, logic.GCConfig{
+		Interval:  config.GCInterval,
+		OrphanAge: config.GCOrphanAge,
+		UploadAge: config.GCUploadAge,
+		DryRun:    config.GCDryRun,
+	})
+
+	// 启动删除 worker：引用归零的文件在宽限期后删除
+	deletionWorker := logic.StartDeletionWorker(workerCtx, logic.DeletionConfig{
+		GracePeriod: config.DeletionGrace,
+	})
+
+	// 启动完整性巡检：限速重新校验存储文件，损坏的文件被隔离
+	scrubber := logic.StartScrubber(workerCtx, logic.ScrubConfig{
+		Interval: config.ScrubInterval,
+		MaxAge:   config.ScrubMaxAge,
+		Rate:     config.ScrubRate,
+	})
+
 	// 设置 Gin
 	if config.Env == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -89,20 +160,58 @@ func main() {
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 	srv.Shutdown(shutdownCtx)
+
+	// 停止转码 worker（执行中的任务会被归还到队列）、垃圾回收、删除 worker 与巡检
+	stopWorkers()
+	workers.Wait()
+	gcWorkers.Wait()
+	deletionWorker.Wait()
+	scrubber.Wait()
 	log.Println("Server stopped")
 }
 
 type Config struct {
-	Env             string
-	Port            string
-	DBDsn           string
-	RedisAddr       string
-	RedisPassword   string
-	RedisDB         int
-	StoragePath     string
-	TempPath        string
-	WebStaticPath   string
-	WebTemplatePath string
+	Env               string
+	Port              string
+	DBDsn             string
+	RedisAddr         string
+	RedisPassword     string
+	RedisDB           int
+	StorageBackend    string
+	StoragePath       string
+	StorageLayout     string
+	StorageDisks      []string
+	StorageReplicas   int
+	StoragePlacement  string
+	TempPath          string
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3AccessKey       string
+	S3SecretKey       string
+	S3Prefix          string
+	S3PathStyle       bool
+	UploadPreallocate bool
+	EncryptionKeys    string
+	Dedup             bool
+	DedupBlockSize    int
+	TranscodeExecutor string
+	TranscodeWorkers  int
+	TranscodeWorkDir  string
+	FFmpegPath        string
+	UploadChunkSize   int64
+	UploadSessionTTL  time.Duration
+	GCInterval        time.Duration
+	GCOrphanAge       time.Duration
+	GCUploadAge       time.Duration
+	GCDryRun          bool
+	DeletionGrace     time.Duration
+	ScrubInterval     time.Duration
+	ScrubMaxAge       time.Duration
+	ScrubRate         int64
+	AdminUsers        []string
+	WebStaticPath     string
+	WebTemplatePath   string
 }
 
 func loadConfig() Config {
@@ -121,16 +230,117 @@ func loadConfig() Config {
 			return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
 				user, pass, host, port, name)
 		}(),
-		RedisAddr:     getEnv("REDIS_ADDR", "127.0.0.1:6379"),
-		RedisPassword: getEnv("REDIS_PASSWORD", ""),
-		RedisDB:       0,
-		StoragePath:   getEnv("STORAGE_PATH", "/data/videos"),
-		TempPath:      getEnv("TEMP_PATH", "/tmp/video-chunks"),
-		WebStaticPath: getEnv("WEB_STATIC_PATH", "./web/static"),
+		RedisAddr:      getEnv("REDIS_ADDR", "127.0.0.1:6379"),
+		RedisPassword:  getEnv("REDIS_PASSWORD", ""),
+		RedisDB:        0,
+		StorageBackend: getEnv("STORAGE_BACKEND", "local"),
+		StoragePath:    getEnv("STORAGE_PATH", "/data/videos"),
+		StorageLayout:  getEnv("STORAGE_LAYOUT", ""),
+		StorageDisks: func() []string {
+			var disks []string
+			for _, d := range strings.Split(getEnv("STORAGE_DISKS", ""), ",") {
+				if d = strings.TrimSpace(d); d != "" {
+					disks = append(disks, d)
+				}
+			}
+			return disks
+		}(),
+		StorageReplicas: func() int {
+			n, err := strconv.Atoi(getEnv("STORAGE_REPLICAS", "0"))
+			if err != nil {
+				return 0
+			}
+			return n
+		}(),
+		StoragePlacement:  getEnv("STORAGE_PLACEMENT", store.PlacementHash),
+		TempPath:          getEnv("TEMP_PATH", "/tmp/video-chunks"),
+		S3Endpoint:        getEnv("S3_ENDPOINT", ""),
+		S3Region:          getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:          getEnv("S3_BUCKET", ""),
+		S3AccessKey:       getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:       getEnv("S3_SECRET_KEY", ""),
+		S3Prefix:          getEnv("S3_PREFIX", ""),
+		S3PathStyle:       getEnv("S3_PATH_STYLE", "false") == "true",
+		UploadPreallocate: getEnv("UPLOAD_PREALLOCATE", "false") == "true",
+		EncryptionKeys:    os.Getenv("STORAGE_ENCRYPTION_KEYS"),
+		Dedup:             getEnv("STORAGE_DEDUP", "false") == "true",
+		DedupBlockSize: func() int {
+			n, err := strconv.Atoi(getEnv("STORAGE_DEDUP_BLOCK_SIZE", ""))
+			if err != nil {
+				return store.DefaultDedupBlockSize
+			}
+			return n
+		}(),
+		TranscodeExecutor: getEnv("TRANSCODE_EXECUTOR", "auto"),
+		TranscodeWorkers: func() int {
+			n, err := strconv.Atoi(getEnv("TRANSCODE_WORKERS", "2"))
+			if err != nil {
+				return 2
+			}
+			return n
+		}(),
+		TranscodeWorkDir: getEnv("TRANSCODE_WORK_DIR", filepath.Join(os.TempDir(), "video-transcode")),
+		FFmpegPath:       getEnv("FFMPEG_PATH", "ffmpeg"),
+		UploadChunkSize: func() int64 {
+			n, err := strconv.ParseInt(getEnv("UPLOAD_CHUNK_SIZE", ""), 10, 64)
+			if err != nil {
+				return logic.DefaultUploadChunkSize
+			}
+			return n
+		}(),
+		UploadSessionTTL: func() time.Duration {
+			d, err := time.ParseDuration(getEnv("UPLOAD_SESSION_TTL", ""))
+			if err != nil {
+				return logic.DefaultUploadSessionTTL
+			}
+			return d
+		}(),
+		GCInterval:    getDuration("GC_INTERVAL", 6*time.Hour),
+		GCOrphanAge:   getDuration("GC_ORPHAN_AGE", logic.DefaultGCOrphanAge),
+		GCUploadAge:   getDuration("GC_UPLOAD_AGE", logic.DefaultGCUploadAge),
+		GCDryRun:      getEnv("GC_DRY_RUN", "false") == "true",
+		DeletionGrace: getDuration("DELETION_GRACE_PERIOD", logic.DefaultDeletionGrace),
+		ScrubInterval: getDuration("SCRUB_INTERVAL", logic.DefaultScrubInterval),
+		ScrubMaxAge:   getDuration("SCRUB_MAX_AGE", logic.DefaultScrubMaxAge),
+		ScrubRate: func() int64 {
+			n, err := strconv.ParseInt(getEnv("SCRUB_RATE", ""), 10, 64)
+			if err != nil {
+				return logic.DefaultScrubRate
+			}
+			return n
+		}(),
+		AdminUsers:      strings.Split(getEnv("ADMIN_USERS", ""), ","),
+		WebStaticPath:   getEnv("WEB_STATIC_PATH", "./web/static"),
 		WebTemplatePath: getEnv("WEB_TEMPLATE_PATH", "./web/templates"),
 	}
 }
 
+// storeConfig 根据 STORAGE_BACKEND 组装存储配置（local / s3）
+func (c Config) storeConfig() store.Config {
+	return store.Config{
+		Backend:   c.StorageBackend,
+		BasePath:  c.StoragePath,
+		TempPath:  c.TempPath,
+		Layout:    c.StorageLayout,
+		Disks:     c.StorageDisks,
+		Replicas:  c.StorageReplicas,
+		Placement: c.StoragePlacement,
+		S3: store.S3Config{
+			Endpoint:  c.S3Endpoint,
+			Region:    c.S3Region,
+			Bucket:    c.S3Bucket,
+			AccessKey: c.S3AccessKey,
+			SecretKey: c.S3SecretKey,
+			Prefix:    c.S3Prefix,
+			PathStyle: c.S3PathStyle,
+		},
+		Preallocate:    c.UploadPreallocate,
+		EncryptionKeys: c.EncryptionKeys,
+		Dedup:          c.Dedup,
+		DedupBlockSize: c.DedupBlockSize,
+	}
+}
+
 func getEnv(key, def string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -138,10 +348,19 @@ func getEnv(key, def string) string {
 	return def
 }
 
+// getDuration 读取时长配置，"0" 表示关闭，无法解析时使用默认值
+func getDuration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(getEnv(key, ""))
+	if err != nil {
+		return def
+	}
+	return d
+}
+
 func registerRoutes(r *gin.Engine) {
 	// 设置 Web 路由（静态文件和页面）
 	handler.SetupWebRoutes(r, config.WebStaticPath, config.WebTemplatePath)
-	
+
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
@@ -155,6 +374,33 @@ func registerRoutes(r *gin.Engine) {
 			auth.POST("/login", handler.Login)
 		}
 
+		// 播放路由：接受 JWT 或签名链接（<video src> 无法携带 Authorization 头）
+		api.GET("/files/:id/download", middleware.SignedURLOrAuth("id"), handler.DownloadFile)
+		api.HEAD("/files/:id/download", middleware.SignedURLOrAuth("id"), handler.DownloadFile)
+
+		hlsGroup := api.Group("/hls")
+		hlsGroup.Use(middleware.SignedURLOrAuth("hash"))
+		{
+			hlsGroup.GET("/:hash/master.m3u8", handler.HLSMaster)
+			hlsGroup.HEAD("/:hash/master.m3u8", handler.HLSMaster)
+			hlsGroup.GET("/:hash/:track/:file", handler.HLSTrackFile)
+			hlsGroup.HEAD("/:hash/:track/:file", handler.HLSTrackFile)
+		}
+
+		// 分享链接（访问者无需登录，密码保护的分享通过 grant 参数访问）
+		publicShares := api.Group("/s")
+		{
+			publicShares.GET("/:token", handler.GetPublicShare)
+			publicShares.POST("/:token/unlock", handler.UnlockShare)
+			publicShares.GET("/:token/stream", handler.StreamShare)
+			publicShares.HEAD("/:token/stream", handler.StreamShare)
+			publicShares.GET("/:token/download", handler.DownloadShare)
+		}
+
+		// tus 协议：OPTIONS 用于能力发现，无需登录
+		api.OPTIONS("/tus", handler.TusOptions)
+		api.OPTIONS("/tus/:id", handler.TusOptions)
+
 		// 需要认证的路由
 		protected := api.Group("")
 		protected.Use(middleware.AuthMiddleware())
@@ -163,7 +409,9 @@ func registerRoutes(r *gin.Engine) {
 			{
 				upload.POST("/init", handler.InitUpload)
 				upload.POST("/chunk", handler.UploadChunk)
+				upload.PUT("/chunk/:index", handler.UploadChunkRaw)
 				upload.POST("/merge", handler.MergeChunks)
+				upload.POST("/fast/challenge", handler.FastUploadChallenge)
 				upload.POST("/fast", handler.FastUpload)
 				upload.DELETE("/cancel", handler.CancelUpload)
 			}
@@ -171,8 +419,9 @@ func registerRoutes(r *gin.Engine) {
 			files := protected.Group("/files")
 			{
 				files.GET("", handler.ListFiles)
+				files.GET("/dedup", handler.GetDedupStats)
 				files.GET("/:id", handler.GetFile)
-				files.GET("/:id/download", handler.DownloadFile)
+				files.POST("/:id/playback-url", handler.PlaybackURL)
 				files.DELETE("/:id", handler.DeleteFile)
 			}
 
@@ -180,6 +429,46 @@ func registerRoutes(r *gin.Engine) {
 			{
 				contents.GET("", handler.ListContents)
 				contents.GET("/:id", handler.GetContent)
+				contents.POST("/:id/transcode", handler.CreateTranscodeJobs)
+				contents.GET("/:id/jobs", handler.ListTranscodeJobs)
+				contents.GET("/:id/versions", handler.ListContentVersions)
+				contents.POST("/:id/versions", handler.AddContentVersion)
+				contents.DELETE("/:id/versions/:hash", handler.RemoveContentVersion)
+				contents.PUT("/:id/default", handler.SetContentDefault)
+				contents.POST("/:id/default/rollback", handler.RollbackContentDefault)
+			}
+
+			protected.GET("/jobs/:id", handler.GetTranscodeJob)
+
+			tus := protected.Group("/tus")
+			tus.Use(handler.TusResumable())
+			{
+				tus.POST("", handler.TusCreate)
+				tus.POST("/", handler.TusCreate)
+				tus.HEAD("/:id", handler.TusHead)
+				tus.PATCH("/:id", handler.TusPatch)
+				tus.DELETE("/:id", handler.TusDelete)
+			}
+
+			shares := protected.Group("/shares")
+			{
+				shares.POST("", handler.CreateShare)
+				shares.GET("", handler.ListShares)
+				shares.DELETE("/:id", handler.RevokeShare)
+			}
+
+			// 管理接口：仅 ADMIN_USERS 中的用户
+			admin := protected.Group("/admin")
+			admin.Use(middleware.AdminMiddleware(config.AdminUsers))
+			{
+				admin.POST("/gc", handler.RunGC)
+				admin.GET("/gc", handler.GetGCReport)
+				admin.POST("/fsck", handler.RunFsck)
+				admin.GET("/fsck", handler.GetFsckReport)
+				admin.GET("/scrub", handler.ListQuarantinedFiles)
+				admin.POST("/scrub/:hash", handler.ScrubFile)
+				admin.GET("/metrics", handler.Metrics)
+				admin.GET("/dedup", handler.GetDedupReport)
 			}
 		}
 	}
@@ -0,0 +1,301 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrBlocksBusy 要登记的块正在被删除，稍后重试
+var ErrBlocksBusy = errors.New("dedup blocks are being deleted")
+
+// dedupBatchSize IN 查询与批量写入的条数上限
+const dedupBatchSize = 500
+
+// SetFileBlocks 用 blocks（块 hash -> 大小）替换文件引用的块，blocks 为空表示删除文件。
+// 新引用的块引用数加一，不再引用的块减一，归零的块标记为删除中并返回。
+// 新引用的块处于删除中且标记晚于 busyAfter 时返回 ErrBlocksBusy（删除者仍在进行）；
+// 更早的标记视为删除者已退出，直接恢复引用（调用方需确认块仍存在）。
+func SetFileBlocks(ctx context.Context, fileHash string, blocks map[string]int64, busyAfter time.Time) ([]string, error) {
+	tx := DB.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var old []string
+	if err := tx.Model(&DedupFileBlock{}).Where("file_hash = ?", fileHash).Pluck("block_hash", &old).Error; err != nil {
+		return nil, err
+	}
+	oldSet := make(map[string]bool, len(old))
+	for _, h := range old {
+		oldSet[h] = true
+	}
+	var added, removed []string
+	for h := range blocks {
+		if !oldSet[h] {
+			added = append(added, h)
+		}
+	}
+	for _, h := range old {
+		if _, ok := blocks[h]; !ok {
+			removed = append(removed, h)
+		}
+	}
+	// 固定加锁顺序，避免并发登记相互死锁
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	now := time.Now()
+	for _, batch := range hashBatches(added) {
+		// 锁定已有记录：删除者标记删除中与这里恢复引用互斥
+		var existing []DedupBlock
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("block_hash IN ?", batch).Find(&existing).Error; err != nil {
+			return nil, err
+		}
+		for _, b := range existing {
+			if b.Deleting && b.UpdatedAt.After(busyAfter) {
+				return nil, ErrBlocksBusy
+			}
+		}
+
+		rows := make([]DedupBlock, 0, len(batch))
+		refs := make([]DedupFileBlock, 0, len(batch))
+		for _, h := range batch {
+			rows = append(rows, DedupBlock{BlockHash: h, Size: blocks[h], RefCount: 1, UpdatedAt: now})
+			refs = append(refs, DedupFileBlock{FileHash: fileHash, BlockHash: h, CreatedAt: now})
+		}
+		// 删除中的块引用数为 0，加一后即恢复
+		if err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "block_hash"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"ref_count":  gorm.Expr("ref_count + 1"),
+				"deleting":   false,
+				"updated_at": now,
+			}),
+		}).Create(&rows).Error; err != nil {
+			return nil, err
+		}
+		if err := tx.Create(&refs).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var freed []string
+	for _, batch := range hashBatches(removed) {
+		if err := tx.Where("file_hash = ? AND block_hash IN ?", fileHash, batch).Delete(&DedupFileBlock{}).Error; err != nil {
+			return nil, err
+		}
+		if err := tx.Model(&DedupBlock{}).
+			Where("block_hash IN ? AND ref_count > 0", batch).
+			UpdateColumn("ref_count", gorm.Expr("ref_count - 1")).Error; err != nil {
+			return nil, err
+		}
+		var zero []string
+		if err := tx.Model(&DedupBlock{}).
+			Where("block_hash IN ? AND ref_count = 0 AND deleting = ?", batch, false).
+			Pluck("block_hash", &zero).Error; err != nil {
+			return nil, err
+		}
+		if len(zero) == 0 {
+			continue
+		}
+		if err := tx.Model(&DedupBlock{}).
+			Where("block_hash IN ?", zero).
+			UpdateColumns(map[string]interface{}{"deleting": true, "updated_at": now}).Error; err != nil {
+			return nil, err
+		}
+		freed = append(freed, zero...)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return freed, nil
+}
+
+// ForgetBlocks 块已从存储删除，移除删除中的索引记录
+func ForgetBlocks(ctx context.Context, hashes []string) error {
+	for _, batch := range hashBatches(hashes) {
+		if err := DB.WithContext(ctx).
+			Where("block_hash IN ? AND deleting = ?", batch, true).
+			Delete(&DedupBlock{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListStaleDeletingBlocks 列出 before 之前标记删除、删除者可能已退出的块
+func ListStaleDeletingBlocks(ctx context.Context, before time.Time, limit int) ([]DedupBlock, error) {
+	var blocks []DedupBlock
+	err := DB.WithContext(ctx).
+		Where("deleting = ? AND updated_at < ?", true, before).
+		Order("updated_at").
+		Limit(limit).
+		Find(&blocks).Error
+	return blocks, err
+}
+
+// ClaimDeletingBlock 接手 before 之前标记删除的块：刷新标记时间，期间其他文件登记该块会等待。
+// 块已被恢复引用或被其他节点接手时返回 false
+func ClaimDeletingBlock(ctx context.Context, blockHash string, before time.Time) (bool, error) {
+	res := DB.WithContext(ctx).Model(&DedupBlock{}).
+		Where("block_hash = ? AND deleting = ? AND updated_at < ?", blockHash, true, before).
+		UpdateColumn("updated_at", time.Now())
+	return res.RowsAffected == 1, res.Error
+}
+
+// ClaimOrphanBlock 为没有索引记录的块插入删除中的记录，成功后由调用方删除块并 ForgetBlocks；
+// 记录已存在（刚有文件登记了该块）时返回 false
+func ClaimOrphanBlock(ctx context.Context, blockHash string, size int64) (bool, error) {
+	res := DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&DedupBlock{BlockHash: blockHash, Size: size, Deleting: true, UpdatedAt: time.Now()})
+	return res.RowsAffected == 1, res.Error
+}
+
+// GetDedupBlockHashes 块索引中的全部块（含删除中的）
+func GetDedupBlockHashes(ctx context.Context) (map[string]bool, error) {
+	var hashes []string
+	if err := DB.WithContext(ctx).Model(&DedupBlock{}).Pluck("block_hash", &hashes).Error; err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		set[h] = true
+	}
+	return set, nil
+}
+
+// DedupFile 以块存储的文件
+type DedupFile struct {
+	FileHash  string
+	Size      int64 // 引用的块的总大小
+	CreatedAt time.Time
+}
+
+// ListDedupFiles 列出块索引中登记的文件
+func ListDedupFiles(ctx context.Context) ([]DedupFile, error) {
+	var files []DedupFile
+	err := DB.WithContext(ctx).Model(&DedupFileBlock{}).
+		Select("dedup_file_blocks.file_hash, SUM(dedup_blocks.size) AS size, MIN(dedup_file_blocks.created_at) AS created_at").
+		Joins("JOIN dedup_blocks ON dedup_blocks.block_hash = dedup_file_blocks.block_hash").
+		Group("dedup_file_blocks.file_hash").
+		Scan(&files).Error
+	return files, err
+}
+
+// DedupTotals 块级去重统计：以块存储的文件总大小与实际存储的块总大小
+type DedupTotals struct {
+	Files        int64
+	LogicalBytes int64
+	Blocks       int64
+	StoredBytes  int64
+}
+
+// GetDedupTotals 全局统计，块只计引用数大于 0 的
+func GetDedupTotals(ctx context.Context) (*DedupTotals, error) {
+	t := &DedupTotals{}
+	var files struct {
+		N    int64
+		Size int64
+	}
+	if err := DB.WithContext(ctx).Model(&FileMeta{}).
+		Select("COUNT(*) AS n, COALESCE(SUM(file_size), 0) AS size").
+		Where("file_hash IN (?)", DB.Model(&DedupFileBlock{}).Select("file_hash")).
+		Scan(&files).Error; err != nil {
+		return nil, err
+	}
+	t.Files, t.LogicalBytes = files.N, files.Size
+
+	var blocks struct {
+		N    int64
+		Size int64
+	}
+	if err := DB.WithContext(ctx).Model(&DedupBlock{}).
+		Select("COUNT(*) AS n, COALESCE(SUM(size), 0) AS size").
+		Where("ref_count > 0").
+		Scan(&blocks).Error; err != nil {
+		return nil, err
+	}
+	t.Blocks, t.StoredBytes = blocks.N, blocks.Size
+	return t, nil
+}
+
+type userDedupRow struct {
+	UserID int
+	N      int64
+	Size   int64
+}
+
+// GetUserDedupTotals 按用户统计：用户已完成/转码中的文件中以块存储的部分，
+// 以及这些文件引用的不同块。userID 为 0 时统计所有用户。
+func GetUserDedupTotals(ctx context.Context, userID int) (map[int]*DedupTotals, error) {
+	owned := DB.Model(&UserContent{}).
+		Select("DISTINCT user_id, file_hash").
+		Where("status IN ? AND file_hash != ''", []int{1, 2})
+	if userID != 0 {
+		owned = owned.Where("user_id = ?", userID)
+	}
+
+	var fileRows []userDedupRow
+	if err := DB.WithContext(ctx).
+		Table("(?) AS owned", owned).
+		Select("owned.user_id, COUNT(*) AS n, COALESCE(SUM(file_metas.file_size), 0) AS size").
+		Joins("JOIN file_metas ON file_metas.file_hash = owned.file_hash").
+		Where("owned.file_hash IN (?)", DB.Model(&DedupFileBlock{}).Select("file_hash")).
+		Group("owned.user_id").
+		Scan(&fileRows).Error; err != nil {
+		return nil, err
+	}
+
+	ownedBlocks := DB.Model(&DedupFileBlock{}).
+		Select("DISTINCT owned.user_id, dedup_file_blocks.block_hash").
+		Joins("JOIN (?) AS owned ON owned.file_hash = dedup_file_blocks.file_hash", owned)
+	var blockRows []userDedupRow
+	if err := DB.WithContext(ctx).
+		Table("(?) AS ub", ownedBlocks).
+		Select("ub.user_id, COUNT(*) AS n, COALESCE(SUM(dedup_blocks.size), 0) AS size").
+		Joins("JOIN dedup_blocks ON dedup_blocks.block_hash = ub.block_hash").
+		Group("ub.user_id").
+		Scan(&blockRows).Error; err != nil {
+		return nil, err
+	}
+
+	totals := make(map[int]*DedupTotals)
+	get := func(id int) *DedupTotals {
+		if totals[id] == nil {
+			totals[id] = &DedupTotals{}
+		}
+		return totals[id]
+	}
+	for _, r := range fileRows {
+		t := get(r.UserID)
+		t.Files, t.LogicalBytes = r.N, r.Size
+	}
+	for _, r := range blockRows {
+		t := get(r.UserID)
+		t.Blocks, t.StoredBytes = r.N, r.Size
+	}
+	return totals, nil
+}
+
+// hashBatches 把 hashes 按 dedupBatchSize 分批
+func hashBatches(hashes []string) [][]string {
+	var out [][]string
+	for len(hashes) > dedupBatchSize {
+		out = append(out, hashes[:dedupBatchSize])
+		hashes = hashes[dedupBatchSize:]
+	}
+	if len(hashes) > 0 {
+		out = append(out, hashes)
+	}
+	return out
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// schedulePendingDeletion 在释放引用的事务中登记待删除；已登记时重新计算宽限期
+func schedulePendingDeletion(tx *gorm.DB, fileHash string) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "file_hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"released_at": time.Now(),
+			"attempts":    0,
+			"last_error":  "",
+		}),
+	}).Create(&PendingDeletion{FileHash: fileHash, ReleasedAt: time.Now()}).Error
+}
+
+// ListDuePendingDeletions 列出 before 之前引用归零的待删除记录
+func ListDuePendingDeletions(ctx context.Context, before time.Time, limit int) ([]PendingDeletion, error) {
+	var pds []PendingDeletion
+	err := DB.WithContext(ctx).
+		Where("released_at < ?", before).
+		Order("released_at").
+		Limit(limit).
+		Find(&pds).Error
+	return pds, err
+}
+
+// ClaimPendingDeletion 再次确认引用仍为 0 后删除 FileMeta，返回 true 表示可以删除存储文件。
+// 宽限期内被重新引用（秒传、重新上传、登记为版本）时撤销待删除记录并返回 false。
+// 待删除记录保留到存储文件删除成功（FinishPendingDeletion）。
+func ClaimPendingDeletion(ctx context.Context, id uint) (bool, error) {
+	tx := DB.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var pd PendingDeletion
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", id).First(&pd).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var fm FileMeta
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("file_hash = ?", pd.FileHash).First(&fm).Error
+	switch {
+	case err == nil && fm.RefCount > 0:
+		if err := tx.Delete(&pd).Error; err != nil {
+			return false, err
+		}
+		return false, tx.Commit().Error
+	case err == nil:
+		if err := tx.Delete(&fm).Error; err != nil {
+			return false, err
+		}
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return false, err
+	}
+
+	return true, tx.Commit().Error
+}
+
+// FinishPendingDeletion 存储文件已删除，移除待删除记录
+func FinishPendingDeletion(ctx context.Context, id uint) error {
+	return DB.WithContext(ctx).Delete(&PendingDeletion{}, id).Error
+}
+
+// FailPendingDeletion 记录删除存储文件失败，下一轮重试
+func FailPendingDeletion(ctx context.Context, id uint, errMsg string) error {
+	return DB.WithContext(ctx).Model(&PendingDeletion{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"attempts":   gorm.Expr("attempts + ?", 1),
+			"last_error": errMsg,
+		}).Error
+}
+
+// FileMetaExists 是否存在 hash 对应的 FileMeta（含等待删除、引用为 0 的记录）
+func FileMetaExists(ctx context.Context, fileHash string) (bool, error) {
+	var n int64
+	err := DB.WithContext(ctx).Model(&FileMeta{}).Where("file_hash = ?", fileHash).Count(&n).Error
+	return n > 0, err
+}
+
+// CountPendingDeletions 待删除记录数
+func CountPendingDeletions(ctx context.Context) (int64, error) {
+	var n int64
+	err := DB.WithContext(ctx).Model(&PendingDeletion{}).Count(&n).Error
+	return n, err
+}
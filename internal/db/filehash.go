@@ -0,0 +1,229 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// sha256HashPrefix SHA-256 文件 hash 的前缀，这类文件的强摘要就是 hash 本身
+const sha256HashPrefix = "sha256:"
+
+// BeforeCreate SHA-256 标识的文件登记时同时记录强摘要
+func (fm *FileMeta) BeforeCreate(tx *gorm.DB) error {
+	if fm.StrongHash == "" && strings.HasPrefix(fm.FileHash, sha256HashPrefix) {
+		fm.StrongHash = fm.FileHash
+	}
+	return nil
+}
+
+// SetStrongHash 记录服务端计算的强摘要，不覆盖已有记录
+func SetStrongHash(ctx context.Context, fileHash, strongHash string) error {
+	return DB.WithContext(ctx).Model(&FileMeta{}).
+		Where("file_hash = ? AND strong_hash = ''", fileHash).
+		UpdateColumn("strong_hash", strongHash).Error
+}
+
+// ListFileMetasWithoutStrongHash 列出尚未记录强摘要的文件（升级前上传的 md5 文件）
+func ListFileMetasWithoutStrongHash(ctx context.Context) ([]FileMeta, error) {
+	var metas []FileMeta
+	err := DB.WithContext(ctx).
+		Select("file_hash, file_size, verified_at, quarantined_at").
+		Where("strong_hash = ''").
+		Order("file_hash").
+		Find(&metas).Error
+	return metas, err
+}
+
+// legacyHashPattern 不带算法前缀的旧文件 hash（升级前的 md5）之外的值都含 ":"
+const legacyHashPattern = "%:%"
+
+// hashColumns 引用文件 hash 的列。file_metas 主键与待删除记录由 RenameFileHash 单独处理，
+// 块索引（dedup_file_blocks）由存储层在链接文件时登记
+var hashColumns = []struct {
+	model  interface{}
+	column string
+}{
+	{&UserContent{}, "file_hash"},
+	{&ContentVersion{}, "file_hash"},
+	{&Content{}, "source_hash"},
+	{&Content{}, "default_hash"},
+	{&ContentDefaultLog{}, "file_hash"},
+	{&Share{}, "file_hash"},
+	{&UploadSession{}, "file_hash"},
+	{&TranscodeJob{}, "source_hash"},
+	{&TranscodeJob{}, "output_hash"},
+}
+
+// ListLegacyFileMetas 列出以不带前缀的旧标识登记的文件
+func ListLegacyFileMetas(ctx context.Context) ([]FileMeta, error) {
+	var metas []FileMeta
+	err := DB.WithContext(ctx).
+		Select("file_hash, file_path, file_size, strong_hash, quarantined_at").
+		Where("file_hash NOT LIKE ?", legacyHashPattern).
+		Order("file_hash").
+		Find(&metas).Error
+	return metas, err
+}
+
+// ListLegacyUploadSessions 列出以旧标识进行中的上传会话
+func ListLegacyUploadSessions(ctx context.Context) ([]UploadSession, error) {
+	var sessions []UploadSession
+	err := DB.WithContext(ctx).
+		Where("status = ? AND file_hash NOT LIKE ?", UploadSessionActive, legacyHashPattern).
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// ListLegacyHashReferences 列出各引用列中仍为旧标识、且没有对应 FileMeta 的值
+// （未完成的上传、已删除文件的分享与转码记录等）
+func ListLegacyHashReferences(ctx context.Context) ([]string, error) {
+	seen := make(map[string]bool)
+	var hashes []string
+	for _, c := range hashColumns {
+		var values []string
+		if err := DB.WithContext(ctx).Model(c.model).
+			Distinct(c.column).
+			Where(c.column+" != '' AND "+c.column+" NOT LIKE ?", legacyHashPattern).
+			Pluck(c.column, &values).Error; err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			if !seen[v] {
+				seen[v] = true
+				hashes = append(hashes, v)
+			}
+		}
+	}
+	return hashes, nil
+}
+
+// FileHashRename 文件标识迁移的结果
+type FileHashRename struct {
+	Folded  bool  // 新标识已有 FileMeta，引用并入其中
+	UserIDs []int // 引用该文件的用户，用于迁移墓碑
+}
+
+// RenameFileHash 把 FileMeta 及所有引用从 oldHash 改为 newHash，newPath 为存储中的新路径。
+// newHash 已有 FileMeta（同一内容已以新标识上传过）时合并引用计数，
+// 同一 content 下因此重复的版本只保留一条。调用方需持有新旧 hash 的 blob 锁。
+func RenameFileHash(ctx context.Context, oldHash, newHash, newPath string) (*FileHashRename, error) {
+	tx := DB.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var fm FileMeta
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("file_hash = ?", oldHash).First(&fm).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrFileMetaNotFound
+		}
+		return nil, err
+	}
+	var target FileMeta
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("file_hash = ?", newHash).First(&target).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	result := &FileHashRename{Folded: err == nil}
+
+	if err := tx.Model(&UserContent{}).Distinct("user_id").
+		Where("file_hash = ?", oldHash).Pluck("user_id", &result.UserIDs).Error; err != nil {
+		return nil, err
+	}
+
+	refs := fm.RefCount
+	if result.Folded {
+		// 同一 content 已有新标识的版本：删除重复的一条，并释放它持有的引用
+		var contents []uint
+		if err := tx.Model(&ContentVersion{}).Where("file_hash = ?", newHash).
+			Pluck("content_id", &contents).Error; err != nil {
+			return nil, err
+		}
+		if len(contents) > 0 {
+			res := tx.Where("file_hash = ? AND content_id IN ?", oldHash, contents).Delete(&ContentVersion{})
+			if res.Error != nil {
+				return nil, res.Error
+			}
+			refs -= int(res.RowsAffected)
+		}
+		refs += target.RefCount
+	}
+	if err := renameHashReferences(tx, oldHash, newHash); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Where("file_hash = ?", oldHash).Delete(&PendingDeletion{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("file_hash = ?", oldHash).Delete(&FileMeta{}).Error; err != nil {
+		return nil, err
+	}
+	if result.Folded {
+		err = tx.Model(&FileMeta{}).Where("file_hash = ?", newHash).UpdateColumn("ref_count", refs).Error
+	} else {
+		renamed := fm
+		renamed.FileHash = newHash
+		renamed.FilePath = newPath
+		renamed.RefCount = refs
+		err = tx.Create(&renamed).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	if refs > 0 {
+		err = tx.Where("file_hash = ?", newHash).Delete(&PendingDeletion{}).Error
+	} else {
+		err = schedulePendingDeletion(tx, newHash)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RenameHashReferences 把没有 FileMeta 的旧标识引用改为 newHash，返回引用它的用户
+func RenameHashReferences(ctx context.Context, oldHash, newHash string) ([]int, error) {
+	tx := DB.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var userIDs []int
+	if err := tx.Model(&UserContent{}).Distinct("user_id").
+		Where("file_hash = ?", oldHash).Pluck("user_id", &userIDs).Error; err != nil {
+		return nil, err
+	}
+	if err := renameHashReferences(tx, oldHash, newHash); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
+
+func renameHashReferences(tx *gorm.DB, oldHash, newHash string) error {
+	for _, c := range hashColumns {
+		if err := tx.Model(c.model).Where(c.column+" = ?", oldHash).
+			UpdateColumn(c.column, newHash).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrFileMetaNotFound = errors.New("file meta not found")
+
+// RefCountCheck 一个 FileMeta 的引用计数核对结果
+type RefCountCheck struct {
+	RefCount int  // FileMeta.RefCount
+	Expected int  // 已完成/转码中的 UserContent 与 ContentVersion 的引用数之和
+	Pending  bool // 是否有待删除记录
+	Repaired bool
+}
+
+// Consistent 引用计数正确，且无引用时已登记待删除
+func (c *RefCountCheck) Consistent() bool {
+	return c.RefCount == c.Expected && (c.Expected > 0 || c.Pending)
+}
+
+type fileRefRow struct {
+	FileHash string
+	Refs     int
+}
+
+// CountFileRefs 按 UserContent（已完成/转码中）与 ContentVersion 统计每个文件的实际引用数
+func CountFileRefs(ctx context.Context) (map[string]int, error) {
+	refs := make(map[string]int)
+
+	var rows []fileRefRow
+	if err := DB.WithContext(ctx).Model(&UserContent{}).
+		Select("file_hash, COUNT(*) AS refs").
+		Where("status IN ? AND file_hash != ''", []int{1, 2}).
+		Group("file_hash").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		refs[r.FileHash] += r.Refs
+	}
+
+	rows = nil
+	if err := DB.WithContext(ctx).Model(&ContentVersion{}).
+		Select("file_hash, COUNT(*) AS refs").
+		Group("file_hash").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		refs[r.FileHash] += r.Refs
+	}
+	return refs, nil
+}
+
+// ListFileMetas 列出所有 FileMeta 的 hash、路径、大小与引用计数
+func ListFileMetas(ctx context.Context) ([]FileMeta, error) {
+	var metas []FileMeta
+	err := DB.WithContext(ctx).
+		Select("file_hash, file_path, file_size, ref_count").
+		Find(&metas).Error
+	return metas, err
+}
+
+// GetPendingDeletionHashes 所有待删除的文件 hash
+func GetPendingDeletionHashes(ctx context.Context) (map[string]bool, error) {
+	var hashes []string
+	if err := DB.WithContext(ctx).Model(&PendingDeletion{}).Pluck("file_hash", &hashes).Error; err != nil {
+		return nil, err
+	}
+	pending := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		pending[h] = true
+	}
+	return pending, nil
+}
+
+// ReconcileFileRefCount 锁定 FileMeta 后重新统计引用数。repair 时把 RefCount 改为实际值，
+// 无引用时补登记待删除，有引用时撤销待删除。FileMeta 不存在时返回 ErrFileMetaNotFound。
+func ReconcileFileRefCount(ctx context.Context, fileHash string, repair bool) (*RefCountCheck, error) {
+	tx := DB.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// 修改引用的事务都会锁定同一行，持锁期间统计到的引用数是准确的
+	var fm FileMeta
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("file_hash = ?", fileHash).First(&fm).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrFileMetaNotFound
+		}
+		return nil, err
+	}
+
+	var ucRefs, versionRefs, pending int64
+	if err := tx.Model(&UserContent{}).
+		Where("file_hash = ? AND status IN ?", fileHash, []int{1, 2}).
+		Count(&ucRefs).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&ContentVersion{}).Where("file_hash = ?", fileHash).Count(&versionRefs).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&PendingDeletion{}).Where("file_hash = ?", fileHash).Count(&pending).Error; err != nil {
+		return nil, err
+	}
+
+	check := &RefCountCheck{
+		RefCount: fm.RefCount,
+		Expected: int(ucRefs + versionRefs),
+		Pending:  pending > 0,
+	}
+	if !repair || check.Consistent() {
+		return check, nil
+	}
+
+	if check.RefCount != check.Expected {
+		if err := tx.Model(&FileMeta{}).Where("file_hash = ?", fileHash).
+			UpdateColumn("ref_count", check.Expected).Error; err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case check.Expected == 0 && !check.Pending:
+		if err := tx.Create(&PendingDeletion{FileHash: fileHash, ReleasedAt: time.Now()}).Error; err != nil {
+			return nil, err
+		}
+	case check.Expected > 0 && check.Pending:
+		if err := tx.Where("file_hash = ?", fileHash).Delete(&PendingDeletion{}).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	check.Repaired = true
+	return check, nil
+}
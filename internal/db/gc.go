@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ListAbandonedUserContents 列出 before 之前最后更新、仍处于上传中或已取消的用户记录
+func ListAbandonedUserContents(ctx context.Context, before time.Time, limit int) ([]UserContent, error) {
+	var ucs []UserContent
+	err := DB.WithContext(ctx).
+		Where("status IN ? AND updated_at < ?", []int{0, -1}, before).
+		Order("updated_at").
+		Limit(limit).
+		Find(&ucs).Error
+	return ucs, err
+}
+
+// DeleteAbandonedUserContent 删除一条上传中或已取消的用户记录。记录在此期间被重新
+// 初始化（状态或更新时间变化）时不删除，返回 false。content 不再被任何用户引用时
+// 与 DeleteUserFile 一样释放其他版本。
+func DeleteAbandonedUserContent(ctx context.Context, id uint, before time.Time) (bool, error) {
+	tx := DB.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var uc UserContent
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND status IN ? AND updated_at < ?", id, []int{0, -1}, before).
+		First(&uc).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := tx.Delete(&uc).Error; err != nil {
+		return false, err
+	}
+
+	var remaining int64
+	if err := tx.Model(&UserContent{}).Where("content_id = ?", uc.ContentID).Count(&remaining).Error; err != nil {
+		return false, err
+	}
+	if remaining == 0 {
+		if err := releaseContentVersions(tx, uc.ContentID); err != nil {
+			return false, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
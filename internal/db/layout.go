@@ -0,0 +1,10 @@
+package db
+
+import "context"
+
+// UpdateFilePath 存储文件迁移到新位置后更新 FileMeta.FilePath
+func UpdateFilePath(ctx context.Context, fileHash, filePath string) error {
+	return DB.WithContext(ctx).Model(&FileMeta{}).
+		Where("file_hash = ?", fileHash).
+		UpdateColumn("file_path", filePath).Error
+}
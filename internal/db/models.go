@@ -19,8 +19,8 @@ type User struct {
 
 // 物理文件（版本）表：每个 FileMeta 是一个具体文件版本，关联到一个 Content
 type FileMeta struct {
-    // 使用文件内容的 MD5 作为主键（若要支持重名文件或重复文件，考虑改用复合主键或自增 ID）
-    FileHash   string    `gorm:"primaryKey;type:char(32)"` // 内容 MD5
+    // 使用文件内容的摘要作为主键（若要支持重名文件或重复文件，考虑改用复合主键或自增 ID）
+    FileHash   string    `gorm:"primaryKey;type:varchar(71)"` // 内容摘要，"sha256:<hex>"；旧客户端上传的为 "md5:<hex>"
     ContentID  uint      `gorm:"index"`                     // 归属的 content
     FilePath   string    `gorm:"type:varchar(255)"`         // 存储路径
     FileSize   int64     // 字节数
@@ -32,6 +32,9 @@ type FileMeta struct {
     Height     int       // 分辨率高
     Duration   int       // 时长（秒）
     RefCount   int       `gorm:"default:0"` // 引用计数（UserContent 引用）
+    VerifiedAt    *time.Time `gorm:"index"` // 最近一次确认存储内容与 hash 一致的时间（合并或巡检）
+    QuarantinedAt *time.Time // 巡检发现内容与 hash 不符的时间，非空时禁止下载与秒传
+    StrongHash    string     `gorm:"index;type:varchar(71);default:''"` // 服务端计算的 "sha256:<hex>"，MD5 文件的碰撞校验与去重依据
     CreatedAt  time.Time
 }
 
@@ -39,7 +42,8 @@ type FileMeta struct {
 type Content struct {
     ID         uint       `gorm:"primaryKey"`                     // content_id
     OwnerID    int        `gorm:"index"`                          // 上传者 user id
-    SourceHash string     `gorm:"index;type:char(32);default:''"` // 上传时的源文件 hash（可为空）
+    SourceHash string     `gorm:"index;type:varchar(71);default:''"` // 上传时的源文件 hash（可为空）
+    DefaultHash string    `gorm:"type:varchar(71);default:''"`    // 默认播放版本，空表示源文件
     Title      string
     CreatedAt  time.Time
 }
@@ -57,6 +61,123 @@ type UserContent struct {
     UpdatedAt time.Time
 }
 
+// ContentVersion 内容的其他版本（转码产物或用户指定的上传文件），源文件由 Content.SourceHash 表示。
+// 每条记录持有对应 FileMeta 的一个引用。
+type ContentVersion struct {
+	ID        uint   `gorm:"primaryKey"`
+	ContentID uint   `gorm:"uniqueIndex:idx_content_version"`
+	FileHash  string `gorm:"uniqueIndex:idx_content_version;index;type:varchar(71)"`
+	Kind      string `gorm:"type:varchar(20)"`  // transcode / upload
+	Label     string `gorm:"type:varchar(100)"` // 如 720p
+	CreatedAt time.Time
+}
+
+// ContentDefaultLog 默认播放版本的变更记录，用于回滚
+type ContentDefaultLog struct {
+	ID        uint   `gorm:"primaryKey"`
+	ContentID uint   `gorm:"index"`
+	FileHash  string `gorm:"type:varchar(71)"`
+	CreatedAt time.Time
+}
+
+// 上传会话状态
+const (
+	UploadSessionActive    = 0
+	UploadSessionCompleted = 1
+	UploadSessionCancelled = -1
+)
+
+// UploadSession 分片上传会话：记录服务端选定的分片大小与声明的文件大小，
+// 分片与合并请求都以会话为准校验
+type UploadSession struct {
+	ID          string `gorm:"primaryKey;type:varchar(64)"`
+	UserID      int    `gorm:"index:idx_upload_session_user_hash"`
+	FileHash    string `gorm:"index:idx_upload_session_user_hash;type:varchar(71)"`
+	ContentID   uint
+	FileName    string
+	FileSize    int64 // 声明的文件大小
+	ChunkSize   int64 // 除最后一片外每片的大小
+	TotalChunks int
+	Status      int       `gorm:"default:0"`
+	ExpiresAt   time.Time `gorm:"index"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Share 分享链接：把用户的一个文件分享给未注册的访问者
+type Share struct {
+	ID            uint   `gorm:"primaryKey"`
+	Token         string `gorm:"uniqueIndex;type:varchar(64)"` // 链接中的随机令牌
+	UserID        int    `gorm:"index"`
+	UserContentID uint   `gorm:"index"`
+	FileHash      string `gorm:"type:varchar(71)"`
+	Password      string // bcrypt 哈希，空表示无密码
+	ExpiresAt     *time.Time
+	MaxDownloads  int  // 0 表示不限
+	Downloads     int  // 已下载次数
+	Views         int  // 打开次数
+	ViewOnly      bool // 仅在线观看，不允许下载
+	RevokedAt     *time.Time
+	LastAccessAt  *time.Time
+	CreatedAt     time.Time
+}
+
+// PendingDeletion 引用归零、等待删除存储文件的 FileMeta。与释放引用写在同一事务中，
+// 删除 worker 在宽限期后再次确认引用仍为 0 才删除元数据与存储文件
+type PendingDeletion struct {
+	ID         uint      `gorm:"primaryKey"`
+	FileHash   string    `gorm:"uniqueIndex;type:varchar(71)"`
+	ReleasedAt time.Time `gorm:"index"` // 引用归零的时间，宽限期从此起算
+	Attempts   int       // 删除存储文件失败的次数
+	LastError  string    `gorm:"type:text"`
+}
+
+// DedupBlock 块级去重的块索引：RefCount 为引用该块的文件数。引用归零时标记 Deleting，
+// 块从存储删除后移除记录；标记期间其他文件登记该块需等待删除完成
+type DedupBlock struct {
+	BlockHash string    `gorm:"primaryKey;type:varchar(71)"` // "sha256:<hex>"
+	Size      int64
+	RefCount  int       `gorm:"default:0"`
+	Deleting  bool      `gorm:"index;default:false"`
+	UpdatedAt time.Time
+}
+
+// DedupFileBlock 以块存储的文件引用的块，每个文件对每个块一条
+type DedupFileBlock struct {
+	FileHash  string `gorm:"primaryKey;type:varchar(71)"`
+	BlockHash string `gorm:"primaryKey;type:varchar(71);index"`
+	CreatedAt time.Time
+}
+
+// 转码任务状态
+const (
+	JobPending   = 0 // 等待执行（含等待重试）
+	JobRunning   = 1 // 执行中（持有 Redis 租约）
+	JobSucceeded = 2 // 成功
+	JobFailed    = 3 // 重试次数用尽
+)
+
+// TranscodeJob 转码任务：表中记录是任务的唯一依据，Redis 队列只负责调度
+type TranscodeJob struct {
+	ID          uint   `gorm:"primaryKey"`
+	ContentID   uint   `gorm:"index"`
+	UserID      int    `gorm:"index"`            // 提交者
+	SourceHash  string `gorm:"type:varchar(71)"` // 源文件
+	Preset      string `gorm:"type:varchar(50)"` // 输出规格，如 720p
+	Status      int    `gorm:"index"`            // 见 JobPending 等
+	Progress    int    // 0-100
+	Attempts    int    // 已执行次数
+	MaxAttempts int
+	LastError   string    `gorm:"type:text"`
+	OutputHash  string    `gorm:"type:varchar(71);default:''"` // 产物 FileMeta
+	WorkerID    string    `gorm:"type:varchar(100);default:''"`
+	NextRunAt   time.Time `gorm:"index"`
+	StartedAt   *time.Time
+	FinishedAt  *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
 // 初始化数据库连接 (标准 Gorm 连接代码)
 var DB *gorm.DB
 
@@ -77,7 +198,7 @@ func InitDB(dsn string) error {
     sqlDB.SetConnMaxLifetime(time.Hour)
 
     // AutoMigrate：注意顺序，先 Content，再 FileMeta，再 UserContent
-    if err := DB.AutoMigrate(&Content{}, &FileMeta{}, &UserContent{}, &User{}); err != nil {
+    if err := DB.AutoMigrate(&Content{}, &FileMeta{}, &UserContent{}, &User{}, &TranscodeJob{}, &ContentVersion{}, &ContentDefaultLog{}, &Share{}, &UploadSession{}, &PendingDeletion{}, &DedupBlock{}, &DedupFileBlock{}); err != nil {
         return fmt.Errorf("failed to migrate database: %w", err)
     }
     return nil
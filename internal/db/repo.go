@@ -3,8 +3,6 @@ package db
 import (
 	"context"
 	"errors"
-	"os"
-	"path/filepath"
 	"time"
 
 	"gorm.io/gorm"
@@ -14,6 +12,7 @@ import (
 var (
 	ErrUploadAlreadyCompleted = errors.New("upload already completed")
 	ErrUploadCancelled        = errors.New("upload was cancelled")
+	ErrUserFileNotFound       = errors.New("user file not found")
 )
 
 // CreateOrUpdateUserFileUploading：upload/init 时调用（纯数据库操作）
@@ -66,6 +65,13 @@ func CreateOrUpdateUserFileUploading(ctx context.Context, userID int, fileName,
 		tx.Rollback()
 		return 0, err
 	} else {
+		// 已完成（含转码中）的记录持有引用，重新上传时先释放，合并后重新引用
+		if uc.Status == 1 || uc.Status == 2 {
+			if err := releaseFileRef(tx, uc.FileHash); err != nil {
+				tx.Rollback()
+				return 0, err
+			}
+		}
 		if err := tx.Model(&uc).Updates(map[string]interface{}{
 			"status":     0,
 			"updated_at": now,
@@ -98,11 +104,17 @@ func CreateUserFileForFastUpload(ctx context.Context, userID int, contentID uint
 		return err
 	}
 
-	if err := tx.Model(&FileMeta{}).
-		Where("file_hash = ?", fileHash).
-		UpdateColumn("ref_count", gorm.Expr("ref_count + ?", 1)).Error; err != nil {
+	res := tx.Model(&FileMeta{}).
+		Where("file_hash = ? AND quarantined_at IS NULL", fileHash).
+		UpdateColumn("ref_count", gorm.Expr("ref_count + ?", 1))
+	if res.Error != nil {
 		tx.Rollback()
-		return err
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		// 文件元数据已不存在或已被隔离，不能引用
+		tx.Rollback()
+		return gorm.ErrRecordNotFound
 	}
 
 	uc := UserContent{}
@@ -146,17 +158,19 @@ func FinishMergeAndCreateMeta(ctx context.Context, userID int, contentID uint, f
 		}
 	}()
 
+	now := time.Now()
 	var fm FileMeta
 	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
 		Where("file_hash = ?", fileHash).First(&fm).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			fm = FileMeta{
-				FileHash:  fileHash,
-				ContentID: contentID,
-				FilePath:  filePath,
-				FileSize:  fileSize,
-				RefCount:  1,
-				CreatedAt: time.Now(),
+				FileHash:   fileHash,
+				ContentID:  contentID,
+				FilePath:   filePath,
+				FileSize:   fileSize,
+				RefCount:   1,
+				VerifiedAt: &now,
+				CreatedAt:  now,
 			}
 			if err := tx.Create(&fm).Error; err != nil {
 				tx.Rollback()
@@ -167,7 +181,12 @@ func FinishMergeAndCreateMeta(ctx context.Context, userID int, contentID uint, f
 			return err
 		}
 	} else {
-		if err := tx.Model(&fm).UpdateColumn("ref_count", gorm.Expr("ref_count + ?", 1)).Error; err != nil {
+		// 合并时已校验内容，同时解除隔离（重新上传可修复被隔离的文件）
+		if err := tx.Model(&fm).UpdateColumns(map[string]interface{}{
+			"ref_count":      gorm.Expr("ref_count + ?", 1),
+			"verified_at":    now,
+			"quarantined_at": nil,
+		}).Error; err != nil {
 			tx.Rollback()
 			return err
 		}
@@ -178,16 +197,25 @@ func FinishMergeAndCreateMeta(ctx context.Context, userID int, contentID uint, f
 		Updates(map[string]interface{}{
 			"status":     1,
 			"file_hash":  fileHash,
-			"updated_at": time.Now(),
+			"updated_at": now,
 		}).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
+	// md5 碰撞时文件改存在强摘要下，内容的源文件随之更新
+	if err := tx.Model(&Content{}).
+		Where("id = ? AND owner_id = ? AND source_hash <> ?", contentID, userID, fileHash).
+		Update("source_hash", fileHash).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	return tx.Commit().Error
 }
 
-// DeleteUserFile：删除用户文件（纯数据库操作）
+// DeleteUserFile：删除用户文件（纯数据库操作）。引用归零的文件登记为待删除，
+// 与删除记录在同一事务中提交
 func DeleteUserFile(ctx context.Context, userID int, fileHash string) error {
 	tx := DB.WithContext(ctx).Begin()
 	if tx.Error != nil {
@@ -197,35 +225,31 @@ func DeleteUserFile(ctx context.Context, userID int, fileHash string) error {
 		_ = tx.Rollback()
 	}()
 
-	if err := tx.Where("user_id = ? AND file_hash = ?", userID, fileHash).
-		Delete(&UserContent{}).Error; err != nil {
-		return err
-	}
-
-	var fm FileMeta
-	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
-		Where("file_hash = ?", fileHash).First(&fm).Error; err != nil {
+	var uc UserContent
+	if err := tx.Where("user_id = ? AND file_hash = ?", userID, fileHash).First(&uc).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return tx.Commit().Error
+			return ErrUserFileNotFound
 		}
 		return err
 	}
-
-	if err := tx.Model(&FileMeta{}).
-		Where("file_hash = ?", fileHash).
-		UpdateColumn("ref_count", gorm.Expr("GREATEST(ref_count - ?, 0)", 1)).Error; err != nil {
+	if err := tx.Delete(&uc).Error; err != nil {
 		return err
 	}
 
-	if err := tx.Where("file_hash = ?", fileHash).First(&fm).Error; err != nil {
-		return err
+	// 只有已完成（含转码中）的记录持有引用
+	if uc.Status == 1 || uc.Status == 2 {
+		if err := releaseFileRef(tx, fileHash); err != nil {
+			return err
+		}
 	}
 
-	if fm.RefCount <= 0 {
-		if fm.FilePath != "" {
-			_ = os.Remove(filepath.Clean(fm.FilePath))
-		}
-		if err := tx.Where("file_hash = ?", fileHash).Delete(&FileMeta{}).Error; err != nil {
+	// content 不再被任何用户引用时，一并释放它的其他版本
+	var remaining int64
+	if err := tx.Model(&UserContent{}).Where("content_id = ?", uc.ContentID).Count(&remaining).Error; err != nil {
+		return err
+	}
+	if remaining == 0 {
+		if err := releaseContentVersions(tx, uc.ContentID); err != nil {
 			return err
 		}
 	}
@@ -274,6 +298,13 @@ func GetFileMeta(ctx context.Context, fileHash string) (*FileMeta, error) {
 	return &fm, nil
 }
 
+// UpdateFileMetaMedia 写入探测得到的媒体信息
+func UpdateFileMetaMedia(ctx context.Context, fileHash string, media map[string]interface{}) error {
+	return DB.WithContext(ctx).Model(&FileMeta{}).
+		Where("file_hash = ?", fileHash).
+		Updates(media).Error
+}
+
 // GetContentsByOwner 获取用户的所有内容
 func GetContentsByOwner(ctx context.Context, userID int) ([]Content, error) {
 	var contents []Content
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// ListFilesDueForScrub 列出从未巡检或最近一次确认早于 before 的文件，从未确认的优先。
+// 被隔离与等待删除（无引用）的文件不再巡检。
+func ListFilesDueForScrub(ctx context.Context, before time.Time, limit int) ([]FileMeta, error) {
+	var metas []FileMeta
+	err := DB.WithContext(ctx).
+		Where("quarantined_at IS NULL AND ref_count > 0").
+		Where("verified_at IS NULL OR verified_at < ?", before).
+		Order("verified_at").
+		Limit(limit).
+		Find(&metas).Error
+	return metas, err
+}
+
+// MarkFileVerified 记录内容与 hash 一致，同时解除隔离
+func MarkFileVerified(ctx context.Context, fileHash string, at time.Time) error {
+	return DB.WithContext(ctx).Model(&FileMeta{}).
+		Where("file_hash = ?", fileHash).
+		UpdateColumns(map[string]interface{}{
+			"verified_at":    at,
+			"quarantined_at": nil,
+		}).Error
+}
+
+// QuarantineFile 隔离内容与 hash 不符的文件。只有 verified_at 仍为巡检开始时读到的值才隔离：
+// 期间重新上传合并（会更新 verified_at）的文件内容已被替换，不应隔离。
+func QuarantineFile(ctx context.Context, fileHash string, seenVerifiedAt *time.Time, at time.Time) (bool, error) {
+	q := DB.WithContext(ctx).Model(&FileMeta{}).
+		Where("file_hash = ? AND quarantined_at IS NULL", fileHash)
+	if seenVerifiedAt == nil {
+		q = q.Where("verified_at IS NULL")
+	} else {
+		q = q.Where("verified_at = ?", *seenVerifiedAt)
+	}
+	res := q.UpdateColumn("quarantined_at", at)
+	return res.RowsAffected > 0, res.Error
+}
+
+// ListQuarantinedFiles 列出被隔离的文件
+func ListQuarantinedFiles(ctx context.Context) ([]FileMeta, error) {
+	var metas []FileMeta
+	err := DB.WithContext(ctx).
+		Where("quarantined_at IS NOT NULL").
+		Order("quarantined_at DESC").
+		Find(&metas).Error
+	return metas, err
+}
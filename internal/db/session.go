@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrUploadSessionNotFound = errors.New("upload session not found")
+
+// CreateUploadSession 创建上传会话
+func CreateUploadSession(ctx context.Context, s *UploadSession) error {
+	return DB.WithContext(ctx).Create(s).Error
+}
+
+// GetUploadSession 获取用户的上传会话
+func GetUploadSession(ctx context.Context, userID int, id string) (*UploadSession, error) {
+	var s UploadSession
+	if err := DB.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&s).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUploadSessionNotFound
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetLatestUploadSession 获取用户对某文件最近一次未结束的上传会话
+func GetLatestUploadSession(ctx context.Context, userID int, fileHash string) (*UploadSession, error) {
+	var s UploadSession
+	err := DB.WithContext(ctx).
+		Where("user_id = ? AND file_hash = ? AND status = ?", userID, fileHash, UploadSessionActive).
+		Order("created_at DESC").
+		First(&s).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUploadSessionNotFound
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ExtendUploadSession 续期上传会话
+func ExtendUploadSession(ctx context.Context, id string, expiresAt time.Time) error {
+	return DB.WithContext(ctx).Model(&UploadSession{}).
+		Where("id = ?", id).
+		Update("expires_at", expiresAt).Error
+}
+
+// FinishUploadSessions 结束用户对某文件所有未结束的上传会话
+func FinishUploadSessions(ctx context.Context, userID int, fileHash string, status int) error {
+	return DB.WithContext(ctx).Model(&UploadSession{}).
+		Where("user_id = ? AND file_hash = ? AND status = ?", userID, fileHash, UploadSessionActive).
+		Update("status", status).Error
+}
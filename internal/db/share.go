@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrShareNotFound = errors.New("share not found")
+
+// CreateShare 创建分享
+func CreateShare(ctx context.Context, share *Share) error {
+	return DB.WithContext(ctx).Create(share).Error
+}
+
+// ListShares 获取用户创建的所有分享
+func ListShares(ctx context.Context, userID int) ([]Share, error) {
+	var shares []Share
+	err := DB.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&shares).Error
+	return shares, err
+}
+
+// GetShareByToken 根据令牌获取分享
+func GetShareByToken(ctx context.Context, token string) (*Share, error) {
+	var share Share
+	if err := DB.WithContext(ctx).Where("token = ?", token).First(&share).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShareNotFound
+		}
+		return nil, err
+	}
+	return &share, nil
+}
+
+// GetUserContentByID 根据 ID 获取用户内容记录
+func GetUserContentByID(ctx context.Context, id uint) (*UserContent, error) {
+	var uc UserContent
+	if err := DB.WithContext(ctx).Where("id = ?", id).First(&uc).Error; err != nil {
+		return nil, err
+	}
+	return &uc, nil
+}
+
+// RevokeShare 撤销分享（只能撤销自己的）
+func RevokeShare(ctx context.Context, userID int, shareID uint) error {
+	res := DB.WithContext(ctx).Model(&Share{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", shareID, userID).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+// RecordShareView 记录一次打开
+func RecordShareView(ctx context.Context, shareID uint) error {
+	return DB.WithContext(ctx).Model(&Share{}).
+		Where("id = ?", shareID).
+		Updates(map[string]interface{}{
+			"views":          gorm.Expr("views + ?", 1),
+			"last_access_at": time.Now(),
+		}).Error
+}
+
+// ConsumeShareDownload 占用一次下载次数，次数已用完时返回 false
+func ConsumeShareDownload(ctx context.Context, shareID uint) (bool, error) {
+	res := DB.WithContext(ctx).Model(&Share{}).
+		Where("id = ? AND (max_downloads = 0 OR downloads < max_downloads)", shareID).
+		Updates(map[string]interface{}{
+			"downloads":      gorm.Expr("downloads + ?", 1),
+			"last_access_at": time.Now(),
+		})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
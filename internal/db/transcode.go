@@ -0,0 +1,294 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrJobNotClaimable = errors.New("job is not pending or not due")
+	ErrJobLeaseLost    = errors.New("job is no longer owned by this worker")
+)
+
+// CreateTranscodeJobs 为 content 创建转码任务（同规格已有未完成任务时复用），并把用户内容标记为转码中
+func CreateTranscodeJobs(ctx context.Context, userID int, contentID uint, sourceHash string, presets []string, maxAttempts int) ([]TranscodeJob, error) {
+	tx := DB.WithContext(ctx).Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// 锁住 content，避免并发提交同一规格
+	var ct Content
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", contentID).First(&ct).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	now := time.Now()
+	jobs := make([]TranscodeJob, 0, len(presets))
+	for _, preset := range presets {
+		var job TranscodeJob
+		err := tx.Where("content_id = ? AND preset = ? AND status IN ?", contentID, preset, []int{JobPending, JobRunning}).
+			First(&job).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			job = TranscodeJob{
+				ContentID:   contentID,
+				UserID:      userID,
+				SourceHash:  sourceHash,
+				Preset:      preset,
+				Status:      JobPending,
+				MaxAttempts: maxAttempts,
+				NextRunAt:   now,
+			}
+			if err := tx.Create(&job).Error; err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		} else if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := tx.Model(&UserContent{}).
+		Where("content_id = ? AND status = 1", contentID).
+		Updates(map[string]interface{}{
+			"status":     2,
+			"updated_at": now,
+		}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// GetTranscodeJob 获取转码任务
+func GetTranscodeJob(ctx context.Context, jobID uint) (*TranscodeJob, error) {
+	var job TranscodeJob
+	if err := DB.WithContext(ctx).Where("id = ?", jobID).First(&job).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ListTranscodeJobs 获取 content 下的所有转码任务
+func ListTranscodeJobs(ctx context.Context, contentID uint) ([]TranscodeJob, error) {
+	var jobs []TranscodeJob
+	err := DB.WithContext(ctx).
+		Where("content_id = ?", contentID).
+		Order("created_at DESC").
+		Find(&jobs).Error
+	return jobs, err
+}
+
+// ListDueTranscodeJobs 获取已到执行时间的等待任务（用于重新入队）
+func ListDueTranscodeJobs(ctx context.Context, now time.Time, limit int) ([]TranscodeJob, error) {
+	var jobs []TranscodeJob
+	err := DB.WithContext(ctx).
+		Where("status = ? AND next_run_at <= ?", JobPending, now).
+		Order("next_run_at").
+		Limit(limit).
+		Find(&jobs).Error
+	return jobs, err
+}
+
+// ListRunningTranscodeJobs 获取执行中的任务（用于检查租约）
+func ListRunningTranscodeJobs(ctx context.Context) ([]TranscodeJob, error) {
+	var jobs []TranscodeJob
+	err := DB.WithContext(ctx).Where("status = ?", JobRunning).Find(&jobs).Error
+	return jobs, err
+}
+
+// ClaimTranscodeJob 认领任务：只有等待中且已到期的任务可以被认领，认领计为一次执行
+func ClaimTranscodeJob(ctx context.Context, jobID uint, workerID string) (*TranscodeJob, error) {
+	now := time.Now()
+	res := DB.WithContext(ctx).Model(&TranscodeJob{}).
+		Where("id = ? AND status = ? AND next_run_at <= ?", jobID, JobPending, now).
+		Updates(map[string]interface{}{
+			"status":     JobRunning,
+			"worker_id":  workerID,
+			"attempts":   gorm.Expr("attempts + ?", 1),
+			"progress":   0,
+			"started_at": now,
+		})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrJobNotClaimable
+	}
+	return GetTranscodeJob(ctx, jobID)
+}
+
+// UpdateTranscodeProgress 更新任务进度
+func UpdateTranscodeProgress(ctx context.Context, jobID uint, workerID string, progress int) error {
+	res := DB.WithContext(ctx).Model(&TranscodeJob{}).
+		Where("id = ? AND status = ? AND worker_id = ?", jobID, JobRunning, workerID).
+		Update("progress", progress)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrJobLeaseLost
+	}
+	return nil
+}
+
+// CompleteTranscodeJob 任务成功：登记产物 FileMeta 并作为版本挂在同一 content 下，然后结束任务
+func CompleteTranscodeJob(ctx context.Context, jobID uint, workerID string, contentID uint, preset, fileHash, filePath string, fileSize int64) error {
+	tx := DB.WithContext(ctx).Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	now := time.Now()
+	res := tx.Model(&TranscodeJob{}).
+		Where("id = ? AND status = ? AND worker_id = ?", jobID, JobRunning, workerID).
+		Updates(map[string]interface{}{
+			"status":      JobSucceeded,
+			"progress":    100,
+			"output_hash": fileHash,
+			"last_error":  "",
+			"finished_at": now,
+		})
+	if res.Error != nil {
+		tx.Rollback()
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		tx.Rollback()
+		return ErrJobLeaseLost
+	}
+
+	// 产物登记为 content 的版本，由版本记录持有引用
+	var fm FileMeta
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("file_hash = ?", fileHash).First(&fm).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			tx.Rollback()
+			return err
+		}
+		fm = FileMeta{
+			FileHash:   fileHash,
+			ContentID:  contentID,
+			FilePath:   filePath,
+			FileSize:   fileSize,
+			RefCount:   0,
+			VerifiedAt: &now,
+			CreatedAt:  now,
+		}
+		if err := tx.Create(&fm).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := addContentVersion(tx, contentID, fileHash, "transcode", preset); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := finishContentTranscoding(tx, contentID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// FailTranscodeJob 任务失败：retryAt 非 nil 时回到等待状态，否则标记为失败
+func FailTranscodeJob(ctx context.Context, jobID uint, workerID string, contentID uint, errMsg string, retryAt *time.Time) error {
+	tx := DB.WithContext(ctx).Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	updates := map[string]interface{}{
+		"last_error": errMsg,
+		"worker_id":  "",
+	}
+	if retryAt != nil {
+		updates["status"] = JobPending
+		updates["next_run_at"] = *retryAt
+	} else {
+		updates["status"] = JobFailed
+		updates["finished_at"] = time.Now()
+	}
+
+	res := tx.Model(&TranscodeJob{}).
+		Where("id = ? AND status = ? AND worker_id = ?", jobID, JobRunning, workerID).
+		Updates(updates)
+	if res.Error != nil {
+		tx.Rollback()
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		tx.Rollback()
+		return ErrJobLeaseLost
+	}
+
+	if retryAt == nil {
+		if err := finishContentTranscoding(tx, contentID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+// ReleaseTranscodeJob 服务关闭时归还任务，不计入执行次数
+func ReleaseTranscodeJob(ctx context.Context, jobID uint, workerID string) error {
+	return DB.WithContext(ctx).Model(&TranscodeJob{}).
+		Where("id = ? AND status = ? AND worker_id = ?", jobID, JobRunning, workerID).
+		Updates(map[string]interface{}{
+			"status":      JobPending,
+			"worker_id":   "",
+			"progress":    0,
+			"attempts":    gorm.Expr("GREATEST(attempts - ?, 0)", 1),
+			"next_run_at": time.Now(),
+		}).Error
+}
+
+// RecoverTranscodeJob 回收租约已过期的执行中任务（worker 异常退出），返回回收后的状态
+func RecoverTranscodeJob(ctx context.Context, job *TranscodeJob) (int, error) {
+	if job.Attempts >= job.MaxAttempts {
+		return JobFailed, FailTranscodeJob(ctx, job.ID, job.WorkerID, job.ContentID, "worker lease expired", nil)
+	}
+	now := time.Now()
+	return JobPending, FailTranscodeJob(ctx, job.ID, job.WorkerID, job.ContentID, "worker lease expired", &now)
+}
+
+// finishContentTranscoding content 下没有未完成任务时，把转码中的用户内容恢复为已完成
+func finishContentTranscoding(tx *gorm.DB, contentID uint) error {
+	var active int64
+	if err := tx.Model(&TranscodeJob{}).
+		Where("content_id = ? AND status IN ?", contentID, []int{JobPending, JobRunning}).
+		Count(&active).Error; err != nil {
+		return err
+	}
+	if active > 0 {
+		return nil
+	}
+	return tx.Model(&UserContent{}).
+		Where("content_id = ? AND status = 2", contentID).
+		Updates(map[string]interface{}{
+			"status":     1,
+			"updated_at": time.Now(),
+		}).Error
+}
@@ -0,0 +1,309 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrVersionExists    = errors.New("file is already a version of this content")
+	ErrVersionNotFound  = errors.New("version not found")
+	ErrNoDefaultHistory = errors.New("no earlier default version to roll back to")
+)
+
+// ListContentVersions 获取 content 的所有版本（不含源文件）
+func ListContentVersions(ctx context.Context, contentID uint) ([]ContentVersion, error) {
+	var versions []ContentVersion
+	err := DB.WithContext(ctx).
+		Where("content_id = ?", contentID).
+		Order("created_at").
+		Find(&versions).Error
+	return versions, err
+}
+
+// AddContentVersion 把已存在的 FileMeta 登记为 content 的一个版本
+func AddContentVersion(ctx context.Context, contentID uint, fileHash, kind, label string) error {
+	tx := DB.WithContext(ctx).Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var ct Content
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", contentID).First(&ct).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if ct.SourceHash == fileHash {
+		tx.Rollback()
+		return ErrVersionExists
+	}
+
+	added, err := addContentVersion(tx, contentID, fileHash, kind, label)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if !added {
+		tx.Rollback()
+		return ErrVersionExists
+	}
+
+	return tx.Commit().Error
+}
+
+// RemoveContentVersion 移除版本并释放引用
+func RemoveContentVersion(ctx context.Context, contentID uint, fileHash string) error {
+	tx := DB.WithContext(ctx).Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var ct Content
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", contentID).First(&ct).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	res := tx.Where("content_id = ? AND file_hash = ?", contentID, fileHash).Delete(&ContentVersion{})
+	if res.Error != nil {
+		tx.Rollback()
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		tx.Rollback()
+		return ErrVersionNotFound
+	}
+
+	if err := forgetDefault(tx, &ct, fileHash); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := releaseFileRef(tx, fileHash); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// SetContentDefault 设置默认播放版本（源文件或已登记的版本），并记录变更以便回滚
+func SetContentDefault(ctx context.Context, contentID uint, fileHash string) error {
+	tx := DB.WithContext(ctx).Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var ct Content
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", contentID).First(&ct).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if fileHash != ct.SourceHash {
+		var count int64
+		if err := tx.Model(&ContentVersion{}).
+			Where("content_id = ? AND file_hash = ?", contentID, fileHash).
+			Count(&count).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if count == 0 {
+			tx.Rollback()
+			return ErrVersionNotFound
+		}
+	}
+
+	if err := tx.Model(&ct).Update("default_hash", fileHash).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Create(&ContentDefaultLog{
+		ContentID: contentID,
+		FileHash:  fileHash,
+		CreatedAt: time.Now(),
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// RollbackContentDefault 撤销最近一次默认版本设置，返回回滚后的默认版本（空表示源文件）
+func RollbackContentDefault(ctx context.Context, contentID uint) (string, error) {
+	tx := DB.WithContext(ctx).Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var ct Content
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", contentID).First(&ct).Error; err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	var latest ContentDefaultLog
+	if err := tx.Where("content_id = ?", contentID).Order("id DESC").First(&latest).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrNoDefaultHistory
+		}
+		return "", err
+	}
+	if err := tx.Delete(&latest).Error; err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	previous := ""
+	var prev ContentDefaultLog
+	if err := tx.Where("content_id = ?", contentID).Order("id DESC").First(&prev).Error; err == nil {
+		previous = prev.FileHash
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		tx.Rollback()
+		return "", err
+	}
+
+	if err := tx.Model(&ct).Update("default_hash", previous).Error; err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return "", err
+	}
+	return previous, nil
+}
+
+// GetAccessibleVersion 查找用户可访问的版本：版本所属 content 下有该用户的内容记录
+func GetAccessibleVersion(ctx context.Context, userID int, fileHash string) (*ContentVersion, *Content, error) {
+	var v ContentVersion
+	err := DB.WithContext(ctx).
+		Joins("JOIN user_contents ON user_contents.content_id = content_versions.content_id").
+		Where("user_contents.user_id = ? AND content_versions.file_hash = ?", userID, fileHash).
+		First(&v).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	var ct Content
+	if err := DB.WithContext(ctx).Where("id = ?", v.ContentID).First(&ct).Error; err != nil {
+		return nil, nil, err
+	}
+	return &v, &ct, nil
+}
+
+// addContentVersion 在事务中登记版本并增加引用，版本已存在时返回 false
+func addContentVersion(tx *gorm.DB, contentID uint, fileHash, kind, label string) (bool, error) {
+	var count int64
+	if err := tx.Model(&ContentVersion{}).
+		Where("content_id = ? AND file_hash = ?", contentID, fileHash).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+
+	res := tx.Model(&FileMeta{}).
+		Where("file_hash = ?", fileHash).
+		UpdateColumn("ref_count", gorm.Expr("ref_count + ?", 1))
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return false, gorm.ErrRecordNotFound
+	}
+
+	if err := tx.Create(&ContentVersion{
+		ContentID: contentID,
+		FileHash:  fileHash,
+		Kind:      kind,
+		Label:     label,
+		CreatedAt: time.Now(),
+	}).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// releaseContentVersions content 不再被任何用户引用时，移除其所有版本并释放引用
+func releaseContentVersions(tx *gorm.DB, contentID uint) error {
+	var versions []ContentVersion
+	if err := tx.Where("content_id = ?", contentID).Find(&versions).Error; err != nil {
+		return err
+	}
+
+	for _, v := range versions {
+		if err := tx.Delete(&v).Error; err != nil {
+			return err
+		}
+		if err := releaseFileRef(tx, v.FileHash); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Where("content_id = ?", contentID).Delete(&ContentDefaultLog{}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&Content{}).Where("id = ?", contentID).Update("default_hash", "").Error
+}
+
+// forgetDefault 版本被移除后清理默认版本与其变更记录
+func forgetDefault(tx *gorm.DB, ct *Content, fileHash string) error {
+	if err := tx.Where("content_id = ? AND file_hash = ?", ct.ID, fileHash).Delete(&ContentDefaultLog{}).Error; err != nil {
+		return err
+	}
+	if ct.DefaultHash != fileHash {
+		return nil
+	}
+
+	previous := ""
+	var prev ContentDefaultLog
+	if err := tx.Where("content_id = ?", ct.ID).Order("id DESC").First(&prev).Error; err == nil {
+		previous = prev.FileHash
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return tx.Model(ct).Update("default_hash", previous).Error
+}
+
+// releaseFileRef 释放 FileMeta 的一个引用。引用归零时保留元数据并登记待删除，
+// 存储文件由删除 worker 在宽限期后确认仍无引用再删除
+func releaseFileRef(tx *gorm.DB, fileHash string) error {
+	var fm FileMeta
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("file_hash = ?", fileHash).First(&fm).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if fm.RefCount > 1 {
+		return tx.Model(&FileMeta{}).
+			Where("file_hash = ?", fileHash).
+			UpdateColumn("ref_count", gorm.Expr("ref_count - ?", 1)).Error
+	}
+
+	if err := tx.Model(&FileMeta{}).Where("file_hash = ?", fileHash).UpdateColumn("ref_count", 0).Error; err != nil {
+		return err
+	}
+	return schedulePendingDeletion(tx, fileHash)
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net/http"
+	"time"
+
+	"video-platform/internal/logic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RunGCRequest 触发垃圾回收请求
+type RunGCRequest struct {
+	DryRun bool `json:"dry_run"`
+}
+
+// RunGC 立即执行一次垃圾回收，dry_run 时只返回将被回收的对象
+func RunGC(c *gin.Context) {
+	var req RunGCRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Minute)
+	defer cancel()
+
+	report, err := logic.RunGC(ctx, req.DryRun)
+	if err != nil {
+		switch {
+		case errors.Is(err, logic.ErrGCRunning):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, logic.ErrGCUnsupported):
+			c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// GetGCReport 本节点最近一次垃圾回收的结果
+func GetGCReport(c *gin.Context) {
+	report := logic.LastGCReport()
+	if report == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "gc has not run on this node"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// RunFsckRequest 触发一致性检查请求
+type RunFsckRequest struct {
+	Repair bool `json:"repair"`
+}
+
+// RunFsck 立即执行一次一致性检查，repair 时修复能自动修复的问题
+func RunFsck(c *gin.Context) {
+	var req RunFsckRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Minute)
+	defer cancel()
+
+	report, err := logic.RunFsck(ctx, req.Repair)
+	if err != nil {
+		if errors.Is(err, logic.ErrFsckRunning) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// GetFsckReport 本节点最近一次一致性检查的结果
+func GetFsckReport(c *gin.Context) {
+	report := logic.LastFsckReport()
+	if report == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "fsck has not run on this node"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// ListQuarantinedFiles 列出巡检发现内容损坏而被隔离的文件
+func ListQuarantinedFiles(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	files, err := logic.ListQuarantinedFiles(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"files": files})
+}
+
+// ScrubFile 立即重新校验一个文件，内容一致时解除隔离
+func ScrubFile(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Minute)
+	defer cancel()
+
+	result, err := logic.ScrubFile(ctx, c.Param("hash"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// GetDedupReport 块级去重的全局与各用户统计
+func GetDedupReport(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Minute)
+	defer cancel()
+
+	report, err := logic.GetDedupReport(ctx)
+	if err != nil {
+		if errors.Is(err, logic.ErrDedupDisabled) {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// Metrics 以 expvar JSON 格式输出运行指标（含 gc 累计计数）
+func Metrics(c *gin.Context) {
+	expvar.Handler().ServeHTTP(c.Writer, c.Request)
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"video-platform/internal/hls"
+	"video-platform/internal/logic"
+	"video-platform/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const m3u8ContentType = "application/vnd.apple.mpegurl"
+
+// HLSMaster 主播放列表
+func HLSMaster(c *gin.Context) {
+	p, ok := loadPresentation(c)
+	if !ok {
+		return
+	}
+	c.Data(http.StatusOK, m3u8ContentType, []byte(p.MasterPlaylist(playlistQuery(c))))
+}
+
+// HLSTrackFile 媒体播放列表 / 初始化段 / 媒体分段
+func HLSTrackFile(c *gin.Context) {
+	p, ok := loadPresentation(c)
+	if !ok {
+		return
+	}
+
+	track, err := p.Track(c.Param("track"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	file := c.Param("file")
+	switch {
+	case file == "index.m3u8":
+		c.Data(http.StatusOK, m3u8ContentType, []byte(track.MediaPlaylist(playlistQuery(c))))
+
+	case file == "init.mp4":
+		c.Data(http.StatusOK, "video/mp4", track.InitSegment())
+
+	case strings.HasPrefix(file, "seg") && strings.HasSuffix(file, ".m4s"):
+		index, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(file, "seg"), ".m4s"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "segment not found"})
+			return
+		}
+		size, err := track.SegmentSize(index)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Content-Type", "video/mp4")
+		c.Header("Content-Length", strconv.FormatInt(size, 10))
+		c.Status(http.StatusOK)
+		if c.Request.Method == http.MethodHead {
+			return
+		}
+		if err := track.WriteSegment(c.Writer, index, logic.HLSRangeReader(c.Param("hash"))); err != nil {
+			if errors.Is(err, hls.ErrSegmentNotFound) {
+				return
+			}
+			log.Printf("HLS segment %s/%s/%s write failed: %v", c.Param("hash"), track.Name, file, err)
+		}
+
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	}
+}
+
+// playlistQuery 通过签名链接访问时，播放列表中的 URI 需要带上同样的签名参数
+func playlistQuery(c *gin.Context) string {
+	if !c.GetBool("signed_url") {
+		return ""
+	}
+	q := c.Request.URL.Query()
+	signed := url.Values{}
+	for _, key := range []string{utils.PlaybackUserParam, utils.PlaybackExpiresParam, utils.PlaybackSigParam} {
+		signed.Set(key, q.Get(key))
+	}
+	return "?" + signed.Encode()
+}
+
+func loadPresentation(c *gin.Context) (*hls.Presentation, bool) {
+	userID := getUserID(c)
+	fileHash := c.Param("hash")
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	p, err := logic.GetHLSPresentation(ctx, userID, fileHash)
+	if err != nil {
+		if errors.Is(err, logic.ErrHLSUnsupported) {
+			log.Printf("HLS unavailable for %s: %v", fileHash, err)
+		}
+		if errors.Is(err, logic.ErrFileQuarantined) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return p, true
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"video-platform/internal/logic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateShareRequest 创建分享请求
+type CreateShareRequest struct {
+	FileHash     string `json:"file_hash" binding:"required"`
+	Password     string `json:"password"`
+	ExpiresIn    int64  `json:"expires_in"` // 秒，0 表示永不过期
+	MaxDownloads int    `json:"max_downloads"`
+	ViewOnly     bool   `json:"view_only"`
+}
+
+// UnlockShareRequest 输入分享密码
+type UnlockShareRequest struct {
+	Password string `json:"password"`
+}
+
+// CreateShare 创建分享链接
+func CreateShare(c *gin.Context) {
+	var req CreateShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	share, err := logic.CreateShare(ctx, logic.CreateShareParams{
+		UserID:       getUserID(c),
+		FileHash:     req.FileHash,
+		Password:     req.Password,
+		ExpiresIn:    req.ExpiresIn,
+		MaxDownloads: req.MaxDownloads,
+		ViewOnly:     req.ViewOnly,
+	})
+	if err != nil {
+		writeShareError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, share)
+}
+
+// ListShares 列出自己创建的分享
+func ListShares(c *gin.Context) {
+	shares, err := logic.ListShares(c.Request.Context(), getUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shares": shares})
+}
+
+// RevokeShare 撤销分享
+func RevokeShare(c *gin.Context) {
+	if err := logic.RevokeShare(c.Request.Context(), getUserID(c), c.Param("id")); err != nil {
+		writeShareError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "revoked"})
+}
+
+// GetPublicShare 访问者获取分享信息（无需登录）
+func GetPublicShare(c *gin.Context) {
+	info, err := logic.GetPublicShare(c.Request.Context(), c.Param("token"))
+	if err != nil {
+		writeShareError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+// UnlockShare 校验分享密码并返回访问凭证
+func UnlockShare(c *gin.Context) {
+	var req UnlockShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	grant, expiresAt, err := logic.UnlockShare(c.Request.Context(), c.Param("token"), req.Password)
+	if err != nil {
+		writeShareError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"grant": grant, "expires_at": expiresAt})
+}
+
+// StreamShare 在线播放分享的文件
+func StreamShare(c *gin.Context) {
+	serveShare(c, false)
+}
+
+// DownloadShare 下载分享的文件
+func DownloadShare(c *gin.Context) {
+	serveShare(c, true)
+}
+
+func serveShare(c *gin.Context, download bool) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	result, resume, err := logic.OpenShareFile(ctx, c.Param("token"), c.Query("grant"), c.Query("resume"), downloadRequest(c), download)
+	if err != nil {
+		writeShareError(c, err)
+		return
+	}
+	if resume != "" {
+		// 断点续传时带 ?resume= 不再占用下载次数
+		c.Header("X-Share-Resume", resume)
+	}
+
+	disposition := "inline"
+	if download {
+		disposition = "attachment"
+	}
+	writeDownload(c, result, disposition)
+}
+
+// writeShareError 分享接口的错误映射
+func writeShareError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, logic.ErrShareRevoked), errors.Is(err, logic.ErrShareExpired),
+		errors.Is(err, logic.ErrShareLimitReached):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, logic.ErrSharePasswordRequired):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, logic.ErrSharePasswordWrong), errors.Is(err, logic.ErrShareViewOnly),
+		errors.Is(err, logic.ErrShareDownloadOnly):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, logic.ErrFileQuarantined):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case errors.Is(err, logic.ErrShareInvalidParams):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, logic.ErrShareNotFound), errors.Is(err, logic.ErrShareFileNotOwned):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"video-platform/internal/logic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateTranscodeRequest 提交转码请求
+type CreateTranscodeRequest struct {
+	Presets []string `json:"presets" binding:"required,min=1"`
+}
+
+// CreateTranscodeJobs 为内容提交转码任务
+func CreateTranscodeJobs(c *gin.Context) {
+	var req CreateTranscodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := getUserID(c)
+	contentID := c.Param("id")
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	jobs, err := logic.CreateTranscodeJobs(ctx, userID, contentID, req.Presets)
+	if err != nil {
+		switch {
+		case errors.Is(err, logic.ErrTranscodeDisabled):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		case errors.Is(err, logic.ErrUnknownPreset), errors.Is(err, logic.ErrPresetExceedsSource):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, logic.ErrSourceNotReady):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"jobs": jobs})
+}
+
+// ListTranscodeJobs 列出内容下的转码任务
+func ListTranscodeJobs(c *gin.Context) {
+	userID := getUserID(c)
+	contentID := c.Param("id")
+
+	jobs, err := logic.ListTranscodeJobs(c.Request.Context(), userID, contentID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+// GetTranscodeJob 获取转码任务进度
+func GetTranscodeJob(c *gin.Context) {
+	userID := getUserID(c)
+	jobID := c.Param("id")
+
+	job, err := logic.GetTranscodeJob(c.Request.Context(), userID, jobID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
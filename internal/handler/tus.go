@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"video-platform/internal/logic"
+	"video-platform/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tus 1.0 协议常量
+const (
+	TusVersion           = "1.0.0"
+	TusExtensions        = "creation,termination,checksum"
+	TusChecksumAlgorithm = "md5,sha1,sha256"
+	TusBasePath          = "/api/v1/tus/"
+
+	// StatusChecksumMismatch tus checksum 扩展定义的状态码
+	StatusChecksumMismatch = 460
+)
+
+// TusOptions 返回服务端支持的 tus 版本与扩展
+func TusOptions(c *gin.Context) {
+	c.Header("Tus-Resumable", TusVersion)
+	c.Header("Tus-Version", TusVersion)
+	c.Header("Tus-Extension", TusExtensions)
+	c.Header("Tus-Checksum-Algorithm", TusChecksumAlgorithm)
+	c.Status(http.StatusNoContent)
+}
+
+// TusResumable 检查 Tus-Resumable 请求头并在响应中带上协议版本
+func TusResumable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", TusVersion)
+		if c.GetHeader("Tus-Resumable") != TusVersion {
+			c.Header("Tus-Version", TusVersion)
+			c.AbortWithStatus(http.StatusPreconditionFailed)
+			return
+		}
+		c.Next()
+	}
+}
+
+// TusCreate 创建上传（creation 扩展）。Upload-Metadata 需包含 filename 与 filehash（"sha256:<hex>"，旧客户端为文件 MD5），
+// filehash 为 md5 时可带 stronghash（"sha256:<hex>"）以便秒传
+func TusCreate(c *gin.Context) {
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length required (Upload-Defer-Length is not supported)"})
+		return
+	}
+
+	meta := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	fileName := firstNonEmpty(meta["filename"], meta["name"])
+	fileHash := firstNonEmpty(meta["filehash"], meta["md5"])
+	if fileName == "" || fileHash == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Metadata must contain filename and filehash"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	upload, err := logic.CreateTusUpload(ctx, getUserID(c), fileName, fileHash, meta["stronghash"], length)
+	if err != nil {
+		writeTusError(c, err)
+		return
+	}
+
+	c.Header("Location", TusBasePath+upload.ID)
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Status(http.StatusCreated)
+}
+
+// TusHead 查询上传进度
+func TusHead(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 300*time.Second)
+	defer cancel()
+
+	upload, err := logic.GetTusUpload(ctx, getUserID(c), c.Param("id"))
+	if err != nil {
+		writeTusError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Status(http.StatusOK)
+}
+
+// TusPatch 从 Upload-Offset 起追加数据，可带 Upload-Checksum（checksum 扩展）
+func TusPatch(c *gin.Context) {
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.AbortWithStatus(http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Offset"})
+		return
+	}
+
+	var checksum *store.ChunkChecksum
+	if h := c.GetHeader("Upload-Checksum"); h != "" {
+		algorithm, encoded, _ := strings.Cut(h, " ")
+		sum, err := base64.StdEncoding.DecodeString(encoded)
+		if err == nil {
+			checksum, err = store.NewChunkChecksum(algorithm, sum)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Checksum"})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 300*time.Second)
+	defer cancel()
+
+	upload, err := logic.WriteTusUpload(ctx, getUserID(c), c.Param("id"), offset, c.Request.Body, checksum)
+	if err != nil {
+		writeTusError(c, err)
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// TusDelete 终止上传（termination 扩展）
+func TusDelete(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := logic.TerminateTusUpload(ctx, getUserID(c), c.Param("id")); err != nil {
+		writeTusError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// parseTusMetadata 解析 "key base64value,key2 base64value2"
+func parseTusMetadata(header string) map[string]string {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		meta[key] = string(value)
+	}
+	return meta
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// writeTusError tus 接口的错误映射
+func writeTusError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, logic.ErrTusNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, logic.ErrTusGone), errors.Is(err, logic.ErrUploadCancelled):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, logic.ErrTusOffsetMismatch):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, logic.ErrTusLocked):
+		c.JSON(http.StatusLocked, gin.H{"error": err.Error()})
+	case errors.Is(err, logic.ErrTusTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	case errors.Is(err, logic.ErrTusChecksumMismatch):
+		c.JSON(StatusChecksumMismatch, gin.H{"error": err.Error()})
+	case errors.Is(err, logic.ErrMergeVerifyFailed):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, logic.ErrInvalidFileSize), errors.Is(err, logic.ErrInvalidFileHash), errors.Is(err, logic.ErrInvalidStrongHash):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
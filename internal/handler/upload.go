@@ -2,6 +2,8 @@ package handler
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -10,6 +12,7 @@ import (
 	"strconv"
 	"time"
 
+	"video-platform/internal/db"
 	"video-platform/internal/logic"
 
 	"github.com/gin-gonic/gin"
@@ -20,6 +23,8 @@ type InitUploadRequest struct {
 	FileName string `json:"file_name" binding:"required"`
 	FileHash string `json:"file_hash" binding:"required"`
 	FileSize int64  `json:"file_size" binding:"required"`
+	// StrongHash 文件的 SHA-256（"sha256:<hex>"），可选。file_hash 为 md5 时需要它才能秒传
+	StrongHash string `json:"strong_hash"`
 }
 
 // InitUpload 初始化上传
@@ -34,27 +39,46 @@ func InitUpload(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
 	defer cancel()
 
-	result, err := logic.InitUpload(ctx, userID, req.FileName, req.FileHash)
+	result, err := logic.InitUpload(ctx, userID, req.FileName, req.FileHash, req.StrongHash, req.FileSize)
 	if err != nil {
+		if errors.Is(err, logic.ErrInvalidFileSize) || errors.Is(err, logic.ErrInvalidFileHash) || errors.Is(err, logic.ErrInvalidStrongHash) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	resp := gin.H{
 		"status":          result.Status,
 		"content_id":      result.ContentID,
 		"uploaded_chunks": result.UploadedChunks,
-	})
+	}
+	if result.Challenge != nil {
+		resp["challenge"] = result.Challenge
+	}
+	if result.SessionID != "" {
+		resp["session_id"] = result.SessionID
+		resp["chunk_size"] = result.ChunkSize
+		resp["total_chunks"] = result.TotalChunks
+		resp["expires_at"] = result.ExpiresAt
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
 // UploadChunkRequest 上传分块请求
 type UploadChunkRequest struct {
+	SessionID   string `form:"session_id"`
 	ContentID   uint   `form:"content_id" binding:"required"`
 	FileHash    string `form:"file_hash" binding:"required"`
 	ChunkIndex  int    `form:"chunk_index"`
 	TotalChunks int    `form:"total_chunks" binding:"required"`
+	Checksum    string `form:"chunk_checksum"` // 也可以放在 X-Chunk-Checksum 头中
 }
 
+// ChunkChecksumHeader 分片摘要请求头，格式 "算法:十六进制摘要"（md5 / sha1 / sha256）
+const ChunkChecksumHeader = "X-Chunk-Checksum"
+
 // UploadChunk 上传分块
 func UploadChunk(c *gin.Context) {
 	var req UploadChunkRequest
@@ -97,39 +121,22 @@ func UploadChunk(c *gin.Context) {
 	// 上传分片
 	params := logic.UploadChunkParams{
 		UserID:      userID,
+		SessionID:   req.SessionID,
 		FileHash:    req.FileHash,
 		ContentID:   req.ContentID,
 		ChunkIndex:  req.ChunkIndex,
 		TotalChunks: req.TotalChunks,
+		Checksum:    req.Checksum,
+		Size:        file.Size,
 		Content:     src,
 	}
+	if h := c.GetHeader(ChunkChecksumHeader); h != "" {
+		params.Checksum = h
+	}
 
-	err = logic.UploadChunk(ctx, params)
-	if err != nil {
+	if err := logic.UploadChunk(ctx, params); err != nil {
 		log.Printf("UploadChunk logic error: %v", err)
-		if errors.Is(err, logic.ErrUploadAlreadyCompleted) {
-			c.JSON(http.StatusConflict, gin.H{
-				"error":   "Upload already completed",
-				"message": "This file has been uploaded",
-			})
-			return
-		}
-		if errors.Is(err, logic.ErrUploadCancelled) {
-			c.JSON(http.StatusGone, gin.H{
-				"error":   "Upload cancelled",
-				"message": "Please reinitialize upload",
-			})
-			return
-		}
-		if errors.Is(err, logic.ErrChunkAlreadyUploaded) {
-			// 幂等性：分片已上传，返回成功
-			c.JSON(http.StatusOK, gin.H{
-				"status":      "chunk_exists",
-				"chunk_index": req.ChunkIndex,
-			})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeUploadChunkError(c, err, req.ChunkIndex)
 		return
 	}
 
@@ -140,8 +147,101 @@ func UploadChunk(c *gin.Context) {
 	})
 }
 
+// writeUploadChunkError 上传分片的错误响应
+func writeUploadChunkError(c *gin.Context, err error, index int) {
+	if errors.Is(err, logic.ErrUploadAlreadyCompleted) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Upload already completed",
+			"message": "This file has been uploaded",
+		})
+		return
+	}
+	if errors.Is(err, logic.ErrUploadCancelled) {
+		c.JSON(http.StatusGone, gin.H{
+			"error":   "Upload cancelled",
+			"message": "Please reinitialize upload",
+		})
+		return
+	}
+	if errors.Is(err, logic.ErrChunkAlreadyUploaded) {
+		// 幂等性：分片已上传，返回成功
+		c.JSON(http.StatusOK, gin.H{
+			"status":      "chunk_exists",
+			"chunk_index": index,
+		})
+		return
+	}
+	if writeChunkError(c, err) {
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// UploadSessionHeader 原始分片上传时携带会话 ID 的请求头（也可用 session_id 查询参数）
+const UploadSessionHeader = "X-Upload-Session"
+
+// UploadChunkRaw 以 application/octet-stream 请求体上传分片，直接流式写入存储，
+// 不经过 multipart 解析与临时文件
+func UploadChunkRaw(c *gin.Context) {
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chunk index"})
+		return
+	}
+	if ct := c.ContentType(); ct != "" && ct != "application/octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/octet-stream"})
+		return
+	}
+
+	sessionID := c.GetHeader(UploadSessionHeader)
+	if sessionID == "" {
+		sessionID = c.Query("session_id")
+	}
+	if sessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "session_id required"})
+		return
+	}
+
+	checksum := c.GetHeader(ChunkChecksumHeader)
+	if checksum == "" {
+		if md5b64 := c.GetHeader("Content-MD5"); md5b64 != "" {
+			sum, err := base64.StdEncoding.DecodeString(md5b64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Content-MD5"})
+				return
+			}
+			checksum = "md5:" + hex.EncodeToString(sum)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+	defer cancel()
+
+	// 大小上限由会话决定：Content-Length 不符时直接拒绝，
+	// 分块传输时最多读到会话分片大小加一个字节
+	params := logic.UploadChunkParams{
+		UserID:     getUserID(c),
+		SessionID:  sessionID,
+		ChunkIndex: index,
+		Checksum:   checksum,
+		Size:       c.Request.ContentLength,
+		Content:    c.Request.Body,
+	}
+	if err := logic.UploadChunk(ctx, params); err != nil {
+		log.Printf("UploadChunkRaw logic error: %v", err)
+		writeUploadChunkError(c, err, index)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "chunk_uploaded",
+		"chunk_index": index,
+	})
+}
+
 // MergeChunksRequest 合并分块请求
 type MergeChunksRequest struct {
+	SessionID   string `json:"session_id"`
 	ContentID   uint   `json:"content_id" binding:"required"`
 	FileHash    string `json:"file_hash" binding:"required"`
 	FileName    string `json:"file_name" binding:"required"`
@@ -163,6 +263,7 @@ func MergeChunks(c *gin.Context) {
 
 	params := logic.MergeChunksParams{
 		UserID:      userID,
+		SessionID:   req.SessionID,
 		ContentID:   req.ContentID,
 		FileName:    req.FileName,
 		FileHash:    req.FileHash,
@@ -172,6 +273,20 @@ func MergeChunks(c *gin.Context) {
 
 	result, err := logic.MergeChunks(ctx, params)
 	if err != nil {
+		if errors.Is(err, logic.ErrMergeVerifyFailed) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":   err.Error(),
+				"message": "File content does not match file_hash/file_size, please upload again",
+			})
+			return
+		}
+		if errors.Is(err, logic.ErrUploadAlreadyCompleted) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if writeSessionError(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -179,16 +294,57 @@ func MergeChunks(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"status":     "completed",
 		"content_id": req.ContentID,
+		"file_hash":  result.FileHash,
 		"file_path":  result.FilePath,
 		"file_size":  result.FileSize,
 	})
 }
 
-// FastUploadRequest 秒传请求
-type FastUploadRequest struct {
+// FastUploadChallengeRequest 秒传挑战请求
+type FastUploadChallengeRequest struct {
 	ContentID uint   `json:"content_id" binding:"required"`
-	FileName  string `json:"file_name" binding:"required"`
 	FileHash  string `json:"file_hash" binding:"required"`
+	// file_hash 为 md5 时需要 file_size 与 strong_hash（"sha256:<hex>"）都与已有文件一致
+	FileSize   int64  `json:"file_size"`
+	StrongHash string `json:"strong_hash"`
+}
+
+// FastUploadChallenge 获取秒传持有性证明挑战
+func FastUploadChallenge(c *gin.Context) {
+	var req FastUploadChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := getUserID(c)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	challenge, err := logic.NewFastUploadChallenge(ctx, userID, req.ContentID, req.FileHash, req.FileSize, req.StrongHash)
+	if err != nil {
+		if errors.Is(err, logic.ErrInvalidStrongHash) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, logic.ErrFastUploadUnavailable) || errors.Is(err, logic.ErrChallengeContent) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, challenge)
+}
+
+// FastUploadRequest 秒传请求
+type FastUploadRequest struct {
+	ContentID   uint     `json:"content_id" binding:"required"`
+	FileName    string   `json:"file_name" binding:"required"`
+	FileHash    string   `json:"file_hash" binding:"required"`
+	ChallengeID string   `json:"challenge_id" binding:"required"`
+	Proofs      []string `json:"proofs" binding:"required"`
 }
 
 // FastUpload 秒传
@@ -203,7 +359,23 @@ func FastUpload(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
 	defer cancel()
 
-	if err := logic.FastUpload(ctx, userID, req.ContentID, req.FileName, req.FileHash); err != nil {
+	params := logic.FastUploadParams{
+		UserID:      userID,
+		ContentID:   req.ContentID,
+		FileName:    req.FileName,
+		FileHash:    req.FileHash,
+		ChallengeID: req.ChallengeID,
+		Proofs:      req.Proofs,
+	}
+
+	if err := logic.FastUpload(ctx, params); err != nil {
+		if errors.Is(err, logic.ErrChallengeInvalid) || errors.Is(err, logic.ErrChallengeFailed) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":   err.Error(),
+				"message": "Request a new challenge or upload the file",
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -243,6 +415,46 @@ func CancelUpload(c *gin.Context) {
 	})
 }
 
+// writeChunkError 分片写入相关的错误映射（含上传会话错误），返回 false 表示未处理
+func writeChunkError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, logic.ErrChunkChecksum):
+		// 传输中损坏，分片已丢弃，客户端重传该分片即可
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":     err.Error(),
+			"code":      "chunk_checksum_mismatch",
+			"retryable": true,
+		})
+	case errors.Is(err, logic.ErrInvalidChecksum):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		return writeSessionError(c, err)
+	}
+	return true
+}
+
+// writeSessionError 上传会话相关的错误映射，返回 false 表示不是会话错误
+func writeSessionError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, logic.ErrUploadSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   err.Error(),
+			"message": "Please reinitialize upload",
+		})
+	case errors.Is(err, logic.ErrUploadSessionExpired), errors.Is(err, logic.ErrUploadCancelled):
+		c.JSON(http.StatusGone, gin.H{
+			"error":   err.Error(),
+			"message": "Please reinitialize upload",
+		})
+	case errors.Is(err, logic.ErrUploadSessionMismatch), errors.Is(err, logic.ErrChunkOutOfRange),
+		errors.Is(err, logic.ErrChunkSizeMismatch):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
 // getUserID 从请求上下文中获取用户 ID
 func getUserID(c *gin.Context) int {
 	userID, exists := c.Get("user_id")
@@ -272,6 +484,26 @@ func ListFiles(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"files": files})
 }
 
+// GetDedupStats 当前用户文件的块级去重统计
+func GetDedupStats(c *gin.Context) {
+	userID := getUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+
+	stats, err := logic.GetUserDedupStats(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, logic.ErrDedupDisabled) {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
 // GetFile 获取文件详情
 func GetFile(c *gin.Context) {
 	userID := getUserID(c)
@@ -295,6 +527,10 @@ func DeleteFile(c *gin.Context) {
 	defer cancel()
 
 	if err := logic.DeleteFile(ctx, userID, fileHash); err != nil {
+		if errors.Is(err, db.ErrUserFileNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -341,29 +577,75 @@ func DownloadFile(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
 	defer cancel()
 
-	rangeHeader := c.GetHeader("Range")
-
-	result, err := logic.DownloadFile(ctx, userID, fileHash, rangeHeader)
+	result, err := logic.DownloadFile(ctx, userID, fileHash, downloadRequest(c))
 	if err != nil {
+		if errors.Is(err, logic.ErrFileQuarantined) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
-	defer result.Reader.Close()
 
-	// 设置响应头
-	c.Header("Content-Type", result.ContentType)
+	// 签名链接用于 <video> 直接播放，使用 inline
+	disposition := "attachment"
+	if c.GetBool("signed_url") {
+		disposition = "inline"
+	}
+	writeDownload(c, result, disposition)
+}
+
+// downloadRequest 提取条件请求与 Range 相关的请求头
+func downloadRequest(c *gin.Context) logic.DownloadRequest {
+	return logic.DownloadRequest{
+		Method:            c.Request.Method,
+		Range:             c.GetHeader("Range"),
+		IfRange:           c.GetHeader("If-Range"),
+		IfMatch:           c.GetHeader("If-Match"),
+		IfNoneMatch:       c.GetHeader("If-None-Match"),
+		IfModifiedSince:   c.GetHeader("If-Modified-Since"),
+		IfUnmodifiedSince: c.GetHeader("If-Unmodified-Since"),
+	}
+}
+
+// writeDownload 写出文件内容（含条件请求、单区间与多区间 Range 响应）
+func writeDownload(c *gin.Context, result *logic.DownloadResult, disposition string) {
+	if result.Reader != nil {
+		defer result.Reader.Close()
+	}
+
+	// 校验器在所有响应中都返回，便于客户端缓存与续传
+	c.Header("ETag", result.ETag)
+	c.Header("Last-Modified", result.LastModified.Format(http.TimeFormat))
 	c.Header("Accept-Ranges", "bytes")
-	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, result.FileName))
 
-	if result.IsRange {
-		// Range 响应
-		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d",
-			result.RangeStart, result.RangeEnd, result.FileSize))
-		c.Header("Content-Length", strconv.FormatInt(result.RangeLength, 10))
-		c.Status(http.StatusPartialContent)
-	} else {
-		c.Header("Content-Length", strconv.FormatInt(result.FileSize, 10))
-		c.Status(http.StatusOK)
+	switch result.Status {
+	case http.StatusNotModified, http.StatusPreconditionFailed:
+		c.Status(result.Status)
+		return
+	case http.StatusRequestedRangeNotSatisfiable:
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", result.FileSize))
+		c.Status(result.Status)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`%s; filename="%s"`, disposition, result.FileName))
+	c.Header("Content-Length", strconv.FormatInt(result.ContentLength, 10))
+	switch {
+	case len(result.Ranges) > 1:
+		c.Header("Content-Type", "multipart/byteranges; boundary="+result.Boundary)
+	case len(result.Ranges) == 1:
+		r := result.Ranges[0]
+		c.Header("Content-Type", result.ContentType)
+		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", r.Start, r.End, result.FileSize))
+	default:
+		c.Header("Content-Type", result.ContentType)
+	}
+	c.Status(result.Status)
+
+	if result.Reader == nil {
+		// HEAD 请求
+		return
 	}
 
 	// 流式传输
@@ -372,3 +654,17 @@ func DownloadFile(c *gin.Context) {
 		return err == nil
 	})
 }
+
+// PlaybackURL 签发文件的签名播放链接
+func PlaybackURL(c *gin.Context) {
+	userID := getUserID(c)
+	fileHash := c.Param("id")
+
+	urls, err := logic.IssuePlaybackURLs(c.Request.Context(), userID, fileHash)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, urls)
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"video-platform/internal/logic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AddVersionRequest 添加版本请求
+type AddVersionRequest struct {
+	FileHash    string `json:"file_hash" binding:"required"`
+	Label       string `json:"label"`
+	MakeDefault bool   `json:"make_default"`
+}
+
+// SetDefaultVersionRequest 设置默认版本请求
+type SetDefaultVersionRequest struct {
+	FileHash string `json:"file_hash" binding:"required"`
+}
+
+// ListContentVersions 列出内容下的所有版本
+func ListContentVersions(c *gin.Context) {
+	userID := getUserID(c)
+	contentID := c.Param("id")
+
+	versions, err := logic.ListContentVersions(c.Request.Context(), userID, contentID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"versions": versions})
+}
+
+// AddContentVersion 把已上传的文件添加为内容的新版本
+func AddContentVersion(c *gin.Context) {
+	var req AddVersionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	versions, err := logic.AddContentVersion(ctx, logic.AddContentVersionParams{
+		UserID:      getUserID(c),
+		ContentID:   c.Param("id"),
+		FileHash:    req.FileHash,
+		Label:       req.Label,
+		MakeDefault: req.MakeDefault,
+	})
+	if err != nil {
+		writeVersionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"versions": versions})
+}
+
+// RemoveContentVersion 移除内容的一个版本
+func RemoveContentVersion(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := logic.RemoveContentVersion(ctx, getUserID(c), c.Param("id"), c.Param("hash")); err != nil {
+		writeVersionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// SetContentDefault 设置默认播放版本
+func SetContentDefault(c *gin.Context) {
+	var req SetDefaultVersionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := logic.SetContentDefault(c.Request.Context(), getUserID(c), c.Param("id"), req.FileHash); err != nil {
+		writeVersionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"default_hash": req.FileHash})
+}
+
+// RollbackContentDefault 回滚到上一个默认播放版本
+func RollbackContentDefault(c *gin.Context) {
+	hash, err := logic.RollbackContentDefault(c.Request.Context(), getUserID(c), c.Param("id"))
+	if err != nil {
+		writeVersionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"default_hash": hash})
+}
+
+// writeVersionError 版本接口的错误映射
+func writeVersionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, logic.ErrVersionExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, logic.ErrCannotRemoveSource), errors.Is(err, logic.ErrNoDefaultHistory):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		// 版本或内容不存在 / 无权访问
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	}
+}
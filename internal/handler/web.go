@@ -20,6 +20,7 @@ func SetupWebRoutes(r *gin.Engine, staticPath, templatesPath string) {
 	r.GET("/upload", uploadPage)
 	r.GET("/files", filesPage)
 	r.GET("/play/:hash", playPage)
+	r.GET("/s/:token", sharePage)
 }
 
 func indexPage(c *gin.Context) {
@@ -53,3 +54,10 @@ func playPage(c *gin.Context) {
 		"fileHash": hash,
 	})
 }
+
+func sharePage(c *gin.Context) {
+	c.HTML(http.StatusOK, "share.html", gin.H{
+		"title": "视频分享",
+		"token": c.Param("token"),
+	})
+}
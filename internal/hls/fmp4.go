@@ -0,0 +1,242 @@
+package hls
+
+import (
+	"fmt"
+	"io"
+
+	"video-platform/internal/mp4"
+)
+
+const (
+	sampleFlagsSync    = 0x02000000 // sample_depends_on=2（不依赖其他帧）
+	sampleFlagsNonSync = 0x01010000 // sample_depends_on=1, is_non_sync_sample=1
+)
+
+var unityMatrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+// InitSegment 生成 fMP4 初始化段（ftyp + moov/mvex）
+func (t *Track) InitSegment() []byte {
+	src := t.Source
+	w := &mp4.Writer{}
+
+	w.StartBox("ftyp")
+	w.String("iso6")
+	w.U32(0)
+	w.String("iso6mp41")
+	w.EndBox()
+
+	w.StartBox("moov")
+
+	w.StartFullBox("mvhd", 0, 0)
+	w.U32(0) // creation_time
+	w.U32(0) // modification_time
+	w.U32(src.Timescale)
+	w.U32(0) // duration
+	w.U32(0x00010000)
+	w.U16(0x0100)
+	w.Zeros(10)
+	for _, v := range unityMatrix {
+		w.U32(v)
+	}
+	w.Zeros(24)
+	w.U32(src.ID + 1) // next_track_ID
+	w.EndBox()
+
+	w.StartBox("trak")
+	w.StartFullBox("tkhd", 0, 0x000003)
+	w.U32(0)
+	w.U32(0)
+	w.U32(src.ID)
+	w.U32(0)
+	w.U32(0) // duration
+	w.Zeros(8)
+	w.U16(0) // layer
+	w.U16(0) // alternate_group
+	if src.IsAudio() {
+		w.U16(0x0100)
+	} else {
+		w.U16(0)
+	}
+	w.U16(0)
+	for _, v := range unityMatrix {
+		w.U32(v)
+	}
+	w.U32(src.Width)
+	w.U32(src.Height)
+	w.EndBox()
+
+	w.StartBox("mdia")
+	w.StartFullBox("mdhd", 0, 0)
+	w.U32(0)
+	w.U32(0)
+	w.U32(src.Timescale)
+	w.U32(0)
+	lang := src.Language
+	if lang == 0 {
+		lang = 0x55c4 // und
+	}
+	w.U16(lang)
+	w.U16(0)
+	w.EndBox()
+
+	w.StartFullBox("hdlr", 0, 0)
+	w.U32(0)
+	w.String(src.Handler)
+	w.Zeros(12)
+	if src.IsVideo() {
+		w.String("VideoHandler\x00")
+	} else {
+		w.String("SoundHandler\x00")
+	}
+	w.EndBox()
+
+	w.StartBox("minf")
+	if src.IsVideo() {
+		w.StartFullBox("vmhd", 0, 1)
+		w.Zeros(8)
+		w.EndBox()
+	} else {
+		w.StartFullBox("smhd", 0, 0)
+		w.Zeros(4)
+		w.EndBox()
+	}
+	w.StartBox("dinf")
+	w.StartFullBox("dref", 0, 0)
+	w.U32(1)
+	w.StartFullBox("url ", 0, 1)
+	w.EndBox()
+	w.EndBox()
+	w.EndBox()
+
+	w.StartBox("stbl")
+	w.Write(src.Stsd)
+	for _, typ := range []string{"stts", "stsc", "stco"} {
+		w.StartFullBox(typ, 0, 0)
+		w.U32(0)
+		w.EndBox()
+	}
+	w.StartFullBox("stsz", 0, 0)
+	w.U32(0)
+	w.U32(0)
+	w.EndBox()
+	w.EndBox() // stbl
+	w.EndBox() // minf
+	w.EndBox() // mdia
+	w.EndBox() // trak
+
+	w.StartBox("mvex")
+	w.StartFullBox("trex", 0, 0)
+	w.U32(src.ID)
+	w.U32(1) // default_sample_description_index
+	w.U32(0)
+	w.U32(0)
+	w.U32(0)
+	w.EndBox()
+	w.EndBox()
+
+	w.EndBox() // moov
+	return w.Bytes()
+}
+
+// SegmentSize 分段的总字节数（moof + mdat），用于 Content-Length
+func (t *Track) SegmentSize(index int) (int64, error) {
+	if index < 0 || index >= len(t.Segments) {
+		return 0, ErrSegmentNotFound
+	}
+	seg := t.Segments[index]
+	return int64(len(t.moof(index))) + 8 + seg.Bytes, nil
+}
+
+// moof 生成分段的 moof box
+func (t *Track) moof(index int) []byte {
+	seg := t.Segments[index]
+	samples := t.Source.Samples[seg.First:seg.Last]
+
+	w := &mp4.Writer{}
+	w.StartBox("moof")
+
+	w.StartFullBox("mfhd", 0, 0)
+	w.U32(uint32(index + 1))
+	w.EndBox()
+
+	w.StartBox("traf")
+	w.StartFullBox("tfhd", 0, 0x020000) // default-base-is-moof
+	w.U32(t.Source.ID)
+	w.EndBox()
+
+	w.StartFullBox("tfdt", 1, 0)
+	w.U64(seg.StartDTS)
+	w.EndBox()
+
+	// data-offset | duration | size | flags | composition offset
+	w.StartFullBox("trun", 1, 0x000001|0x000100|0x000200|0x000400|0x000800)
+	w.U32(uint32(len(samples)))
+	dataOffsetPos := w.Len()
+	w.U32(0)
+	for _, s := range samples {
+		w.U32(s.Duration)
+		w.U32(s.Size)
+		if s.Sync {
+			w.U32(sampleFlagsSync)
+		} else {
+			w.U32(sampleFlagsNonSync)
+		}
+		w.U32(uint32(int32(int64(s.CTO) - t.shift)))
+	}
+	w.EndBox() // trun
+	w.EndBox() // traf
+	w.EndBox() // moof
+
+	// 数据从 mdat 头之后开始，偏移相对 moof 起点
+	w.PatchU32(dataOffsetPos, uint32(w.Len()+8))
+	return w.Bytes()
+}
+
+// WriteSegment 写出一个媒体分段，样本数据通过 read 从原文件按连续区间读取
+func (t *Track) WriteSegment(out io.Writer, index int, read RangeReader) error {
+	if index < 0 || index >= len(t.Segments) {
+		return ErrSegmentNotFound
+	}
+	seg := t.Segments[index]
+	if seg.First >= seg.Last {
+		return ErrSegmentNotFound
+	}
+
+	if _, err := out.Write(t.moof(index)); err != nil {
+		return err
+	}
+
+	mdatHeader := (&mp4.Writer{})
+	mdatHeader.U32(uint32(8 + seg.Bytes))
+	mdatHeader.String("mdat")
+	if _, err := out.Write(mdatHeader.Bytes()); err != nil {
+		return err
+	}
+
+	samples := t.Source.Samples[seg.First:seg.Last]
+	for i := 0; i < len(samples); {
+		// 合并文件中相邻的样本，减少区间读取次数
+		start := samples[i].Offset
+		end := start + int64(samples[i].Size)
+		j := i + 1
+		for j < len(samples) && samples[j].Offset == end {
+			end += int64(samples[j].Size)
+			j++
+		}
+
+		rc, err := read(start, end-1)
+		if err != nil {
+			return fmt.Errorf("read samples at %d failed: %w", start, err)
+		}
+		n, err := io.Copy(out, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if n != end-start {
+			return fmt.Errorf("short sample read at %d: %d of %d bytes", start, n, end-start)
+		}
+		i = j
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+// Package hls 将普通（非分片）MP4 按需打包为 HLS：主播放列表、媒体播放列表与 fMP4 分段。
+//
+// 分段在视频关键帧处切分，音频按视频分段的时间边界对齐。所有分段都是从原文件的
+// 样本表计算出来的，不落盘，样本数据通过调用方提供的 RangeReader 读取。
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"video-platform/internal/mp4"
+)
+
+// DefaultTargetDuration 默认目标分段时长（秒）
+const DefaultTargetDuration = 6.0
+
+var (
+	ErrNoPlayableTrack = errors.New("no playable audio/video track")
+	ErrTrackNotFound   = errors.New("track not found")
+	ErrSegmentNotFound = errors.New("segment not found")
+)
+
+// RangeReader 读取原文件 [start, end] 闭区间
+type RangeReader func(start, end int64) (io.ReadCloser, error)
+
+// Presentation 一个文件的 HLS 打包方案
+type Presentation struct {
+	Tracks []*Track
+}
+
+// Track 参与打包的轨道
+type Track struct {
+	Name     string // URI 中使用的名字，如 t1
+	Source   *mp4.Track
+	Codecs   string // RFC 6381 codecs，无法识别时为空
+	Segments []Segment
+	shift    int64 // 由 edit list 得出的显示时间偏移
+}
+
+// Segment 分段：Samples[First:Last) 区间
+type Segment struct {
+	First, Last int
+	StartDTS    uint64
+	Duration    float64 // 秒
+	Bytes       int64
+}
+
+// NewPresentation 根据 moov 解析结果生成打包方案
+func NewPresentation(m *mp4.Movie, target float64) (*Presentation, error) {
+	if m.Fragmented {
+		return nil, fmt.Errorf("fragmented mp4 is not supported")
+	}
+	if target <= 0 {
+		target = DefaultTargetDuration
+	}
+
+	video := m.VideoTrack()
+	audio := m.AudioTrack()
+	if video != nil && len(video.Samples) == 0 {
+		video = nil
+	}
+	if audio != nil && len(audio.Samples) == 0 {
+		audio = nil
+	}
+	if video == nil && audio == nil {
+		return nil, ErrNoPlayableTrack
+	}
+
+	p := &Presentation{}
+
+	// 参考轨：有视频时按视频关键帧切分，否则按音频样本切分
+	ref := video
+	if ref == nil {
+		ref = audio
+	}
+	refTrack := newTrack(ref)
+	boundaries := cutAtKeyframes(ref, target)
+	refTrack.Segments = segmentsFromCuts(ref, boundaries)
+	p.Tracks = append(p.Tracks, refTrack)
+
+	if video != nil && audio != nil {
+		// 音频按参考轨分段的时间点切分
+		at := newTrack(audio)
+		cuts := make([]int, 0, len(boundaries))
+		for _, b := range boundaries {
+			seconds := float64(ref.Samples[b].DTS) / float64(ref.Timescale)
+			cuts = append(cuts, firstSampleAtOrAfter(audio, seconds))
+		}
+		at.Segments = segmentsFromCuts(audio, cuts)
+		p.Tracks = append(p.Tracks, at)
+	}
+
+	return p, nil
+}
+
+func newTrack(src *mp4.Track) *Track {
+	t := &Track{
+		Name:   fmt.Sprintf("t%d", src.ID),
+		Source: src,
+		Codecs: codecString(src),
+	}
+	if src.MediaTime > 0 {
+		t.shift = src.MediaTime
+	}
+	return t
+}
+
+// cutAtKeyframes 返回分段起始样本下标（第一个总是 0）
+func cutAtKeyframes(t *mp4.Track, target float64) []int {
+	cuts := []int{0}
+	limit := uint64(target * float64(t.Timescale))
+	start := t.Samples[0].DTS
+	for i := 1; i < len(t.Samples); i++ {
+		s := t.Samples[i]
+		if s.Sync && s.DTS-start >= limit {
+			cuts = append(cuts, i)
+			start = s.DTS
+		}
+	}
+	return cuts
+}
+
+func firstSampleAtOrAfter(t *mp4.Track, seconds float64) int {
+	ts := uint64(math.Round(seconds * float64(t.Timescale)))
+	lo, hi := 0, len(t.Samples)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if t.Samples[mid].DTS < ts {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+func segmentsFromCuts(t *mp4.Track, cuts []int) []Segment {
+	segs := make([]Segment, 0, len(cuts))
+	for i, first := range cuts {
+		last := len(t.Samples)
+		if i+1 < len(cuts) {
+			last = cuts[i+1]
+		}
+		if first > len(t.Samples) {
+			first = len(t.Samples)
+		}
+		seg := Segment{First: first, Last: last}
+		if first < len(t.Samples) {
+			seg.StartDTS = t.Samples[first].DTS
+		}
+		var dur uint64
+		for j := first; j < last; j++ {
+			dur += uint64(t.Samples[j].Duration)
+			seg.Bytes += int64(t.Samples[j].Size)
+		}
+		seg.Duration = float64(dur) / float64(t.Timescale)
+		segs = append(segs, seg)
+	}
+	return segs
+}
+
+// Track 按名字查找轨道
+func (p *Presentation) Track(name string) (*Track, error) {
+	for _, t := range p.Tracks {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return nil, ErrTrackNotFound
+}
+
+// MasterPlaylist 生成主播放列表。query 非空时（如 "?sig=..."）附加到每个 URI 上，
+// 用于签名播放链接：相对 URI 解析时不会继承播放列表自身的查询参数。
+func (p *Presentation) MasterPlaylist(query string) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	main := p.Tracks[0]
+	var audio *Track
+	if len(p.Tracks) > 1 {
+		audio = p.Tracks[1]
+		fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aud\",NAME=\"audio\",DEFAULT=YES,AUTOSELECT=YES,URI=\"%s/index.m3u8%s\"\n", audio.Name, query)
+	}
+
+	bandwidth := main.peakBitrate()
+	codecs := []string{}
+	if main.Codecs != "" {
+		codecs = append(codecs, main.Codecs)
+	}
+	if audio != nil {
+		bandwidth += audio.peakBitrate()
+		if audio.Codecs != "" {
+			codecs = append(codecs, audio.Codecs)
+		}
+	}
+
+	fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d", bandwidth)
+	if main.Source.IsVideo() && main.Source.Width > 0 && main.Source.Height > 0 {
+		fmt.Fprintf(&b, ",RESOLUTION=%dx%d", main.Source.Width>>16, main.Source.Height>>16)
+	}
+	if len(codecs) > 0 {
+		fmt.Fprintf(&b, ",CODECS=\"%s\"", strings.Join(codecs, ","))
+	}
+	if audio != nil {
+		b.WriteString(",AUDIO=\"aud\"")
+	}
+	fmt.Fprintf(&b, "\n%s/index.m3u8%s\n", main.Name, query)
+	return b.String()
+}
+
+// MediaPlaylist 生成媒体播放列表，query 的含义同 MasterPlaylist
+func (t *Track) MediaPlaylist(query string) string {
+	maxDur := 0.0
+	for _, s := range t.Segments {
+		maxDur = math.Max(maxDur, s.Duration)
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(maxDur)))
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"init.mp4%s\"\n", query)
+	for i, s := range t.Segments {
+		if s.First >= s.Last {
+			continue
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.6f,\nseg%d.m4s%s\n", s.Duration, i, query)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String()
+}
+
+// peakBitrate 分段峰值码率（bps）
+func (t *Track) peakBitrate() int64 {
+	var peak float64
+	for _, s := range t.Segments {
+		if s.Duration > 0 {
+			peak = math.Max(peak, float64(s.Bytes*8)/s.Duration)
+		}
+	}
+	return int64(peak)
+}
+
+// codecString 根据样本描述生成 RFC 6381 codecs 字符串
+func codecString(t *mp4.Track) string {
+	entry, ok := mp4.FirstSampleEntry(t.Stsd)
+	if !ok {
+		return ""
+	}
+	switch entry.Type {
+	case "avc1", "avc3":
+		if avcC, ok := entry.Child("avcC"); ok && len(avcC) >= 4 {
+			return fmt.Sprintf("%s.%02x%02x%02x", entry.Type, avcC[1], avcC[2], avcC[3])
+		}
+	case "mp4a":
+		if oti, aot, ok := entry.AudioObjectType(); ok {
+			if aot > 0 {
+				return fmt.Sprintf("mp4a.%x.%d", oti, aot)
+			}
+			return fmt.Sprintf("mp4a.%x", oti)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package logic
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"video-platform/internal/db"
+	"video-platform/internal/redis"
+
+	"github.com/google/uuid"
+)
+
+const (
+	challengeRangeCount = 3
+	challengeRangeSize  = 64 * 1024 // 每个挑战区间最多 64KB
+)
+
+var (
+	ErrFastUploadUnavailable = errors.New("file not available for fast upload")
+	ErrChallengeContent      = errors.New("content not found or access denied")
+	ErrChallengeInvalid      = errors.New("fast upload challenge not found or expired")
+	ErrChallengeFailed       = errors.New("fast upload proof does not match")
+)
+
+// FastUploadChallenge 秒传持有性证明挑战
+// 客户端需对每个区间（闭区间）计算 MD5，按顺序在 /upload/fast 中提交
+type FastUploadChallenge struct {
+	ChallengeID string                 `json:"challenge_id"`
+	Ranges      []redis.ChallengeRange `json:"ranges"`
+	ExpiresIn   int                    `json:"expires_in"`
+}
+
+// NewFastUploadChallenge 为已存储的文件生成随机区间挑战。md5 可以构造碰撞，md5 标识的文件
+// 还要求声明的强摘要与已有文件一致。fileSize 为 0 时不核对大小
+func NewFastUploadChallenge(ctx context.Context, userID int, contentID uint, fileHash string, fileSize int64, strongHash string) (*FastUploadChallenge, error) {
+	fileHash = canonicalFileHash(fileHash)
+	strongHash, err := normalizeStrongHash(strongHash)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.GetContentByID(ctx, userID, strconv.FormatUint(uint64(contentID), 10)); err != nil {
+		return nil, ErrChallengeContent
+	}
+
+	fm, err := db.GetFileMeta(ctx, fileHash)
+	if err != nil || fm.FileSize <= 0 || fm.QuarantinedAt != nil || !Store.FileExists(fileHash) {
+		return nil, ErrFastUploadUnavailable
+	}
+	if fileSize == 0 {
+		fileSize = fm.FileSize
+	}
+	if !sameContent(fm, fileSize, strongHash) {
+		return nil, ErrFastUploadUnavailable
+	}
+
+	ranges, err := randomRanges(fm.FileSize, challengeRangeCount, challengeRangeSize)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := &redis.Challenge{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		ContentID: contentID,
+		FileHash:  fileHash,
+		Ranges:    ranges,
+	}
+	if err := redis.SaveChallenge(ctx, ch); err != nil {
+		return nil, fmt.Errorf("save challenge failed: %w", err)
+	}
+
+	return &FastUploadChallenge{
+		ChallengeID: ch.ID,
+		Ranges:      ranges,
+		ExpiresIn:   int(redis.ChallengeTTL.Seconds()),
+	}, nil
+}
+
+// verifyFastUploadProof 校验客户端对挑战区间给出的摘要，挑战只能使用一次
+func verifyFastUploadProof(ctx context.Context, userID int, contentID uint, fileHash, challengeID string, proofs []string) error {
+	ch, err := redis.TakeChallenge(ctx, userID, challengeID)
+	if err != nil {
+		return fmt.Errorf("load challenge failed: %w", err)
+	}
+	if ch == nil || ch.FileHash != fileHash || ch.ContentID != contentID {
+		return ErrChallengeInvalid
+	}
+	if len(proofs) != len(ch.Ranges) {
+		return ErrChallengeFailed
+	}
+
+	for i, r := range ch.Ranges {
+		expected, err := rangeDigest(fileHash, r)
+		if err != nil {
+			return fmt.Errorf("read challenge range failed: %w", err)
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(proofs[i]))) != 1 {
+			return ErrChallengeFailed
+		}
+	}
+	return nil
+}
+
+func rangeDigest(fileHash string, r redis.ChallengeRange) (string, error) {
+	reader, err := Store.GetFileRange(fileHash, r.Start, r.End)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	h := md5.New()
+	n, err := io.Copy(h, reader)
+	if err != nil {
+		return "", err
+	}
+	if n != r.End-r.Start+1 {
+		return "", fmt.Errorf("short read: %d bytes", n)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// randomRanges 在文件内随机选取 count 个区间
+func randomRanges(fileSize int64, count int, size int64) ([]redis.ChallengeRange, error) {
+	if size > fileSize {
+		size = fileSize
+	}
+	ranges := make([]redis.ChallengeRange, 0, count)
+	for i := 0; i < count; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(fileSize-size+1))
+		if err != nil {
+			return nil, err
+		}
+		start := n.Int64()
+		ranges = append(ranges, redis.ChallengeRange{Start: start, End: start + size - 1})
+	}
+	return ranges, nil
+}
@@ -0,0 +1,235 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"video-platform/internal/db"
+	"video-platform/internal/store"
+)
+
+var ErrDedupDisabled = errors.New("block deduplication is not enabled")
+
+const (
+	// blockDeleteTimeout 块标记删除超过该时长仍在删除中，视为删除者已退出：
+	// 登记该块的文件直接恢复引用，回收时接手删除
+	blockDeleteTimeout = time.Hour
+
+	blockBusyWait     = 2 * time.Minute
+	blockBusyRetry    = 200 * time.Millisecond
+	blockIndexTimeout = 5 * time.Minute
+)
+
+// blockIndex 以数据库实现块级去重的块索引
+type blockIndex struct{}
+
+// SetFileBlocks 登记文件引用的块，块正在被删除时等待删除完成
+func (blockIndex) SetFileBlocks(fileHash string, blocks []store.BlockRef) ([]string, error) {
+	sizes := make(map[string]int64, len(blocks))
+	for _, b := range blocks {
+		sizes[b.Hash] = b.Size
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), blockIndexTimeout)
+	defer cancel()
+	deadline := time.Now().Add(blockBusyWait)
+	for {
+		freed, err := db.SetFileBlocks(ctx, fileHash, sizes, time.Now().Add(-blockDeleteTimeout))
+		if !errors.Is(err, db.ErrBlocksBusy) {
+			return freed, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("set blocks of %s: %w", fileHash, err)
+		}
+		time.Sleep(blockBusyRetry)
+	}
+}
+
+// ForgetBlocks 块已从存储删除
+func (blockIndex) ForgetBlocks(hashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), blockIndexTimeout)
+	defer cancel()
+	return db.ForgetBlocks(ctx, hashes)
+}
+
+// ListIndexedFiles 块索引中登记的文件，大小为引用的块之和
+func (blockIndex) ListIndexedFiles() ([]store.StoredFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), blockIndexTimeout)
+	defer cancel()
+	files, err := db.ListDedupFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]store.StoredFile, 0, len(files))
+	for _, f := range files {
+		out = append(out, store.StoredFile{Hash: f.FileHash, Size: f.Size, ModTime: f.CreatedAt})
+	}
+	return out, nil
+}
+
+// DedupStats 块级去重统计
+type DedupStats struct {
+	Files        int64   `json:"files"`         // 以块存储的文件数
+	LogicalBytes int64   `json:"logical_bytes"` // 这些文件的大小之和
+	Blocks       int64   `json:"blocks"`        // 这些文件引用的不同块
+	StoredBytes  int64   `json:"stored_bytes"`  // 块的大小之和，即实际占用
+	SavedBytes   int64   `json:"saved_bytes"`   // logical_bytes - stored_bytes
+	Ratio        float64 `json:"ratio"`         // logical_bytes / stored_bytes，1 表示没有重复
+}
+
+func newDedupStats(t *db.DedupTotals) DedupStats {
+	s := DedupStats{
+		Files:        t.Files,
+		LogicalBytes: t.LogicalBytes,
+		Blocks:       t.Blocks,
+		StoredBytes:  t.StoredBytes,
+		SavedBytes:   t.LogicalBytes - t.StoredBytes,
+		Ratio:        1,
+	}
+	if t.StoredBytes > 0 {
+		s.Ratio = float64(t.LogicalBytes) / float64(t.StoredBytes)
+	}
+	return s
+}
+
+// UserDedupStats 单个用户的去重统计。与其他用户共享的块同样计入 stored_bytes，
+// 因此各用户之和大于全局
+type UserDedupStats struct {
+	UserID int `json:"user_id"`
+	DedupStats
+}
+
+// DedupReport 全局与各用户的去重统计，按节省的字节数从多到少排列
+type DedupReport struct {
+	BlockSize int              `json:"block_size"`
+	System    DedupStats       `json:"system"`
+	Users     []UserDedupStats `json:"users"`
+}
+
+// GetDedupReport 全局与各用户的去重统计
+func GetDedupReport(ctx context.Context) (*DedupReport, error) {
+	dedup, ok := Store.(store.Deduplicator)
+	if !ok {
+		return nil, ErrDedupDisabled
+	}
+	totals, err := db.GetDedupTotals(ctx)
+	if err != nil {
+		return nil, err
+	}
+	users, err := db.GetUserDedupTotals(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DedupReport{
+		BlockSize: dedup.BlockSize(),
+		System:    newDedupStats(totals),
+		Users:     make([]UserDedupStats, 0, len(users)),
+	}
+	for id, t := range users {
+		report.Users = append(report.Users, UserDedupStats{UserID: id, DedupStats: newDedupStats(t)})
+	}
+	sort.Slice(report.Users, func(i, j int) bool {
+		a, b := report.Users[i], report.Users[j]
+		if a.SavedBytes != b.SavedBytes {
+			return a.SavedBytes > b.SavedBytes
+		}
+		return a.UserID < b.UserID
+	})
+	return report, nil
+}
+
+// GetUserDedupStats 用户文件的去重统计
+func GetUserDedupStats(ctx context.Context, userID int) (*DedupStats, error) {
+	if _, ok := Store.(store.Deduplicator); !ok {
+		return nil, ErrDedupDisabled
+	}
+	users, err := db.GetUserDedupTotals(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	t := users[userID]
+	if t == nil {
+		t = &db.DedupTotals{}
+	}
+	stats := newDedupStats(t)
+	return &stats, nil
+}
+
+// gcDedupBlocks 回收块存储中没有文件引用的块：删除者中途退出遗留的删除中记录，
+// 以及没有索引记录的块（合并中途失败等遗留）
+func gcDedupBlocks(ctx context.Context, dedup store.Deduplicator, report *GCReport) {
+	now := time.Now()
+	before := now.Add(-blockDeleteTimeout)
+	stale, err := db.ListStaleDeletingBlocks(ctx, before, gcBatchSize)
+	if err != nil {
+		report.fail("list stale deleting blocks: %v", err)
+		return
+	}
+	for _, b := range stale {
+		item := GCItem{FileHash: b.BlockHash, Size: b.Size, Age: now.Sub(b.UpdatedAt).Truncate(time.Second).String()}
+		if !report.DryRun {
+			claimed, err := db.ClaimDeletingBlock(ctx, b.BlockHash, before)
+			if err != nil {
+				report.fail("claim block %s: %v", b.BlockHash, err)
+				continue
+			}
+			if !claimed {
+				continue
+			}
+			if err := deleteBlock(ctx, dedup, b.BlockHash); err != nil {
+				report.fail("delete block %s: %v", b.BlockHash, err)
+				continue
+			}
+			report.FreedBytes += b.Size
+		}
+		report.OrphanBlocks = append(report.OrphanBlocks, item)
+	}
+
+	blocks, err := dedup.ListBlocks()
+	if err != nil {
+		report.fail("list blocks: %v", err)
+		return
+	}
+	known, err := db.GetDedupBlockHashes(ctx)
+	if err != nil {
+		report.fail("load block index: %v", err)
+		return
+	}
+	for _, b := range blocks {
+		age := now.Sub(b.ModTime)
+		if known[b.Hash] || age < gcConfig.OrphanAge {
+			continue
+		}
+		item := GCItem{FileHash: b.Hash, Size: b.Size, Age: age.Truncate(time.Second).String()}
+		if !report.DryRun {
+			// 先插入删除中的记录：列出之后刚好有文件登记该块时插入失败，跳过
+			claimed, err := db.ClaimOrphanBlock(ctx, b.Hash, b.Size)
+			if err != nil {
+				report.fail("claim block %s: %v", b.Hash, err)
+				continue
+			}
+			if !claimed {
+				continue
+			}
+			if err := deleteBlock(ctx, dedup, b.Hash); err != nil {
+				report.fail("delete block %s: %v", b.Hash, err)
+				continue
+			}
+			report.FreedBytes += b.Size
+		}
+		report.OrphanBlocks = append(report.OrphanBlocks, item)
+	}
+}
+
+// deleteBlock 删除已标记删除中的块并移除索引记录。删除失败时记录保持删除中，
+// 超过 blockDeleteTimeout 后由下次回收重试
+func deleteBlock(ctx context.Context, dedup store.Deduplicator, hash string) error {
+	if err := dedup.DeleteBlock(hash); err != nil {
+		return err
+	}
+	return db.ForgetBlocks(ctx, []string{hash})
+}
@@ -0,0 +1,130 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"video-platform/internal/db"
+	"video-platform/internal/store"
+)
+
+// dedupBlock 块索引中的记录，不存在时返回 nil
+func dedupBlock(t *testing.T, hash string) *db.DedupBlock {
+	t.Helper()
+	var rows []db.DedupBlock
+	if err := db.DB.Where("block_hash = ?", hash).Find(&rows).Error; err != nil {
+		t.Fatalf("query block: %v", err)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return &rows[0]
+}
+
+func blockRefs(hashes ...string) []store.BlockRef {
+	refs := make([]store.BlockRef, len(hashes))
+	for i, h := range hashes {
+		refs[i] = store.BlockRef{Hash: h, Size: 10}
+	}
+	return refs
+}
+
+func TestSetFileBlocksFreesReplacedBlocks(t *testing.T) {
+	setupLogicTest(t, nil)
+	index := blockIndex{}
+
+	if _, err := index.SetFileBlocks("fileA", blockRefs("b1", "b2", "b3")); err != nil {
+		t.Fatalf("register fileA: %v", err)
+	}
+	if _, err := index.SetFileBlocks("fileB", blockRefs("b2")); err != nil {
+		t.Fatalf("register fileB: %v", err)
+	}
+
+	// 重新合并 fileA：b1 不再引用，b2 仍被 fileB 引用，b4 为新块
+	freed, err := index.SetFileBlocks("fileA", blockRefs("b2", "b3", "b4"))
+	if err != nil {
+		t.Fatalf("re-register fileA: %v", err)
+	}
+	if len(freed) != 1 || freed[0] != "b1" {
+		t.Fatalf("freed = %v, want [b1]", freed)
+	}
+	for hash, refs := range map[string]int{"b1": 0, "b2": 2, "b3": 1, "b4": 1} {
+		b := dedupBlock(t, hash)
+		if b == nil || b.RefCount != refs || b.Deleting != (refs == 0) {
+			t.Fatalf("block %s = %+v, want %d refs", hash, b, refs)
+		}
+	}
+
+	// 删除 fileA 只释放它独有的块
+	freed, err = index.SetFileBlocks("fileA", nil)
+	if err != nil {
+		t.Fatalf("release fileA: %v", err)
+	}
+	sort.Strings(freed)
+	if len(freed) != 2 || freed[0] != "b3" || freed[1] != "b4" {
+		t.Fatalf("freed = %v, want [b3 b4]", freed)
+	}
+	if b := dedupBlock(t, "b2"); b.RefCount != 1 || b.Deleting {
+		t.Fatalf("shared block = %+v, want 1 ref", b)
+	}
+
+	if err := index.ForgetBlocks([]string{"b1", "b2", "b3", "b4"}); err != nil {
+		t.Fatalf("ForgetBlocks: %v", err)
+	}
+	for _, hash := range []string{"b1", "b3", "b4"} {
+		if dedupBlock(t, hash) != nil {
+			t.Fatalf("deleted block %s still indexed", hash)
+		}
+	}
+	// 仍被引用的块不受 ForgetBlocks 影响
+	if dedupBlock(t, "b2") == nil {
+		t.Fatal("referenced block forgotten")
+	}
+	var refs []db.DedupFileBlock
+	if err := db.DB.Find(&refs).Error; err != nil {
+		t.Fatalf("query file blocks: %v", err)
+	}
+	if len(refs) != 1 || refs[0].FileHash != "fileB" || refs[0].BlockHash != "b2" {
+		t.Fatalf("file blocks = %+v, want only fileB -> b2", refs)
+	}
+}
+
+func TestSetFileBlocksWaitsForDeletingBlock(t *testing.T) {
+	setupLogicTest(t, nil)
+	ctx := context.Background()
+	index := blockIndex{}
+
+	if _, err := index.SetFileBlocks("fileA", blockRefs("b1")); err != nil {
+		t.Fatalf("register fileA: %v", err)
+	}
+	if _, err := index.SetFileBlocks("fileA", nil); err != nil {
+		t.Fatalf("release fileA: %v", err)
+	}
+
+	// 删除者仍在进行：登记该块返回 ErrBlocksBusy，记录保持不变
+	_, err := db.SetFileBlocks(ctx, "fileB", map[string]int64{"b1": 10}, time.Now().Add(-time.Minute))
+	if !errors.Is(err, db.ErrBlocksBusy) {
+		t.Fatalf("register deleting block: %v, want ErrBlocksBusy", err)
+	}
+	if b := dedupBlock(t, "b1"); b.RefCount != 0 || !b.Deleting {
+		t.Fatalf("block = %+v, want still deleting", b)
+	}
+
+	// 标记早于 busyAfter 视为删除者已退出，直接恢复引用
+	if _, err := db.SetFileBlocks(ctx, "fileB", map[string]int64{"b1": 10}, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("register stale deleting block: %v", err)
+	}
+	if b := dedupBlock(t, "b1"); b.RefCount != 1 || b.Deleting {
+		t.Fatalf("block = %+v, want restored with 1 ref", b)
+	}
+	// 恢复后删除者的 ForgetBlocks 不会移除记录
+	if err := index.ForgetBlocks([]string{"b1"}); err != nil {
+		t.Fatalf("ForgetBlocks: %v", err)
+	}
+	if dedupBlock(t, "b1") == nil {
+		t.Fatal("restored block forgotten")
+	}
+}
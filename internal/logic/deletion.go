@@ -0,0 +1,216 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"video-platform/internal/db"
+	"video-platform/internal/redis"
+)
+
+const (
+	// DefaultDeletionGrace 引用归零后保留存储文件的时长，期间可被秒传或重新上传复用
+	DefaultDeletionGrace = time.Hour
+
+	deletionInterval  = time.Minute
+	deletionBatchSize = 100
+)
+
+// blobLockTTL blobLock 的过期时间，长时间持有时由 heldBlob 每 1/3 时长续期一次（测试中调小）
+var blobLockTTL = 120 * time.Second
+
+// ErrBlobLockLost 持有期间 blobLock 过期（续期失败），文件可能已被删除 worker 删除
+var ErrBlobLockLost = errors.New("blob lock lost")
+
+// DeletionConfig 删除 worker 配置
+type DeletionConfig struct {
+	GracePeriod time.Duration
+	Interval    time.Duration
+}
+
+// deletionMetrics 通过 expvar 暴露的累计指标
+var deletionMetrics = expvar.NewMap("deletion")
+
+// blobLock 同一 hash 存储文件的写入（合并、转码产物入库）与删除互斥，
+// 避免删除 worker 删掉刚合并、尚未登记 FileMeta 的文件
+func blobLock(fileHash string) *redis.DistributedLock {
+	return redis.NewLock("blob:"+fileHash, blobLockTTL)
+}
+
+// heldBlob 已取得的 blobLock。合并、迁移、修复等可能超过锁时长的操作用它持有锁：
+// 后台像转码租约一样定期续期，续期发现锁已丢失时取消 Context，
+// 调用方在登记数据库前用 Check 确认锁仍然有效
+type heldBlob struct {
+	hash   string
+	lock   *redis.DistributedLock
+	ctx    context.Context
+	cancel context.CancelFunc
+	stop   chan struct{}
+	done   chan struct{}
+	lost   atomic.Bool
+}
+
+// lockBlob 阻塞取得 fileHash 的 blobLock 并开始续期，用完后调用 Unlock
+func lockBlob(ctx context.Context, fileHash string) (*heldBlob, error) {
+	lock := blobLock(fileHash)
+	if err := lock.Lock(ctx); err != nil {
+		return nil, fmt.Errorf("acquire lock failed: %w", err)
+	}
+	b := &heldBlob{hash: fileHash, lock: lock, stop: make(chan struct{}), done: make(chan struct{})}
+	b.ctx, b.cancel = context.WithCancel(ctx)
+	go b.keepalive()
+	return b, nil
+}
+
+// keepalive 续期直到 Unlock；调用方的 ctx 取消后仍继续续期，
+// 因为存储层的写入不受 ctx 控制，可能还在进行
+func (b *heldBlob) keepalive() {
+	defer close(b.done)
+	ticker := time.NewTicker(blobLockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			if err := b.lock.Extend(context.Background(), blobLockTTL); err != nil {
+				log.Printf("Warning: extend blob lock of %s failed: %v", b.hash, err)
+				if errors.Is(err, redis.ErrLockNotHeld) {
+					b.lost.Store(true)
+					b.cancel()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Context 锁丢失时取消的 ctx，用于可以中途停止的读取
+func (b *heldBlob) Context() context.Context {
+	return b.ctx
+}
+
+// Check 立即续期一次确认仍持有锁，锁已丢失时返回 ErrBlobLockLost。
+// 两次后台续期之间锁也可能刚好过期，提交前必须调用
+func (b *heldBlob) Check() error {
+	if !b.lost.Load() {
+		err := b.lock.Extend(context.Background(), blobLockTTL)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, redis.ErrLockNotHeld) {
+			return fmt.Errorf("extend blob lock failed: %w", err)
+		}
+		b.lost.Store(true)
+		b.cancel()
+	}
+	return fmt.Errorf("%w: %s", ErrBlobLockLost, b.hash)
+}
+
+// Unlock 停止续期并释放锁
+func (b *heldBlob) Unlock() {
+	close(b.stop)
+	<-b.done
+	b.cancel()
+	if !b.lost.Load() {
+		_ = b.lock.Unlock(context.Background())
+	}
+}
+
+// StartDeletionWorker 启动删除 worker，ctx 取消后返回的 WaitGroup 完成
+func StartDeletionWorker(ctx context.Context, cfg DeletionConfig) *sync.WaitGroup {
+	if cfg.GracePeriod < 0 {
+		cfg.GracePeriod = DefaultDeletionGrace
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = deletionInterval
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			processPendingDeletions(ctx, cfg)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	log.Printf("Deletion worker started: grace=%s, interval=%s", cfg.GracePeriod, cfg.Interval)
+	return wg
+}
+
+// processPendingDeletions 删除宽限期已过、仍无引用的存储文件。
+// 多个节点同时运行时只有拿到锁的节点执行。
+func processPendingDeletions(ctx context.Context, cfg DeletionConfig) {
+	lock := redis.NewLock("deletion:worker", cfg.Interval)
+	ok, err := lock.TryLock(ctx)
+	if err != nil || !ok {
+		return
+	}
+	defer lock.Unlock(context.Background())
+
+	due, err := db.ListDuePendingDeletions(ctx, time.Now().Add(-cfg.GracePeriod), deletionBatchSize)
+	if err != nil {
+		log.Printf("Warning: list pending deletions failed: %v", err)
+		return
+	}
+	for _, pd := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		deletePendingBlob(ctx, pd)
+	}
+
+	if n, err := db.CountPendingDeletions(ctx); err == nil {
+		pending := new(expvar.Int)
+		pending.Set(n)
+		deletionMetrics.Set("pending", pending)
+	}
+}
+
+// deletePendingBlob 先在数据库中确认并删除 FileMeta，再删除存储文件；
+// 存储删除失败时保留待删除记录，下一轮重试
+func deletePendingBlob(ctx context.Context, pd db.PendingDeletion) {
+	lock := blobLock(pd.FileHash)
+	ok, err := lock.TryLock(ctx)
+	if err != nil || !ok {
+		// 正在合并同一文件，下一轮再处理
+		return
+	}
+	defer lock.Unlock(context.Background())
+
+	remove, err := db.ClaimPendingDeletion(ctx, pd.ID)
+	if err != nil {
+		log.Printf("Warning: claim pending deletion %s failed: %v", pd.FileHash, err)
+		return
+	}
+	if !remove {
+		deletionMetrics.Add("revived", 1)
+		log.Printf("Pending deletion of %s cancelled: file is referenced again", pd.FileHash)
+		return
+	}
+
+	if err := Store.DeleteFile(pd.FileHash); err != nil {
+		deletionMetrics.Add("failed", 1)
+		log.Printf("Warning: delete stored file %s failed (attempt %d): %v", pd.FileHash, pd.Attempts+1, err)
+		_ = db.FailPendingDeletion(ctx, pd.ID, err.Error())
+		return
+	}
+	if err := db.FinishPendingDeletion(ctx, pd.ID); err != nil {
+		log.Printf("Warning: finish pending deletion %s failed: %v", pd.FileHash, err)
+	}
+	deletionMetrics.Add("deleted", 1)
+	log.Printf("Deleted stored file %s", pd.FileHash)
+}
@@ -0,0 +1,98 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"video-platform/internal/db"
+	"video-platform/internal/store"
+)
+
+// expiringStore 合并时让 blobLock 过期，模拟合并耗时超过锁时长且续期失败
+type expiringStore struct {
+	store.Uploader
+	mr *miniredis.Miniredis
+}
+
+func (s expiringStore) MergeChunks(userID int, fileHash string, totalChunks int, fileSize int64, strongHash string) (string, int64, error) {
+	s.mr.Del("lock:blob:" + fileHash)
+	return s.Uploader.MergeChunks(userID, fileHash, totalChunks, fileSize, strongHash)
+}
+
+func TestHeldBlobKeepsLockAlive(t *testing.T) {
+	mr := setupLogicTest(t, nil)
+	blobLockTTL = 60 * time.Millisecond
+	const key = "lock:blob:md5:aa"
+
+	blob, err := lockBlob(context.Background(), "md5:aa")
+	if err != nil {
+		t.Fatalf("lockBlob: %v", err)
+	}
+	// 累计快进超过锁时长，续期让锁一直有效
+	for i := 0; i < 10; i++ {
+		time.Sleep(25 * time.Millisecond)
+		mr.FastForward(10 * time.Millisecond)
+	}
+	if !mr.Exists(key) {
+		t.Fatal("blob lock expired while held")
+	}
+	if err := blob.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	blob.Unlock()
+	if mr.Exists(key) {
+		t.Fatal("blob lock still held after Unlock")
+	}
+}
+
+func TestHeldBlobLost(t *testing.T) {
+	mr := setupLogicTest(t, nil)
+	blobLockTTL = 30 * time.Millisecond
+
+	blob, err := lockBlob(context.Background(), "md5:aa")
+	if err != nil {
+		t.Fatalf("lockBlob: %v", err)
+	}
+	defer blob.Unlock()
+	mr.Del("lock:blob:md5:aa")
+
+	select {
+	case <-blob.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled after the lock was lost")
+	}
+	if err := blob.Check(); !errors.Is(err, ErrBlobLockLost) {
+		t.Fatalf("Check = %v, want ErrBlobLockLost", err)
+	}
+}
+
+func TestMergeChunksAbortsWhenBlobLockLost(t *testing.T) {
+	mr := setupLogicTest(t, nil)
+	ctx := context.Background()
+	data := []byte("merge outlived the blob lock")
+	sum := sha256.Sum256(data)
+	hash := store.FormatFileHash(store.HashSHA256, sum[:])
+
+	init, err := InitUpload(ctx, testUserID, "a.mp4", hash, "", int64(len(data)))
+	if err != nil {
+		t.Fatalf("InitUpload: %v", err)
+	}
+	if err := Store.WriteChunk(testUserID, hash, 0, bytes.NewReader(data), nil); err != nil {
+		t.Fatalf("write chunk: %v", err)
+	}
+	Store = expiringStore{Uploader: Store, mr: mr}
+
+	_, err = MergeChunks(ctx, MergeChunksParams{UserID: testUserID, SessionID: init.SessionID, FileHash: hash})
+	if !errors.Is(err, ErrBlobLockLost) {
+		t.Fatalf("MergeChunks = %v, want ErrBlobLockLost", err)
+	}
+	if exists, err := db.FileMetaExists(ctx, hash); err != nil || exists {
+		t.Fatalf("file meta registered after the lock was lost: exists=%v err=%v", exists, err)
+	}
+}
@@ -2,128 +2,327 @@ package logic
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
 	"strconv"
 	"strings"
+	"time"
 
 	"video-platform/internal/db"
 )
 
+// maxRanges 单个请求最多处理的区间数，超过时返回整个文件
+const maxRanges = 32
+
+// DownloadRequest 下载请求中与条件请求、Range 相关的请求头
+type DownloadRequest struct {
+	Method            string // GET / HEAD，HEAD 不打开文件内容
+	Range             string
+	IfRange           string
+	IfMatch           string
+	IfNoneMatch       string
+	IfModifiedSince   string
+	IfUnmodifiedSince string
+}
+
+// ByteRange 字节区间（闭区间）
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// Length 区间长度
+func (r ByteRange) Length() int64 {
+	return r.End - r.Start + 1
+}
+
 // DownloadResult 下载结果
 type DownloadResult struct {
-	Reader      io.ReadCloser
-	FileSize    int64
-	FileName    string
-	ContentType string
-	IsRange     bool
-	RangeStart  int64
-	RangeEnd    int64
-	RangeLength int64
+	Status        int           // 200 / 206 / 304 / 412 / 416
+	Reader        io.ReadCloser // 仅 200 / 206 且非 HEAD 时有效
+	FileSize      int64
+	FileName      string
+	ContentType   string
+	ContentLength int64
+	ETag          string
+	LastModified  time.Time
+	Ranges        []ByteRange // 206 时的区间，多于一个时响应为 multipart/byteranges
+	Boundary      string      // multipart/byteranges 的分隔符
 }
 
 // DownloadFile 下载文件
-func DownloadFile(ctx context.Context, userID int, fileHash string, rangeHeader string) (*DownloadResult, error) {
+func DownloadFile(ctx context.Context, userID int, fileHash string, req DownloadRequest) (*DownloadResult, error) {
 	// 1. 验证用户权限
-	uc, err := db.GetUserContentByHash(ctx, userID, fileHash)
+	fileName, err := resolveFileAccess(ctx, userID, fileHash)
 	if err != nil {
-		return nil, fmt.Errorf("file not found or access denied")
+		return nil, err
 	}
 
+	return openDownload(ctx, fileHash, fileName, req)
+}
+
+// openDownload 按条件请求与 Range 语义（RFC 9110）打开存储中的文件（权限已由调用方检查）
+func openDownload(ctx context.Context, fileHash, fileName string, req DownloadRequest) (*DownloadResult, error) {
 	// 2. 获取文件元数据
 	fm, err := db.GetFileMeta(ctx, fileHash)
 	if err != nil {
 		return nil, fmt.Errorf("file metadata not found")
 	}
+	if fm.QuarantinedAt != nil {
+		return nil, ErrFileQuarantined
+	}
 
 	// 3. 检查文件是否存在
 	if !Store.FileExists(fileHash) {
 		return nil, fmt.Errorf("file not found on storage")
 	}
 
+	// 文件按内容寻址，hash 不变内容就不变，可以作为强 ETag
 	result := &DownloadResult{
-		FileName:    uc.FileName,
-		FileSize:    fm.FileSize,
-		ContentType: getContentType(uc.FileName),
+		Status:       http.StatusOK,
+		FileName:     fileName,
+		FileSize:     fm.FileSize,
+		ContentType:  getContentType(fileName),
+		ETag:         `"` + fm.FileHash + `"`,
+		LastModified: fm.CreatedAt.UTC().Truncate(time.Second),
 	}
 
-	// 4. 处理 Range 请求
-	if rangeHeader != "" {
-		start, end, err := parseRangeHeader(rangeHeader, fm.FileSize)
-		if err != nil {
-			return nil, fmt.Errorf("invalid range: %w", err)
+	// 4. 条件请求
+	if status := checkPreconditions(req, result.ETag, result.LastModified); status != 0 {
+		result.Status = status
+		return result, nil
+	}
+
+	// 5. 处理 Range 请求（If-Range 不匹配时返回整个文件）
+	if req.Range != "" && ifRangeMatches(req.IfRange, result.ETag, result.LastModified) {
+		ranges, err := parseRangeHeader(req.Range, fm.FileSize)
+		switch {
+		case errors.Is(err, errRangeNotSatisfiable):
+			result.Status = http.StatusRequestedRangeNotSatisfiable
+			return result, nil
+		case err == nil && len(ranges) > 0 && len(ranges) <= maxRanges && sumRanges(ranges) <= fm.FileSize:
+			result.Status = http.StatusPartialContent
+			result.Ranges = ranges
 		}
+		// 语法错误或区间过多时忽略 Range
+	}
+
+	switch len(result.Ranges) {
+	case 0:
+		result.ContentLength = fm.FileSize
+	case 1:
+		result.ContentLength = result.Ranges[0].Length()
+	default:
+		result.Boundary, result.ContentLength = multipartLayout(result.Ranges, result.ContentType, fm.FileSize)
+	}
+
+	if req.Method == http.MethodHead {
+		return result, nil
+	}
 
-		reader, err := Store.GetFileRange(fileHash, start, end)
+	// 6. 打开文件内容
+	switch len(result.Ranges) {
+	case 0:
+		reader, _, err := Store.GetFile(fileHash)
+		if err != nil {
+			return nil, fmt.Errorf("get file failed: %w", err)
+		}
+		result.Reader = reader
+	case 1:
+		reader, err := Store.GetFileRange(fileHash, result.Ranges[0].Start, result.Ranges[0].End)
 		if err != nil {
 			return nil, fmt.Errorf("get file range failed: %w", err)
 		}
-
 		result.Reader = reader
-		result.IsRange = true
-		result.RangeStart = start
-		result.RangeEnd = end
-		result.RangeLength = end - start + 1
-		return result, nil
+	default:
+		result.Reader = multipartReader(fileHash, result.Ranges, result.ContentType, fm.FileSize, result.Boundary)
 	}
+	return result, nil
+}
 
-	// 5. 普通下载
-	reader, size, err := Store.GetFile(fileHash)
-	if err != nil {
-		return nil, fmt.Errorf("get file failed: %w", err)
+// checkPreconditions 按 RFC 9110 13.2.2 的顺序求值条件请求头，返回 0 表示继续处理
+func checkPreconditions(req DownloadRequest, etag string, lastModified time.Time) int {
+	if req.IfMatch != "" {
+		if !etagListMatches(req.IfMatch, etag, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if t, err := http.ParseTime(req.IfUnmodifiedSince); err == nil && lastModified.After(t) {
+		return http.StatusPreconditionFailed
 	}
 
-	result.Reader = reader
-	result.FileSize = size
-	return result, nil
+	if req.IfNoneMatch != "" {
+		if etagListMatches(req.IfNoneMatch, etag, false) {
+			if req.Method == http.MethodGet || req.Method == http.MethodHead || req.Method == "" {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if t, err := http.ParseTime(req.IfModifiedSince); err == nil && !lastModified.After(t) {
+		return http.StatusNotModified
+	}
+	return 0
 }
 
-func parseRangeHeader(rangeHeader string, fileSize int64) (start, end int64, err error) {
-	if !strings.HasPrefix(rangeHeader, "bytes=") {
-		return 0, 0, fmt.Errorf("invalid range format")
+// ifRangeMatches If-Range 为空或与当前表示匹配时 Range 才生效
+func ifRangeMatches(ifRange, etag string, lastModified time.Time) bool {
+	ifRange = strings.TrimSpace(ifRange)
+	if ifRange == "" {
+		return true
 	}
-
-	rangeSpec := strings.TrimPrefix(rangeHeader, "bytes=")
-	parts := strings.Split(rangeSpec, "-")
-	if len(parts) != 2 {
-		return 0, 0, fmt.Errorf("invalid range format")
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		// If-Range 只接受强比较
+		return ifRange == etag
 	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && lastModified.Equal(t)
+}
 
-	if parts[0] == "" {
-		suffix, err := strconv.ParseInt(parts[1], 10, 64)
-		if err != nil {
-			return 0, 0, fmt.Errorf("invalid range suffix")
+// etagListMatches 比较 If-Match / If-None-Match 中的 ETag 列表
+func etagListMatches(list, etag string, strong bool) bool {
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
 		}
-		start = fileSize - suffix
-		end = fileSize - 1
-	} else if parts[1] == "" {
-		start, err = strconv.ParseInt(parts[0], 10, 64)
-		if err != nil {
-			return 0, 0, fmt.Errorf("invalid range start")
+		if strings.HasPrefix(tag, "W/") {
+			if strong {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
 		}
-		end = fileSize - 1
-	} else {
-		start, err = strconv.ParseInt(parts[0], 10, 64)
-		if err != nil {
-			return 0, 0, fmt.Errorf("invalid range start")
+		if tag == etag {
+			return true
 		}
-		end, err = strconv.ParseInt(parts[1], 10, 64)
-		if err != nil {
-			return 0, 0, fmt.Errorf("invalid range end")
+	}
+	return false
+}
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseRangeHeader 解析 bytes= 区间列表。无法满足的区间被丢弃，
+// 全部无法满足时返回 errRangeNotSatisfiable
+func parseRangeHeader(rangeHeader string, fileSize int64) ([]ByteRange, error) {
+	if !strings.HasPrefix(rangeHeader, "bytes=") {
+		return nil, fmt.Errorf("invalid range format")
+	}
+
+	var ranges []ByteRange
+	for _, spec := range strings.Split(strings.TrimPrefix(rangeHeader, "bytes="), ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
 		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid range format")
+		}
+
+		var r ByteRange
+		if first == "" {
+			suffix, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || suffix < 0 {
+				return nil, fmt.Errorf("invalid range suffix")
+			}
+			if suffix == 0 || fileSize == 0 {
+				continue
+			}
+			if suffix > fileSize {
+				suffix = fileSize
+			}
+			r = ByteRange{Start: fileSize - suffix, End: fileSize - 1}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, fmt.Errorf("invalid range start")
+			}
+			end := fileSize - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, fmt.Errorf("invalid range end")
+				}
+				if end >= fileSize {
+					end = fileSize - 1
+				}
+			}
+			if start >= fileSize {
+				continue
+			}
+			r = ByteRange{Start: start, End: end}
+		}
+		ranges = append(ranges, r)
 	}
 
-	if start < 0 {
-		start = 0
+	if len(ranges) == 0 {
+		return nil, errRangeNotSatisfiable
 	}
-	if end >= fileSize {
-		end = fileSize - 1
+	return ranges, nil
+}
+
+func sumRanges(ranges []ByteRange) int64 {
+	var n int64
+	for _, r := range ranges {
+		n += r.Length()
 	}
-	if start > end {
-		return 0, 0, fmt.Errorf("invalid range: start > end")
+	return n
+}
+
+func partHeader(r ByteRange, contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Type":  {contentType},
+		"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", r.Start, r.End, size)},
 	}
+}
 
-	return start, end, nil
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// multipartLayout 生成分隔符并预先计算 multipart/byteranges 响应体长度
+func multipartLayout(ranges []ByteRange, contentType string, size int64) (string, int64) {
+	var w countingWriter
+	mw := multipart.NewWriter(&w)
+	for _, r := range ranges {
+		mw.CreatePart(partHeader(r, contentType, size))
+	}
+	mw.Close()
+	return mw.Boundary(), int64(w) + sumRanges(ranges)
+}
+
+// multipartReader 边读存储边生成 multipart/byteranges 响应体
+func multipartReader(fileHash string, ranges []ByteRange, contentType string, size int64, boundary string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		mw := multipart.NewWriter(pw)
+		mw.SetBoundary(boundary)
+		for _, r := range ranges {
+			part, err := mw.CreatePart(partHeader(r, contentType, size))
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			rc, err := Store.GetFileRange(fileHash, r.Start, r.End)
+			if err != nil {
+				pw.CloseWithError(fmt.Errorf("get file range failed: %w", err))
+				return
+			}
+			_, err = io.Copy(part, rc)
+			rc.Close()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(mw.Close())
+	}()
+	return pr
 }
 
 func getContentType(fileName string) string {
@@ -0,0 +1,192 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"video-platform/internal/store"
+)
+
+func TestParseRangeHeader(t *testing.T) {
+	const size = 100
+	for _, tc := range []struct {
+		header  string
+		want    []ByteRange
+		invalid bool // 语法错误，Range 被忽略
+		unsat   bool // 416
+	}{
+		{header: "bytes=0-9", want: []ByteRange{{0, 9}}},
+		{header: "bytes=90-", want: []ByteRange{{90, 99}}},
+		{header: "bytes=-10", want: []ByteRange{{90, 99}}},
+		{header: "bytes=-200", want: []ByteRange{{0, 99}}},
+		{header: "bytes=95-200", want: []ByteRange{{95, 99}}},
+		{header: "bytes=0-0, 10-19 ,-5", want: []ByteRange{{0, 0}, {10, 19}, {95, 99}}},
+		{header: "bytes=100-,0-4", want: []ByteRange{{0, 4}}}, // 无法满足的区间被丢弃
+		{header: "bytes=100-", unsat: true},
+		{header: "bytes=100-200,150-", unsat: true},
+		{header: "bytes=-0", unsat: true},
+		{header: "bytes=10-5", invalid: true},
+		{header: "bytes=a-5", invalid: true},
+		{header: "bytes=5", invalid: true},
+		{header: "items=0-5", invalid: true},
+	} {
+		got, err := parseRangeHeader(tc.header, size)
+		switch {
+		case tc.unsat:
+			if err != errRangeNotSatisfiable {
+				t.Errorf("%q: err = %v, want not satisfiable", tc.header, err)
+			}
+		case tc.invalid:
+			if err == nil || err == errRangeNotSatisfiable {
+				t.Errorf("%q: err = %v, want syntax error", tc.header, err)
+			}
+		case err != nil || !reflect.DeepEqual(got, tc.want):
+			t.Errorf("%q = %v, %v; want %v", tc.header, got, err, tc.want)
+		}
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	const etag = `"sha256:abc"`
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	before := modified.Add(-time.Hour).Format(http.TimeFormat)
+	at := modified.Format(http.TimeFormat)
+
+	for _, tc := range []struct {
+		name string
+		req  DownloadRequest
+		want int
+	}{
+		{"none", DownloadRequest{}, 0},
+		{"if-match hit", DownloadRequest{IfMatch: `"x", ` + etag}, 0},
+		{"if-match star", DownloadRequest{IfMatch: "*"}, 0},
+		{"if-match miss", DownloadRequest{IfMatch: `"x"`}, http.StatusPreconditionFailed},
+		{"if-match weak", DownloadRequest{IfMatch: "W/" + etag}, http.StatusPreconditionFailed},
+		{"if-unmodified-since passed", DownloadRequest{IfUnmodifiedSince: before}, http.StatusPreconditionFailed},
+		{"if-unmodified-since ok", DownloadRequest{IfUnmodifiedSince: at}, 0},
+		{"if-match wins over if-unmodified-since", DownloadRequest{IfMatch: etag, IfUnmodifiedSince: before}, 0},
+		{"if-none-match hit", DownloadRequest{Method: http.MethodGet, IfNoneMatch: etag}, http.StatusNotModified},
+		{"if-none-match weak hit", DownloadRequest{Method: http.MethodHead, IfNoneMatch: "W/" + etag}, http.StatusNotModified},
+		{"if-none-match miss", DownloadRequest{Method: http.MethodGet, IfNoneMatch: `"x"`}, 0},
+		{"if-none-match on other method", DownloadRequest{Method: http.MethodPost, IfNoneMatch: "*"}, http.StatusPreconditionFailed},
+		{"if-modified-since not modified", DownloadRequest{IfModifiedSince: at}, http.StatusNotModified},
+		{"if-modified-since modified", DownloadRequest{IfModifiedSince: before}, 0},
+		{"if-none-match wins over if-modified-since", DownloadRequest{IfNoneMatch: `"x"`, IfModifiedSince: at}, 0},
+	} {
+		if got := checkPreconditions(tc.req, etag, modified); got != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	const etag = `"sha256:abc"`
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		ifRange string
+		want    bool
+	}{
+		{"", true},
+		{etag, true},
+		{`"other"`, false},
+		{"W/" + etag, false}, // If-Range 只做强比较
+		{modified.Format(http.TimeFormat), true},
+		{modified.Add(time.Second).Format(http.TimeFormat), false},
+		{"not a date", false},
+	} {
+		if got := ifRangeMatches(tc.ifRange, etag, modified); got != tc.want {
+			t.Errorf("If-Range %q = %v, want %v", tc.ifRange, got, tc.want)
+		}
+	}
+}
+
+func TestOpenDownloadRanges(t *testing.T) {
+	setupLogicTest(t, nil)
+	data := []byte(strings.Repeat("0123456789", 10))
+	uploadSource(t, data)
+	sum := sha256.Sum256(data)
+	hash := store.FormatFileHash(store.HashSHA256, sum[:])
+
+	manyRanges := make([]string, maxRanges+1)
+	for i := range manyRanges {
+		manyRanges[i] = fmt.Sprintf("%d-%d", i, i)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		req    DownloadRequest
+		status int
+		ranges int
+	}{
+		{name: "whole file", req: DownloadRequest{}, status: http.StatusOK},
+		{name: "suffix", req: DownloadRequest{Range: "bytes=-10"}, status: http.StatusPartialContent, ranges: 1},
+		{name: "multi range", req: DownloadRequest{Range: "bytes=0-4,50-59,-3"}, status: http.StatusPartialContent, ranges: 3},
+		{name: "unsatisfiable", req: DownloadRequest{Range: "bytes=200-,300-400"}, status: http.StatusRequestedRangeNotSatisfiable},
+		{name: "too many ranges", req: DownloadRequest{Range: "bytes=" + strings.Join(manyRanges, ",")}, status: http.StatusOK},
+		{name: "overlapping ranges", req: DownloadRequest{Range: "bytes=0-79,20-99"}, status: http.StatusOK},
+		{name: "syntax error", req: DownloadRequest{Range: "bytes=9-1"}, status: http.StatusOK},
+		{name: "weak if-range", req: DownloadRequest{Range: "bytes=0-4", IfRange: `W/"` + hash + `"`}, status: http.StatusOK},
+		{name: "matching if-range", req: DownloadRequest{Range: "bytes=0-4", IfRange: `"` + hash + `"`}, status: http.StatusPartialContent, ranges: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.req.Method = http.MethodGet
+			result, err := openDownload(context.Background(), hash, "video.mp4", tc.req)
+			if err != nil {
+				t.Fatalf("openDownload: %v", err)
+			}
+			if result.Status != tc.status || len(result.Ranges) != tc.ranges {
+				t.Fatalf("status = %d with %d ranges, want %d with %d", result.Status, len(result.Ranges), tc.status, tc.ranges)
+			}
+			if result.Reader == nil {
+				return
+			}
+			body, err := io.ReadAll(result.Reader)
+			result.Reader.Close()
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+			if int64(len(body)) != result.ContentLength {
+				t.Fatalf("body is %d bytes, Content-Length %d", len(body), result.ContentLength)
+			}
+
+			switch len(result.Ranges) {
+			case 0:
+				if !bytes.Equal(body, data) {
+					t.Fatal("whole file body differs")
+				}
+			case 1:
+				r := result.Ranges[0]
+				if !bytes.Equal(body, data[r.Start:r.End+1]) {
+					t.Fatalf("range body = %q", body)
+				}
+			default:
+				mr := multipart.NewReader(bytes.NewReader(body), result.Boundary)
+				for _, r := range result.Ranges {
+					part, err := mr.NextPart()
+					if err != nil {
+						t.Fatalf("next part: %v", err)
+					}
+					if got, want := part.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-%d/%d", r.Start, r.End, len(data)); got != want {
+						t.Fatalf("Content-Range = %q, want %q", got, want)
+					}
+					got, _ := io.ReadAll(part)
+					if !bytes.Equal(got, data[r.Start:r.End+1]) {
+						t.Fatalf("part %v = %q", r, got)
+					}
+				}
+				if _, err := mr.NextPart(); err != io.EOF {
+					t.Fatalf("extra part: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"video-platform/internal/store"
+)
+
+var ErrEncryptionDisabled = errors.New("storage encryption is not enabled")
+
+// KeyRotationResult 重新包装数据密钥的结果
+type KeyRotationResult struct {
+	Files     int      `json:"files"`
+	Rewrapped int      `json:"rewrapped"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// RotateStorageKeys 用当前主密钥重新包装所有文件的数据密钥，文件内容不变。
+// 完成且没有错误后即可从配置中移除旧主密钥（上传中的文件在合并时会改用当前主密钥）。
+func RotateStorageKeys(ctx context.Context) (*KeyRotationResult, error) {
+	rotator, ok := Store.(store.KeyRotator)
+	if !ok {
+		return nil, ErrEncryptionDisabled
+	}
+	hashes, err := rotator.KeyedFiles()
+	if err != nil {
+		return nil, fmt.Errorf("list data keys failed: %w", err)
+	}
+
+	result := &KeyRotationResult{Files: len(hashes)}
+	for _, hash := range hashes {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		rewrapped, err := rewrapFileKey(ctx, rotator, hash)
+		if err != nil {
+			log.Printf("Warning: rewrap data key of %s failed: %v", hash, err)
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", hash, err))
+			continue
+		}
+		if rewrapped {
+			result.Rewrapped++
+		}
+	}
+	log.Printf("Key rotation finished: files=%d rewrapped=%d errors=%d", result.Files, result.Rewrapped, len(result.Errors))
+	return result, nil
+}
+
+// rewrapFileKey 与同一 hash 的合并、删除互斥，避免覆盖合并期间写入的新密钥
+func rewrapFileKey(ctx context.Context, rotator store.KeyRotator, fileHash string) (bool, error) {
+	lock := blobLock(fileHash)
+	if err := lock.Lock(ctx); err != nil {
+		return false, fmt.Errorf("acquire lock failed: %w", err)
+	}
+	defer lock.Unlock(context.Background())
+	return rotator.RewrapFileKey(fileHash)
+}
@@ -0,0 +1,394 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"video-platform/internal/db"
+	"video-platform/internal/redis"
+	"video-platform/internal/store"
+)
+
+var (
+	ErrInvalidFileHash     = errors.New(`file_hash must be "sha256:<hex>" or a legacy "md5:<hex>" digest`)
+	ErrInvalidStrongHash   = errors.New(`strong_hash must be "sha256:<hex>"`)
+	ErrHashBackfillRunning = errors.New("strong hash backfill is already running")
+)
+
+const hashBackfillLockTTL = 10 * time.Minute
+
+// normalizeFileHash 校验客户端声明的文件 hash 并返回规范形式
+func normalizeFileHash(fileHash string) (string, error) {
+	normalized, err := store.NormalizeFileHash(fileHash)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidFileHash, err)
+	}
+	return normalized, nil
+}
+
+// normalizeStrongHash 校验客户端声明的强摘要（可省略）并返回规范形式
+func normalizeStrongHash(strongHash string) (string, error) {
+	if strongHash == "" {
+		return "", nil
+	}
+	normalized, err := store.NormalizeFileHash(strongHash)
+	if err != nil || !store.IsStrongFileHash(normalized) {
+		return "", ErrInvalidStrongHash
+	}
+	return normalized, nil
+}
+
+// canonicalFileHash 规范化后续请求中的文件 hash，无法解析时原样返回（查不到对应的会话）
+func canonicalFileHash(fileHash string) string {
+	if normalized, err := store.NormalizeFileHash(fileHash); err == nil {
+		return normalized
+	}
+	return fileHash
+}
+
+// mergeStrongHash 合并 md5 文件时要求的 SHA-256：同 hash 的文件已存在时，新内容与它一致才替换，
+// 否则是 MD5 碰撞，存储层把新内容改存在自己的强摘要下。已有文件还没有记录强摘要时现场计算并记录。
+// 调用方需持有该文件的 blob 锁。
+func mergeStrongHash(ctx context.Context, fileHash string) (string, error) {
+	if store.IsStrongFileHash(fileHash) {
+		return "", nil
+	}
+	exists, err := db.FileMetaExists(ctx, fileHash)
+	if err != nil || !exists {
+		return "", err
+	}
+	fm, err := db.GetFileMeta(ctx, fileHash)
+	if err != nil {
+		return "", err
+	}
+	if fm.StrongHash != "" {
+		return fm.StrongHash, nil
+	}
+	if fm.QuarantinedAt != nil || !Store.FileExists(fileHash) {
+		// 已有内容不可信或已丢失，重新上传即修复
+		return "", nil
+	}
+
+	actual, strong, size, err := hashStored(ctx, fileHash, 0)
+	if err != nil {
+		return "", fmt.Errorf("hash stored file failed: %w", err)
+	}
+	if actual != canonicalFileHash(fileHash) || size != fm.FileSize {
+		return "", nil
+	}
+	if err := db.SetStrongHash(ctx, fileHash, strong); err != nil {
+		log.Printf("Warning: record strong hash of %s failed: %v", fileHash, err)
+	}
+	return strong, nil
+}
+
+// sameContent md5 标识的文件以 (md5, 大小, 强摘要) 确定内容，三者都一致才视为同一文件；
+// sha256 标识本身足以确定内容，只需大小一致
+func sameContent(fm *db.FileMeta, size int64, strongHash string) bool {
+	if fm.FileSize != size {
+		return false
+	}
+	if store.IsStrongFileHash(fm.FileHash) {
+		return true
+	}
+	return fm.StrongHash != "" && fm.StrongHash == strongHash
+}
+
+// recordStrongHash 合并 md5 文件后记录强摘要，合并时未校验强摘要的读回存储文件计算
+func recordStrongHash(ctx context.Context, fileHash, strongHash string) {
+	if store.IsStrongFileHash(fileHash) {
+		return
+	}
+	if strongHash == "" {
+		_, strong, _, err := hashStored(ctx, fileHash, 0)
+		if err != nil {
+			log.Printf("Warning: compute strong hash of %s failed: %v", fileHash, err)
+			return
+		}
+		strongHash = strong
+	}
+	if err := db.SetStrongHash(ctx, fileHash, strongHash); err != nil {
+		log.Printf("Warning: record strong hash of %s failed: %v", fileHash, err)
+	}
+}
+
+// HashBackfillResult 补算强摘要与迁移文件标识的结果
+type HashBackfillResult struct {
+	DryRun     bool            `json:"dry_run"`
+	Files      int             `json:"files"`      // 缺少强摘要的文件
+	Backfilled int             `json:"backfilled"` // 已记录强摘要的文件
+	Skipped    int             `json:"skipped"`    // 被隔离的文件，内容不可信
+	Cancelled  int             `json:"cancelled"`  // 以旧标识进行中、被取消的上传（客户端重新 init 后从头上传）
+	Legacy     int             `json:"legacy"`     // 以不带前缀的旧标识登记的文件
+	Migrated   int             `json:"migrated"`   // 已迁移标识的文件
+	References int             `json:"references"` // 没有对应文件、单独迁移的旧标识引用
+	Migrations []HashMigration `json:"migrations,omitempty"`
+	Errors     []string        `json:"errors,omitempty"`
+}
+
+// HashMigration 一个文件的标识迁移。"md5:<hex>" 已被内容不同的文件占用时改用强摘要；
+// dry run 时强摘要尚未补算的文件 To 为空
+type HashMigration struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Folded bool   `json:"folded,omitempty"` // 新标识已有同一内容的文件，引用并入其中
+}
+
+// BackfillStrongHashes 为升级前上传的文件读取存储内容、计算并记录 SHA-256，
+// 并把不带前缀的旧 md5 标识迁移为 "md5:<hex>"：存储中的文件、FileMeta 与所有引用
+// （用户文件、版本、分享、转码任务、上传会话等）、墓碑。
+// 计算时同时核对文件 hash 与大小，不一致的文件只报告，由完整性巡检处理。
+// rate 为读取限速（字节/秒），0 表示不限速。中途退出后重新执行即可继续。
+func BackfillStrongHashes(ctx context.Context, dryRun bool, rate int64) (*HashBackfillResult, error) {
+	lock := redis.NewLock("hashes:backfill", hashBackfillLockTTL)
+	acquired, err := lock.TryLock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquire lock failed: %w", err)
+	}
+	if !acquired {
+		return nil, ErrHashBackfillRunning
+	}
+	defer lock.Unlock(context.Background())
+
+	result := &HashBackfillResult{DryRun: dryRun}
+	if err := cancelLegacyUploads(ctx, result); err != nil {
+		return result, err
+	}
+
+	metas, err := db.ListFileMetasWithoutStrongHash(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list file meta failed: %w", err)
+	}
+	result.Files = len(metas)
+	log.Printf("Strong hash backfill started: files=%d dry_run=%v", len(metas), dryRun)
+
+	for i := range metas {
+		fm := &metas[i]
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if fm.QuarantinedAt != nil {
+			result.Skipped++
+			continue
+		}
+		if dryRun {
+			continue
+		}
+		_ = lock.Extend(ctx, hashBackfillLockTTL)
+		if err := backfillStrongHash(ctx, fm, rate); err != nil {
+			log.Printf("Warning: backfill strong hash of %s failed: %v", fm.FileHash, err)
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", fm.FileHash, err))
+			continue
+		}
+		result.Backfilled++
+	}
+
+	if err := migrateLegacyHashes(ctx, lock, result); err != nil {
+		return result, err
+	}
+
+	log.Printf("Strong hash backfill finished: files=%d backfilled=%d skipped=%d legacy=%d migrated=%d references=%d errors=%d",
+		result.Files, result.Backfilled, result.Skipped, result.Legacy, result.Migrated, result.References, len(result.Errors))
+	return result, nil
+}
+
+// cancelLegacyUploads 以旧标识进行中的上传无法迁移分片（存储中的分片按 hash 存放），
+// 取消会话并清理分片；客户端再次 init 时按规范标识新建会话
+func cancelLegacyUploads(ctx context.Context, result *HashBackfillResult) error {
+	sessions, err := db.ListLegacyUploadSessions(ctx)
+	if err != nil {
+		return fmt.Errorf("list upload sessions failed: %w", err)
+	}
+	for _, sess := range sessions {
+		result.Cancelled++
+		if result.DryRun {
+			continue
+		}
+		if err := db.FinishUploadSessions(ctx, sess.UserID, sess.FileHash, db.UploadSessionCancelled); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("cancel upload %s: %v", sess.ID, err))
+			continue
+		}
+		_ = Store.CleanupChunks(sess.UserID, sess.FileHash)
+		_ = redis.ClearUploadedChunks(ctx, sess.UserID, sess.FileHash)
+	}
+	return nil
+}
+
+// migrateLegacyHashes 迁移以旧标识登记的文件，再迁移没有对应文件的其余引用
+func migrateLegacyHashes(ctx context.Context, lock *redis.DistributedLock, result *HashBackfillResult) error {
+	metas, err := db.ListLegacyFileMetas(ctx)
+	if err != nil {
+		return fmt.Errorf("list legacy file meta failed: %w", err)
+	}
+	result.Legacy = len(metas)
+	for i := range metas {
+		fm := &metas[i]
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_ = lock.Extend(ctx, hashBackfillLockTTL)
+		m, err := migrateFileHash(ctx, fm, result.DryRun)
+		if m != nil {
+			result.Migrations = append(result.Migrations, *m)
+		}
+		if err != nil {
+			log.Printf("Warning: migrate file hash %s failed: %v", fm.FileHash, err)
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", fm.FileHash, err))
+			continue
+		}
+		if !result.DryRun {
+			result.Migrated++
+		}
+	}
+
+	refs, err := db.ListLegacyHashReferences(ctx)
+	if err != nil {
+		return fmt.Errorf("list legacy references failed: %w", err)
+	}
+	for _, old := range refs {
+		newHash, err := store.NormalizeFileHash(old)
+		if err != nil {
+			// 不是文件 hash（如手工写入的值），保持原样
+			continue
+		}
+		if result.DryRun {
+			result.References++
+			continue
+		}
+		userIDs, err := db.RenameHashReferences(ctx, old, newHash)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", old, err))
+			continue
+		}
+		moveTombstones(ctx, userIDs, old, newHash)
+		result.References++
+	}
+	return nil
+}
+
+// migrateFileHash 把一个旧标识的文件迁移为 "md5:<hex>"。该标识已被内容不同的文件占用
+// （MD5 碰撞，或大小不同）时改用强摘要。存储中先建立新标识的链接，数据库中一次性改写所有引用，
+// 最后删除旧标识的文件；中途失败时旧标识仍然完整可用，重新执行即可。
+func migrateFileHash(ctx context.Context, fm *db.FileMeta, dryRun bool) (*HashMigration, error) {
+	m := &HashMigration{From: fm.FileHash, To: canonicalFileHash(fm.FileHash)}
+	if m.To == m.From {
+		return nil, fmt.Errorf("%w: cannot migrate %q", ErrInvalidFileHash, fm.FileHash)
+	}
+	taken, err := db.FileMetaExists(ctx, m.To)
+	if err != nil {
+		return m, err
+	}
+	if taken {
+		existing, err := db.GetFileMeta(ctx, m.To)
+		if err != nil {
+			return m, err
+		}
+		m.Folded = sameContent(existing, fm.FileSize, fm.StrongHash)
+	}
+	if taken && !m.Folded {
+		m.To = fm.StrongHash
+		if m.To == "" {
+			if dryRun {
+				return m, nil
+			}
+			return m, fmt.Errorf("%s is taken by different content and the strong hash is unknown", canonicalFileHash(fm.FileHash))
+		}
+		if m.Folded, err = db.FileMetaExists(ctx, m.To); err != nil {
+			return m, err
+		}
+	}
+	if dryRun {
+		return m, nil
+	}
+
+	linker, ok := Store.(store.FileLinker)
+	if !ok {
+		return m, fmt.Errorf("storage backend %T cannot link files", Store)
+	}
+	var blobs []*heldBlob
+	for _, h := range []string{m.From, m.To} {
+		blob, err := lockBlob(ctx, h)
+		if err != nil {
+			return m, err
+		}
+		defer blob.Unlock()
+		blobs = append(blobs, blob)
+	}
+
+	newPath := fm.FilePath
+	if Store.FileExists(m.From) {
+		path, err := linker.LinkFile(m.From, m.To)
+		if err != nil {
+			return m, fmt.Errorf("link stored file failed: %w", err)
+		}
+		newPath = path
+	}
+	for _, blob := range blobs {
+		if err := blob.Check(); err != nil {
+			return m, err
+		}
+	}
+	rename, err := db.RenameFileHash(ctx, m.From, m.To, newPath)
+	if err != nil {
+		return m, fmt.Errorf("rename references failed: %w", err)
+	}
+	m.Folded = rename.Folded
+	if err := Store.DeleteFile(m.From); err != nil {
+		// 没有引用的旧文件由垃圾回收清理
+		log.Printf("Warning: delete stored file %s after migration failed: %v", m.From, err)
+	}
+	moveTombstones(ctx, rename.UserIDs, m.From, m.To)
+	log.Printf("Migrated file hash %s -> %s (folded=%v)", m.From, m.To, m.Folded)
+	return m, nil
+}
+
+// moveTombstones 把用户的墓碑移到新标识下
+func moveTombstones(ctx context.Context, userIDs []int, oldHash, newHash string) {
+	for _, userID := range userIDs {
+		if err := redis.RenameTombstone(ctx, userID, oldHash, newHash); err != nil {
+			log.Printf("Warning: move tombstone of user %d from %s failed: %v", userID, oldHash, err)
+		}
+	}
+}
+
+// backfillStrongHash 不持有文件锁读取文件（限速读取大文件可能很久，不能一直阻塞合并与删除），
+// 读完后在锁内确认期间文件没有被替换或删除再登记：重新合并会更新 verified_at
+func backfillStrongHash(ctx context.Context, fm *db.FileMeta, rate int64) error {
+	if store.IsStrongFileHash(fm.FileHash) {
+		return db.SetStrongHash(ctx, fm.FileHash, fm.FileHash)
+	}
+
+	actual, strong, size, err := hashStored(ctx, fm.FileHash, rate)
+	if err != nil {
+		return err
+	}
+
+	blob, err := lockBlob(ctx, fm.FileHash)
+	if err != nil {
+		return err
+	}
+	defer blob.Unlock()
+
+	current, err := db.GetFileMeta(ctx, fm.FileHash)
+	if err != nil {
+		return err
+	}
+	if current.FileSize != fm.FileSize || current.QuarantinedAt != nil || !sameTime(current.VerifiedAt, fm.VerifiedAt) {
+		return fmt.Errorf("stored file changed during backfill, run again")
+	}
+	if actual != canonicalFileHash(fm.FileHash) || size != fm.FileSize {
+		return fmt.Errorf("stored content does not match (hash %s, size %d), run scrub", actual, size)
+	}
+	return db.SetStrongHash(ctx, fm.FileHash, strong)
+}
+
+// sameTime 两个可为空的时间相同
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
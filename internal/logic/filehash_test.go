@@ -0,0 +1,286 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"video-platform/internal/db"
+	"video-platform/internal/redis"
+	"video-platform/internal/store"
+)
+
+// storeMD5File 以升级前的方式登记一个 md5 文件（没有强摘要），返回其规范标识
+func storeMD5File(t *testing.T, data []byte) string {
+	t.Helper()
+	sum := md5.Sum(data)
+	hash := store.FormatFileHash(store.HashMD5, sum[:])
+	if err := Store.WriteChunk(testUserID, hash, 0, bytes.NewReader(data), nil); err != nil {
+		t.Fatalf("write %s: %v", hash, err)
+	}
+	path, size, err := Store.MergeChunks(testUserID, hash, 1, int64(len(data)), "")
+	if err != nil {
+		t.Fatalf("merge %s: %v", hash, err)
+	}
+	now := time.Now()
+	if err := db.DB.Create(&db.FileMeta{FileHash: hash, FilePath: path, FileSize: size, RefCount: 1, VerifiedAt: &now}).Error; err != nil {
+		t.Fatalf("create file meta: %v", err)
+	}
+	return hash
+}
+
+// strongHashOf 返回数据的 "sha256:<hex>"
+func strongHashOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return store.FormatFileHash(store.HashSHA256, sum[:])
+}
+
+// remergingStore 读取文件时模拟同 hash 被重新合并（更新 verified_at）
+type remergingStore struct {
+	store.Uploader
+}
+
+func (s remergingStore) GetFile(fileHash string) (io.ReadCloser, int64, error) {
+	db.DB.Model(&db.FileMeta{}).Where("file_hash = ?", fileHash).UpdateColumn("verified_at", time.Now().Add(time.Second))
+	return s.Uploader.GetFile(fileHash)
+}
+
+func TestBackfillStrongHash(t *testing.T) {
+	setupLogicTest(t, nil)
+	ctx := context.Background()
+	data := []byte("uploaded before strong hashes")
+	hash := storeMD5File(t, data)
+
+	result, err := BackfillStrongHashes(ctx, false, 0)
+	if err != nil {
+		t.Fatalf("BackfillStrongHashes: %v", err)
+	}
+	if result.Files != 1 || result.Backfilled != 1 || len(result.Errors) != 0 {
+		t.Fatalf("result = %+v, want one file backfilled", result)
+	}
+	fm, err := db.GetFileMeta(ctx, hash)
+	if err != nil {
+		t.Fatalf("GetFileMeta: %v", err)
+	}
+	if fm.StrongHash != strongHashOf(data) {
+		t.Fatalf("strong hash = %q, want %q", fm.StrongHash, strongHashOf(data))
+	}
+}
+
+func TestBackfillStrongHashSkipsFileReplacedDuringRead(t *testing.T) {
+	setupLogicTest(t, nil)
+	ctx := context.Background()
+	hash := storeMD5File(t, []byte("replaced while hashing"))
+	Store = remergingStore{Store}
+
+	result, err := BackfillStrongHashes(ctx, false, 0)
+	if err != nil {
+		t.Fatalf("BackfillStrongHashes: %v", err)
+	}
+	if result.Backfilled != 0 || len(result.Errors) != 1 {
+		t.Fatalf("result = %+v, want the replaced file reported as an error", result)
+	}
+	fm, err := db.GetFileMeta(ctx, hash)
+	if err != nil {
+		t.Fatalf("GetFileMeta: %v", err)
+	}
+	if fm.StrongHash != "" {
+		t.Fatalf("strong hash %q recorded for a file replaced during the read", fm.StrongHash)
+	}
+}
+
+// storeLegacyFile 以不带前缀的旧标识登记一个 md5 文件，返回旧标识
+func storeLegacyFile(t *testing.T, data []byte, refs int) string {
+	t.Helper()
+	canonical := storeMD5File(t, data)
+	legacy := strings.TrimPrefix(canonical, store.HashMD5+":")
+	path, err := Store.(store.FileLinker).LinkFile(canonical, legacy)
+	if err != nil {
+		t.Fatalf("link legacy file: %v", err)
+	}
+	if err := Store.DeleteFile(canonical); err != nil {
+		t.Fatalf("delete canonical file: %v", err)
+	}
+	if err := db.DB.Model(&db.FileMeta{}).Where("file_hash = ?", canonical).
+		Updates(map[string]interface{}{"file_hash": legacy, "file_path": path, "ref_count": refs}).Error; err != nil {
+		t.Fatalf("rename file meta: %v", err)
+	}
+	return legacy
+}
+
+// runBackfill 执行补算与迁移，要求没有错误
+func runBackfill(t *testing.T) *HashBackfillResult {
+	t.Helper()
+	result, err := BackfillStrongHashes(context.Background(), false, 0)
+	if err != nil {
+		t.Fatalf("BackfillStrongHashes: %v", err)
+	}
+	if len(result.Errors) != 0 {
+		t.Fatalf("backfill errors: %v", result.Errors)
+	}
+	return result
+}
+
+// assertFileMeta 检查迁移后的 FileMeta 与待删除记录
+func assertFileMeta(t *testing.T, hash string, refs int, pending bool) *db.FileMeta {
+	t.Helper()
+	ctx := context.Background()
+	fm, err := db.GetFileMeta(ctx, hash)
+	if err != nil {
+		t.Fatalf("GetFileMeta(%s): %v", hash, err)
+	}
+	if fm.RefCount != refs {
+		t.Fatalf("%s ref count = %d, want %d", hash, fm.RefCount, refs)
+	}
+	var n int64
+	if err := db.DB.Model(&db.PendingDeletion{}).Where("file_hash = ?", hash).Count(&n).Error; err != nil {
+		t.Fatalf("count pending deletions: %v", err)
+	}
+	if (n == 1) != pending {
+		t.Fatalf("%s pending deletion = %v, want %v", hash, n == 1, pending)
+	}
+	return fm
+}
+
+// assertMigratedAway 旧标识的 FileMeta、存储文件、待删除记录与墓碑都已移走
+func assertMigratedAway(t *testing.T, legacy string) {
+	t.Helper()
+	ctx := context.Background()
+	if exists, err := db.FileMetaExists(ctx, legacy); err != nil || exists {
+		t.Fatalf("legacy file meta exists = %v, %v", exists, err)
+	}
+	if Store.FileExists(legacy) {
+		t.Fatal("legacy stored file kept")
+	}
+	var n int64
+	db.DB.Model(&db.PendingDeletion{}).Where("file_hash = ?", legacy).Count(&n)
+	if n != 0 {
+		t.Fatal("pending deletion of the legacy hash kept")
+	}
+	if exists, _, err := redis.CheckTombstone(ctx, testUserID, legacy); err != nil || exists {
+		t.Fatalf("legacy tombstone exists = %v, %v", exists, err)
+	}
+}
+
+func TestMigrateLegacyFileHash(t *testing.T) {
+	setupLogicTest(t, nil)
+	ctx := context.Background()
+	data := []byte("uploaded with a bare md5 hash")
+	legacy := storeLegacyFile(t, data, 1)
+	want := store.HashMD5 + ":" + legacy
+
+	uc := &db.UserContent{UserID: testUserID, ContentID: 1, FileName: "a.mp4", FileHash: legacy, Status: 1}
+	session := &db.UploadSession{ID: "legacy-upload", UserID: testUserID + 1, FileHash: legacy, ExpiresAt: time.Now().Add(time.Hour)}
+	for _, row := range []interface{}{uc, session} {
+		if err := db.DB.Create(row).Error; err != nil {
+			t.Fatalf("create %T: %v", row, err)
+		}
+	}
+	if err := redis.CreateTombstone(ctx, testUserID, legacy, 1, "completed"); err != nil {
+		t.Fatalf("CreateTombstone: %v", err)
+	}
+
+	result := runBackfill(t)
+	if result.Migrated != 1 || result.Cancelled != 1 || len(result.Migrations) != 1 {
+		t.Fatalf("result = %+v, want one migration and one cancelled upload", result)
+	}
+	if m := result.Migrations[0]; m.From != legacy || m.To != want || m.Folded {
+		t.Fatalf("migration = %+v, want %s -> %s", m, legacy, want)
+	}
+	fm := assertFileMeta(t, want, 1, false)
+	if fm.StrongHash != strongHashOf(data) || !Store.FileExists(want) {
+		t.Fatalf("migrated file: strong hash %q, stored %v", fm.StrongHash, Store.FileExists(want))
+	}
+	assertMigratedAway(t, legacy)
+
+	if err := db.DB.First(uc, uc.ID).Error; err != nil || uc.FileHash != want {
+		t.Fatalf("user content hash = %q, %v; want %s", uc.FileHash, err, want)
+	}
+	if err := db.DB.First(session, "id = ?", session.ID).Error; err != nil || session.Status != db.UploadSessionCancelled {
+		t.Fatalf("legacy upload session status = %d, %v; want cancelled", session.Status, err)
+	}
+	if exists, _, err := redis.CheckTombstone(ctx, testUserID, want); err != nil || !exists {
+		t.Fatalf("tombstone under new hash exists = %v, %v", exists, err)
+	}
+}
+
+func TestMigrateLegacyFileHashFoldsIntoExisting(t *testing.T) {
+	setupLogicTest(t, nil)
+	ctx := context.Background()
+	data := []byte("uploaded twice, before and after the upgrade")
+	legacy := storeLegacyFile(t, data, 2)
+	// 升级后同一内容以 md5:<hex> 再次上传，content 1 两个标识下各有一个版本
+	canonical := storeMD5File(t, data)
+
+	for _, row := range []interface{}{
+		&db.ContentVersion{ContentID: 1, FileHash: legacy, Kind: "upload"},
+		&db.ContentVersion{ContentID: 2, FileHash: legacy, Kind: "upload"},
+		&db.ContentVersion{ContentID: 1, FileHash: canonical, Kind: "upload"},
+		&db.UserContent{UserID: testUserID, ContentID: 1, FileName: "a.mp4", FileHash: legacy, Status: 1},
+	} {
+		if err := db.DB.Create(row).Error; err != nil {
+			t.Fatalf("create %T: %v", row, err)
+		}
+	}
+	if err := redis.CreateTombstone(ctx, testUserID, legacy, 1, "completed"); err != nil {
+		t.Fatalf("CreateTombstone: %v", err)
+	}
+
+	result := runBackfill(t)
+	if len(result.Migrations) != 1 || !result.Migrations[0].Folded || result.Migrations[0].To != canonical {
+		t.Fatalf("migrations = %+v, want %s folded into %s", result.Migrations, legacy, canonical)
+	}
+	// 2 个旧引用去掉重复的版本，加上已有的 1 个
+	assertFileMeta(t, canonical, 2, false)
+	assertMigratedAway(t, legacy)
+	if !Store.FileExists(canonical) {
+		t.Fatal("folded file missing from storage")
+	}
+
+	var versions []db.ContentVersion
+	if err := db.DB.Order("content_id").Find(&versions).Error; err != nil {
+		t.Fatalf("list versions: %v", err)
+	}
+	if len(versions) != 2 || versions[0].ContentID != 1 || versions[1].ContentID != 2 ||
+		versions[0].FileHash != canonical || versions[1].FileHash != canonical {
+		t.Fatalf("versions = %+v, want one %s version for each content", versions, canonical)
+	}
+	if exists, _, err := redis.CheckTombstone(ctx, testUserID, canonical); err != nil || !exists {
+		t.Fatalf("tombstone under new hash exists = %v, %v", exists, err)
+	}
+}
+
+func TestMigrateLegacyFileHashCollisionUsesStrongHash(t *testing.T) {
+	setupLogicTest(t, nil)
+	data := []byte("legacy file with no references left")
+	legacy := storeLegacyFile(t, data, 0)
+	if err := db.DB.Create(&db.PendingDeletion{FileHash: legacy, ReleasedAt: time.Now()}).Error; err != nil {
+		t.Fatalf("create pending deletion: %v", err)
+	}
+	// md5:<hex> 已被内容不同（MD5 碰撞）的文件占用
+	taken := store.HashMD5 + ":" + legacy
+	other := []byte("different content with the same md5")
+	if err := db.DB.Create(&db.FileMeta{FileHash: taken, FileSize: int64(len(other)), RefCount: 1, StrongHash: strongHashOf(other)}).Error; err != nil {
+		t.Fatalf("create colliding file meta: %v", err)
+	}
+
+	result := runBackfill(t)
+	want := strongHashOf(data)
+	if len(result.Migrations) != 1 || result.Migrations[0].To != want || result.Migrations[0].Folded {
+		t.Fatalf("migrations = %+v, want %s -> %s", result.Migrations, legacy, want)
+	}
+	// 没有引用的文件迁移后仍等待删除
+	fm := assertFileMeta(t, want, 0, true)
+	if fm.StrongHash != want || !Store.FileExists(want) {
+		t.Fatalf("migrated file: strong hash %q, stored %v", fm.StrongHash, Store.FileExists(want))
+	}
+	assertMigratedAway(t, legacy)
+	if fm := assertFileMeta(t, taken, 1, false); fm.StrongHash != strongHashOf(other) {
+		t.Fatalf("colliding file meta changed: %+v", fm)
+	}
+}
@@ -0,0 +1,287 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"video-platform/internal/db"
+	"video-platform/internal/redis"
+	"video-platform/internal/store"
+)
+
+var ErrFsckRunning = errors.New("fsck is already running")
+
+// fsck 检查出的问题类别
+const (
+	FsckRefCount       = "refcount_mismatch"    // FileMeta.RefCount 与实际引用数不符
+	FsckUnscheduled    = "unscheduled_deletion" // 没有引用的 FileMeta 未登记待删除，存储文件永远不会被删除
+	FsckMissingFile    = "missing_file"         // 有引用的 FileMeta 在存储中找不到文件
+	FsckDanglingRef    = "dangling_reference"   // 已完成的记录或版本引用的文件没有 FileMeta
+	FsckStaleTombstone = "stale_tombstone"      // completed 墓碑没有对应的已完成记录或文件，会导致错误的秒传
+	FsckTombstoneDrift = "tombstone_content"    // completed 墓碑中的 content_id 与记录不符
+)
+
+const fsckLockTTL = 10 * time.Minute
+
+var lastFsck struct {
+	sync.Mutex
+	report *FsckReport
+}
+
+// FsckIssue 一处不一致
+type FsckIssue struct {
+	Kind     string `json:"kind"`
+	FileHash string `json:"file_hash"`
+	UserID   int    `json:"user_id,omitempty"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+}
+
+// FsckReport 一次检查的结果，Repair 为 false 时只报告不修改
+type FsckReport struct {
+	Repair     bool           `json:"repair"`
+	StartedAt  time.Time      `json:"started_at"`
+	Duration   string         `json:"duration"`
+	FileMetas  int            `json:"file_metas"`
+	Tombstones int            `json:"tombstones"`
+	Summary    map[string]int `json:"summary"`
+	Issues     []FsckIssue    `json:"issues"`
+	Errors     []string       `json:"errors,omitempty"`
+}
+
+func (r *FsckReport) fail(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("Warning: fsck: %s", msg)
+	r.Errors = append(r.Errors, msg)
+}
+
+func (r *FsckReport) add(issue FsckIssue) {
+	r.Issues = append(r.Issues, issue)
+	r.Summary[issue.Kind]++
+}
+
+// Unresolved 未修复的问题数
+func (r *FsckReport) Unresolved() int {
+	n := 0
+	for _, issue := range r.Issues {
+		if !issue.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// RunFsck 核对引用计数、存储文件与墓碑。repair 时修复能自动修复的问题：
+// 重算 RefCount 并补登记/撤销待删除，删除失效墓碑，改正墓碑中的 content_id。
+// 存储文件丢失与悬空引用只报告。多个节点同时运行时返回 ErrFsckRunning。
+func RunFsck(ctx context.Context, repair bool) (*FsckReport, error) {
+	lock := redis.NewLock("fsck:run", fsckLockTTL)
+	acquired, err := lock.TryLock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquire lock failed: %w", err)
+	}
+	if !acquired {
+		return nil, ErrFsckRunning
+	}
+	defer lock.Unlock(context.Background())
+
+	report := &FsckReport{Repair: repair, StartedAt: time.Now(), Summary: make(map[string]int)}
+	log.Printf("Fsck started (repair=%v)", repair)
+
+	missing := fsckFileMetas(ctx, report)
+	_ = lock.Extend(ctx, fsckLockTTL)
+	fsckTombstones(ctx, missing, report)
+
+	elapsed := time.Since(report.StartedAt)
+	report.Duration = elapsed.String()
+	log.Printf("Fsck finished in %v: file_metas=%d tombstones=%d issues=%d unresolved=%d errors=%d",
+		elapsed, report.FileMetas, report.Tombstones, len(report.Issues), report.Unresolved(), len(report.Errors))
+
+	lastFsck.Lock()
+	lastFsck.report = report
+	lastFsck.Unlock()
+	return report, nil
+}
+
+// LastFsckReport 本节点最近一次检查的结果，未执行过时返回 nil
+func LastFsckReport() *FsckReport {
+	lastFsck.Lock()
+	defer lastFsck.Unlock()
+	return lastFsck.report
+}
+
+// fsckFileMetas 核对每个 FileMeta 的引用计数与存储文件，返回存储文件丢失的 hash
+func fsckFileMetas(ctx context.Context, report *FsckReport) map[string]bool {
+	refs, err := db.CountFileRefs(ctx)
+	if err != nil {
+		report.fail("count references: %v", err)
+		return nil
+	}
+	metas, err := db.ListFileMetas(ctx)
+	if err != nil {
+		report.fail("list file meta: %v", err)
+		return nil
+	}
+	pending, err := db.GetPendingDeletionHashes(ctx)
+	if err != nil {
+		report.fail("list pending deletions: %v", err)
+		return nil
+	}
+	exists := storedFileChecker()
+	report.FileMetas = len(metas)
+
+	known := make(map[string]bool, len(metas))
+	missing := make(map[string]bool)
+	for _, fm := range metas {
+		if ctx.Err() != nil {
+			report.fail("interrupted: %v", ctx.Err())
+			return missing
+		}
+		known[fm.FileHash] = true
+		expected := refs[fm.FileHash]
+
+		if fm.RefCount != expected || (expected == 0 && !pending[fm.FileHash]) {
+			fsckRefCount(ctx, fm.FileHash, report)
+		}
+
+		// 无引用的文件等待删除 worker 处理，不要求存储文件存在
+		if expected > 0 && !exists(fm.FileHash) {
+			missing[fm.FileHash] = true
+			report.add(FsckIssue{
+				Kind:     FsckMissingFile,
+				FileHash: fm.FileHash,
+				Detail:   fmt.Sprintf("%d references, path %q", expected, fm.FilePath),
+			})
+		}
+	}
+
+	for hash, n := range refs {
+		if !known[hash] {
+			report.add(FsckIssue{
+				Kind:     FsckDanglingRef,
+				FileHash: hash,
+				Detail:   fmt.Sprintf("%d references without file meta", n),
+			})
+		}
+	}
+	return missing
+}
+
+// fsckRefCount 在数据库中加锁复核（扫描期间引用可能已变化），确认不一致后按需修复
+func fsckRefCount(ctx context.Context, fileHash string, report *FsckReport) {
+	check, err := db.ReconcileFileRefCount(ctx, fileHash, report.Repair)
+	if err != nil {
+		if !errors.Is(err, db.ErrFileMetaNotFound) {
+			report.fail("reconcile refcount %s: %v", fileHash, err)
+		}
+		return
+	}
+	if check.RefCount != check.Expected {
+		report.add(FsckIssue{
+			Kind:     FsckRefCount,
+			FileHash: fileHash,
+			Detail:   fmt.Sprintf("ref_count %d, actual references %d", check.RefCount, check.Expected),
+			Repaired: check.Repaired,
+		})
+	}
+	if check.Expected == 0 && !check.Pending {
+		report.add(FsckIssue{
+			Kind:     FsckUnscheduled,
+			FileHash: fileHash,
+			Detail:   "no references and no pending deletion",
+			Repaired: check.Repaired,
+		})
+	}
+}
+
+// storedFileChecker 存储支持枚举时一次列出全部文件，否则逐个查询
+func storedFileChecker() func(hash string) bool {
+	lister, ok := Store.(store.Lister)
+	if !ok {
+		return Store.FileExists
+	}
+	files, err := lister.ListFiles()
+	if err != nil {
+		log.Printf("Warning: fsck: list files failed, checking one by one: %v", err)
+		return Store.FileExists
+	}
+	stored := make(map[string]bool, len(files))
+	for _, f := range files {
+		stored[f.Hash] = true
+	}
+	return func(hash string) bool {
+		// 列出之后才合并的文件不在列表中
+		return stored[hash] || Store.FileExists(hash)
+	}
+}
+
+// fsckTombstones 核对 completed 墓碑：必须对应该用户已完成（或转码中）的记录，
+// 文件元数据存在且存储文件未丢失，content_id 与记录一致。
+// 没有墓碑的已完成记录不算问题：墓碑会过期，秒传检查会回退到数据库。
+func fsckTombstones(ctx context.Context, missing map[string]bool, report *FsckReport) {
+	tombstones, err := redis.ListTombstones(ctx)
+	if err != nil {
+		report.fail("list tombstones: %v", err)
+		return
+	}
+	uploads, err := db.GetCompletedUploadsForTombstone(ctx)
+	if err != nil {
+		report.fail("list uploads: %v", err)
+		return
+	}
+	metas, err := db.GetFileMetaHashes(ctx)
+	if err != nil {
+		report.fail("load file meta: %v", err)
+		return
+	}
+	report.Tombstones = len(tombstones)
+
+	completed := make(map[string]uint, len(uploads))
+	for _, u := range uploads {
+		if u.Status == 1 || u.Status == 2 {
+			completed[fmt.Sprintf("%d:%s", u.UserID, u.FileHash)] = u.ContentID
+		}
+	}
+
+	for _, t := range tombstones {
+		if t.Status != "completed" {
+			continue
+		}
+		contentID, ok := completed[fmt.Sprintf("%d:%s", t.UserID, t.FileHash)]
+		switch {
+		case !ok, !metas[t.FileHash], missing[t.FileHash]:
+			detail := "no completed upload"
+			if ok {
+				detail = "file is missing"
+			}
+			issue := FsckIssue{Kind: FsckStaleTombstone, FileHash: t.FileHash, UserID: t.UserID, Detail: detail}
+			if report.Repair {
+				if err := redis.DeleteTombstone(ctx, t.UserID, t.FileHash); err != nil {
+					report.fail("delete tombstone %d:%s: %v", t.UserID, t.FileHash, err)
+				} else {
+					issue.Repaired = true
+				}
+			}
+			report.add(issue)
+		case t.ContentID != contentID:
+			issue := FsckIssue{
+				Kind:     FsckTombstoneDrift,
+				FileHash: t.FileHash,
+				UserID:   t.UserID,
+				Detail:   fmt.Sprintf("tombstone content %d, upload content %d", t.ContentID, contentID),
+			}
+			if report.Repair {
+				if err := redis.CreateTombstoneNoExpire(ctx, t.UserID, t.FileHash, contentID, "completed"); err != nil {
+					report.fail("rewrite tombstone %d:%s: %v", t.UserID, t.FileHash, err)
+				} else {
+					issue.Repaired = true
+				}
+			}
+			report.add(issue)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"video-platform/internal/db"
+	"video-platform/internal/redis"
+	"video-platform/internal/store"
+)
+
+var (
+	ErrGCRunning     = errors.New("garbage collection is already running")
+	ErrGCUnsupported = errors.New("storage backend does not support listing")
+)
+
+// 默认回收阈值
+const (
+	DefaultGCOrphanAge = 24 * time.Hour
+	DefaultGCUploadAge = 72 * time.Hour
+
+	gcLockTTL   = 10 * time.Minute
+	gcBatchSize = 1000
+)
+
+// GCConfig 垃圾回收配置
+type GCConfig struct {
+	Interval  time.Duration // 后台回收间隔，0 表示只能通过管理接口触发
+	OrphanAge time.Duration // 没有 FileMeta 的存储文件超过该时长才回收
+	UploadAge time.Duration // 分片目录与上传中/已取消的记录无活动超过该时长才回收
+	DryRun    bool          // 后台回收只报告不删除
+}
+
+var gcConfig = GCConfig{
+	OrphanAge: DefaultGCOrphanAge,
+	UploadAge: DefaultGCUploadAge,
+}
+
+// gcMetrics 通过 expvar 暴露的累计指标
+var gcMetrics = expvar.NewMap("gc")
+
+var lastGC struct {
+	sync.Mutex
+	report *GCReport
+}
+
+// GCItem 一项可回收（或已回收）的对象
+type GCItem struct {
+	UserID    int    `json:"user_id,omitempty"`
+	FileHash  string `json:"file_hash"`
+	ContentID uint   `json:"content_id,omitempty"`
+	Status    *int   `json:"status,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Age       string `json:"age"`
+}
+
+// GCReport 一次回收的结果，DryRun 时只列出将被回收的对象
+type GCReport struct {
+	DryRun           bool      `json:"dry_run"`
+	StartedAt        time.Time `json:"started_at"`
+	Duration         string    `json:"duration"`
+	OrphanFiles      []GCItem  `json:"orphan_files"`
+	StaleUploads     []GCItem  `json:"stale_uploads"`
+	AbandonedRecords []GCItem  `json:"abandoned_records"`
+	OrphanBlocks     []GCItem  `json:"orphan_blocks,omitempty"` // 块级去重存储中没有文件引用的块
+	FreedBytes       int64     `json:"freed_bytes"`
+	Errors           []string  `json:"errors,omitempty"`
+}
+
+func (r *GCReport) fail(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("Warning: gc: %s", msg)
+	r.Errors = append(r.Errors, msg)
+}
+
+// StartGC 按配置启动后台垃圾回收，ctx 取消后返回的 WaitGroup 完成
+func StartGC(ctx context.Context, cfg GCConfig) *sync.WaitGroup {
+	if cfg.OrphanAge <= 0 {
+		cfg.OrphanAge = DefaultGCOrphanAge
+	}
+	if cfg.UploadAge <= 0 {
+		cfg.UploadAge = DefaultGCUploadAge
+	}
+	gcConfig = cfg
+
+	wg := &sync.WaitGroup{}
+	if cfg.Interval <= 0 {
+		return wg
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := RunGC(ctx, cfg.DryRun); err != nil && !errors.Is(err, ErrGCRunning) {
+					log.Printf("Warning: gc failed: %v", err)
+				}
+			}
+		}
+	}()
+	log.Printf("GC started: interval=%s, orphan_age=%s, upload_age=%s, dry_run=%v",
+		cfg.Interval, cfg.OrphanAge, cfg.UploadAge, cfg.DryRun)
+	return wg
+}
+
+// RunGC 执行一次回收。多个节点同时运行时只有拿到锁的节点执行，其余返回 ErrGCRunning。
+func RunGC(ctx context.Context, dryRun bool) (*GCReport, error) {
+	lister, ok := Store.(store.Lister)
+	if !ok {
+		return nil, ErrGCUnsupported
+	}
+
+	lock := redis.NewLock("gc:sweep", gcLockTTL)
+	acquired, err := lock.TryLock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquire lock failed: %w", err)
+	}
+	if !acquired {
+		return nil, ErrGCRunning
+	}
+	defer lock.Unlock(context.Background())
+
+	report := &GCReport{DryRun: dryRun, StartedAt: time.Now()}
+	log.Printf("GC sweep started (dry_run=%v)", dryRun)
+
+	gcOrphanFiles(ctx, lister, report)
+	_ = lock.Extend(ctx, gcLockTTL)
+	gcStaleUploads(ctx, lister, report)
+	_ = lock.Extend(ctx, gcLockTTL)
+	gcAbandonedRecords(ctx, report)
+	if dedup, ok := Store.(store.Deduplicator); ok {
+		_ = lock.Extend(ctx, gcLockTTL)
+		gcDedupBlocks(ctx, dedup, report)
+	}
+
+	elapsed := time.Since(report.StartedAt)
+	report.Duration = elapsed.String()
+	recordGCMetrics(report, elapsed)
+	log.Printf("GC sweep finished in %v: orphan_files=%d stale_uploads=%d abandoned_records=%d orphan_blocks=%d freed=%d errors=%d",
+		elapsed, len(report.OrphanFiles), len(report.StaleUploads), len(report.AbandonedRecords), len(report.OrphanBlocks), report.FreedBytes, len(report.Errors))
+
+	lastGC.Lock()
+	lastGC.report = report
+	lastGC.Unlock()
+	return report, nil
+}
+
+// LastGCReport 本节点最近一次回收的结果，未执行过时返回 nil
+func LastGCReport() *GCReport {
+	lastGC.Lock()
+	defer lastGC.Unlock()
+	return lastGC.report
+}
+
+// gcOrphanFiles 回收没有 FileMeta 的存储文件（合并后写库失败、删除时存储出错等遗留）
+func gcOrphanFiles(ctx context.Context, lister store.Lister, report *GCReport) {
+	files, err := lister.ListFiles()
+	if err != nil {
+		report.fail("list files: %v", err)
+		return
+	}
+	known, err := db.GetFileMetaHashes(ctx)
+	if err != nil {
+		report.fail("load file meta: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, f := range files {
+		age := now.Sub(f.ModTime)
+		if known[f.Hash] || age < gcConfig.OrphanAge {
+			continue
+		}
+		item := GCItem{FileHash: f.Hash, Size: f.Size, Age: age.Truncate(time.Second).String()}
+		if !report.DryRun {
+			deleted, err := deleteOrphanFile(ctx, f.Hash)
+			if err != nil {
+				report.fail("delete orphan file %s: %v", f.Hash, err)
+				continue
+			}
+			if !deleted {
+				continue
+			}
+			report.FreedBytes += f.Size
+		}
+		report.OrphanFiles = append(report.OrphanFiles, item)
+	}
+}
+
+// deleteOrphanFile 持有文件锁并再次确认没有 FileMeta 后删除：列出之后可能刚好有
+// 合并写入了 FileMeta，或者正在合并同 hash 的文件（此时跳过）
+func deleteOrphanFile(ctx context.Context, fileHash string) (bool, error) {
+	lock := blobLock(fileHash)
+	ok, err := lock.TryLock(ctx)
+	if err != nil {
+		return false, fmt.Errorf("acquire lock failed: %w", err)
+	}
+	if !ok {
+		return false, nil
+	}
+	defer lock.Unlock(context.Background())
+
+	if exists, err := db.FileMetaExists(ctx, fileHash); err != nil || exists {
+		return false, err
+	}
+	if err := Store.DeleteFile(fileHash); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// gcStaleUploads 回收长时间无活动、且没有有效上传会话的分片数据与 tus 暂存文件
+func gcStaleUploads(ctx context.Context, lister store.Lister, report *GCReport) {
+	uploads, err := lister.ListUploads()
+	if err != nil {
+		report.fail("list uploads: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, u := range uploads {
+		age := now.Sub(u.ModTime)
+		if age < gcConfig.UploadAge {
+			continue
+		}
+		item := GCItem{UserID: u.UserID, FileHash: u.Hash, Age: age.Truncate(time.Second).String()}
+		if report.DryRun {
+			if !uploadSessionLive(ctx, u.UserID, u.Hash) {
+				report.StaleUploads = append(report.StaleUploads, item)
+			}
+			continue
+		}
+
+		// 与 InitUpload 互斥，避免清理掉刚重新初始化的上传
+		cleaned, err := withUploadInitLock(ctx, u.UserID, u.Hash, func() (bool, error) {
+			if uploadSessionLive(ctx, u.UserID, u.Hash) {
+				return false, nil
+			}
+			if err := db.FinishUploadSessions(ctx, u.UserID, u.Hash, db.UploadSessionCancelled); err != nil {
+				return false, err
+			}
+			_ = redis.ClearUploadedChunks(ctx, u.UserID, u.Hash)
+			return true, Store.CleanupChunks(u.UserID, u.Hash)
+		})
+		if err != nil {
+			report.fail("cleanup upload %d/%s: %v", u.UserID, u.Hash, err)
+			continue
+		}
+		if cleaned {
+			report.StaleUploads = append(report.StaleUploads, item)
+		}
+	}
+
+	// tus 暂存文件：会话结束后残留的部分分片
+	matches, _ := filepath.Glob(filepath.Join(tusDir(), "*"))
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil || fi.IsDir() || now.Sub(fi.ModTime()) < gcConfig.UploadAge {
+			continue
+		}
+		if !report.DryRun {
+			if err := os.Remove(m); err != nil {
+				report.fail("remove tus tail %s: %v", m, err)
+				continue
+			}
+			report.FreedBytes += fi.Size()
+		}
+	}
+}
+
+// gcAbandonedRecords 删除长时间停留在上传中（0）或已取消（-1）的用户记录
+func gcAbandonedRecords(ctx context.Context, report *GCReport) {
+	before := time.Now().Add(-gcConfig.UploadAge)
+	ucs, err := db.ListAbandonedUserContents(ctx, before, gcBatchSize)
+	if err != nil {
+		report.fail("list abandoned records: %v", err)
+		return
+	}
+
+	for i := range ucs {
+		uc := ucs[i]
+		item := GCItem{
+			UserID:    uc.UserID,
+			FileHash:  uc.FileHash,
+			ContentID: uc.ContentID,
+			Status:    &uc.Status,
+			Age:       time.Since(uc.UpdatedAt).Truncate(time.Second).String(),
+		}
+		if report.DryRun {
+			if !uploadSessionLive(ctx, uc.UserID, uc.FileHash) {
+				report.AbandonedRecords = append(report.AbandonedRecords, item)
+			}
+			continue
+		}
+
+		deleted, err := withUploadInitLock(ctx, uc.UserID, uc.FileHash, func() (bool, error) {
+			if uploadSessionLive(ctx, uc.UserID, uc.FileHash) {
+				return false, nil
+			}
+			ok, err := db.DeleteAbandonedUserContent(ctx, uc.ID, before)
+			if err != nil || !ok {
+				return false, err
+			}
+			_ = db.FinishUploadSessions(ctx, uc.UserID, uc.FileHash, db.UploadSessionCancelled)
+			_ = Store.CleanupChunks(uc.UserID, uc.FileHash)
+			return true, nil
+		})
+		if err != nil {
+			report.fail("delete record %d: %v", uc.ID, err)
+			continue
+		}
+		if !deleted {
+			continue
+		}
+
+		_ = redis.DeleteTombstone(ctx, uc.UserID, uc.FileHash)
+		report.AbandonedRecords = append(report.AbandonedRecords, item)
+	}
+}
+
+// uploadSessionLive 用户对该文件是否还有未过期的上传会话
+func uploadSessionLive(ctx context.Context, userID int, fileHash string) bool {
+	sess, err := db.GetLatestUploadSession(ctx, userID, fileHash)
+	if err != nil {
+		// 查询失败时按仍在上传处理，宁可少回收
+		return !errors.Is(err, db.ErrUploadSessionNotFound)
+	}
+	return time.Now().Before(sess.ExpiresAt)
+}
+
+// withUploadInitLock 持有 InitUpload 使用的锁执行 fn，锁被占用时跳过
+func withUploadInitLock(ctx context.Context, userID int, fileHash string, fn func() (bool, error)) (bool, error) {
+	lock := redis.NewLock(fmt.Sprintf("upload:init:%d:%s", userID, fileHash), 30*time.Second)
+	ok, err := lock.TryLock(ctx)
+	if err != nil {
+		return false, fmt.Errorf("acquire lock failed: %w", err)
+	}
+	if !ok {
+		return false, nil
+	}
+	defer lock.Unlock(context.Background())
+	return fn()
+}
+
+func recordGCMetrics(report *GCReport, elapsed time.Duration) {
+	gcMetrics.Add("runs", 1)
+	if report.DryRun {
+		gcMetrics.Add("dry_runs", 1)
+	} else {
+		gcMetrics.Add("orphan_files_removed", int64(len(report.OrphanFiles)))
+		gcMetrics.Add("stale_uploads_removed", int64(len(report.StaleUploads)))
+		gcMetrics.Add("abandoned_records_removed", int64(len(report.AbandonedRecords)))
+		gcMetrics.Add("orphan_blocks_removed", int64(len(report.OrphanBlocks)))
+		gcMetrics.Add("freed_bytes", report.FreedBytes)
+	}
+	gcMetrics.Add("errors", int64(len(report.Errors)))
+
+	last := new(expvar.Int)
+	last.Set(report.StartedAt.Unix())
+	gcMetrics.Set("last_run_unix", last)
+	duration := new(expvar.Float)
+	duration.Set(elapsed.Seconds())
+	gcMetrics.Set("last_duration_seconds", duration)
+}
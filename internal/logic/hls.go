@@ -0,0 +1,111 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"video-platform/internal/db"
+	"video-platform/internal/hls"
+	"video-platform/internal/mp4"
+)
+
+// ErrHLSUnsupported 文件不是可打包的 MP4/MOV
+var ErrHLSUnsupported = errors.New("file cannot be packaged as hls")
+
+const hlsCacheSize = 64
+
+// hlsCache 缓存已解析的打包方案，避免每个分段请求都重新读取 moov
+var hlsCache = struct {
+	sync.Mutex
+	items map[string]*hls.Presentation
+	order []string
+}{items: make(map[string]*hls.Presentation)}
+
+// storeReaderAt 通过 Store.GetFileRange 实现 io.ReaderAt
+type storeReaderAt struct {
+	hash string
+	size int64
+}
+
+func (r *storeReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p)) - 1
+	if end >= r.size {
+		end = r.size - 1
+	}
+	rc, err := Store.GetFileRange(r.hash, off, end)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	n, err := io.ReadFull(rc, p[:end-off+1])
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// GetHLSPresentation 获取文件的 HLS 打包方案（权限检查与 DownloadFile 一致）
+func GetHLSPresentation(ctx context.Context, userID int, fileHash string) (*hls.Presentation, error) {
+	// 1. 验证用户权限
+	if _, err := resolveFileAccess(ctx, userID, fileHash); err != nil {
+		return nil, err
+	}
+
+	// 2. 获取文件元数据
+	fm, err := db.GetFileMeta(ctx, fileHash)
+	if err != nil {
+		return nil, fmt.Errorf("file metadata not found")
+	}
+	if fm.QuarantinedAt != nil {
+		return nil, ErrFileQuarantined
+	}
+
+	// 3. 检查文件是否存在
+	if !Store.FileExists(fileHash) {
+		return nil, fmt.Errorf("file not found on storage")
+	}
+
+	hlsCache.Lock()
+	p, ok := hlsCache.items[fileHash]
+	hlsCache.Unlock()
+	if ok {
+		return p, nil
+	}
+
+	movie, err := mp4.Parse(&storeReaderAt{hash: fileHash, size: fm.FileSize}, fm.FileSize)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHLSUnsupported, err)
+	}
+	p, err = hls.NewPresentation(movie, hls.DefaultTargetDuration)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHLSUnsupported, err)
+	}
+
+	hlsCache.Lock()
+	if _, exists := hlsCache.items[fileHash]; !exists {
+		if len(hlsCache.order) >= hlsCacheSize {
+			oldest := hlsCache.order[0]
+			hlsCache.order = hlsCache.order[1:]
+			delete(hlsCache.items, oldest)
+		}
+		hlsCache.items[fileHash] = p
+		hlsCache.order = append(hlsCache.order, fileHash)
+	}
+	hlsCache.Unlock()
+
+	return p, nil
+}
+
+// HLSRangeReader 返回读取原文件区间的函数，供分段写出使用
+func HLSRangeReader(fileHash string) hls.RangeReader {
+	return func(start, end int64) (io.ReadCloser, error) {
+		return Store.GetFileRange(fileHash, start, end)
+	}
+}
@@ -0,0 +1,116 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"video-platform/internal/db"
+	"video-platform/internal/redis"
+	"video-platform/internal/store"
+)
+
+var (
+	ErrLayoutUnsupported      = errors.New("storage backend does not support directory layouts")
+	ErrLayoutMigrationRunning = errors.New("layout migration is already running")
+)
+
+const layoutLockTTL = 10 * time.Minute
+
+// LayoutMigrationResult 目录布局迁移结果
+type LayoutMigrationResult struct {
+	DryRun       bool     `json:"dry_run"`
+	Misplaced    int      `json:"misplaced"`     // 不在当前布局位置上的文件
+	Moved        int      `json:"moved"`         // 已移动的文件
+	PathsUpdated int      `json:"paths_updated"` // 已改正 FileMeta.FilePath 的记录
+	Errors       []string `json:"errors,omitempty"`
+}
+
+// MigrateStorageLayout 把按旧布局存放的文件移动到当前布局，并改正 FileMeta.FilePath。
+// 服务运行期间可以执行：每个文件在 blob 锁内移动，读取方在新旧位置之间回退。
+// 中途退出后重新执行即可继续。
+func MigrateStorageLayout(ctx context.Context, dryRun bool) (*LayoutMigrationResult, error) {
+	migrator, ok := Store.(store.LayoutMigrator)
+	if !ok {
+		return nil, ErrLayoutUnsupported
+	}
+	lock := redis.NewLock("layout:migrate", layoutLockTTL)
+	acquired, err := lock.TryLock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquire lock failed: %w", err)
+	}
+	if !acquired {
+		return nil, ErrLayoutMigrationRunning
+	}
+	defer lock.Unlock(context.Background())
+
+	hashes, err := migrator.MisplacedFiles()
+	if err != nil {
+		return nil, fmt.Errorf("list misplaced files failed: %w", err)
+	}
+	result := &LayoutMigrationResult{DryRun: dryRun, Misplaced: len(hashes)}
+	log.Printf("Layout migration started: misplaced=%d dry_run=%v", len(hashes), dryRun)
+
+	failed := make(map[string]bool)
+	for i, hash := range hashes {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if dryRun {
+			continue
+		}
+		if i%100 == 0 {
+			_ = lock.Extend(ctx, layoutLockTTL)
+		}
+		if err := migrateStoredFile(ctx, migrator, hash); err != nil {
+			log.Printf("Warning: migrate %s failed: %v", hash, err)
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", hash, err))
+			failed[hash] = true
+			continue
+		}
+		result.Moved++
+	}
+
+	// 改正路径与当前布局不符的记录（包括上次迁移移动了文件但没来得及更新的）
+	metas, err := db.ListFileMetas(ctx)
+	if err != nil {
+		return result, fmt.Errorf("list file meta failed: %w", err)
+	}
+	for _, fm := range metas {
+		want := migrator.FilePath(fm.FileHash)
+		if want == "" || fm.FilePath == want || failed[fm.FileHash] {
+			continue
+		}
+		if !dryRun {
+			if err := db.UpdateFilePath(ctx, fm.FileHash, want); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: update path: %v", fm.FileHash, err))
+				continue
+			}
+		}
+		result.PathsUpdated++
+	}
+
+	log.Printf("Layout migration finished: misplaced=%d moved=%d paths_updated=%d errors=%d",
+		result.Misplaced, result.Moved, result.PathsUpdated, len(result.Errors))
+	return result, nil
+}
+
+// migrateStoredFile 与同一 hash 的合并、删除互斥，避免移动正在被删除或替换的文件
+func migrateStoredFile(ctx context.Context, migrator store.LayoutMigrator, fileHash string) error {
+	blob, err := lockBlob(ctx, fileHash)
+	if err != nil {
+		return err
+	}
+	defer blob.Unlock()
+
+	path, err := migrator.MigrateFile(fileHash)
+	if err != nil {
+		return err
+	}
+	if err := blob.Check(); err != nil {
+		return err
+	}
+	return db.UpdateFilePath(ctx, fileHash, path)
+}
@@ -0,0 +1,37 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"video-platform/internal/utils"
+)
+
+// PlaybackURLTTL 签名播放链接的有效期
+const PlaybackURLTTL = 2 * time.Hour
+
+// PlaybackURLs 签名播放链接（相对路径）
+type PlaybackURLs struct {
+	DownloadURL string `json:"download_url"`
+	HLSURL      string `json:"hls_url"`
+	ExpiresAt   int64  `json:"expires_at"`
+}
+
+// IssuePlaybackURLs 为用户可访问的文件签发播放链接，链接只对该文件、该用户有效
+func IssuePlaybackURLs(ctx context.Context, userID int, fileHash string) (*PlaybackURLs, error) {
+	if _, err := resolveFileAccess(ctx, userID, fileHash); err != nil {
+		return nil, err
+	}
+	if !Store.FileExists(fileHash) {
+		return nil, fmt.Errorf("file not found on storage")
+	}
+
+	expiresAt := time.Now().Add(PlaybackURLTTL)
+	query := utils.SignPlayback(userID, fileHash, expiresAt).Encode()
+	return &PlaybackURLs{
+		DownloadURL: fmt.Sprintf("/api/v1/files/%s/download?%s", fileHash, query),
+		HLSURL:      fmt.Sprintf("/api/v1/hls/%s/master.m3u8?%s", fileHash, query),
+		ExpiresAt:   expiresAt.Unix(),
+	}, nil
+}
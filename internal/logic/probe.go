@@ -0,0 +1,36 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+
+	"video-platform/internal/db"
+	"video-platform/internal/probe"
+)
+
+// ProbeFileMeta 读取已存储文件的容器头，写入 FileMeta 的媒体字段
+func ProbeFileMeta(ctx context.Context, fileHash string) error {
+	fm, err := db.GetFileMeta(ctx, fileHash)
+	if err != nil {
+		return err
+	}
+	if fm.Format != "" {
+		// 已探测过（同 hash 的文件内容相同）
+		return nil
+	}
+
+	info, err := probe.Probe(&storeReaderAt{hash: fileHash, size: fm.FileSize}, fm.FileSize)
+	if err != nil {
+		return fmt.Errorf("probe failed: %w", err)
+	}
+
+	return db.UpdateFileMetaMedia(ctx, fileHash, map[string]interface{}{
+		"format":      info.Format,
+		"video_codec": info.VideoCodec,
+		"audio_codec": info.AudioCodec,
+		"bitrate":     info.Bitrate,
+		"width":       info.Width,
+		"height":      info.Height,
+		"duration":    info.Duration,
+	})
+}
@@ -0,0 +1,347 @@
+package logic
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"video-platform/internal/db"
+	"video-platform/internal/redis"
+	"video-platform/internal/store"
+)
+
+var (
+	ErrFileQuarantined = errors.New("file failed integrity check and is quarantined")
+	ErrScrubRunning    = errors.New("scrub is already running")
+)
+
+// 巡检默认值
+const (
+	DefaultScrubInterval = time.Hour
+	DefaultScrubMaxAge   = 30 * 24 * time.Hour
+	DefaultScrubRate     = 32 << 20 // 每秒读取字节数
+
+	scrubBatchSize     = 100
+	scrubLockTTL       = 10 * time.Minute
+	scrubReportTimeout = 5 * time.Second
+)
+
+// ScrubConfig 完整性巡检配置
+type ScrubConfig struct {
+	Interval time.Duration // 每轮间隔，0 表示关闭后台巡检
+	MaxAge   time.Duration // 距上次确认超过该时长的文件重新校验
+	Rate     int64         // 读取限速（字节/秒），0 表示不限速
+}
+
+var scrubConfig = ScrubConfig{
+	MaxAge: DefaultScrubMaxAge,
+	Rate:   DefaultScrubRate,
+}
+
+// scrubMetrics 通过 expvar 暴露的累计指标
+var scrubMetrics = expvar.NewMap("scrub")
+
+// ScrubResult 一个文件的校验结果
+type ScrubResult struct {
+	FileHash    string `json:"file_hash"`
+	Size        int64  `json:"size"`
+	ActualHash  string `json:"actual_hash,omitempty"`
+	OK          bool   `json:"ok"`
+	Quarantined bool   `json:"quarantined"`
+	Error       string `json:"error,omitempty"`
+}
+
+// QuarantinedFile 被隔离的文件
+type QuarantinedFile struct {
+	FileHash      string     `json:"file_hash"`
+	FileSize      int64      `json:"file_size"`
+	RefCount      int        `json:"ref_count"`
+	VerifiedAt    *time.Time `json:"verified_at,omitempty"`
+	QuarantinedAt time.Time  `json:"quarantined_at"`
+}
+
+// StartScrubber 按配置启动后台巡检，ctx 取消后返回的 WaitGroup 完成
+func StartScrubber(ctx context.Context, cfg ScrubConfig) *sync.WaitGroup {
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = DefaultScrubMaxAge
+	}
+	if cfg.Rate < 0 {
+		cfg.Rate = 0
+	}
+	scrubConfig = cfg
+
+	wg := &sync.WaitGroup{}
+	if cfg.Interval <= 0 {
+		return wg
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := scrubBatch(ctx); err != nil && !errors.Is(err, ErrScrubRunning) {
+					log.Printf("Warning: scrub failed: %v", err)
+				}
+			}
+		}
+	}()
+	log.Printf("Scrubber started: interval=%s, max_age=%s, rate=%d B/s", cfg.Interval, cfg.MaxAge, cfg.Rate)
+	return wg
+}
+
+// reportCorruptReplica 读取时发现副本损坏，登记给巡检在下一轮优先修复
+func reportCorruptReplica(fileHash string) {
+	scrubMetrics.Add("reported", 1)
+	ctx, cancel := context.WithTimeout(context.Background(), scrubReportTimeout)
+	defer cancel()
+	if err := redis.AddScrubSuspect(ctx, fileHash); err != nil {
+		log.Printf("Warning: report corrupt replica of %s failed: %v", fileHash, err)
+	}
+}
+
+// scrubBatch 先处理读取时报告损坏的文件，再校验一批到期的文件。多个节点同时运行时只有拿到锁的节点执行。
+func scrubBatch(ctx context.Context) error {
+	lock := redis.NewLock("scrub:worker", scrubLockTTL)
+	acquired, err := lock.TryLock(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire lock failed: %w", err)
+	}
+	if !acquired {
+		return ErrScrubRunning
+	}
+	defer lock.Unlock(context.Background())
+
+	suspects, err := redis.PopScrubSuspects(ctx, scrubBatchSize)
+	if err != nil {
+		log.Printf("Warning: load reported corrupt files failed: %v", err)
+	}
+	for _, hash := range suspects {
+		if ctx.Err() != nil {
+			break
+		}
+		_ = lock.Extend(ctx, scrubLockTTL)
+		scrubSuspect(ctx, hash)
+	}
+
+	due, err := db.ListFilesDueForScrub(ctx, time.Now().Add(-scrubConfig.MaxAge), scrubBatchSize)
+	if err != nil {
+		return fmt.Errorf("list files failed: %w", err)
+	}
+	for i := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		_ = lock.Extend(ctx, scrubLockTTL)
+		scrubFile(ctx, &due[i])
+	}
+
+	if quarantined, err := db.ListQuarantinedFiles(ctx); err == nil {
+		n := new(expvar.Int)
+		n.Set(int64(len(quarantined)))
+		scrubMetrics.Set("quarantined", n)
+	}
+	return nil
+}
+
+// scrubSuspect 读取时报告损坏的文件：先校验全部副本并补齐（读取时移走的副本表现为副本不足），
+// 再按常规流程校验，仍不一致时隔离
+func scrubSuspect(ctx context.Context, fileHash string) {
+	fm, err := db.GetFileMeta(ctx, fileHash)
+	if err != nil {
+		return
+	}
+	healFromReplicas(ctx, fileHash)
+	scrubFile(ctx, fm)
+}
+
+// ScrubFile 立即校验一个文件（管理接口），内容一致时解除隔离
+func ScrubFile(ctx context.Context, fileHash string) (*ScrubResult, error) {
+	fm, err := db.GetFileMeta(ctx, fileHash)
+	if err != nil {
+		return nil, fmt.Errorf("file metadata not found")
+	}
+	return scrubFile(ctx, fm), nil
+}
+
+// scrubFile 限速读取存储文件并重新计算 hash：一致时记录确认时间，
+// 不一致（或加密内容认证失败）时先尝试用其他副本修复，修复不了再隔离。
+// 读取出错（文件丢失、网络错误）不隔离，下一轮重试。
+func scrubFile(ctx context.Context, fm *db.FileMeta) *ScrubResult {
+	result := &ScrubResult{FileHash: fm.FileHash}
+
+	actual, strong, size, err := hashStoredFile(ctx, fm.FileHash)
+	corrupted := isCorruptRead(err)
+	// md5 文件同时比较记录的 SHA-256，MD5 碰撞的内容也会被发现
+	matches := func() bool {
+		return actual == canonicalFileHash(fm.FileHash) && (fm.StrongHash == "" || strong == fm.StrongHash)
+	}
+	if (corrupted || err == nil && !matches()) && healFromReplicas(ctx, fm.FileHash) {
+		actual, strong, size, err = hashStoredFile(ctx, fm.FileHash)
+		corrupted = isCorruptRead(err)
+	}
+	if err != nil && !corrupted {
+		scrubMetrics.Add("errors", 1)
+		result.Error = err.Error()
+		log.Printf("Warning: scrub %s: %v", fm.FileHash, err)
+		return result
+	}
+	result.Size = size
+	scrubMetrics.Add("bytes", size)
+
+	if !corrupted && matches() && size == fm.FileSize {
+		result.OK = true
+		scrubMetrics.Add("verified", 1)
+		if err := db.MarkFileVerified(ctx, fm.FileHash, time.Now()); err != nil {
+			result.Error = err.Error()
+		}
+		if fm.StrongHash == "" {
+			if err := db.SetStrongHash(ctx, fm.FileHash, strong); err != nil {
+				log.Printf("Warning: record strong hash of %s failed: %v", fm.FileHash, err)
+			}
+		}
+		return result
+	}
+
+	result.ActualHash = actual
+	if corrupted {
+		result.Error = err.Error()
+	}
+	if fm.QuarantinedAt != nil {
+		result.Quarantined = true
+		return result
+	}
+
+	scrubMetrics.Add("corrupted", 1)
+	quarantined, err := db.QuarantineFile(ctx, fm.FileHash, fm.VerifiedAt, time.Now())
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Quarantined = quarantined
+	switch {
+	case quarantined && corrupted:
+		log.Printf("Warning: scrub %s: %v, file quarantined", fm.FileHash, err)
+	case quarantined:
+		log.Printf("Warning: scrub %s: content hash %s (%s), size %d (expected %d), file quarantined",
+			fm.FileHash, actual, strong, size, fm.FileSize)
+	}
+	return result
+}
+
+// isCorruptRead 读取时发现内容损坏（加密认证失败、副本校验失败）
+func isCorruptRead(err error) bool {
+	return errors.Is(err, store.ErrCorrupted) || errors.Is(err, store.ErrReplicaCorrupt) ||
+		errors.Is(err, store.ErrBlockCorrupt)
+}
+
+// healFromReplicas 多副本存储校验全部副本，移走损坏的并从完好的副本补齐，返回是否有副本被替换。
+// 读取时已被移到一旁的副本表现为副本不足，同样计为修复。
+func healFromReplicas(ctx context.Context, fileHash string) bool {
+	repairer, ok := Store.(store.ReplicaRepairer)
+	if !ok {
+		return false
+	}
+	result, err := repairFileReplicas(ctx, repairer, fileHash, true)
+	if err != nil {
+		log.Printf("Warning: scrub %s: repair from replicas failed: %v", fileHash, err)
+		return false
+	}
+	if result.Corrupt == 0 && result.Copied == 0 {
+		return false
+	}
+	scrubMetrics.Add("repaired", 1)
+	log.Printf("Scrub %s: repaired from replicas, corrupt=%d copied=%d", fileHash, result.Corrupt, result.Copied)
+	return true
+}
+
+// hashStoredFile 按巡检限速读取存储文件，见 hashStored
+func hashStoredFile(ctx context.Context, fileHash string) (actual, strong string, size int64, err error) {
+	return hashStored(ctx, fileHash, scrubConfig.Rate)
+}
+
+// hashStored 读取存储文件，返回按文件 hash 算法计算的摘要（规范形式）、SHA-256 强摘要与长度。
+// rate 为读取限速（字节/秒），0 表示不限速。
+func hashStored(ctx context.Context, fileHash string, rate int64) (actual, strong string, size int64, err error) {
+	rc, _, err := Store.GetFile(fileHash)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("open file failed: %w", err)
+	}
+	defer rc.Close()
+
+	algorithm := store.FileHashAlgorithm(fileHash)
+	digest := store.NewFileDigest(fileHash)
+	strongDigest, w := digest, io.Writer(digest)
+	if algorithm != store.HashSHA256 {
+		strongDigest = sha256.New()
+		w = io.MultiWriter(digest, strongDigest)
+	}
+	size, err = io.Copy(w, &throttledReader{ctx: ctx, r: rc, rate: rate, start: time.Now()})
+	if err != nil {
+		return "", "", size, fmt.Errorf("read file failed: %w", err)
+	}
+	actual = store.FormatFileHash(algorithm, digest.Sum(nil))
+	strong = store.FormatFileHash(store.HashSHA256, strongDigest.Sum(nil))
+	return actual, strong, size, nil
+}
+
+// throttledReader 把平均读取速度限制在 rate 字节/秒，ctx 取消时停止
+type throttledReader struct {
+	ctx   context.Context
+	r     io.Reader
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if err := t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := t.r.Read(p)
+	t.read += int64(n)
+	if t.rate <= 0 {
+		return n, err
+	}
+
+	ahead := time.Duration(float64(t.read)/float64(t.rate)*float64(time.Second)) - time.Since(t.start)
+	if ahead > 0 {
+		timer := time.NewTimer(ahead)
+		defer timer.Stop()
+		select {
+		case <-t.ctx.Done():
+			return n, t.ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return n, err
+}
+
+// ListQuarantinedFiles 列出被隔离的文件
+func ListQuarantinedFiles(ctx context.Context) ([]QuarantinedFile, error) {
+	metas, err := db.ListQuarantinedFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]QuarantinedFile, 0, len(metas))
+	for _, fm := range metas {
+		files = append(files, QuarantinedFile{
+			FileHash:      fm.FileHash,
+			FileSize:      fm.FileSize,
+			RefCount:      fm.RefCount,
+			VerifiedAt:    fm.VerifiedAt,
+			QuarantinedAt: *fm.QuarantinedAt,
+		})
+	}
+	return files, nil
+}
@@ -0,0 +1,79 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"video-platform/internal/db"
+	"video-platform/internal/redis"
+	"video-platform/internal/store"
+)
+
+func TestScrubRepairsReplicaReportedByRead(t *testing.T) {
+	setupLogicTest(t, nil)
+	dir := t.TempDir()
+	disks := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+	md, err := store.NewMultiDiskStore(disks, filepath.Join(dir, "tmp"), 2, store.PlacementHash, nil)
+	if err != nil {
+		t.Fatalf("NewMultiDiskStore: %v", err)
+	}
+	md.SetCorruptionHandler(reportCorruptReplica)
+	Store = md
+	prevRate := scrubConfig.Rate
+	scrubConfig.Rate = 0
+	t.Cleanup(func() { scrubConfig.Rate = prevRate })
+
+	data := []byte(strings.Repeat("scrub me ", 512))
+	uploadSource(t, data)
+	sum := sha256.Sum256(data)
+	hash := store.FormatFileHash(store.HashSHA256, sum[:])
+	ctx := context.Background()
+
+	// 改写读取顺序中第一份副本
+	path := md.FilePath(hash)
+	corrupt := append([]byte{data[0] ^ 0xff}, data[1:]...)
+	if err := os.WriteFile(path, corrupt, 0644); err != nil {
+		t.Fatalf("corrupt replica: %v", err)
+	}
+
+	rc, _, err := Store.GetFile(hash)
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	// 损坏的段从另一份副本读出
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read = %d bytes, %v; want the intact content", len(got), err)
+	}
+	if ok, _ := redis.Client.SIsMember(ctx, redis.ScrubSuspectKey, hash).Result(); !ok {
+		t.Fatal("corrupt replica not reported to scrubber")
+	}
+
+	if err := scrubBatch(ctx); err != nil {
+		t.Fatalf("scrubBatch: %v", err)
+	}
+	if n, _ := redis.Client.SCard(ctx, redis.ScrubSuspectKey).Result(); n != 0 {
+		t.Fatalf("%d reported files left", n)
+	}
+	result, err := md.RepairFile(hash, true)
+	if err != nil {
+		t.Fatalf("RepairFile: %v", err)
+	}
+	if result.Intact != 2 || result.Corrupt != 0 || result.Copied != 0 {
+		t.Fatalf("replicas after scrub = %+v, want 2 intact", result)
+	}
+	fm, err := db.GetFileMeta(ctx, hash)
+	if err != nil {
+		t.Fatalf("GetFileMeta: %v", err)
+	}
+	if fm.QuarantinedAt != nil || fm.VerifiedAt == nil {
+		t.Fatalf("file meta = %+v, want verified and not quarantined", fm)
+	}
+}
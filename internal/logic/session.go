@@ -0,0 +1,228 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"video-platform/internal/db"
+	"video-platform/internal/redis"
+	"video-platform/internal/store"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUploadSessionNotFound = errors.New("upload session not found")
+	ErrUploadSessionExpired  = errors.New("upload session expired")
+	ErrUploadSessionMismatch = errors.New("request does not match upload session")
+	ErrChunkOutOfRange       = errors.New("chunk index out of range")
+	ErrChunkSizeMismatch     = errors.New("chunk size does not match upload session")
+	ErrInvalidFileSize       = errors.New("invalid file size")
+)
+
+// 上传会话默认参数
+const (
+	DefaultUploadChunkSize  = 5 << 20
+	DefaultUploadSessionTTL = 24 * time.Hour
+	DefaultUploadMaxChunks  = 10000 // 与 S3 multipart 的分片数上限一致
+)
+
+// UploadConfig 分片上传配置
+type UploadConfig struct {
+	ChunkSize  int64         // 默认分片大小
+	SessionTTL time.Duration // 会话有效期，每次 init 续期
+	MaxChunks  int           // 单个文件最多分片数，超过时增大分片
+	TusDir     string        // tus 上传未凑满分片的数据暂存目录（本地）
+}
+
+var uploadConfig = UploadConfig{
+	ChunkSize:  DefaultUploadChunkSize,
+	SessionTTL: DefaultUploadSessionTTL,
+	MaxChunks:  DefaultUploadMaxChunks,
+}
+
+// ConfigureUploads 设置分片上传参数，零值使用默认值
+func ConfigureUploads(cfg UploadConfig) {
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = DefaultUploadChunkSize
+	}
+	if cfg.SessionTTL <= 0 {
+		cfg.SessionTTL = DefaultUploadSessionTTL
+	}
+	if cfg.MaxChunks <= 0 {
+		cfg.MaxChunks = DefaultUploadMaxChunks
+	}
+	if limit := minChunkSize(); cfg.ChunkSize < limit {
+		log.Printf("Warning: upload chunk size %d is below the storage minimum, using %d", cfg.ChunkSize, limit)
+	}
+	uploadConfig = cfg
+}
+
+// minChunkSize 存储后端要求的分片下限（S3 multipart 的 5MiB），没有时为 0
+func minChunkSize() int64 {
+	if l, ok := Store.(store.ChunkSizeLimiter); ok {
+		return l.MinChunkSize()
+	}
+	return 0
+}
+
+// chooseChunkSize 选择分片大小：默认大小（不小于存储后端的下限），分片数超过上限时按 1MB 向上取整放大
+func chooseChunkSize(fileSize int64) int64 {
+	chunkSize := uploadConfig.ChunkSize
+	if limit := minChunkSize(); chunkSize < limit {
+		chunkSize = limit
+	}
+	if (fileSize+chunkSize-1)/chunkSize > int64(uploadConfig.MaxChunks) {
+		const mb = 1 << 20
+		chunkSize = (fileSize + int64(uploadConfig.MaxChunks) - 1) / int64(uploadConfig.MaxChunks)
+		chunkSize = (chunkSize + mb - 1) / mb * mb
+	}
+	return chunkSize
+}
+
+// openUploadSession 复用仍有效且声明一致的会话，否则新建。
+// 旧会话过期或声明的大小不同时，已上传的分片不再可信，一并清理。
+func openUploadSession(ctx context.Context, userID int, contentID uint, fileName, fileHash string, fileSize int64) (*db.UploadSession, error) {
+	if fileSize <= 0 {
+		return nil, ErrInvalidFileSize
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(uploadConfig.SessionTTL)
+
+	sess, err := db.GetLatestUploadSession(ctx, userID, fileHash)
+	switch {
+	case err == nil && sess.FileSize == fileSize && sess.ContentID == contentID && now.Before(sess.ExpiresAt):
+		if err := db.ExtendUploadSession(ctx, sess.ID, expiresAt); err != nil {
+			return nil, err
+		}
+		sess.ExpiresAt = expiresAt
+		if err := preallocate(sess); err != nil {
+			return nil, err
+		}
+		return sess, nil
+	case err == nil:
+		log.Printf("Upload session %s for user=%d hash=%s is stale, discarding chunks", sess.ID, userID, fileHash)
+		if err := db.FinishUploadSessions(ctx, userID, fileHash, db.UploadSessionCancelled); err != nil {
+			return nil, err
+		}
+		_ = Store.CleanupChunks(userID, fileHash)
+		_ = redis.ClearUploadedChunks(ctx, userID, fileHash)
+	case !errors.Is(err, db.ErrUploadSessionNotFound):
+		return nil, err
+	}
+
+	chunkSize := chooseChunkSize(fileSize)
+	sess = &db.UploadSession{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		FileHash:    fileHash,
+		ContentID:   contentID,
+		FileName:    fileName,
+		FileSize:    fileSize,
+		ChunkSize:   chunkSize,
+		TotalChunks: int((fileSize + chunkSize - 1) / chunkSize),
+		Status:      db.UploadSessionActive,
+		ExpiresAt:   expiresAt,
+	}
+	if err := db.CreateUploadSession(ctx, sess); err != nil {
+		return nil, fmt.Errorf("create upload session failed: %w", err)
+	}
+	if err := preallocate(sess); err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+// preallocate 存储支持预分配时按会话的文件大小与分片大小准备目标文件
+func preallocate(sess *db.UploadSession) error {
+	p, ok := Store.(store.Preallocator)
+	if !ok {
+		return nil
+	}
+	if err := p.Preallocate(sess.UserID, sess.FileHash, sess.FileSize, sess.ChunkSize); err != nil {
+		return fmt.Errorf("preallocate upload failed: %w", err)
+	}
+	return nil
+}
+
+// loadUploadSession 获取并检查上传会话。sessionID 为空时（旧客户端）
+// 使用该用户对该文件最近的会话。
+func loadUploadSession(ctx context.Context, userID int, sessionID, fileHash string) (*db.UploadSession, error) {
+	var (
+		sess *db.UploadSession
+		err  error
+	)
+	if sessionID != "" {
+		sess, err = db.GetUploadSession(ctx, userID, sessionID)
+	} else {
+		sess, err = db.GetLatestUploadSession(ctx, userID, fileHash)
+	}
+	if err != nil {
+		if errors.Is(err, db.ErrUploadSessionNotFound) {
+			return nil, ErrUploadSessionNotFound
+		}
+		return nil, err
+	}
+
+	if fileHash != "" && sess.FileHash != fileHash {
+		return nil, ErrUploadSessionMismatch
+	}
+	switch sess.Status {
+	case db.UploadSessionCompleted:
+		return nil, ErrUploadAlreadyCompleted
+	case db.UploadSessionCancelled:
+		return nil, ErrUploadCancelled
+	}
+	if time.Now().After(sess.ExpiresAt) {
+		return nil, ErrUploadSessionExpired
+	}
+	return sess, nil
+}
+
+// chunkLength 会话中第 index 片应有的字节数
+func chunkLength(sess *db.UploadSession, index int) int64 {
+	if index == sess.TotalChunks-1 {
+		return sess.FileSize - int64(index)*sess.ChunkSize
+	}
+	return sess.ChunkSize
+}
+
+// sizedReader 读取恰好 remaining 字节，数据过短或过长时返回 ErrChunkSizeMismatch，
+// 让存储层丢弃写了一半的分片
+type sizedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (s *sizedReader) Read(p []byte) (int, error) {
+	if s.remaining == 0 {
+		// 确认没有多余数据
+		var one [1]byte
+		n, err := io.ReadFull(s.r, one[:])
+		if n > 0 {
+			return 0, ErrChunkSizeMismatch
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, io.EOF
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.r.Read(p)
+	s.remaining -= int64(n)
+	if err == io.EOF && s.remaining > 0 {
+		return n, ErrChunkSizeMismatch
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
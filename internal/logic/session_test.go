@@ -0,0 +1,43 @@
+package logic
+
+import (
+	"testing"
+
+	"video-platform/internal/store"
+)
+
+// limitedStore 要求分片不小于 min 的存储（如 S3）
+type limitedStore struct {
+	store.Uploader
+	min int64
+}
+
+func (s limitedStore) MinChunkSize() int64 { return s.min }
+
+func TestChooseChunkSize(t *testing.T) {
+	prevConfig, prevStore := uploadConfig, Store
+	t.Cleanup(func() { uploadConfig, Store = prevConfig, prevStore })
+	const mb = 1 << 20
+
+	for _, tc := range []struct {
+		name      string
+		chunkSize int64
+		maxChunks int
+		min       int64
+		fileSize  int64
+		want      int64
+	}{
+		{"default", 5 * mb, 10000, 0, 100 * mb, 5 * mb},
+		{"small chunks on local store", mb, 10000, 0, 100 * mb, mb},
+		{"raised to s3 minimum", mb, 10000, 5 * mb, 100 * mb, 5 * mb},
+		{"grown past max chunks", 5 * mb, 10, 5 * mb, 100*mb + 1, 11 * mb},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			Store = limitedStore{min: tc.min}
+			ConfigureUploads(UploadConfig{ChunkSize: tc.chunkSize, MaxChunks: tc.maxChunks})
+			if got := chooseChunkSize(tc.fileSize); got != tc.want {
+				t.Fatalf("chooseChunkSize(%d) = %d, want %d", tc.fileSize, got, tc.want)
+			}
+		})
+	}
+}
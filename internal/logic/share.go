@@ -0,0 +1,317 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"video-platform/internal/db"
+	"video-platform/internal/utils"
+)
+
+var (
+	ErrShareNotFound         = errors.New("share not found")
+	ErrShareRevoked          = errors.New("share has been revoked")
+	ErrShareExpired          = errors.New("share has expired")
+	ErrShareLimitReached     = errors.New("share download limit reached")
+	ErrShareViewOnly         = errors.New("share is view-only")
+	ErrShareDownloadOnly     = errors.New("share has a download limit and can only be downloaded")
+	ErrSharePasswordRequired = errors.New("share password required")
+	ErrSharePasswordWrong    = errors.New("wrong share password")
+	ErrShareFileNotOwned     = errors.New("file not found or not uploaded by user")
+	ErrShareInvalidParams    = errors.New("invalid share parameters")
+)
+
+const (
+	// ShareGrantTTL 输入密码后访问凭证的有效期
+	ShareGrantTTL = 6 * time.Hour
+	// ShareResumeTTL 已计数下载的续传凭证有效期
+	ShareResumeTTL = 6 * time.Hour
+)
+
+// ShareInfo 分享信息（分享者视角）
+type ShareInfo struct {
+	ID           uint   `json:"id"`
+	Token        string `json:"token"`
+	URL          string `json:"url"`
+	FileHash     string `json:"file_hash"`
+	FileName     string `json:"file_name"`
+	HasPassword  bool   `json:"has_password"`
+	ExpiresAt    string `json:"expires_at,omitempty"`
+	MaxDownloads int    `json:"max_downloads"`
+	Downloads    int    `json:"downloads"`
+	Views        int    `json:"views"`
+	ViewOnly     bool   `json:"view_only"`
+	Revoked      bool   `json:"revoked"`
+	LastAccessAt string `json:"last_access_at,omitempty"`
+	CreatedAt    string `json:"created_at"`
+}
+
+func newShareInfo(s *db.Share, fileName string) ShareInfo {
+	info := ShareInfo{
+		ID:           s.ID,
+		Token:        s.Token,
+		URL:          "/s/" + s.Token,
+		FileHash:     s.FileHash,
+		FileName:     fileName,
+		HasPassword:  s.Password != "",
+		MaxDownloads: s.MaxDownloads,
+		Downloads:    s.Downloads,
+		Views:        s.Views,
+		ViewOnly:     s.ViewOnly,
+		Revoked:      s.RevokedAt != nil,
+		CreatedAt:    s.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+	if s.ExpiresAt != nil {
+		info.ExpiresAt = s.ExpiresAt.Format("2006-01-02 15:04:05")
+	}
+	if s.LastAccessAt != nil {
+		info.LastAccessAt = s.LastAccessAt.Format("2006-01-02 15:04:05")
+	}
+	return info
+}
+
+// CreateShareParams 创建分享参数
+type CreateShareParams struct {
+	UserID       int
+	FileHash     string
+	Password     string
+	ExpiresIn    int64 // 秒，0 表示永不过期
+	MaxDownloads int   // 0 表示不限
+	ViewOnly     bool
+}
+
+// CreateShare 为用户已上传完成的文件创建分享链接
+func CreateShare(ctx context.Context, params CreateShareParams) (*ShareInfo, error) {
+	if params.ExpiresIn < 0 || params.MaxDownloads < 0 {
+		return nil, ErrShareInvalidParams
+	}
+
+	uc, err := db.GetUserContentByHash(ctx, params.UserID, params.FileHash)
+	if err != nil || (uc.Status != 1 && uc.Status != 2) {
+		return nil, ErrShareFileNotOwned
+	}
+
+	token, err := utils.GenerateShareToken()
+	if err != nil {
+		return nil, fmt.Errorf("generate share token failed: %w", err)
+	}
+
+	share := &db.Share{
+		Token:         token,
+		UserID:        params.UserID,
+		UserContentID: uc.ID,
+		FileHash:      uc.FileHash,
+		MaxDownloads:  params.MaxDownloads,
+		ViewOnly:      params.ViewOnly,
+	}
+	if params.Password != "" {
+		hashed, err := utils.HashPassword(params.Password)
+		if err != nil {
+			return nil, fmt.Errorf("hash share password failed: %w", err)
+		}
+		share.Password = hashed
+	}
+	if params.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(params.ExpiresIn) * time.Second)
+		share.ExpiresAt = &expiresAt
+	}
+
+	if err := db.CreateShare(ctx, share); err != nil {
+		return nil, fmt.Errorf("create share failed: %w", err)
+	}
+
+	info := newShareInfo(share, uc.FileName)
+	return &info, nil
+}
+
+// ListShares 列出用户创建的分享
+func ListShares(ctx context.Context, userID int) ([]ShareInfo, error) {
+	shares, err := db.ListShares(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ShareInfo, 0, len(shares))
+	for i := range shares {
+		fileName := ""
+		if uc, err := db.GetUserContentByID(ctx, shares[i].UserContentID); err == nil {
+			fileName = uc.FileName
+		}
+		result = append(result, newShareInfo(&shares[i], fileName))
+	}
+	return result, nil
+}
+
+// RevokeShare 撤销分享
+func RevokeShare(ctx context.Context, userID int, shareID string) error {
+	id, err := strconv.ParseUint(shareID, 10, 64)
+	if err != nil {
+		return ErrShareNotFound
+	}
+	if err := db.RevokeShare(ctx, userID, uint(id)); err != nil {
+		if errors.Is(err, db.ErrShareNotFound) {
+			return ErrShareNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// PublicShareInfo 分享信息（访问者视角），不包含分享者信息
+type PublicShareInfo struct {
+	FileName         string `json:"file_name"`
+	FileSize         int64  `json:"file_size"`
+	Format           string `json:"format"`
+	Width            int    `json:"width"`
+	Height           int    `json:"height"`
+	Duration         int    `json:"duration"`
+	RequiresPassword bool   `json:"requires_password"`
+	ViewOnly         bool   `json:"view_only"`
+	ExpiresAt        string `json:"expires_at,omitempty"`
+	DownloadsLeft    *int   `json:"downloads_left,omitempty"` // 不限次数时省略
+}
+
+// loadShare 读取分享并检查是否仍然有效
+func loadShare(ctx context.Context, token string) (*db.Share, *db.UserContent, error) {
+	share, err := db.GetShareByToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, db.ErrShareNotFound) {
+			return nil, nil, ErrShareNotFound
+		}
+		return nil, nil, err
+	}
+	if share.RevokedAt != nil {
+		return nil, nil, ErrShareRevoked
+	}
+	if share.ExpiresAt != nil && time.Now().After(*share.ExpiresAt) {
+		return nil, nil, ErrShareExpired
+	}
+
+	// 分享者删除了文件后链接随之失效
+	uc, err := db.GetUserContentByID(ctx, share.UserContentID)
+	if err != nil || uc.FileHash != share.FileHash || (uc.Status != 1 && uc.Status != 2) {
+		return nil, nil, ErrShareNotFound
+	}
+	return share, uc, nil
+}
+
+// GetPublicShare 获取分享信息，计一次打开
+func GetPublicShare(ctx context.Context, token string) (*PublicShareInfo, error) {
+	share, uc, err := loadShare(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	fm, err := db.GetFileMeta(ctx, share.FileHash)
+	if err != nil {
+		return nil, ErrShareNotFound
+	}
+
+	if err := db.RecordShareView(ctx, share.ID); err != nil {
+		return nil, err
+	}
+
+	info := &PublicShareInfo{
+		FileName:         uc.FileName,
+		FileSize:         fm.FileSize,
+		Format:           fm.Format,
+		Width:            fm.Width,
+		Height:           fm.Height,
+		Duration:         fm.Duration,
+		RequiresPassword: share.Password != "",
+		ViewOnly:         share.ViewOnly,
+	}
+	if share.ExpiresAt != nil {
+		info.ExpiresAt = share.ExpiresAt.Format("2006-01-02 15:04:05")
+	}
+	if share.MaxDownloads > 0 && !share.ViewOnly {
+		left := share.MaxDownloads - share.Downloads
+		if left < 0 {
+			left = 0
+		}
+		info.DownloadsLeft = &left
+	}
+	return info, nil
+}
+
+// UnlockShare 校验分享密码，返回访问凭证与其过期时间
+func UnlockShare(ctx context.Context, token, password string) (string, int64, error) {
+	share, _, err := loadShare(ctx, token)
+	if err != nil {
+		return "", 0, err
+	}
+	if share.Password != "" && !utils.CheckPasswordHash(password, share.Password) {
+		return "", 0, ErrSharePasswordWrong
+	}
+
+	expiresAt := shareExpiry(share, ShareGrantTTL)
+	return utils.SignShareGrant(share.Token, expiresAt), expiresAt.Unix(), nil
+}
+
+// shareExpiry 凭证的过期时间，不晚于分享本身
+func shareExpiry(share *db.Share, ttl time.Duration) time.Time {
+	expiresAt := time.Now().Add(ttl)
+	if share.ExpiresAt != nil && share.ExpiresAt.Before(expiresAt) {
+		expiresAt = *share.ExpiresAt
+	}
+	return expiresAt
+}
+
+// OpenShareFile 打开分享的文件。download 为 true 时按下载处理：有下载次数限制时，
+// 每个返回内容（200 / 206）的请求都占用一次下载次数，除非带有已计数下载签发的续传凭证 resume；
+// 计数的响应返回新的续传凭证。不限次数时只统计新下载（整个文件或从 0 开始的区间）。
+// HEAD 与 304 等条件响应不计数。在线播放无法携带续传凭证，只用于只能观看或不限次数的分享。
+func OpenShareFile(ctx context.Context, token, grant, resume string, req DownloadRequest, download bool) (*DownloadResult, string, error) {
+	share, uc, err := loadShare(ctx, token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if share.Password != "" {
+		if err := utils.VerifyShareGrant(share.Token, grant); err != nil {
+			return nil, "", ErrSharePasswordRequired
+		}
+	}
+
+	if download && share.ViewOnly {
+		return nil, "", ErrShareViewOnly
+	}
+	if !download && !share.ViewOnly && share.MaxDownloads > 0 {
+		return nil, "", ErrShareDownloadOnly
+	}
+
+	result, err := openDownload(ctx, share.FileHash, uc.FileName, req)
+	if err != nil || !download || req.Method == http.MethodHead {
+		return result, "", err
+	}
+	if result.Status != http.StatusOK && result.Status != http.StatusPartialContent {
+		return result, "", nil
+	}
+
+	if share.MaxDownloads == 0 {
+		if result.Status == http.StatusOK || result.Ranges[0].Start == 0 {
+			if _, err := db.ConsumeShareDownload(ctx, share.ID); err != nil {
+				result.Reader.Close()
+				return nil, "", err
+			}
+		}
+		return result, "", nil
+	}
+
+	if resume != "" && utils.VerifyShareResume(share.Token, share.FileHash, resume) == nil {
+		return result, "", nil
+	}
+	ok, err := db.ConsumeShareDownload(ctx, share.ID)
+	if err == nil && !ok {
+		err = ErrShareLimitReached
+	}
+	if err != nil {
+		result.Reader.Close()
+		return nil, "", err
+	}
+	return result, utils.SignShareResume(share.Token, share.FileHash, shareExpiry(share, ShareResumeTTL)), nil
+}
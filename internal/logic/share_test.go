@@ -0,0 +1,117 @@
+package logic
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"video-platform/internal/db"
+	"video-platform/internal/store"
+)
+
+// createTestShare 上传一个文件并以给定参数分享，返回分享令牌
+func createTestShare(t *testing.T, maxDownloads int, viewOnly bool) string {
+	t.Helper()
+	setupLogicTest(t, nil)
+	data := []byte("shared video content")
+	uploadSource(t, data)
+	sum := sha256.Sum256(data)
+
+	info, err := CreateShare(context.Background(), CreateShareParams{
+		UserID:       testUserID,
+		FileHash:     store.FormatFileHash(store.HashSHA256, sum[:]),
+		MaxDownloads: maxDownloads,
+		ViewOnly:     viewOnly,
+	})
+	if err != nil {
+		t.Fatalf("CreateShare: %v", err)
+	}
+	return info.Token
+}
+
+// fetchShare 以 GET 请求分享，读完并关闭响应内容
+func fetchShare(t *testing.T, token, resume, rangeHeader string, download bool) (*DownloadResult, string, error) {
+	t.Helper()
+	result, next, err := OpenShareFile(context.Background(), token, "", resume,
+		DownloadRequest{Method: http.MethodGet, Range: rangeHeader}, download)
+	if err == nil && result.Reader != nil {
+		io.Copy(io.Discard, result.Reader)
+		result.Reader.Close()
+	}
+	return result, next, err
+}
+
+func shareDownloads(t *testing.T, token string) int {
+	t.Helper()
+	share, err := db.GetShareByToken(context.Background(), token)
+	if err != nil {
+		t.Fatalf("GetShareByToken: %v", err)
+	}
+	return share.Downloads
+}
+
+func TestShareDownloadLimitCountsEveryRange(t *testing.T) {
+	token := createTestShare(t, 1, false)
+
+	result, resume, err := fetchShare(t, token, "", "", true)
+	if err != nil || result.Status != http.StatusOK {
+		t.Fatalf("first download: %v", err)
+	}
+	if resume == "" {
+		t.Fatal("counted download returned no resume token")
+	}
+
+	// 不从 0 开始的区间同样占用下载次数
+	for _, r := range []string{"bytes=1-", "bytes=1-,0-0", ""} {
+		if _, _, err := fetchShare(t, token, "", r, true); !errors.Is(err, ErrShareLimitReached) {
+			t.Fatalf("download with Range %q: err = %v, want ErrShareLimitReached", r, err)
+		}
+	}
+	if _, _, err := fetchShare(t, token, "1.bad", "bytes=1-", true); !errors.Is(err, ErrShareLimitReached) {
+		t.Fatalf("download with forged resume token: err = %v, want ErrShareLimitReached", err)
+	}
+
+	// 续传凭证只用于这次已计数的下载
+	result, next, err := fetchShare(t, token, resume, "bytes=5-", true)
+	if err != nil || result.Status != http.StatusPartialContent {
+		t.Fatalf("resumed download: %v", err)
+	}
+	if next != "" {
+		t.Fatal("resumed download issued a new resume token")
+	}
+	if n := shareDownloads(t, token); n != 1 {
+		t.Fatalf("downloads = %d, want 1", n)
+	}
+
+	// HEAD 不计数
+	head, _, err := OpenShareFile(context.Background(), token, "", "", DownloadRequest{Method: http.MethodHead}, true)
+	if err != nil || head.Status != http.StatusOK {
+		t.Fatalf("HEAD: %v", err)
+	}
+}
+
+func TestShareStreamRequiresViewOnlyOrUnlimited(t *testing.T) {
+	limited := createTestShare(t, 3, false)
+	if _, _, err := fetchShare(t, limited, "", "", false); !errors.Is(err, ErrShareDownloadOnly) {
+		t.Fatalf("stream of limited share: err = %v, want ErrShareDownloadOnly", err)
+	}
+
+	viewOnly := createTestShare(t, 3, true)
+	if result, _, err := fetchShare(t, viewOnly, "", "bytes=0-3", false); err != nil || result.Status != http.StatusPartialContent {
+		t.Fatalf("stream of view-only share: %v", err)
+	}
+	if _, _, err := fetchShare(t, viewOnly, "", "", true); !errors.Is(err, ErrShareViewOnly) {
+		t.Fatalf("download of view-only share: err = %v, want ErrShareViewOnly", err)
+	}
+
+	unlimited := createTestShare(t, 0, false)
+	if _, _, err := fetchShare(t, unlimited, "", "", false); err != nil {
+		t.Fatalf("stream of unlimited share: %v", err)
+	}
+	if n := shareDownloads(t, unlimited); n != 0 {
+		t.Fatalf("stream counted %d downloads", n)
+	}
+}
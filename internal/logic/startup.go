@@ -2,6 +2,7 @@ package logic
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"time"
 
@@ -37,7 +38,7 @@ func LoadTombstonesOnStartup(ctx context.Context) error {
 	for _, u := range uploads {
 		var status string
 		switch u.Status {
-		case 1: // 已完成
+		case 1, 2: // 已完成 / 转码中（源文件已就绪）
 			// 只有文件真实存在才标记为 completed
 			if existingFiles[u.FileHash] {
 				status = "completed"
@@ -73,12 +74,14 @@ func LoadTombstonesOnStartup(ctx context.Context) error {
 	return nil
 }
 
-// ValidateTombstones 验证墓碑与数据库/文件系统的一致性（可选的定期任务）
+// ValidateTombstones 核对墓碑与数据库/存储的一致性，只报告不修改（完整检查见 RunFsck）
 func ValidateTombstones(ctx context.Context) error {
-	count, err := redis.CountTombstones(ctx)
-	if err != nil {
-		return err
+	report := &FsckReport{StartedAt: time.Now(), Summary: make(map[string]int)}
+	fsckTombstones(ctx, nil, report)
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("validate tombstones: %s", report.Errors[0])
 	}
-	log.Printf("Current tombstone count in Redis: %d", count)
+	log.Printf("Tombstones checked: total=%d stale=%d content_mismatch=%d",
+		report.Tombstones, report.Summary[FsckStaleTombstone], report.Summary[FsckTombstoneDrift])
 	return nil
 }
@@ -0,0 +1,562 @@
+package logic
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"video-platform/internal/db"
+	"video-platform/internal/redis"
+	"video-platform/internal/store"
+	"video-platform/internal/transcode"
+)
+
+var (
+	ErrTranscodeDisabled   = errors.New("transcoding is not enabled")
+	ErrUnknownPreset       = errors.New("unknown transcode preset")
+	ErrPresetExceedsSource = errors.New("preset resolution exceeds source")
+	ErrSourceNotReady      = errors.New("source file is not uploaded yet")
+	ErrJobNotFound         = errors.New("job not found")
+)
+
+// transcodeLeaseTTL 任务租约时长，worker 每 1/3 时长续租一次（测试中调小）
+var transcodeLeaseTTL = 30 * time.Second
+
+const (
+	transcodePollInterval = 2 * time.Second
+	transcodeReapInterval = time.Minute
+	transcodeMaxAttempts  = 3
+	transcodeBackoffBase  = 30 * time.Second
+	transcodeBackoffMax   = 10 * time.Minute
+	transcodeProgressStep = 5 // 进度变化达到该值才写库
+)
+
+// TranscodeConfig 转码 worker 配置
+type TranscodeConfig struct {
+	Executor transcode.Executor // nil 表示不启用转码
+	Workers  int
+	WorkDir  string // 源文件与产物的临时目录
+}
+
+var transcoder struct {
+	executor transcode.Executor
+	workDir  string
+}
+
+// TranscodeEnabled 是否配置了转码执行器
+func TranscodeEnabled() bool {
+	return transcoder.executor != nil
+}
+
+// StartTranscodeWorkers 启动转码 worker 与租约回收协程，ctx 取消后返回的 WaitGroup 完成
+func StartTranscodeWorkers(ctx context.Context, cfg TranscodeConfig) (*sync.WaitGroup, error) {
+	wg := &sync.WaitGroup{}
+	if cfg.Executor == nil {
+		return wg, nil
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if err := os.MkdirAll(cfg.WorkDir, 0755); err != nil {
+		return nil, err
+	}
+	transcoder.executor = cfg.Executor
+	transcoder.workDir = cfg.WorkDir
+
+	host, _ := os.Hostname()
+	for i := 0; i < cfg.Workers; i++ {
+		workerID := fmt.Sprintf("%s-%d-%d", host, os.Getpid(), i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			transcodeWorker(ctx, workerID)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		reapTranscodeJobs(ctx)
+		ticker := time.NewTicker(transcodeReapInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reapTranscodeJobs(ctx)
+			}
+		}
+	}()
+
+	log.Printf("Transcode workers started: executor=%s, workers=%d", cfg.Executor.Name(), cfg.Workers)
+	return wg, nil
+}
+
+// TranscodeJobInfo 转码任务信息
+type TranscodeJobInfo struct {
+	ID         uint   `json:"id"`
+	ContentID  uint   `json:"content_id"`
+	Preset     string `json:"preset"`
+	Status     string `json:"status"`
+	Progress   int    `json:"progress"`
+	Attempts   int    `json:"attempts"`
+	LastError  string `json:"last_error,omitempty"`
+	OutputHash string `json:"output_hash,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+var jobStatusNames = map[int]string{
+	db.JobPending:   "pending",
+	db.JobRunning:   "running",
+	db.JobSucceeded: "succeeded",
+	db.JobFailed:    "failed",
+}
+
+func newTranscodeJobInfo(job *db.TranscodeJob) TranscodeJobInfo {
+	return TranscodeJobInfo{
+		ID:         job.ID,
+		ContentID:  job.ContentID,
+		Preset:     job.Preset,
+		Status:     jobStatusNames[job.Status],
+		Progress:   job.Progress,
+		Attempts:   job.Attempts,
+		LastError:  job.LastError,
+		OutputHash: job.OutputHash,
+		CreatedAt:  job.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+// CreateTranscodeJobs 为用户的内容提交转码任务
+func CreateTranscodeJobs(ctx context.Context, userID int, contentID string, presets []string) ([]TranscodeJobInfo, error) {
+	if !TranscodeEnabled() {
+		return nil, ErrTranscodeDisabled
+	}
+
+	content, err := db.GetContentByID(ctx, userID, contentID)
+	if err != nil {
+		return nil, fmt.Errorf("content not found or access denied")
+	}
+	source, err := db.GetFileMeta(ctx, content.SourceHash)
+	if err != nil || !Store.FileExists(content.SourceHash) {
+		return nil, ErrSourceNotReady
+	}
+
+	for _, name := range presets {
+		preset, ok := transcode.LookupPreset(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownPreset, name)
+		}
+		if source.Height > 0 && preset.Height > source.Height {
+			return nil, fmt.Errorf("%w: %s > %dp", ErrPresetExceedsSource, name, source.Height)
+		}
+	}
+
+	jobs, err := db.CreateTranscodeJobs(ctx, userID, content.ID, content.SourceHash, presets, transcodeMaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]TranscodeJobInfo, 0, len(jobs))
+	for i := range jobs {
+		if jobs[i].Status == db.JobPending {
+			if err := redis.EnqueueTranscodeJob(ctx, jobs[i].ID, jobs[i].NextRunAt); err != nil {
+				// 已落库，回收协程会重新入队
+				log.Printf("Warning: enqueue transcode job %d failed: %v", jobs[i].ID, err)
+			}
+		}
+		result = append(result, newTranscodeJobInfo(&jobs[i]))
+	}
+	return result, nil
+}
+
+// ListTranscodeJobs 列出内容下的转码任务
+func ListTranscodeJobs(ctx context.Context, userID int, contentID string) ([]TranscodeJobInfo, error) {
+	content, err := db.GetContentByID(ctx, userID, contentID)
+	if err != nil {
+		return nil, fmt.Errorf("content not found or access denied")
+	}
+	jobs, err := db.ListTranscodeJobs(ctx, content.ID)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]TranscodeJobInfo, 0, len(jobs))
+	for i := range jobs {
+		result = append(result, newTranscodeJobInfo(&jobs[i]))
+	}
+	return result, nil
+}
+
+// GetTranscodeJob 获取单个转码任务
+func GetTranscodeJob(ctx context.Context, userID int, jobID string) (*TranscodeJobInfo, error) {
+	id, err := strconv.ParseUint(jobID, 10, 64)
+	if err != nil {
+		return nil, ErrJobNotFound
+	}
+	job, err := db.GetTranscodeJob(ctx, uint(id))
+	if err != nil || job.UserID != userID {
+		return nil, ErrJobNotFound
+	}
+	info := newTranscodeJobInfo(job)
+	return &info, nil
+}
+
+// transcodeWorker 从队列中取任务执行，直到 ctx 取消
+func transcodeWorker(ctx context.Context, workerID string) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		jobID, ok, err := redis.DequeueTranscodeJob(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Warning: dequeue transcode job failed: %v", err)
+		}
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(transcodePollInterval):
+			}
+			continue
+		}
+
+		runTranscodeJob(ctx, workerID, jobID)
+	}
+}
+
+// runTranscodeJob 持有租约执行一个任务，并根据结果完成、重试或归还任务
+func runTranscodeJob(ctx context.Context, workerID string, jobID uint) {
+	// 1. 获取租约：同一任务同时只有一个 worker 执行
+	lease := redis.NewTranscodeLease(jobID, transcodeLeaseTTL)
+	ok, err := lease.TryLock(ctx)
+	if err != nil || !ok {
+		return
+	}
+	defer lease.Unlock(context.Background())
+
+	// 2. 认领任务（状态不是等待中说明已被处理，直接丢弃这条队列记录）
+	job, err := db.ClaimTranscodeJob(ctx, jobID, workerID)
+	if err != nil {
+		if !errors.Is(err, db.ErrJobNotClaimable) {
+			log.Printf("Warning: claim transcode job %d failed: %v", jobID, err)
+		}
+		return
+	}
+	log.Printf("Transcode job %d started: preset=%s attempt=%d/%d worker=%s", job.ID, job.Preset, job.Attempts, job.MaxAttempts, workerID)
+
+	// 3. 执行期间续租，续租失败说明任务已被回收，立即停止
+	jobCtx, cancelJob := context.WithCancel(ctx)
+	defer cancelJob()
+	leaseLost := make(chan struct{})
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		ticker := time.NewTicker(transcodeLeaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-jobCtx.Done():
+				return
+			case <-ticker.C:
+				if err := lease.Extend(jobCtx, transcodeLeaseTTL); err != nil && jobCtx.Err() == nil {
+					log.Printf("Warning: extend lease of transcode job %d failed: %v", job.ID, err)
+					if errors.Is(err, redis.ErrLockNotHeld) {
+						close(leaseLost)
+						cancelJob()
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	output, err := executeTranscodeJob(jobCtx, job, workerID)
+	cancelJob()
+	<-heartbeatDone
+	if output != nil {
+		defer output.release()
+	}
+
+	// 后续状态写入不受 worker 关闭影响
+	finishCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	select {
+	case <-leaseLost:
+		log.Printf("Transcode job %d abandoned: lease lost", job.ID)
+		return
+	default:
+	}
+
+	if err != nil && ctx.Err() != nil {
+		// 服务关闭：归还任务，不计入执行次数
+		if err := db.ReleaseTranscodeJob(finishCtx, job.ID, workerID); err != nil {
+			log.Printf("Warning: release transcode job %d failed: %v", job.ID, err)
+		}
+		_ = redis.EnqueueTranscodeJob(finishCtx, job.ID, time.Now())
+		return
+	}
+
+	if err == nil {
+		// 写入产物期间锁丢失时文件可能已被删除 worker 删除
+		err = output.lock.Check()
+	}
+	if err != nil {
+		failTranscodeJob(finishCtx, job, workerID, err)
+		return
+	}
+
+	if err := db.CompleteTranscodeJob(finishCtx, job.ID, workerID, job.ContentID, job.Preset, output.hash, output.path, output.size); err != nil {
+		log.Printf("Complete transcode job %d failed: %v", job.ID, err)
+		if output.created {
+			if exists, metaErr := db.FileMetaExists(finishCtx, output.hash); metaErr == nil && !exists {
+				_ = Store.DeleteFile(output.hash)
+			}
+		}
+		if !errors.Is(err, db.ErrJobLeaseLost) {
+			failTranscodeJob(finishCtx, job, workerID, err)
+		}
+		return
+	}
+
+	if err := ProbeFileMeta(finishCtx, output.hash); err != nil {
+		log.Printf("Warning: probe media info for %s failed: %v", output.hash, err)
+	}
+	log.Printf("Transcode job %d succeeded: output=%s size=%d", job.ID, output.hash, output.size)
+}
+
+// failTranscodeJob 记录失败，未超过最大次数时按指数退避重新入队
+func failTranscodeJob(ctx context.Context, job *db.TranscodeJob, workerID string, cause error) {
+	var retryAt *time.Time
+	if job.Attempts < job.MaxAttempts {
+		t := time.Now().Add(transcodeBackoff(job.Attempts))
+		retryAt = &t
+	}
+
+	if err := db.FailTranscodeJob(ctx, job.ID, workerID, job.ContentID, cause.Error(), retryAt); err != nil {
+		log.Printf("Warning: record failure of transcode job %d failed: %v", job.ID, err)
+		return
+	}
+	if retryAt == nil {
+		log.Printf("Transcode job %d failed permanently: %v", job.ID, cause)
+		return
+	}
+	log.Printf("Transcode job %d failed (attempt %d/%d), retry at %s: %v",
+		job.ID, job.Attempts, job.MaxAttempts, retryAt.Format(time.RFC3339), cause)
+	if err := redis.EnqueueTranscodeJob(ctx, job.ID, *retryAt); err != nil {
+		log.Printf("Warning: enqueue transcode job %d failed: %v", job.ID, err)
+	}
+}
+
+// transcodeBackoff 第 attempt 次失败后的等待时间
+func transcodeBackoff(attempt int) time.Duration {
+	d := transcodeBackoffBase
+	for i := 1; i < attempt && d < transcodeBackoffMax; i++ {
+		d *= 2
+	}
+	if d > transcodeBackoffMax {
+		d = transcodeBackoffMax
+	}
+	return d
+}
+
+// transcodeOutput 已写入存储的转码产物
+type transcodeOutput struct {
+	hash    string
+	path    string
+	size    int64
+	created bool // 本次写入了新文件（失败时需要清理）
+
+	// 持有文件锁直到产物登记为版本，期间删除 worker 不会删除该文件
+	lock *heldBlob
+}
+
+func (o *transcodeOutput) release() {
+	o.lock.Unlock()
+}
+
+// executeTranscodeJob 取出源文件、调用执行器并把产物写入存储
+func executeTranscodeJob(ctx context.Context, job *db.TranscodeJob, workerID string) (*transcodeOutput, error) {
+	preset, ok := transcode.LookupPreset(job.Preset)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPreset, job.Preset)
+	}
+	source, err := db.GetFileMeta(ctx, job.SourceHash)
+	if err != nil {
+		return nil, fmt.Errorf("source file metadata not found: %w", err)
+	}
+
+	dir, err := os.MkdirTemp(transcoder.workDir, fmt.Sprintf("job-%d-", job.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	// 1. 源文件复制到本地（存储后端不一定是本地文件系统）
+	input := filepath.Join(dir, "source")
+	if err := copyStoredFile(job.SourceHash, input); err != nil {
+		return nil, fmt.Errorf("fetch source failed: %w", err)
+	}
+
+	// 2. 转码
+	output := filepath.Join(dir, "output.mp4")
+	last := 0
+	report := func(percent int) {
+		if percent < last+transcodeProgressStep && percent != 100 {
+			return
+		}
+		last = percent
+		if err := db.UpdateTranscodeProgress(ctx, job.ID, workerID, percent); err != nil {
+			log.Printf("Warning: update progress of transcode job %d failed: %v", job.ID, err)
+		}
+	}
+	req := transcode.Request{
+		Input:    input,
+		Output:   output,
+		Preset:   preset,
+		Duration: float64(source.Duration),
+	}
+	if err := transcoder.executor.Transcode(ctx, req, report); err != nil {
+		return nil, err
+	}
+
+	// 3. 产物写入存储
+	return storeTranscodeOutput(job.UserID, output)
+}
+
+// copyStoredFile 把存储中的文件复制到本地路径
+func copyStoredFile(fileHash, dst string) error {
+	rc, _, err := Store.GetFile(fileHash)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// storeTranscodeOutput 以单分片上传的方式把产物写入存储，返回时持有该文件的锁
+func storeTranscodeOutput(userID int, path string) (*transcodeOutput, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	digest := sha256.New()
+	size, err := io.Copy(digest, f)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, fmt.Errorf("transcode produced an empty file")
+	}
+	hash := store.FormatFileHash(store.HashSHA256, digest.Sum(nil))
+
+	lock, err := lockBlob(context.Background(), hash)
+	if err != nil {
+		return nil, err
+	}
+	output, err := writeTranscodeOutput(userID, f, hash, size)
+	if err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	output.lock = lock
+	return output, nil
+}
+
+// writeTranscodeOutput 写入产物，已存在相同内容（含等待删除的文件）时直接复用；
+// 已存在但被隔离时用产物覆盖并解除隔离
+func writeTranscodeOutput(userID int, f *os.File, hash string, size int64) (*transcodeOutput, error) {
+	fm, err := db.GetFileMeta(context.Background(), hash)
+	if err == nil && fm.QuarantinedAt == nil && Store.FileExists(hash) {
+		return &transcodeOutput{hash: hash, path: fm.FilePath, size: size}, nil
+	}
+	exists := err == nil
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	// 产物作为单个分片写入，加密存储需要事先确定布局
+	if p, ok := Store.(store.Preallocator); ok {
+		if err := p.Preallocate(userID, hash, size, size); err != nil {
+			return nil, fmt.Errorf("prepare output failed: %w", err)
+		}
+	}
+	if err := Store.WriteChunk(userID, hash, 0, f, nil); err != nil {
+		return nil, fmt.Errorf("write output failed: %w", err)
+	}
+	filePath, fileSize, err := Store.MergeChunks(userID, hash, 1, size, "")
+	if err != nil {
+		_ = Store.CleanupChunks(userID, hash)
+		return nil, fmt.Errorf("store output failed: %w", err)
+	}
+	if exists {
+		if err := db.MarkFileVerified(context.Background(), hash, time.Now()); err != nil {
+			log.Printf("Warning: release quarantine of %s failed: %v", hash, err)
+		}
+	}
+	return &transcodeOutput{hash: hash, path: filePath, size: fileSize, created: !exists}, nil
+}
+
+// reapTranscodeJobs 把到期的等待任务重新入队，并回收租约已过期的执行中任务。
+// 多个节点同时运行时只有拿到锁的节点执行。
+func reapTranscodeJobs(ctx context.Context) {
+	lock := redis.NewLock("transcode:reaper", transcodeReapInterval/2)
+	ok, err := lock.TryLock(ctx)
+	if err != nil || !ok {
+		return
+	}
+	defer lock.Unlock(context.Background())
+
+	now := time.Now()
+	due, err := db.ListDueTranscodeJobs(ctx, now, 1000)
+	if err != nil {
+		log.Printf("Warning: list pending transcode jobs failed: %v", err)
+	}
+	for _, job := range due {
+		_ = redis.EnqueueTranscodeJob(ctx, job.ID, job.NextRunAt)
+	}
+
+	running, err := db.ListRunningTranscodeJobs(ctx)
+	if err != nil {
+		log.Printf("Warning: list running transcode jobs failed: %v", err)
+		return
+	}
+	for i := range running {
+		job := &running[i]
+		if now.Sub(job.UpdatedAt) < transcodeLeaseTTL {
+			continue
+		}
+		held, err := redis.TranscodeLeaseHeld(ctx, job.ID)
+		if err != nil || held {
+			continue
+		}
+		status, err := db.RecoverTranscodeJob(ctx, job)
+		if err != nil {
+			continue
+		}
+		log.Printf("Recovered transcode job %d from dead worker %s", job.ID, job.WorkerID)
+		if status == db.JobPending {
+			_ = redis.EnqueueTranscodeJob(ctx, job.ID, time.Now())
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"video-platform/internal/db"
+	"video-platform/internal/redis"
+	"video-platform/internal/store"
+	"video-platform/internal/transcode"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	goredis "github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testUserID = 1
+
+// setupLogicTest 以 sqlite、miniredis 与本地存储替换全局依赖，并启用给定的转码执行器
+func setupLogicTest(t *testing.T, executor transcode.Executor) *miniredis.Miniredis {
+	t.Helper()
+	dir := t.TempDir()
+
+	gdb, err := gorm.Open(sqlite.Open(filepath.Join(dir, "test.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, err := gdb.DB()
+	if err != nil {
+		t.Fatalf("sqlite handle: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := gdb.AutoMigrate(&db.Content{}, &db.FileMeta{}, &db.UserContent{}, &db.User{}, &db.TranscodeJob{}, &db.ContentVersion{}, &db.ContentDefaultLog{}, &db.Share{}, &db.UploadSession{}, &db.PendingDeletion{}, &db.DedupBlock{}, &db.DedupFileBlock{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	mr := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+
+	prevDB, prevRedis, prevStore, prevTranscoder, prevTTL, prevBlobTTL := db.DB, redis.Client, Store, transcoder, transcodeLeaseTTL, blobLockTTL
+	t.Cleanup(func() {
+		client.Close()
+		sqlDB.Close()
+		db.DB, redis.Client, Store, transcoder, transcodeLeaseTTL, blobLockTTL = prevDB, prevRedis, prevStore, prevTranscoder, prevTTL, prevBlobTTL
+	})
+
+	db.DB = gdb
+	redis.Client = client
+	Store = store.NewLocalStore(filepath.Join(dir, "files"), filepath.Join(dir, "tmp"))
+	transcoder.executor = executor
+	transcoder.workDir = t.TempDir()
+	return mr
+}
+
+// uploadSource 以普通上传的流程登记一个源文件，返回 content id
+func uploadSource(t *testing.T, data []byte) uint {
+	t.Helper()
+	ctx := context.Background()
+	sum := sha256.Sum256(data)
+	hash := store.FormatFileHash(store.HashSHA256, sum[:])
+
+	contentID, err := db.CreateOrUpdateUserFileUploading(ctx, testUserID, "source.mp4", hash)
+	if err != nil {
+		t.Fatalf("create content: %v", err)
+	}
+	if err := Store.WriteChunk(testUserID, hash, 0, bytes.NewReader(data), nil); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+	path, size, err := Store.MergeChunks(testUserID, hash, 1, int64(len(data)), "")
+	if err != nil {
+		t.Fatalf("merge source: %v", err)
+	}
+	if err := db.FinishMergeAndCreateMeta(ctx, testUserID, contentID, "source.mp4", hash, path, size); err != nil {
+		t.Fatalf("register source: %v", err)
+	}
+	return contentID
+}
+
+// submitJob 提交一个 720p 任务并从队列中取出它
+func submitJob(t *testing.T, contentID uint) uint {
+	t.Helper()
+	ctx := context.Background()
+	jobs, err := CreateTranscodeJobs(ctx, testUserID, strconv.FormatUint(uint64(contentID), 10), []string{"720p"})
+	if err != nil {
+		t.Fatalf("CreateTranscodeJobs: %v", err)
+	}
+	id, ok, err := redis.DequeueTranscodeJob(ctx, time.Now())
+	if err != nil || !ok || id != jobs[0].ID {
+		t.Fatalf("dequeue = %d, %v, %v; want job %d", id, ok, err, jobs[0].ID)
+	}
+	return id
+}
+
+func getJob(t *testing.T, id uint) *db.TranscodeJob {
+	t.Helper()
+	job, err := db.GetTranscodeJob(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetTranscodeJob: %v", err)
+	}
+	return job
+}
+
+func userContentStatus(t *testing.T, contentID uint) int {
+	t.Helper()
+	var uc db.UserContent
+	if err := db.DB.Where("user_id = ? AND content_id = ?", testUserID, contentID).First(&uc).Error; err != nil {
+		t.Fatalf("load user content: %v", err)
+	}
+	return uc.Status
+}
+
+// leaseKey 任务租约在 redis 中的 key
+func leaseKey(id uint) string {
+	return "lock:" + redis.TranscodeLeasePrefix + strconv.FormatUint(uint64(id), 10)
+}
+
+// queuedAt 任务在队列中的可执行时间，不在队列中时 ok 为 false
+func queuedAt(t *testing.T, mr *miniredis.Miniredis, id uint) (time.Time, bool) {
+	t.Helper()
+	score, err := mr.ZScore(redis.TranscodeQueueKey, strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(int64(score)), true
+}
+
+func TestTranscodeJobRegistersOutputAsVersion(t *testing.T) {
+	setupLogicTest(t, &transcode.FakeExecutor{})
+	ctx := context.Background()
+	source := []byte("source video bytes")
+	contentID := uploadSource(t, source)
+
+	id := submitJob(t, contentID)
+	if status := userContentStatus(t, contentID); status != 2 {
+		t.Fatalf("user content status while transcoding = %d, want 2", status)
+	}
+	runTranscodeJob(ctx, "worker-1", id)
+
+	job := getJob(t, id)
+	if job.Status != db.JobSucceeded || job.Attempts != 1 || job.Progress != 100 {
+		t.Fatalf("job = status %d, attempts %d, progress %d; want succeeded after 1 attempt", job.Status, job.Attempts, job.Progress)
+	}
+
+	want := append([]byte("fake-transcode:720p\n"), source...)
+	sum := sha256.Sum256(want)
+	if hash := store.FormatFileHash(store.HashSHA256, sum[:]); job.OutputHash != hash {
+		t.Fatalf("output hash = %s, want %s", job.OutputHash, hash)
+	}
+	rc, _, err := Store.GetFile(job.OutputHash)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(got, want) {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+
+	var versions []db.ContentVersion
+	if err := db.DB.Where("file_hash = ?", job.OutputHash).Find(&versions).Error; err != nil {
+		t.Fatalf("load versions: %v", err)
+	}
+	if len(versions) != 1 || versions[0].ContentID != contentID || versions[0].Kind != "transcode" || versions[0].Label != "720p" {
+		t.Fatalf("versions = %+v, want one 720p transcode version of content %d", versions, contentID)
+	}
+	fm, err := db.GetFileMeta(ctx, job.OutputHash)
+	if err != nil || fm.RefCount != 1 || fm.FileSize != int64(len(want)) {
+		t.Fatalf("output meta = %+v, %v; want one reference", fm, err)
+	}
+	if status := userContentStatus(t, contentID); status != 1 {
+		t.Fatalf("user content status after transcoding = %d, want 1", status)
+	}
+}
+
+func TestTranscodeJobRetriesWithBackoffThenFails(t *testing.T) {
+	mr := setupLogicTest(t, &transcode.FakeExecutor{Err: errors.New("encoder crashed")})
+	ctx := context.Background()
+	contentID := uploadSource(t, []byte("source"))
+	id := submitJob(t, contentID)
+
+	for attempt := 1; attempt <= transcodeMaxAttempts; attempt++ {
+		before := time.Now()
+		runTranscodeJob(ctx, "worker-1", id)
+		job := getJob(t, id)
+		if job.Attempts != attempt || job.LastError != "encoder crashed" || job.WorkerID != "" {
+			t.Fatalf("attempt %d: job = attempts %d, error %q, worker %q", attempt, job.Attempts, job.LastError, job.WorkerID)
+		}
+
+		if attempt == transcodeMaxAttempts {
+			if job.Status != db.JobFailed || job.FinishedAt == nil {
+				t.Fatalf("job status after %d attempts = %d, want failed", attempt, job.Status)
+			}
+			if _, ok := queuedAt(t, mr, id); ok {
+				t.Fatal("permanently failed job is still queued")
+			}
+			break
+		}
+
+		// 按指数退避重新排队
+		backoff := transcodeBackoff(attempt)
+		if job.Status != db.JobPending || job.NextRunAt.Before(before.Add(backoff)) || job.NextRunAt.After(time.Now().Add(backoff)) {
+			t.Fatalf("attempt %d: job = status %d, next run %s; want pending after %s", attempt, job.Status, job.NextRunAt, backoff)
+		}
+		runAt, ok := queuedAt(t, mr, id)
+		if !ok || runAt.Sub(job.NextRunAt).Abs() > time.Millisecond {
+			t.Fatalf("attempt %d: queued at %s (%v), want %s", attempt, runAt, ok, job.NextRunAt)
+		}
+		if _, ok, _ := redis.DequeueTranscodeJob(ctx, time.Now()); ok {
+			t.Fatalf("attempt %d: job dequeued before its retry time", attempt)
+		}
+
+		// 退避期间不能被认领
+		runTranscodeJob(ctx, "worker-2", id)
+		if job := getJob(t, id); job.Attempts != attempt || job.Status != db.JobPending {
+			t.Fatalf("job claimed before its retry time: attempts %d, status %d", job.Attempts, job.Status)
+		}
+
+		// 跳过退避：重试时间提前到现在，按 worker 的方式出队
+		if err := db.DB.Model(&db.TranscodeJob{}).Where("id = ?", id).Update("next_run_at", time.Now().Add(-time.Second)).Error; err != nil {
+			t.Fatalf("advance retry time: %v", err)
+		}
+		if next, ok, err := redis.DequeueTranscodeJob(ctx, runAt); err != nil || !ok || next != id {
+			t.Fatalf("dequeue retry = %d, %v, %v; want job %d", next, ok, err, id)
+		}
+	}
+
+	if status := userContentStatus(t, contentID); status != 1 {
+		t.Fatalf("user content status after failure = %d, want 1", status)
+	}
+}
+
+func TestTranscodeBackoff(t *testing.T) {
+	for attempt, want := range map[int]time.Duration{
+		1:  transcodeBackoffBase,
+		2:  2 * transcodeBackoffBase,
+		3:  4 * transcodeBackoffBase,
+		10: transcodeBackoffMax,
+	} {
+		if got := transcodeBackoff(attempt); got != want {
+			t.Errorf("transcodeBackoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
+
+func TestTranscodeLeaseExtendedWhileRunning(t *testing.T) {
+	mr := setupLogicTest(t, &transcode.FakeExecutor{StepDelay: 250 * time.Millisecond})
+	transcodeLeaseTTL = 300 * time.Millisecond
+	ctx := context.Background()
+	id := submitJob(t, uploadSource(t, []byte("source")))
+
+	// miniredis 的过期时间只随 FastForward 推进：模拟时间与真实时间同步流逝，
+	// 没有续租时租约会在任务结束前过期
+	key := leaseKey(id)
+	var heldAfterTTL atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		start := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(50 * time.Millisecond):
+				mr.FastForward(50 * time.Millisecond)
+				if time.Since(start) > 2*transcodeLeaseTTL && mr.Exists(key) {
+					heldAfterTTL.Store(true)
+				}
+			}
+		}
+	}()
+
+	runTranscodeJob(ctx, "worker-1", id)
+	close(done)
+
+	if job := getJob(t, id); job.Status != db.JobSucceeded {
+		t.Fatalf("job status = %d, want succeeded", job.Status)
+	}
+	if !heldAfterTTL.Load() {
+		t.Fatal("lease was not held beyond its TTL while the job was running")
+	}
+	if mr.Exists(key) {
+		t.Fatal("lease not released after the job finished")
+	}
+}
+
+func TestTranscodeJobAbandonedWhenLeaseLost(t *testing.T) {
+	mr := setupLogicTest(t, &transcode.FakeExecutor{StepDelay: 100 * time.Millisecond})
+	transcodeLeaseTTL = 150 * time.Millisecond
+	ctx := context.Background()
+	id := submitJob(t, uploadSource(t, []byte("source")))
+
+	go func() {
+		// 租约被回收（如 worker 长时间停顿后被判定为已退出）
+		time.Sleep(80 * time.Millisecond)
+		mr.Del(leaseKey(id))
+	}()
+	runTranscodeJob(ctx, "worker-1", id)
+
+	job := getJob(t, id)
+	if job.Status != db.JobRunning || job.OutputHash != "" {
+		t.Fatalf("job = status %d, output %q; want left running for the reaper", job.Status, job.OutputHash)
+	}
+	var versions int64
+	db.DB.Model(&db.ContentVersion{}).Count(&versions)
+	if versions != 0 {
+		t.Fatalf("%d versions registered by an abandoned job", versions)
+	}
+}
+
+func TestTranscodeJobClaimedOnce(t *testing.T) {
+	setupLogicTest(t, &transcode.FakeExecutor{})
+	ctx := context.Background()
+	id := submitJob(t, uploadSource(t, []byte("source")))
+
+	// 其他 worker 持有租约时不执行
+	lease := redis.NewTranscodeLease(id, time.Minute)
+	if ok, err := lease.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("TryLock = %v, %v", ok, err)
+	}
+	runTranscodeJob(ctx, "worker-2", id)
+	if job := getJob(t, id); job.Status != db.JobPending || job.Attempts != 0 {
+		t.Fatalf("job ran while another worker held the lease: status %d, attempts %d", job.Status, job.Attempts)
+	}
+	lease.Unlock(ctx)
+
+	runTranscodeJob(ctx, "worker-1", id)
+	// 已完成的任务即使重复出队也不会再执行
+	runTranscodeJob(ctx, "worker-2", id)
+	if job := getJob(t, id); job.Status != db.JobSucceeded || job.Attempts != 1 || job.WorkerID != "worker-1" {
+		t.Fatalf("job = status %d, attempts %d, worker %q; want one run by worker-1", job.Status, job.Attempts, job.WorkerID)
+	}
+}
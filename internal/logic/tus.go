@@ -0,0 +1,371 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"video-platform/internal/db"
+	"video-platform/internal/redis"
+	"video-platform/internal/store"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrTusNotFound         = errors.New("tus upload not found")
+	ErrTusGone             = errors.New("tus upload expired or terminated")
+	ErrTusOffsetMismatch   = errors.New("upload offset does not match")
+	ErrTusLocked           = errors.New("tus upload is being written by another request")
+	ErrTusTooLarge         = errors.New("data exceeds upload length")
+	ErrTusChecksumMismatch = errors.New("request body does not match Upload-Checksum")
+)
+
+// tusLockTTL 单个 PATCH 持锁时长，每写完一个分片续期
+const tusLockTTL = 60 * time.Second
+
+// TusUpload tus 上传的状态，ID 即上传会话 ID
+type TusUpload struct {
+	ID        string
+	FileName  string
+	FileHash  string
+	Length    int64
+	Offset    int64
+	Completed bool
+}
+
+// tusDir 未凑满一个分片的数据暂存目录
+func tusDir() string {
+	if uploadConfig.TusDir != "" {
+		return uploadConfig.TusDir
+	}
+	return filepath.Join(os.TempDir(), "video-tus")
+}
+
+// tusTailPath 第 index 个分片已收到的部分数据
+func tusTailPath(sessionID string, index int) string {
+	return filepath.Join(tusDir(), sessionID+"."+strconv.Itoa(index)+".tail")
+}
+
+// CreateTusUpload 创建 tus 上传。与 /upload/init 走同一套流程：
+// 用户已拥有该文件时直接返回已完成的上传，否则返回新的（或可续传的）上传会话。
+func CreateTusUpload(ctx context.Context, userID int, fileName, fileHash, strongHash string, length int64) (*TusUpload, error) {
+	fileHash, err := normalizeFileHash(fileHash)
+	if err != nil {
+		return nil, err
+	}
+	result, err := InitUpload(ctx, userID, fileName, fileHash, strongHash, length)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.SessionID == "" {
+		// 秒传：登记一条已完成的会话，HEAD 会返回 Upload-Offset == Upload-Length
+		sess := &db.UploadSession{
+			ID:          uuid.New().String(),
+			UserID:      userID,
+			FileHash:    fileHash,
+			ContentID:   result.ContentID,
+			FileName:    fileName,
+			FileSize:    length,
+			ChunkSize:   length,
+			TotalChunks: 1,
+			Status:      db.UploadSessionCompleted,
+			ExpiresAt:   time.Now().Add(uploadConfig.SessionTTL),
+		}
+		if err := db.CreateUploadSession(ctx, sess); err != nil {
+			return nil, fmt.Errorf("create upload session failed: %w", err)
+		}
+		return &TusUpload{ID: sess.ID, FileName: fileName, FileHash: fileHash, Length: length, Offset: length, Completed: true}, nil
+	}
+
+	return GetTusUpload(ctx, userID, result.SessionID)
+}
+
+// GetTusUpload 获取 tus 上传状态。数据已全部收到但还没合并（如合并时进程退出）时补做合并。
+func GetTusUpload(ctx context.Context, userID int, id string) (*TusUpload, error) {
+	sess, err := getTusSession(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	upload, err := tusState(sess)
+	if err != nil {
+		return nil, err
+	}
+	if !upload.Completed && upload.Offset == upload.Length {
+		if err := finishTusUpload(ctx, sess); err != nil {
+			return nil, err
+		}
+		upload.Completed = true
+	}
+	return upload, nil
+}
+
+// WriteTusUpload 处理 PATCH：从 offset 起追加 body。checksum 非空时先完整接收并校验，
+// 不一致则丢弃本次数据；数据收齐后与 /upload/merge 一样合并入库。
+func WriteTusUpload(ctx context.Context, userID int, id string, offset int64, body io.Reader, checksum *store.ChunkChecksum) (*TusUpload, error) {
+	lock := redis.NewLock("tus:"+id, tusLockTTL)
+	ok, err := lock.TryLock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquire lock failed: %w", err)
+	}
+	if !ok {
+		return nil, ErrTusLocked
+	}
+	defer lock.Unlock(context.Background())
+
+	sess, err := getTusSession(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	upload, err := tusState(sess)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Completed {
+		if offset == upload.Length {
+			return upload, nil
+		}
+		return nil, ErrTusOffsetMismatch
+	}
+	if offset != upload.Offset {
+		return nil, ErrTusOffsetMismatch
+	}
+	if err := os.MkdirAll(tusDir(), 0755); err != nil {
+		return nil, err
+	}
+
+	// 只接收到 Upload-Length 为止，多余的数据视为错误
+	reqBody := &tusBody{r: body}
+	src := io.LimitReader(reqBody, upload.Length-upload.Offset+1)
+	if checksum != nil {
+		spooled, err := spoolTusBody(id, src, checksum)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			spooled.Close()
+			os.Remove(spooled.Name())
+		}()
+		src = spooled
+	}
+
+	// 数据超出 Upload-Length 时仍保留并合并前面的部分，再报告错误
+	writeErr := appendTusData(sess, upload, src, func() {
+		_ = lock.Extend(ctx, tusLockTTL)
+	})
+	if reqBody.err != nil && errors.Is(writeErr, reqBody.err) {
+		// 请求体中断：已写入的部分保留，客户端 HEAD 后从新的 offset 继续
+		log.Printf("tus upload %s: body read stopped at offset %d: %v", id, upload.Offset, writeErr)
+		return upload, nil
+	}
+	if writeErr != nil && !errors.Is(writeErr, ErrTusTooLarge) {
+		return nil, fmt.Errorf("write tus upload at offset %d: %w", upload.Offset, writeErr)
+	}
+
+	if upload.Offset == upload.Length {
+		if err := finishTusUpload(ctx, sess); err != nil {
+			return nil, err
+		}
+		upload.Completed = true
+	}
+	if writeErr != nil {
+		return nil, writeErr
+	}
+	return upload, nil
+}
+
+// TerminateTusUpload 终止 tus 上传（termination 扩展），与取消上传相同
+func TerminateTusUpload(ctx context.Context, userID int, id string) error {
+	sess, err := getTusSession(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	removeTusTails(sess)
+	if sess.Status == db.UploadSessionCompleted {
+		return nil
+	}
+	return CancelUpload(ctx, userID, sess.FileHash, sess.ContentID)
+}
+
+// getTusSession 获取会话，过期或已取消的上传视为已不存在
+func getTusSession(ctx context.Context, userID int, id string) (*db.UploadSession, error) {
+	sess, err := db.GetUploadSession(ctx, userID, id)
+	if err != nil {
+		if errors.Is(err, db.ErrUploadSessionNotFound) {
+			return nil, ErrTusNotFound
+		}
+		return nil, err
+	}
+	if sess.Status == db.UploadSessionCancelled ||
+		(sess.Status == db.UploadSessionActive && time.Now().After(sess.ExpiresAt)) {
+		return nil, ErrTusGone
+	}
+	return sess, nil
+}
+
+// tusState 由已上传的连续分片与暂存数据计算 offset，进程重启后同样成立
+func tusState(sess *db.UploadSession) (*TusUpload, error) {
+	upload := &TusUpload{
+		ID:       sess.ID,
+		FileName: sess.FileName,
+		FileHash: sess.FileHash,
+		Length:   sess.FileSize,
+	}
+	if sess.Status == db.UploadSessionCompleted {
+		upload.Offset = sess.FileSize
+		upload.Completed = true
+		return upload, nil
+	}
+
+	chunks, err := Store.GetUploadedChunks(sess.UserID, sess.FileHash)
+	if err != nil {
+		return nil, fmt.Errorf("get uploaded chunks failed: %w", err)
+	}
+	next := 0
+	for _, idx := range chunks {
+		if idx != next {
+			break
+		}
+		next++
+	}
+
+	upload.Offset = int64(next) * sess.ChunkSize
+	if upload.Offset > sess.FileSize {
+		upload.Offset = sess.FileSize
+	}
+	if next < sess.TotalChunks {
+		if fi, err := os.Stat(tusTailPath(sess.ID, next)); err == nil {
+			upload.Offset += fi.Size()
+		}
+	}
+	return upload, nil
+}
+
+// spoolTusBody 把 PATCH 数据完整落盘并校验 Upload-Checksum
+func spoolTusBody(id string, body io.Reader, checksum *store.ChunkChecksum) (*os.File, error) {
+	f, err := os.CreateTemp(tusDir(), id+".patch-*")
+	if err != nil {
+		return nil, err
+	}
+	digest := checksum.New()
+	if _, err := io.Copy(io.MultiWriter(f, digest), body); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if !bytes.Equal(digest.Sum(nil), checksum.Sum) {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, ErrTusChecksumMismatch
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// tusBody 记录请求体的读取错误，以便与写入存储的错误区分
+type tusBody struct {
+	r   io.Reader
+	err error
+}
+
+func (b *tusBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// appendTusData 把数据追加到当前分片的暂存文件，凑满一个分片后写入存储，
+// 每写入一个分片调用一次 committed
+func appendTusData(sess *db.UploadSession, upload *TusUpload, src io.Reader, committed func()) error {
+	for upload.Offset < upload.Length {
+		index := int(upload.Offset / sess.ChunkSize)
+		need := chunkLength(sess, index)
+		tailPath := tusTailPath(sess.ID, index)
+
+		tail, err := os.OpenFile(tailPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		have := upload.Offset - int64(index)*sess.ChunkSize
+		n, copyErr := io.CopyN(tail, src, need-have)
+		tail.Close()
+		upload.Offset += n
+
+		if have+n == need {
+			if err := commitTusChunk(sess, index, tailPath); err != nil {
+				upload.Offset -= need
+				return err
+			}
+			committed()
+		}
+		if copyErr == io.EOF {
+			return nil
+		}
+		if copyErr != nil {
+			return copyErr
+		}
+	}
+
+	// 已到 Upload-Length，后面不应再有数据
+	var one [1]byte
+	if n, _ := src.Read(one[:]); n > 0 {
+		return ErrTusTooLarge
+	}
+	return nil
+}
+
+// commitTusChunk 把凑满的分片写入存储并删除暂存文件
+func commitTusChunk(sess *db.UploadSession, index int, tailPath string) error {
+	f, err := os.Open(tailPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := Store.WriteChunk(sess.UserID, sess.FileHash, index, f, nil); err != nil {
+		// 分片写入失败时暂存数据也不再可信，客户端从该分片起点重传
+		os.Remove(tailPath)
+		return fmt.Errorf("write chunk failed: %w", err)
+	}
+	os.Remove(tailPath)
+	return nil
+}
+
+// finishTusUpload 数据收齐后与 /upload/merge 走相同的合并流程
+func finishTusUpload(ctx context.Context, sess *db.UploadSession) error {
+	_, err := MergeChunks(ctx, MergeChunksParams{
+		UserID:    sess.UserID,
+		SessionID: sess.ID,
+		ContentID: sess.ContentID,
+		FileName:  sess.FileName,
+		FileHash:  sess.FileHash,
+	})
+	if err != nil && !errors.Is(err, ErrUploadAlreadyCompleted) {
+		return err
+	}
+	removeTusTails(sess)
+	return nil
+}
+
+// removeTusTails 删除会话的所有暂存文件
+func removeTusTails(sess *db.UploadSession) {
+	matches, _ := filepath.Glob(filepath.Join(tusDir(), sess.ID+".*"))
+	for _, m := range matches {
+		os.Remove(m)
+	}
+}
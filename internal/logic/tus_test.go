@@ -0,0 +1,100 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+
+	"video-platform/internal/store"
+)
+
+var errDiskFull = errors.New("disk full")
+
+// failingChunkStore 写入分片总是失败
+type failingChunkStore struct {
+	store.Uploader
+}
+
+func (failingChunkStore) WriteChunk(int, string, int, io.Reader, *store.ChunkChecksum) error {
+	return errDiskFull
+}
+
+// brokenBody 读出 data 后返回 err，模拟客户端连接中断
+type brokenBody struct {
+	data []byte
+	err  error
+}
+
+func (b *brokenBody) Read(p []byte) (int, error) {
+	if len(b.data) == 0 {
+		return 0, b.err
+	}
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	return n, nil
+}
+
+// createTusTest 以 4 字节分片创建一个 tus 上传
+func createTusTest(t *testing.T, data []byte) *TusUpload {
+	t.Helper()
+	setupLogicTest(t, nil)
+	prev := uploadConfig
+	t.Cleanup(func() { uploadConfig = prev })
+	uploadConfig.ChunkSize = 4
+	uploadConfig.TusDir = t.TempDir()
+
+	sum := sha256.Sum256(data)
+	hash := store.FormatFileHash(store.HashSHA256, sum[:])
+	upload, err := CreateTusUpload(context.Background(), testUserID, "video.mp4", hash, "", int64(len(data)))
+	if err != nil {
+		t.Fatalf("CreateTusUpload: %v", err)
+	}
+	return upload
+}
+
+func TestWriteTusUploadKeepsDataWhenBodyBreaks(t *testing.T) {
+	data := []byte("0123456789")
+	upload := createTusTest(t, data)
+	ctx := context.Background()
+
+	body := &brokenBody{data: data[:6], err: io.ErrUnexpectedEOF}
+	got, err := WriteTusUpload(ctx, testUserID, upload.ID, 0, body, nil)
+	if err != nil {
+		t.Fatalf("WriteTusUpload: %v", err)
+	}
+	if got.Offset != 6 {
+		t.Fatalf("offset = %d, want 6", got.Offset)
+	}
+
+	got, err = WriteTusUpload(ctx, testUserID, upload.ID, 6, bytes.NewReader(data[6:]), nil)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if !got.Completed || got.Offset != int64(len(data)) {
+		t.Fatalf("upload = %+v, want completed", got)
+	}
+}
+
+func TestWriteTusUploadReportsStorageError(t *testing.T) {
+	data := []byte("0123456789")
+	upload := createTusTest(t, data)
+	ctx := context.Background()
+	Store = failingChunkStore{Store}
+
+	_, err := WriteTusUpload(ctx, testUserID, upload.ID, 0, bytes.NewReader(data), nil)
+	if !errors.Is(err, errDiskFull) {
+		t.Fatalf("err = %v, want %v", err, errDiskFull)
+	}
+
+	// 写入失败的分片从起点重传
+	got, err := GetTusUpload(ctx, testUserID, upload.ID)
+	if err != nil {
+		t.Fatalf("GetTusUpload: %v", err)
+	}
+	if got.Offset != 0 {
+		t.Fatalf("offset = %d, want 0", got.Offset)
+	}
+}
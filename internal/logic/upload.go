@@ -7,6 +7,7 @@ import (
 	"io"
 	"log"
 	"sort"
+	"strconv"
 	"time"
 
 	"video-platform/internal/db"
@@ -18,25 +19,65 @@ var (
 	ErrUploadAlreadyCompleted = errors.New("upload already completed")
 	ErrUploadCancelled        = errors.New("upload was cancelled")
 	ErrChunkAlreadyUploaded   = errors.New("chunk already uploaded")
+	ErrMergeVerifyFailed      = errors.New("merged file does not match declared hash or size")
+	ErrChunkChecksum          = errors.New("chunk does not match declared checksum")
+	ErrInvalidChecksum        = errors.New("invalid chunk checksum")
 )
 
 // Store 全局存储实例
 var Store store.Uploader
 
 // InitStore 初始化存储
-func InitStore(basePath, tempPath string) {
-	Store = store.NewLocalStore(basePath, tempPath)
+func InitStore(cfg store.Config) error {
+	s, err := store.New(cfg)
+	if err != nil {
+		return err
+	}
+	if d, ok := s.(store.Deduplicator); ok {
+		d.SetBlockIndex(blockIndex{})
+	}
+	if r, ok := s.(store.CorruptionReporter); ok {
+		r.SetCorruptionHandler(reportCorruptReplica)
+	}
+	Store = s
+	return nil
 }
 
 // InitUploadResult 初始化上传结果
 type InitUploadResult struct {
-	ContentID      uint   `json:"content_id"`
-	Status         string `json:"status"`
-	UploadedChunks []int  `json:"uploaded_chunks,omitempty"`
+	ContentID      uint                 `json:"content_id"`
+	Status         string               `json:"status"`
+	UploadedChunks []int                `json:"uploaded_chunks,omitempty"`
+	Challenge      *FastUploadChallenge `json:"challenge,omitempty"`
+
+	// 分片上传会话（秒传成功时为空）
+	SessionID   string `json:"session_id,omitempty"`
+	ChunkSize   int64  `json:"chunk_size,omitempty"`
+	TotalChunks int    `json:"total_chunks,omitempty"`
+	ExpiresAt   int64  `json:"expires_at,omitempty"`
 }
 
-// InitUpload 初始化上传
-func InitUpload(ctx context.Context, userID int, fileName, fileHash string) (*InitUploadResult, error) {
+// withSession 把上传会话信息填入结果
+func (r *InitUploadResult) withSession(sess *db.UploadSession) *InitUploadResult {
+	r.SessionID = sess.ID
+	r.ChunkSize = sess.ChunkSize
+	r.TotalChunks = sess.TotalChunks
+	r.ExpiresAt = sess.ExpiresAt.Unix()
+	return r
+}
+
+// InitUpload 初始化上传。strongHash 为客户端声明的 SHA-256（可省略），md5 文件要与已有文件的
+// (md5, 大小, 强摘要) 都一致才能秒传
+func InitUpload(ctx context.Context, userID int, fileName, fileHash, strongHash string, fileSize int64) (*InitUploadResult, error) {
+	fileHash, err := normalizeFileHash(fileHash)
+	if err != nil {
+		return nil, err
+	}
+	strongHash, err = normalizeStrongHash(strongHash)
+	if err != nil {
+		return nil, err
+	}
+
 	// 1. 检查墓碑（秒传检查）
 	exists, status, err := redis.CheckTombstone(ctx, userID, fileHash)
 	log.Printf("tombstone check: exists=%v status=%s err=%v", exists, status, err)
@@ -48,15 +89,15 @@ func InitUpload(ctx context.Context, userID int, fileName, fileHash string) (*In
 	if exists && status == "completed" {
 		contentID, err := redis.GetTombstoneContentID(ctx, userID, fileHash)
 		if err == nil && contentID > 0 {
-			// 验证文件确实存在
-			if Store.FileExists(fileHash) {
+			// 验证文件确实存在、内容一致且未被隔离（被隔离时需要重新上传）
+			if Store.FileExists(fileHash) && storedFileUsable(ctx, fileHash, fileSize, strongHash) {
 				return &InitUploadResult{
 					ContentID: contentID,
 					Status:    "fast_upload",
 				}, nil
 			}
-			// 文件不存在，删除墓碑
-			log.Printf("File not found on storage, deleting tombstone")
+			// 文件不存在或已损坏，删除墓碑
+			log.Printf("File not found on storage or quarantined, deleting tombstone")
 			_ = redis.DeleteTombstone(ctx, userID, fileHash)
 		}
 	}
@@ -67,8 +108,8 @@ func InitUpload(ctx context.Context, userID int, fileName, fileHash string) (*In
 
 	// 2. 检查数据库是否已完成（双重保险）
 	if !exists {
-		if uc, err := db.GetUserContentByHash(ctx, userID, fileHash); err == nil && uc.Status == 1 {
-			if fm, err := db.GetFileMeta(ctx, fileHash); err == nil && fm.FilePath != "" {
+		if uc, err := db.GetUserContentByHash(ctx, userID, fileHash); err == nil && (uc.Status == 1 || uc.Status == 2) {
+			if fm, err := db.GetFileMeta(ctx, fileHash); err == nil && fm.FilePath != "" && fm.QuarantinedAt == nil && sameContent(fm, fileSize, strongHash) {
 				if Store.FileExists(fileHash) {
 					_ = redis.CreateTombstoneNoExpire(ctx, userID, fileHash, uc.ContentID, "completed")
 					return &InitUploadResult{
@@ -94,7 +135,27 @@ func InitUpload(ctx context.Context, userID int, fileName, fileHash string) (*In
 		return nil, err
 	}
 
-	// 5. 断点续传检查 - 只以文件系统为准！！！
+	// 5. 上传会话（秒传挑战失败时客户端也要用它上传分片）
+	sess, err := openUploadSession(ctx, userID, contentID, fileName, fileHash, fileSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// 6. 文件已被其他用户上传过：下发持有性证明挑战，客户端答对后才能秒传。
+	// MD5 可以构造碰撞，md5 文件还要求声明的强摘要与已有文件一致
+	if fm, err := db.GetFileMeta(ctx, fileHash); err == nil && sameContent(fm, fileSize, strongHash) && fm.QuarantinedAt == nil && Store.FileExists(fileHash) {
+		challenge, err := NewFastUploadChallenge(ctx, userID, contentID, fileHash, fileSize, strongHash)
+		if err == nil {
+			return (&InitUploadResult{
+				ContentID: contentID,
+				Status:    "fast_upload_challenge",
+				Challenge: challenge,
+			}).withSession(sess), nil
+		}
+		log.Printf("Warning: create fast upload challenge failed: %v", err)
+	}
+
+	// 7. 断点续传检查 - 只以文件系统为准！！！
 	// Redis 可能因重启丢失数据，所以必须以文件系统为准
 	uploadedChunks, err := Store.GetUploadedChunks(userID, fileHash)
 	if err != nil {
@@ -110,31 +171,60 @@ func InitUpload(ctx context.Context, userID int, fileName, fileHash string) (*In
 		sort.Ints(uploadedChunks)
 	}
 
-	return &InitUploadResult{
+	return (&InitUploadResult{
 		ContentID:      contentID,
 		Status:         resultStatus,
 		UploadedChunks: uploadedChunks,
-	}, nil
+	}).withSession(sess), nil
 }
 
 // UploadChunkParams 上传分片参数
 type UploadChunkParams struct {
 	UserID      int
-	FileHash    string
+	SessionID   string // 为空时使用该文件最近的上传会话
+	FileHash    string // 为空时取会话中的文件 hash
 	ContentID   uint
 	ChunkIndex  int
 	TotalChunks int
+	Checksum    string // 分片摘要 "算法:十六进制"，可选
+	Size        int64  // 请求声明的分片大小，未知时为 -1
 	Content     io.Reader
 }
 
 // UploadChunk 上传分片
 func UploadChunk(ctx context.Context, params UploadChunkParams) error {
-	// 1. 检查墓碑
+	checksum, err := store.ParseChunkChecksum(params.Checksum)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidChecksum, err)
+	}
+
+	// 1. 按上传会话校验分片序号、总数与大小
+	if params.FileHash != "" {
+		params.FileHash = canonicalFileHash(params.FileHash)
+	}
+	sess, err := loadUploadSession(ctx, params.UserID, params.SessionID, params.FileHash)
+	if err != nil {
+		return err
+	}
+	if (params.TotalChunks != 0 && params.TotalChunks != sess.TotalChunks) ||
+		(params.ContentID != 0 && params.ContentID != sess.ContentID) {
+		return ErrUploadSessionMismatch
+	}
+	if params.ChunkIndex < 0 || params.ChunkIndex >= sess.TotalChunks {
+		return ErrChunkOutOfRange
+	}
+	if params.Size >= 0 && params.Size != chunkLength(sess, params.ChunkIndex) {
+		// 请求声明的长度不对，不必读取请求体
+		return ErrChunkSizeMismatch
+	}
+	params.FileHash = sess.FileHash
+
+	// 2. 检查墓碑
 	if err := CheckBeforeUploadChunk(ctx, params.UserID, params.FileHash); err != nil {
 		return err
 	}
 
-	// 2. 检查分片是否已存在于文件系统（幂等性）
+	// 3. 检查分片是否已存在于文件系统（幂等性）
 	existingChunks, _ := Store.GetUploadedChunks(params.UserID, params.FileHash)
 	for _, idx := range existingChunks {
 		if idx == params.ChunkIndex {
@@ -143,14 +233,18 @@ func UploadChunk(ctx context.Context, params UploadChunkParams) error {
 		}
 	}
 
-	// 3. 写入分片
-	if err := Store.WriteChunk(params.UserID, params.FileHash, params.ChunkIndex, params.Content); err != nil {
+	// 4. 写入分片，大小必须与会话一致，摘要由存储层边写边校验
+	content := &sizedReader{r: params.Content, remaining: chunkLength(sess, params.ChunkIndex)}
+	if err := Store.WriteChunk(params.UserID, params.FileHash, params.ChunkIndex, content, checksum); err != nil {
+		if errors.Is(err, store.ErrChunkChecksum) {
+			return fmt.Errorf("%w: %v", ErrChunkChecksum, err)
+		}
 		return fmt.Errorf("write chunk failed: %w", err)
 	}
 
 	log.Printf("Chunk %d written successfully for user=%d hash=%s", params.ChunkIndex, params.UserID, params.FileHash)
 
-	// 4. 记录到 Redis（可选，仅用于加速，不作为唯一依据）
+	// 5. 记录到 Redis（可选，仅用于加速，不作为唯一依据）
 	if err := redis.RecordUploadedChunk(ctx, params.UserID, params.FileHash, params.ChunkIndex); err != nil {
 		log.Printf("Warning: record chunk to redis failed: %v", err)
 	}
@@ -161,6 +255,7 @@ func UploadChunk(ctx context.Context, params UploadChunkParams) error {
 // MergeChunksParams 合并分片参数
 type MergeChunksParams struct {
 	UserID      int
+	SessionID   string // 为空时使用该文件最近的上传会话
 	ContentID   uint
 	FileName    string
 	FileHash    string
@@ -170,12 +265,28 @@ type MergeChunksParams struct {
 
 // MergeChunksResult 合并结果
 type MergeChunksResult struct {
+	FileHash string // 文件登记的标识，md5 碰撞时为内容的强摘要
 	FilePath string
 	FileSize int64
 }
 
 // MergeChunks 合并分片
 func MergeChunks(ctx context.Context, params MergeChunksParams) (*MergeChunksResult, error) {
+	// 0. 按上传会话校验声明的分片数与大小
+	params.FileHash = canonicalFileHash(params.FileHash)
+	sess, err := loadUploadSession(ctx, params.UserID, params.SessionID, params.FileHash)
+	if err != nil {
+		return nil, err
+	}
+	if (params.TotalChunks != 0 && params.TotalChunks != sess.TotalChunks) ||
+		(params.FileSize != 0 && params.FileSize != sess.FileSize) ||
+		(params.ContentID != 0 && params.ContentID != sess.ContentID) {
+		return nil, ErrUploadSessionMismatch
+	}
+	params.TotalChunks = sess.TotalChunks
+	params.FileSize = sess.FileSize
+	params.ContentID = sess.ContentID
+
 	// 1. 获取分布式锁
 	lockKey := fmt.Sprintf("upload:merge:%d:%s", params.UserID, params.FileHash)
 	lock := redis.NewLock(lockKey, 120*time.Second)
@@ -197,34 +308,91 @@ func MergeChunks(ctx context.Context, params MergeChunksParams) (*MergeChunksRes
 		return nil, fmt.Errorf("missing chunks: %v (have %d, need %d)", missing, len(uploadedChunks), params.TotalChunks)
 	}
 
-	// 3. 合并分片
-	filePath, fileSize, err := Store.MergeChunks(params.UserID, params.FileHash, params.TotalChunks)
+	// 3. 合并分片（存储层边合并边校验 hash 与大小）。
+	// 持有文件锁直到登记 FileMeta，避免删除 worker 删除同 hash 的文件
+	blob, err := lockBlob(ctx, params.FileHash)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Unlock()
+
+	// md5 文件：同 hash 的文件已存在时还要校验 SHA-256，碰撞的内容不会替换已有文件
+	strongHash, err := mergeStrongHash(blob.Context(), params.FileHash)
+	if err != nil {
+		return nil, err
+	}
+
+	fileHash := params.FileHash
+	filePath, fileSize, err := Store.MergeChunks(params.UserID, params.FileHash, params.TotalChunks, params.FileSize, strongHash)
+	var collision *store.CollisionError
+	if errors.As(err, &collision) {
+		// md5 碰撞：内容已改存在自己的强摘要下，以该标识登记
+		log.Printf("MergeChunks: md5 collision for user=%d hash=%s, stored as %s", params.UserID, params.FileHash, collision.FileHash)
+		fileHash, filePath, fileSize = collision.FileHash, collision.FilePath, collision.FileSize
+		strongBlob, err := lockBlob(ctx, fileHash)
+		if err != nil {
+			return nil, err
+		}
+		defer strongBlob.Unlock()
+		// 加锁前删除 worker 可能刚好删除了同标识的待删除文件
+		if !Store.FileExists(fileHash) {
+			return nil, fmt.Errorf("merge chunks failed: %s was deleted concurrently, upload again", fileHash)
+		}
+		err = nil
+	}
 	if err != nil {
+		if errors.Is(err, store.ErrIntegrity) {
+			// 分片内容已不可信，清理后让客户端重新上传
+			log.Printf("MergeChunks: integrity check failed for user=%d hash=%s: %v", params.UserID, params.FileHash, err)
+			_ = Store.CleanupChunks(params.UserID, params.FileHash)
+			_ = redis.ClearUploadedChunks(ctx, params.UserID, params.FileHash)
+			return nil, fmt.Errorf("%w: %v", ErrMergeVerifyFailed, err)
+		}
+		return nil, fmt.Errorf("merge chunks failed: %w", err)
+	}
+	// 合并耗时超过锁时长且续期失败时，删除 worker 可能已删除了该文件，不能登记
+	if err := blob.Check(); err != nil {
 		return nil, fmt.Errorf("merge chunks failed: %w", err)
 	}
 
 	log.Printf("MergeChunks: merged to %s, size=%d", filePath, fileSize)
 
 	// 4. 更新数据库
-	if err := db.FinishMergeAndCreateMeta(ctx, params.UserID, params.ContentID, params.FileName, params.FileHash, filePath, fileSize); err != nil {
-		Store.DeleteFile(params.FileHash)
+	if err := db.FinishMergeAndCreateMeta(ctx, params.UserID, params.ContentID, params.FileName, fileHash, filePath, fileSize); err != nil {
+		// 同 hash 的文件可能已被其他记录引用，这里不删除；确实无人引用时由垃圾回收清理
 		return nil, fmt.Errorf("update database failed: %w", err)
 	}
+	recordStrongHash(ctx, fileHash, strongHash)
 
-	// 5. 清理 Redis 分片记录
+	// 5. 探测媒体信息（失败不影响上传结果）
+	if err := ProbeFileMeta(ctx, fileHash); err != nil {
+		log.Printf("Warning: probe media info for %s failed: %v", fileHash, err)
+	}
+
+	// 6. 清理 Redis 分片记录，结束上传会话
 	_ = redis.ClearUploadedChunks(ctx, params.UserID, params.FileHash)
+	if err := db.FinishUploadSessions(ctx, params.UserID, params.FileHash, db.UploadSessionCompleted); err != nil {
+		log.Printf("Warning: finish upload session failed: %v", err)
+	}
 
-	// 6. 创建墓碑
-	if err := redis.CreateTombstone(ctx, params.UserID, params.FileHash, params.ContentID, "completed"); err != nil {
+	// 7. 创建墓碑
+	if err := redis.CreateTombstone(ctx, params.UserID, fileHash, params.ContentID, "completed"); err != nil {
 		log.Printf("create tombstone failed: %v", err)
 	}
 
 	return &MergeChunksResult{
+		FileHash: fileHash,
 		FilePath: filePath,
 		FileSize: fileSize,
 	}, nil
 }
 
+// storedFileUsable 已存储的文件可以直接复用：未因巡检发现损坏而被隔离，且与声明的是同一内容
+func storedFileUsable(ctx context.Context, fileHash string, fileSize int64, strongHash string) bool {
+	fm, err := db.GetFileMeta(ctx, fileHash)
+	return err == nil && fm.QuarantinedAt == nil && sameContent(fm, fileSize, strongHash)
+}
+
 // findMissingChunks 找出缺失的分片
 func findMissingChunks(uploaded []int, total int) []int {
 	set := make(map[int]bool)
@@ -257,8 +425,20 @@ func CheckBeforeUploadChunk(ctx context.Context, userID int, fileHash string) er
 	return nil
 }
 
-// FastUpload 秒传
-func FastUpload(ctx context.Context, userID int, contentID uint, fileName, fileHash string) error {
+// FastUploadParams 秒传参数
+type FastUploadParams struct {
+	UserID      int
+	ContentID   uint
+	FileName    string
+	FileHash    string
+	ChallengeID string
+	Proofs      []string // 与挑战区间一一对应的 MD5
+}
+
+// FastUpload 秒传（需先通过持有性证明挑战）
+func FastUpload(ctx context.Context, params FastUploadParams) error {
+	userID, contentID, fileName, fileHash := params.UserID, params.ContentID, params.FileName, canonicalFileHash(params.FileHash)
+
 	lockKey := fmt.Sprintf("upload:fast:%d:%s", userID, fileHash)
 	lock := redis.NewLock(lockKey, 30*time.Second)
 	if err := lock.Lock(ctx); err != nil {
@@ -266,10 +446,20 @@ func FastUpload(ctx context.Context, userID int, contentID uint, fileName, fileH
 	}
 	defer lock.Unlock(ctx)
 
+	if err := verifyFastUploadProof(ctx, userID, contentID, fileHash, params.ChallengeID, params.Proofs); err != nil {
+		return err
+	}
+
 	if err := db.CreateUserFileForFastUpload(ctx, userID, contentID, fileName, fileHash); err != nil {
 		return err
 	}
 
+	// 秒传成功，放弃未完成的分片上传
+	if err := db.FinishUploadSessions(ctx, userID, fileHash, db.UploadSessionCompleted); err != nil {
+		log.Printf("Warning: finish upload session failed: %v", err)
+	}
+	_ = Store.CleanupChunks(userID, fileHash)
+
 	if err := redis.CreateTombstone(ctx, userID, fileHash, contentID, "completed"); err != nil {
 		log.Printf("create tombstone failed: %v\n", err)
 	}
@@ -289,6 +479,9 @@ func CancelUpload(ctx context.Context, userID int, fileHash string, contentID ui
 	if err := db.UpdateUserContentStatus(ctx, userID, contentID, -1); err != nil {
 		return err
 	}
+	if err := db.FinishUploadSessions(ctx, userID, fileHash, db.UploadSessionCancelled); err != nil {
+		return err
+	}
 
 	// 清理分片文件
 	_ = Store.CleanupChunks(userID, fileHash)
@@ -305,6 +498,7 @@ func DeleteFile(ctx context.Context, userID int, fileHash string) error {
 	}
 	defer lock.Unlock(ctx)
 
+	// 引用归零的文件登记为待删除，由删除 worker 在宽限期后删除存储文件
 	if err := db.DeleteUserFile(ctx, userID, fileHash); err != nil {
 		return err
 	}
@@ -316,20 +510,58 @@ func DeleteFile(ctx context.Context, userID int, fileHash string) error {
 
 // FileInfo 文件信息
 type FileInfo struct {
-	ID        uint   `json:"id"`
-	FileName  string `json:"file_name"`
-	FileHash  string `json:"file_hash"`
-	FileSize  int64  `json:"file_size"`
-	Status    int    `json:"status"`
-	CreatedAt string `json:"created_at"`
+	ID         uint   `json:"id"`
+	FileName   string `json:"file_name"`
+	FileHash   string `json:"file_hash"`
+	FileSize   int64  `json:"file_size"`
+	Format     string `json:"format"`
+	VideoCodec string `json:"video_codec"`
+	AudioCodec string `json:"audio_codec"`
+	Bitrate    int64  `json:"bitrate"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Duration   int    `json:"duration"`
+	Status     int    `json:"status"`
+	CreatedAt  string `json:"created_at"`
+
+	// 巡检发现内容损坏，暂停下载，需要重新上传
+	Quarantined bool `json:"quarantined,omitempty"`
+
+	// 所属内容的默认播放版本（仅单个文件详情返回）
+	DefaultHash string `json:"default_hash,omitempty"`
+}
+
+// newFileInfo 组装文件信息，fm 为 nil 时只包含用户记录字段
+func newFileInfo(uc *db.UserContent, fm *db.FileMeta) FileInfo {
+	info := FileInfo{
+		ID:        uc.ID,
+		FileName:  uc.FileName,
+		FileHash:  uc.FileHash,
+		Status:    uc.Status,
+		CreatedAt: uc.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+	if fm != nil {
+		info.FileSize = fm.FileSize
+		info.Format = fm.Format
+		info.VideoCodec = fm.VideoCodec
+		info.AudioCodec = fm.AudioCodec
+		info.Bitrate = fm.Bitrate
+		info.Width = fm.Width
+		info.Height = fm.Height
+		info.Duration = fm.Duration
+		info.Quarantined = fm.QuarantinedAt != nil
+	}
+	return info
 }
 
 // ContentInfo 内容信息
 type ContentInfo struct {
-	ID         uint   `json:"id"`
-	Title      string `json:"title"`
-	SourceHash string `json:"source_hash"`
-	CreatedAt  string `json:"created_at"`
+	ID          uint          `json:"id"`
+	Title       string        `json:"title"`
+	SourceHash  string        `json:"source_hash"`
+	DefaultHash string        `json:"default_hash"`
+	Versions    []VersionInfo `json:"versions,omitempty"`
+	CreatedAt   string        `json:"created_at"`
 }
 
 // ListUserFiles 列出用户的文件
@@ -340,20 +572,13 @@ func ListUserFiles(ctx context.Context, userID int) ([]FileInfo, error) {
 	}
 
 	var files []FileInfo
-	for _, uc := range userContents {
-		var fileSize int64
-		if fm, err := db.GetFileMeta(ctx, uc.FileHash); err == nil {
-			fileSize = fm.FileSize
+	for i := range userContents {
+		uc := &userContents[i]
+		fm, err := db.GetFileMeta(ctx, uc.FileHash)
+		if err != nil {
+			fm = nil
 		}
-
-		files = append(files, FileInfo{
-			ID:        uc.ID,
-			FileName:  uc.FileName,
-			FileHash:  uc.FileHash,
-			FileSize:  fileSize,
-			Status:    uc.Status,
-			CreatedAt: uc.CreatedAt.Format("2006-01-02 15:04:05"),
-		})
+		files = append(files, newFileInfo(uc, fm))
 	}
 
 	return files, nil
@@ -366,19 +591,16 @@ func GetUserFile(ctx context.Context, userID int, fileHash string) (*FileInfo, e
 		return nil, err
 	}
 
-	var fileSize int64
-	if fm, err := db.GetFileMeta(ctx, fileHash); err == nil {
-		fileSize = fm.FileSize
+	fm, err := db.GetFileMeta(ctx, fileHash)
+	if err != nil {
+		fm = nil
 	}
 
-	return &FileInfo{
-		ID:        uc.ID,
-		FileName:  uc.FileName,
-		FileHash:  uc.FileHash,
-		FileSize:  fileSize,
-		Status:    uc.Status,
-		CreatedAt: uc.CreatedAt.Format("2006-01-02 15:04:05"),
-	}, nil
+	info := newFileInfo(uc, fm)
+	if content, err := db.GetContentByID(ctx, userID, strconv.FormatUint(uint64(uc.ContentID), 10)); err == nil {
+		info.DefaultHash = defaultHash(content)
+	}
+	return &info, nil
 }
 
 // ListUserContents 列出用户的内容
@@ -391,10 +613,11 @@ func ListUserContents(ctx context.Context, userID int) ([]ContentInfo, error) {
 	var result []ContentInfo
 	for _, c := range contents {
 		result = append(result, ContentInfo{
-			ID:         c.ID,
-			Title:      c.Title,
-			SourceHash: c.SourceHash,
-			CreatedAt:  c.CreatedAt.Format("2006-01-02 15:04:05"),
+			ID:          c.ID,
+			Title:       c.Title,
+			SourceHash:  c.SourceHash,
+			DefaultHash: defaultHash(&c),
+			CreatedAt:   c.CreatedAt.Format("2006-01-02 15:04:05"),
 		})
 	}
 
@@ -408,10 +631,17 @@ func GetUserContent(ctx context.Context, userID int, contentID string) (*Content
 		return nil, err
 	}
 
+	versions, err := listVersions(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+
 	return &ContentInfo{
-		ID:         content.ID,
-		Title:      content.Title,
-		SourceHash: content.SourceHash,
-		CreatedAt:  content.CreatedAt.Format("2006-01-02 15:04:05"),
+		ID:          content.ID,
+		Title:       content.Title,
+		SourceHash:  content.SourceHash,
+		DefaultHash: defaultHash(content),
+		Versions:    versions,
+		CreatedAt:   content.CreatedAt.Format("2006-01-02 15:04:05"),
 	}, nil
 }
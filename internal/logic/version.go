@@ -0,0 +1,217 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"video-platform/internal/db"
+)
+
+var (
+	ErrVersionNotFound     = errors.New("version not found")
+	ErrVersionExists       = errors.New("file is already a version of this content")
+	ErrCannotRemoveSource  = errors.New("source file cannot be removed as a version")
+	ErrNoDefaultHistory    = errors.New("no earlier default version to roll back to")
+	ErrVersionFileNotOwned = errors.New("file not found or not uploaded by user")
+)
+
+// VersionInfo 内容下的一个版本
+type VersionInfo struct {
+	FileHash   string `json:"file_hash"`
+	Kind       string `json:"kind"` // source / transcode / upload
+	Label      string `json:"label,omitempty"`
+	IsDefault  bool   `json:"is_default"`
+	FileSize   int64  `json:"file_size"`
+	Format     string `json:"format"`
+	VideoCodec string `json:"video_codec"`
+	AudioCodec string `json:"audio_codec"`
+	Bitrate    int64  `json:"bitrate"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Duration   int    `json:"duration"`
+	CreatedAt  string `json:"created_at"`
+}
+
+func newVersionInfo(fm *db.FileMeta, kind, label string) VersionInfo {
+	return VersionInfo{
+		FileHash:   fm.FileHash,
+		Kind:       kind,
+		Label:      label,
+		FileSize:   fm.FileSize,
+		Format:     fm.Format,
+		VideoCodec: fm.VideoCodec,
+		AudioCodec: fm.AudioCodec,
+		Bitrate:    fm.Bitrate,
+		Width:      fm.Width,
+		Height:     fm.Height,
+		Duration:   fm.Duration,
+		CreatedAt:  fm.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+// defaultHash 内容实际的默认播放版本
+func defaultHash(content *db.Content) string {
+	if content.DefaultHash != "" {
+		return content.DefaultHash
+	}
+	return content.SourceHash
+}
+
+// listVersions 源文件在前，其余版本按登记时间排序
+func listVersions(ctx context.Context, content *db.Content) ([]VersionInfo, error) {
+	versions, err := db.ListContentVersions(ctx, content.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	def := defaultHash(content)
+	result := make([]VersionInfo, 0, len(versions)+1)
+	if fm, err := db.GetFileMeta(ctx, content.SourceHash); err == nil {
+		v := newVersionInfo(fm, "source", "")
+		v.IsDefault = fm.FileHash == def
+		result = append(result, v)
+	}
+	for _, ver := range versions {
+		fm, err := db.GetFileMeta(ctx, ver.FileHash)
+		if err != nil {
+			continue
+		}
+		v := newVersionInfo(fm, ver.Kind, ver.Label)
+		v.IsDefault = ver.FileHash == def
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+// ListContentVersions 列出内容下的所有版本
+func ListContentVersions(ctx context.Context, userID int, contentID string) ([]VersionInfo, error) {
+	content, err := db.GetContentByID(ctx, userID, contentID)
+	if err != nil {
+		return nil, fmt.Errorf("content not found or access denied")
+	}
+	return listVersions(ctx, content)
+}
+
+// AddContentVersionParams 添加版本参数
+type AddContentVersionParams struct {
+	UserID      int
+	ContentID   string
+	FileHash    string
+	Label       string
+	MakeDefault bool
+}
+
+// AddContentVersion 把用户已上传完成的文件添加为内容的新版本
+func AddContentVersion(ctx context.Context, params AddContentVersionParams) ([]VersionInfo, error) {
+	content, err := db.GetContentByID(ctx, params.UserID, params.ContentID)
+	if err != nil {
+		return nil, fmt.Errorf("content not found or access denied")
+	}
+
+	uc, err := db.GetUserContentByHash(ctx, params.UserID, params.FileHash)
+	if err != nil || (uc.Status != 1 && uc.Status != 2) {
+		return nil, ErrVersionFileNotOwned
+	}
+
+	label := params.Label
+	if label == "" {
+		label = uc.FileName
+	}
+	if err := db.AddContentVersion(ctx, content.ID, params.FileHash, "upload", label); err != nil {
+		if errors.Is(err, db.ErrVersionExists) {
+			return nil, ErrVersionExists
+		}
+		return nil, err
+	}
+
+	if params.MakeDefault {
+		if err := db.SetContentDefault(ctx, content.ID, params.FileHash); err != nil {
+			return nil, err
+		}
+	}
+
+	return ListContentVersions(ctx, params.UserID, params.ContentID)
+}
+
+// RemoveContentVersion 移除内容的一个版本（源文件除外），引用归零的文件登记为待删除
+func RemoveContentVersion(ctx context.Context, userID int, contentID, fileHash string) error {
+	content, err := db.GetContentByID(ctx, userID, contentID)
+	if err != nil {
+		return fmt.Errorf("content not found or access denied")
+	}
+	if fileHash == content.SourceHash {
+		return ErrCannotRemoveSource
+	}
+
+	if err := db.RemoveContentVersion(ctx, content.ID, fileHash); err != nil {
+		if errors.Is(err, db.ErrVersionNotFound) {
+			return ErrVersionNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// SetContentDefault 设置默认播放版本
+func SetContentDefault(ctx context.Context, userID int, contentID, fileHash string) error {
+	content, err := db.GetContentByID(ctx, userID, contentID)
+	if err != nil {
+		return fmt.Errorf("content not found or access denied")
+	}
+	if err := db.SetContentDefault(ctx, content.ID, fileHash); err != nil {
+		if errors.Is(err, db.ErrVersionNotFound) {
+			return ErrVersionNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// RollbackContentDefault 回滚到上一个默认播放版本，返回回滚后的默认版本
+func RollbackContentDefault(ctx context.Context, userID int, contentID string) (string, error) {
+	content, err := db.GetContentByID(ctx, userID, contentID)
+	if err != nil {
+		return "", fmt.Errorf("content not found or access denied")
+	}
+	hash, err := db.RollbackContentDefault(ctx, content.ID)
+	if err != nil {
+		if errors.Is(err, db.ErrNoDefaultHistory) {
+			return "", ErrNoDefaultHistory
+		}
+		return "", err
+	}
+	if hash == "" {
+		hash = content.SourceHash
+	}
+	return hash, nil
+}
+
+// resolveFileAccess 检查用户能否访问文件，返回展示用的文件名。
+// 用户自己上传的文件，或用户内容下的其他版本（如转码产物）都可以访问。
+func resolveFileAccess(ctx context.Context, userID int, fileHash string) (string, error) {
+	if uc, err := db.GetUserContentByHash(ctx, userID, fileHash); err == nil {
+		return uc.FileName, nil
+	}
+
+	ver, content, err := db.GetAccessibleVersion(ctx, userID, fileHash)
+	if err != nil {
+		return "", fmt.Errorf("file not found or access denied")
+	}
+
+	// 以内容标题加版本标签命名，如 movie_720p.mp4
+	base := strings.TrimSuffix(content.Title, path.Ext(content.Title))
+	ext := ".mp4"
+	if fm, err := db.GetFileMeta(ctx, fileHash); err == nil && fm.Format != "" {
+		ext = "." + fm.Format
+	}
+	if ver.Label != "" && ver.Kind == "transcode" {
+		return fmt.Sprintf("%s_%s%s", base, ver.Label, ext), nil
+	}
+	if ver.Label != "" {
+		return ver.Label, nil
+	}
+	return base + ext, nil
+}
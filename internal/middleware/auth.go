@@ -43,4 +43,48 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Set("username", claims["username"])
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// SignedURLOrAuth 接受播放签名（查询参数 sig）或 JWT。
+// 签名只对路由参数 hashParam 指定的文件有效，供 <video src> 这类无法携带请求头的场景使用。
+func SignedURLOrAuth(hashParam string) gin.HandlerFunc {
+	jwtAuth := AuthMiddleware()
+	return func(c *gin.Context) {
+		q := c.Request.URL.Query()
+		if q.Get(utils.PlaybackSigParam) == "" {
+			jwtAuth(c)
+			return
+		}
+
+		userID, err := utils.VerifyPlayback(q, c.Param(hashParam))
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "播放链接无效或已过期"})
+			c.Abort()
+			return
+		}
+
+		c.Set("user_id", userID)
+		c.Set("signed_url", true)
+		c.Next()
+	}
+}
+
+// AdminMiddleware 只允许 admins 中的用户名访问，需放在 AuthMiddleware 之后
+func AdminMiddleware(admins []string) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(admins))
+	for _, name := range admins {
+		if name = strings.TrimSpace(name); name != "" {
+			allowed[name] = true
+		}
+	}
+	return func(c *gin.Context) {
+		username, _ := c.Get("username")
+		name, _ := username.(string)
+		if !allowed[name] {
+			c.JSON(http.StatusForbidden, gin.H{"error": "需要管理员权限"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,279 @@
+// Package mp4 是一个只读的 ISO BMFF（MP4/MOV）解析器，以及生成 fMP4 所需的最小 box 写入工具。
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrNotMP4  = errors.New("not an mp4/mov file")
+	ErrNoMoov  = errors.New("moov box not found")
+	ErrBadBox  = errors.New("malformed box")
+	maxMoovLen = int64(256 << 20) // moov 超过 256MB 视为异常
+)
+
+// BoxHeader 顶层 box 头
+type BoxHeader struct {
+	Type       string
+	Offset     int64
+	Size       int64 // 包含头部的总长度
+	HeaderSize int64
+}
+
+// ReadBoxHeader 读取 off 处的 box 头
+func ReadBoxHeader(r io.ReaderAt, off, fileSize int64) (BoxHeader, error) {
+	var buf [16]byte
+	n, err := r.ReadAt(buf[:8], off)
+	if n < 8 {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return BoxHeader{}, err
+	}
+
+	h := BoxHeader{
+		Type:       string(buf[4:8]),
+		Offset:     off,
+		Size:       int64(binary.BigEndian.Uint32(buf[0:4])),
+		HeaderSize: 8,
+	}
+	switch h.Size {
+	case 0:
+		h.Size = fileSize - off
+	case 1:
+		if _, err := r.ReadAt(buf[8:16], off+8); err != nil {
+			return BoxHeader{}, err
+		}
+		h.Size = int64(binary.BigEndian.Uint64(buf[8:16]))
+		h.HeaderSize = 16
+	}
+	if h.Size < h.HeaderSize || off+h.Size > fileSize {
+		return BoxHeader{}, fmt.Errorf("%w: %q at %d", ErrBadBox, h.Type, off)
+	}
+	return h, nil
+}
+
+// TopLevelBoxes 遍历文件的顶层 box（只读取头部）
+func TopLevelBoxes(r io.ReaderAt, fileSize int64) ([]BoxHeader, error) {
+	var boxes []BoxHeader
+	for off := int64(0); off < fileSize; {
+		h, err := ReadBoxHeader(r, off, fileSize)
+		if err != nil {
+			if len(boxes) == 0 {
+				return nil, ErrNotMP4
+			}
+			// 尾部残缺时保留已解析部分
+			return boxes, nil
+		}
+		boxes = append(boxes, h)
+		off += h.Size
+	}
+	return boxes, nil
+}
+
+// ReadBox 读取整个 box（含头部）
+func ReadBox(r io.ReaderAt, h BoxHeader) ([]byte, error) {
+	if h.Size > maxMoovLen {
+		return nil, fmt.Errorf("%w: %q too large (%d bytes)", ErrBadBox, h.Type, h.Size)
+	}
+	buf := make([]byte, h.Size)
+	if _, err := r.ReadAt(buf, h.Offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// box 内存中的 box
+type box struct {
+	typ     string
+	raw     []byte // 含头部
+	payload []byte
+}
+
+// parseBoxes 解析一段连续的 box
+func parseBoxes(data []byte) ([]box, error) {
+	var boxes []box
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return boxes, ErrBadBox
+		}
+		size := int64(binary.BigEndian.Uint32(data[0:4]))
+		typ := string(data[4:8])
+		hdr := int64(8)
+		switch size {
+		case 0:
+			size = int64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return boxes, ErrBadBox
+			}
+			size = int64(binary.BigEndian.Uint64(data[8:16]))
+			hdr = 16
+		}
+		if size < hdr || size > int64(len(data)) {
+			return boxes, fmt.Errorf("%w: %q", ErrBadBox, typ)
+		}
+		boxes = append(boxes, box{typ: typ, raw: data[:size], payload: data[hdr:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+// child 查找第一个指定类型的子 box
+func child(payload []byte, typ string) (box, bool) {
+	boxes, _ := parseBoxes(payload)
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+// path 按路径逐层查找
+func path(payload []byte, types ...string) (box, bool) {
+	var b box
+	cur := payload
+	for _, t := range types {
+		var ok bool
+		b, ok = child(cur, t)
+		if !ok {
+			return box{}, false
+		}
+		cur = b.payload
+	}
+	return b, true
+}
+
+// reader 带边界检查的大端读取器
+type reader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *reader) need(n int) bool {
+	if r.err != nil {
+		return false
+	}
+	if r.pos+n > len(r.data) {
+		r.err = ErrBadBox
+		return false
+	}
+	return true
+}
+
+func (r *reader) u8() uint8 {
+	if !r.need(1) {
+		return 0
+	}
+	v := r.data[r.pos]
+	r.pos++
+	return v
+}
+
+func (r *reader) u16() uint16 {
+	if !r.need(2) {
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.data[r.pos:])
+	r.pos += 2
+	return v
+}
+
+func (r *reader) u32() uint32 {
+	if !r.need(4) {
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.data[r.pos:])
+	r.pos += 4
+	return v
+}
+
+func (r *reader) u64() uint64 {
+	if !r.need(8) {
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.data[r.pos:])
+	r.pos += 8
+	return v
+}
+
+func (r *reader) skip(n int) {
+	if r.need(n) {
+		r.pos += n
+	}
+}
+
+// fullBox 读取 FullBox 的 version 与 flags
+func (r *reader) fullBox() (version uint8, flags uint32) {
+	v := r.u32()
+	return uint8(v >> 24), v & 0xffffff
+}
+
+// Writer 生成 box 的简易写入器，StartBox/EndBox 成对使用
+type Writer struct {
+	buf   bytes.Buffer
+	stack []int
+}
+
+// StartBox 开始一个 box，长度在 EndBox 时回填
+func (w *Writer) StartBox(typ string) {
+	w.stack = append(w.stack, w.buf.Len())
+	w.U32(0)
+	w.buf.WriteString(typ)
+}
+
+// StartFullBox 开始一个 FullBox
+func (w *Writer) StartFullBox(typ string, version uint8, flags uint32) {
+	w.StartBox(typ)
+	w.U32(uint32(version)<<24 | flags&0xffffff)
+}
+
+// EndBox 结束最近一个 box
+func (w *Writer) EndBox() {
+	start := w.stack[len(w.stack)-1]
+	w.stack = w.stack[:len(w.stack)-1]
+	binary.BigEndian.PutUint32(w.buf.Bytes()[start:], uint32(w.buf.Len()-start))
+}
+
+func (w *Writer) U8(v uint8) { w.buf.WriteByte(v) }
+
+func (w *Writer) U16(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *Writer) U32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *Writer) U64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *Writer) Write(p []byte) { w.buf.Write(p) }
+
+func (w *Writer) String(s string) { w.buf.WriteString(s) }
+
+func (w *Writer) Zeros(n int) { w.buf.Write(make([]byte, n)) }
+
+// Len 当前已写入长度
+func (w *Writer) Len() int { return w.buf.Len() }
+
+// Bytes 返回写入结果
+func (w *Writer) Bytes() []byte { return w.buf.Bytes() }
+
+// PatchU32 回填指定位置的 uint32
+func (w *Writer) PatchU32(pos int, v uint32) {
+	binary.BigEndian.PutUint32(w.buf.Bytes()[pos:], v)
+}
@@ -0,0 +1,469 @@
+package mp4
+
+import (
+	"fmt"
+	"io"
+)
+
+// maxSamples 单轨样本数上限，防止异常文件导致超大内存分配
+const maxSamples = 20_000_000
+
+// Movie moov 中解析出的影片信息
+type Movie struct {
+	MajorBrand       string
+	CompatibleBrands []string
+	Timescale        uint32
+	Duration         uint64 // mvhd 时间单位
+	Tracks           []*Track
+	Fragmented       bool // 存在 mvex（fMP4），样本表不完整
+}
+
+// Track 单个轨道
+type Track struct {
+	ID          uint32
+	Handler     string // vide / soun / ...
+	Timescale   uint32
+	Duration    uint64 // mdhd 时间单位
+	Language    uint16 // mdhd 打包后的 ISO-639-2 语言码
+	Width       uint32 // tkhd 16.16 定点
+	Height      uint32 // tkhd 16.16 定点
+	SampleEntry string // stsd 第一个条目的类型，如 avc1 / mp4a
+	Stsd        []byte // 原始 stsd box（含头部），生成 fMP4 初始化段时原样复制
+	MediaTime   int64  // elst 第一个有效编辑的 media_time，-1 表示无
+	Samples     []Sample
+}
+
+// Sample 样本（帧）
+type Sample struct {
+	Offset   int64
+	Size     uint32
+	DTS      uint64
+	Duration uint32
+	CTO      int32 // composition time offset
+	Sync     bool
+}
+
+// IsVideo 是否视频轨
+func (t *Track) IsVideo() bool { return t.Handler == "vide" }
+
+// IsAudio 是否音频轨
+func (t *Track) IsAudio() bool { return t.Handler == "soun" }
+
+// DurationSeconds 轨道时长（秒）
+func (t *Track) DurationSeconds() float64 {
+	if t.Timescale == 0 {
+		return 0
+	}
+	return float64(t.Duration) / float64(t.Timescale)
+}
+
+// DurationSeconds 影片时长（秒）
+func (m *Movie) DurationSeconds() float64 {
+	if m.Timescale == 0 {
+		return 0
+	}
+	return float64(m.Duration) / float64(m.Timescale)
+}
+
+// VideoTrack 返回第一个视频轨
+func (m *Movie) VideoTrack() *Track {
+	for _, t := range m.Tracks {
+		if t.IsVideo() {
+			return t
+		}
+	}
+	return nil
+}
+
+// AudioTrack 返回第一个音频轨
+func (m *Movie) AudioTrack() *Track {
+	for _, t := range m.Tracks {
+		if t.IsAudio() {
+			return t
+		}
+	}
+	return nil
+}
+
+// Parse 从文件中读取 ftyp 与 moov 并解析（包含完整样本表）
+func Parse(r io.ReaderAt, fileSize int64) (*Movie, error) {
+	return parse(r, fileSize, true)
+}
+
+// ParseHeaders 只解析影片与轨道头信息，不展开样本表（用于探测元数据）
+func ParseHeaders(r io.ReaderAt, fileSize int64) (*Movie, error) {
+	return parse(r, fileSize, false)
+}
+
+func parse(r io.ReaderAt, fileSize int64, withSamples bool) (*Movie, error) {
+	boxes, err := TopLevelBoxes(r, fileSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var ftyp, moov []byte
+	for _, h := range boxes {
+		switch h.Type {
+		case "ftyp":
+			if ftyp, err = ReadBox(r, h); err != nil {
+				return nil, err
+			}
+		case "moov":
+			if moov, err = ReadBox(r, h); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if moov == nil {
+		if ftyp == nil {
+			return nil, ErrNotMP4
+		}
+		return nil, ErrNoMoov
+	}
+
+	m, err := parseMoov(moov, withSamples)
+	if err != nil {
+		return nil, err
+	}
+	if ftyp != nil {
+		m.parseFtyp(ftyp)
+	}
+	return m, nil
+}
+
+func (m *Movie) parseFtyp(raw []byte) {
+	boxes, err := parseBoxes(raw)
+	if err != nil || len(boxes) == 0 || len(boxes[0].payload) < 8 {
+		return
+	}
+	p := boxes[0].payload
+	m.MajorBrand = string(p[0:4])
+	for i := 8; i+4 <= len(p); i += 4 {
+		m.CompatibleBrands = append(m.CompatibleBrands, string(p[i:i+4]))
+	}
+}
+
+// ParseMoov 解析 moov box（含头部）
+func ParseMoov(raw []byte) (*Movie, error) {
+	return parseMoov(raw, true)
+}
+
+func parseMoov(raw []byte, withSamples bool) (*Movie, error) {
+	boxes, err := parseBoxes(raw)
+	if err != nil || len(boxes) == 0 || boxes[0].typ != "moov" {
+		return nil, ErrNoMoov
+	}
+	moov := boxes[0].payload
+
+	m := &Movie{}
+	if mvhd, ok := child(moov, "mvhd"); ok {
+		r := &reader{data: mvhd.payload}
+		version, _ := r.fullBox()
+		if version == 1 {
+			r.skip(16)
+			m.Timescale = r.u32()
+			m.Duration = r.u64()
+		} else {
+			r.skip(8)
+			m.Timescale = r.u32()
+			m.Duration = uint64(r.u32())
+		}
+	}
+	if _, ok := child(moov, "mvex"); ok {
+		m.Fragmented = true
+	}
+
+	children, _ := parseBoxes(moov)
+	for _, b := range children {
+		if b.typ != "trak" {
+			continue
+		}
+		t, err := parseTrak(b.payload, withSamples)
+		if err != nil {
+			return nil, err
+		}
+		m.Tracks = append(m.Tracks, t)
+	}
+	return m, nil
+}
+
+func parseTrak(trak []byte, withSamples bool) (*Track, error) {
+	t := &Track{MediaTime: -1}
+
+	if tkhd, ok := child(trak, "tkhd"); ok {
+		r := &reader{data: tkhd.payload}
+		version, _ := r.fullBox()
+		if version == 1 {
+			r.skip(16)
+			t.ID = r.u32()
+			r.skip(4 + 8)
+		} else {
+			r.skip(8)
+			t.ID = r.u32()
+			r.skip(4 + 4)
+		}
+		// reserved(8) layer(2) alternate_group(2) volume(2) reserved(2) matrix(36)
+		r.skip(8 + 2 + 2 + 2 + 2 + 36)
+		t.Width = r.u32()
+		t.Height = r.u32()
+	}
+
+	if elst, ok := path(trak, "edts", "elst"); ok {
+		r := &reader{data: elst.payload}
+		version, _ := r.fullBox()
+		count := r.u32()
+		for i := uint32(0); i < count && r.err == nil; i++ {
+			var mediaTime int64
+			if version == 1 {
+				r.skip(8)
+				mediaTime = int64(r.u64())
+			} else {
+				r.skip(4)
+				mediaTime = int64(int32(r.u32()))
+			}
+			r.skip(4)
+			if mediaTime >= 0 { // -1 为空编辑
+				t.MediaTime = mediaTime
+				break
+			}
+		}
+	}
+
+	mdia, ok := child(trak, "mdia")
+	if !ok {
+		return nil, fmt.Errorf("%w: trak without mdia", ErrBadBox)
+	}
+	if mdhd, ok := child(mdia.payload, "mdhd"); ok {
+		r := &reader{data: mdhd.payload}
+		version, _ := r.fullBox()
+		if version == 1 {
+			r.skip(16)
+			t.Timescale = r.u32()
+			t.Duration = r.u64()
+		} else {
+			r.skip(8)
+			t.Timescale = r.u32()
+			t.Duration = uint64(r.u32())
+		}
+		t.Language = r.u16()
+	}
+	if hdlr, ok := child(mdia.payload, "hdlr"); ok && len(hdlr.payload) >= 12 {
+		t.Handler = string(hdlr.payload[8:12])
+	}
+
+	stbl, ok := path(mdia.payload, "minf", "stbl")
+	if !ok {
+		return t, nil
+	}
+	if stsd, ok := child(stbl.payload, "stsd"); ok {
+		t.Stsd = stsd.raw
+		if len(stsd.payload) >= 16 {
+			t.SampleEntry = string(stsd.payload[12:16])
+		}
+	}
+
+	if !withSamples {
+		return t, nil
+	}
+
+	samples, err := buildSamples(stbl.payload)
+	if err != nil {
+		return nil, fmt.Errorf("track %d: %w", t.ID, err)
+	}
+	t.Samples = samples
+	return t, nil
+}
+
+// buildSamples 根据 stts/ctts/stss/stsz/stsc/stco 展开样本表
+func buildSamples(stbl []byte) ([]Sample, error) {
+	// 样本大小
+	var sizes []uint32
+	if stsz, ok := child(stbl, "stsz"); ok {
+		r := &reader{data: stsz.payload}
+		r.fullBox()
+		uniform := r.u32()
+		count := r.u32()
+		if (uniform == 0 && int64(count)*4 > int64(len(stsz.payload))) || count > maxSamples {
+			return nil, ErrBadBox
+		}
+		sizes = make([]uint32, count)
+		for i := range sizes {
+			if uniform != 0 {
+				sizes[i] = uniform
+			} else {
+				sizes[i] = r.u32()
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	} else if stz2, ok := child(stbl, "stz2"); ok {
+		r := &reader{data: stz2.payload}
+		r.fullBox()
+		r.skip(3)
+		fieldSize := r.u8()
+		count := r.u32()
+		if int64(count) > int64(len(stz2.payload))*2 {
+			return nil, ErrBadBox
+		}
+		sizes = make([]uint32, count)
+		for i := 0; i < int(count); i++ {
+			switch fieldSize {
+			case 4:
+				if !r.need(1) {
+					return nil, ErrBadBox
+				}
+				b := r.data[r.pos]
+				if i%2 == 0 {
+					sizes[i] = uint32(b >> 4)
+				} else {
+					sizes[i] = uint32(b & 0x0f)
+					r.pos++
+				}
+			case 8:
+				sizes[i] = uint32(r.u8())
+			case 16:
+				sizes[i] = uint32(r.u16())
+			default:
+				return nil, ErrBadBox
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	if len(sizes) == 0 {
+		return nil, nil
+	}
+
+	samples := make([]Sample, len(sizes))
+	for i := range samples {
+		samples[i].Size = sizes[i]
+		samples[i].Sync = true
+	}
+
+	// 解码时间
+	if stts, ok := child(stbl, "stts"); ok {
+		r := &reader{data: stts.payload}
+		r.fullBox()
+		entries := r.u32()
+		idx := 0
+		var dts uint64
+		for e := uint32(0); e < entries && r.err == nil; e++ {
+			count := r.u32()
+			delta := r.u32()
+			for j := uint32(0); j < count && idx < len(samples); j++ {
+				samples[idx].DTS = dts
+				samples[idx].Duration = delta
+				dts += uint64(delta)
+				idx++
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+
+	// 显示时间偏移
+	if ctts, ok := child(stbl, "ctts"); ok {
+		r := &reader{data: ctts.payload}
+		r.fullBox()
+		entries := r.u32()
+		idx := 0
+		for e := uint32(0); e < entries && r.err == nil; e++ {
+			count := r.u32()
+			offset := int32(r.u32()) // version 0 在实践中也常出现负值
+			for j := uint32(0); j < count && idx < len(samples); j++ {
+				samples[idx].CTO = offset
+				idx++
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+
+	// 关键帧：没有 stss 时所有样本都是同步样本
+	if stss, ok := child(stbl, "stss"); ok {
+		for i := range samples {
+			samples[i].Sync = false
+		}
+		r := &reader{data: stss.payload}
+		r.fullBox()
+		entries := r.u32()
+		for e := uint32(0); e < entries && r.err == nil; e++ {
+			n := r.u32()
+			if n >= 1 && int(n) <= len(samples) {
+				samples[n-1].Sync = true
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+
+	// chunk 偏移
+	var chunkOffsets []int64
+	if stco, ok := child(stbl, "stco"); ok {
+		r := &reader{data: stco.payload}
+		r.fullBox()
+		n := r.u32()
+		if int(n)*4 > len(stco.payload) {
+			return nil, ErrBadBox
+		}
+		chunkOffsets = make([]int64, n)
+		for i := range chunkOffsets {
+			chunkOffsets[i] = int64(r.u32())
+		}
+	} else if co64, ok := child(stbl, "co64"); ok {
+		r := &reader{data: co64.payload}
+		r.fullBox()
+		n := r.u32()
+		if int(n)*8 > len(co64.payload) {
+			return nil, ErrBadBox
+		}
+		chunkOffsets = make([]int64, n)
+		for i := range chunkOffsets {
+			chunkOffsets[i] = int64(r.u64())
+		}
+	}
+
+	// sample-to-chunk
+	type stscEntry struct{ firstChunk, perChunk uint32 }
+	var stscEntries []stscEntry
+	if stsc, ok := child(stbl, "stsc"); ok {
+		r := &reader{data: stsc.payload}
+		r.fullBox()
+		n := r.u32()
+		for i := uint32(0); i < n && r.err == nil; i++ {
+			first := r.u32()
+			per := r.u32()
+			r.skip(4)
+			stscEntries = append(stscEntries, stscEntry{first, per})
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+
+	idx := 0
+	for e, entry := range stscEntries {
+		lastChunk := uint32(len(chunkOffsets))
+		if e+1 < len(stscEntries) {
+			lastChunk = stscEntries[e+1].firstChunk - 1
+		}
+		for c := entry.firstChunk; c <= lastChunk && c >= 1 && int(c) <= len(chunkOffsets); c++ {
+			off := chunkOffsets[c-1]
+			for j := uint32(0); j < entry.perChunk && idx < len(samples); j++ {
+				samples[idx].Offset = off
+				off += int64(samples[idx].Size)
+				idx++
+			}
+		}
+	}
+	if idx < len(samples) {
+		return nil, fmt.Errorf("%w: sample table covers %d of %d samples", ErrBadBox, idx, len(samples))
+	}
+
+	return samples, nil
+}
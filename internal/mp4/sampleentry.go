@@ -0,0 +1,158 @@
+package mp4
+
+import "encoding/binary"
+
+// SampleEntry stsd 中的样本描述条目
+type SampleEntry struct {
+	Type     string
+	payload  []byte
+	children []box
+
+	// 视频条目
+	Width, Height uint16
+	// 音频条目
+	ChannelCount uint16
+	SampleRate   uint32
+}
+
+var visualEntries = map[string]bool{
+	"avc1": true, "avc3": true, "hvc1": true, "hev1": true, "av01": true,
+	"vp08": true, "vp09": true, "mp4v": true, "dvh1": true, "dvhe": true,
+	"jpeg": true, "apcn": true, "apch": true, "apcs": true, "apco": true, "ap4h": true,
+}
+
+var audioEntries = map[string]bool{
+	"mp4a": true, "Opus": true, "fLaC": true, "ac-3": true, "ec-3": true,
+	"alac": true, "lpcm": true, "sowt": true, "twos": true, ".mp3": true,
+}
+
+// FirstSampleEntry 解析 stsd（含头部）中的第一个样本描述
+func FirstSampleEntry(stsd []byte) (*SampleEntry, bool) {
+	boxes, err := parseBoxes(stsd)
+	if err != nil || len(boxes) == 0 || boxes[0].typ != "stsd" || len(boxes[0].payload) < 8 {
+		return nil, false
+	}
+	entries, _ := parseBoxes(boxes[0].payload[8:])
+	if len(entries) == 0 {
+		return nil, false
+	}
+	b := entries[0]
+	e := &SampleEntry{Type: b.typ, payload: b.payload}
+
+	switch {
+	case visualEntries[b.typ]:
+		// reserved(6) data_reference_index(2) pre_defined/reserved(16) width height ...
+		if len(b.payload) >= 78 {
+			e.Width = binary.BigEndian.Uint16(b.payload[24:26])
+			e.Height = binary.BigEndian.Uint16(b.payload[26:28])
+			e.children, _ = parseBoxes(b.payload[78:])
+		}
+	case audioEntries[b.typ]:
+		if len(b.payload) >= 28 {
+			version := binary.BigEndian.Uint16(b.payload[8:10])
+			e.ChannelCount = binary.BigEndian.Uint16(b.payload[16:18])
+			e.SampleRate = binary.BigEndian.Uint32(b.payload[24:28]) >> 16
+			start := 28
+			switch version { // QuickTime 声音描述扩展
+			case 1:
+				start += 16
+			case 2:
+				start += 36
+			}
+			if len(b.payload) >= start {
+				e.children, _ = parseBoxes(b.payload[start:])
+			}
+		}
+	}
+	return e, true
+}
+
+// Child 返回条目内子 box 的 payload（如 avcC / hvcC / esds）
+func (e *SampleEntry) Child(typ string) ([]byte, bool) {
+	for _, c := range e.children {
+		if c.typ == typ {
+			return c.payload, true
+		}
+	}
+	// QuickTime 的 mp4a 常把 esds 放在 wave 中
+	for _, c := range e.children {
+		if c.typ == "wave" {
+			if b, ok := child(c.payload, typ); ok {
+				return b.payload, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// AudioObjectType 从 esds 中读取 objectTypeIndication 与 AAC audioObjectType
+func (e *SampleEntry) AudioObjectType() (oti uint8, aot uint8, ok bool) {
+	esds, found := e.Child("esds")
+	if !found || len(esds) < 4 {
+		return 0, 0, false
+	}
+	data := esds[4:] // FullBox 头
+	for len(data) > 0 {
+		tag := data[0]
+		size, n := descriptorSize(data[1:])
+		if n == 0 {
+			return 0, 0, false
+		}
+		body := data[1+n:]
+		if size < len(body) {
+			body = body[:size]
+		}
+		switch tag {
+		case 0x03: // ES_Descriptor
+			if len(body) < 3 {
+				return 0, 0, false
+			}
+			flags := body[2]
+			skip := 3
+			if flags&0x80 != 0 {
+				skip += 2
+			}
+			if flags&0x40 != 0 && len(body) > skip {
+				skip += 1 + int(body[skip])
+			}
+			if flags&0x20 != 0 {
+				skip += 2
+			}
+			if skip > len(body) {
+				return 0, 0, false
+			}
+			data = body[skip:]
+		case 0x04: // DecoderConfigDescriptor
+			if len(body) < 13 {
+				return 0, 0, false
+			}
+			oti = body[0]
+			ok = true
+			data = body[13:]
+		case 0x05: // DecoderSpecificInfo
+			if len(body) > 0 {
+				aot = body[0] >> 3
+				if aot == 31 && len(body) > 1 {
+					aot = 32 + (body[0]&0x07)<<3 | body[1]>>5
+				}
+			}
+			return oti, aot, ok
+		default:
+			return oti, aot, ok
+		}
+	}
+	return oti, aot, ok
+}
+
+// descriptorSize 解析 MPEG-4 描述符的可变长度
+func descriptorSize(b []byte) (size int, n int) {
+	for n < 4 && n < len(b) {
+		c := b[n]
+		size = size<<7 | int(c&0x7f)
+		n++
+		if c&0x80 == 0 {
+			return size, n
+		}
+	}
+	return 0, 0
+}
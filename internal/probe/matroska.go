@@ -0,0 +1,406 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// EBML / Matroska 元素 ID（保留长度标记位）
+const (
+	idEBML          = 0x1A45DFA3
+	idDocType       = 0x4282
+	idSegment       = 0x18538067
+	idSeekHead      = 0x114D9B74
+	idSeek          = 0x4DBB
+	idSeekID        = 0x53AB
+	idSeekPosition  = 0x53AC
+	idInfo          = 0x1549A966
+	idTimecodeScale = 0x2AD7B1
+	idDuration      = 0x4489
+	idTracks        = 0x1654AE6B
+	idTrackEntry    = 0xAE
+	idTrackType     = 0x83
+	idCodecID       = 0x86
+	idVideo         = 0xE0
+	idPixelWidth    = 0xB0
+	idPixelHeight   = 0xBA
+	idCluster       = 0x1F43B675
+)
+
+const (
+	trackTypeVideo = 1
+	trackTypeAudio = 2
+
+	// maxEBMLElement 读入内存解析的元素（EBML 头、Info、Tracks、SeekHead）上限
+	maxEBMLElement = 16 << 20
+	// maxTopLevelScan 顺序扫描 Segment 子元素的个数上限
+	maxTopLevelScan = 256
+)
+
+// unknownSize EBML 中长度全为 1 表示未知长度（直播流常见）
+const unknownSize = -1
+
+var (
+	ErrNotMatroska = errors.New("not a matroska/webm file")
+	ErrBadEBML     = errors.New("malformed ebml element")
+)
+
+// matroskaCodecs CodecID 到通用编码名的映射
+var matroskaCodecs = map[string]string{
+	"V_MPEG4/ISO/AVC":  "h264",
+	"V_MPEGH/ISO/HEVC": "hevc",
+	"V_AV1":            "av1",
+	"V_VP8":            "vp8",
+	"V_VP9":            "vp9",
+	"V_THEORA":         "theora",
+	"V_MPEG4/ISO/SP":   "mpeg4",
+	"V_MPEG4/ISO/ASP":  "mpeg4",
+	"V_MPEG4/ISO/AP":   "mpeg4",
+	"V_MPEG2":          "mpeg2video",
+	"V_MJPEG":          "mjpeg",
+	"V_PRORES":         "prores",
+	"A_AAC":            "aac",
+	"A_OPUS":           "opus",
+	"A_VORBIS":         "vorbis",
+	"A_FLAC":           "flac",
+	"A_MPEG/L3":        "mp3",
+	"A_MPEG/L2":        "mp2",
+	"A_AC3":            "ac3",
+	"A_EAC3":           "eac3",
+	"A_DTS":            "dts",
+	"A_TRUEHD":         "truehd",
+	"A_ALAC":           "alac",
+	"A_PCM/INT/LIT":    "pcm",
+	"A_PCM/INT/BIG":    "pcm",
+	"A_PCM/FLOAT/IEEE": "pcm",
+}
+
+// matroskaProber Matroska / WebM 探测器
+type matroskaProber struct{}
+
+func (matroskaProber) Name() string { return "matroska" }
+
+func (matroskaProber) Match(header []byte) bool {
+	return len(header) >= 4 && binary.BigEndian.Uint32(header) == idEBML
+}
+
+// Probe 读取 EBML 头、Segment Info 与 Tracks
+func (matroskaProber) Probe(r io.ReaderAt, size int64) (*Info, error) {
+	e := &ebmlFile{r: r, size: size}
+
+	// 1. EBML 头，DocType 区分 matroska / webm
+	id, dataOff, dataSize, err := e.element(0)
+	if err != nil || id != idEBML {
+		return nil, ErrNotMatroska
+	}
+	header, err := e.read(dataOff, dataSize)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{Format: "mkv"}
+	if v, ok := findElement(header, idDocType); ok && string(trimNull(v)) == "webm" {
+		info.Format = "webm"
+	}
+
+	// 2. Segment
+	segOff := dataOff + dataSize
+	id, segData, segSize, err := e.element(segOff)
+	if err != nil || id != idSegment {
+		return nil, fmt.Errorf("%w: segment not found", ErrNotMatroska)
+	}
+	segEnd := size
+	if segSize != unknownSize && segData+segSize < size {
+		segEnd = segData + segSize
+	}
+
+	// 3. 在 Segment 子元素中查找 Info 与 Tracks，遇到 Cluster 后改用 SeekHead 定位
+	var infoBody, tracksBody []byte
+	seeks := map[uint32]int64{}
+	off := segData
+	for n := 0; off < segEnd && n < maxTopLevelScan && (infoBody == nil || tracksBody == nil); n++ {
+		id, childData, childSize, err := e.element(off)
+		if err != nil {
+			break
+		}
+		if id == idCluster || childSize == unknownSize {
+			break
+		}
+		switch id {
+		case idInfo, idTracks, idSeekHead:
+			body, err := e.read(childData, childSize)
+			if err != nil {
+				return nil, err
+			}
+			switch id {
+			case idInfo:
+				infoBody = body
+			case idTracks:
+				tracksBody = body
+			case idSeekHead:
+				parseSeekHead(body, segData, seeks)
+			}
+		}
+		off = childData + childSize
+	}
+	if infoBody == nil {
+		infoBody, _ = e.readSeekTarget(seeks, idInfo)
+	}
+	if tracksBody == nil {
+		tracksBody, _ = e.readSeekTarget(seeks, idTracks)
+	}
+	if infoBody == nil && tracksBody == nil {
+		return nil, fmt.Errorf("%w: segment info and tracks not found", ErrBadEBML)
+	}
+
+	// 4. 时长 = Duration * TimecodeScale (ns)
+	seconds := 0.0
+	if infoBody != nil {
+		scale := uint64(1000000)
+		if v, ok := findElement(infoBody, idTimecodeScale); ok {
+			if s := readUint(v); s > 0 {
+				scale = s
+			}
+		}
+		if v, ok := findElement(infoBody, idDuration); ok {
+			seconds = readFloat(v) * float64(scale) / 1e9
+		}
+	}
+
+	// 5. 取第一条视频轨与第一条音频轨
+	if tracksBody != nil {
+		_ = walkElements(tracksBody, func(id uint32, entry []byte) bool {
+			if id != idTrackEntry {
+				return true
+			}
+			typ, _ := findElement(entry, idTrackType)
+			codecID, _ := findElement(entry, idCodecID)
+			codec := matroskaCodecName(string(trimNull(codecID)))
+			switch readUint(typ) {
+			case trackTypeVideo:
+				if info.VideoCodec == "" {
+					info.VideoCodec = codec
+					if video, ok := findElement(entry, idVideo); ok {
+						if v, ok := findElement(video, idPixelWidth); ok {
+							info.Width = int(readUint(v))
+						}
+						if v, ok := findElement(video, idPixelHeight); ok {
+							info.Height = int(readUint(v))
+						}
+					}
+				}
+			case trackTypeAudio:
+				if info.AudioCodec == "" {
+					info.AudioCodec = codec
+				}
+			}
+			return info.VideoCodec == "" || info.AudioCodec == ""
+		})
+	}
+
+	fillTiming(info, seconds, size)
+	return info, nil
+}
+
+// matroskaCodecName CodecID 转换为通用编码名，未知编码原样返回
+func matroskaCodecName(codecID string) string {
+	if name, ok := matroskaCodecs[codecID]; ok {
+		return name
+	}
+	// A_AAC/MPEG4/LC 等旧式写法
+	if strings.HasPrefix(codecID, "A_AAC") {
+		return "aac"
+	}
+	return codecID
+}
+
+// parseSeekHead 记录 SeekHead 中各元素相对 Segment 数据起点的位置
+func parseSeekHead(body []byte, segData int64, seeks map[uint32]int64) {
+	_ = walkElements(body, func(id uint32, seek []byte) bool {
+		if id != idSeek {
+			return true
+		}
+		sid, ok1 := findElement(seek, idSeekID)
+		pos, ok2 := findElement(seek, idSeekPosition)
+		if ok1 && ok2 {
+			seeks[uint32(readUint(sid))] = segData + int64(readUint(pos))
+		}
+		return true
+	})
+}
+
+// ebmlFile 基于 io.ReaderAt 的 EBML 元素读取
+type ebmlFile struct {
+	r    io.ReaderAt
+	size int64
+}
+
+// element 读取 off 处的元素头，返回 ID、数据起点与数据长度（未知长度为 unknownSize）
+func (e *ebmlFile) element(off int64) (id uint32, dataOff int64, dataSize int64, err error) {
+	if off < 0 || off >= e.size {
+		return 0, 0, 0, io.EOF
+	}
+	buf := make([]byte, 12)
+	if rest := e.size - off; rest < int64(len(buf)) {
+		buf = buf[:rest]
+	}
+	if _, err := e.r.ReadAt(buf, off); err != nil && err != io.EOF {
+		return 0, 0, 0, err
+	}
+	id, n, err := parseID(buf)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	dataSize, m, err := parseSize(buf[n:])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	dataOff = off + int64(n+m)
+	if dataSize != unknownSize && dataSize > e.size-dataOff {
+		return 0, 0, 0, fmt.Errorf("%w: element 0x%X exceeds file", ErrBadEBML, id)
+	}
+	return id, dataOff, dataSize, nil
+}
+
+// read 读取元素数据到内存
+func (e *ebmlFile) read(off, n int64) ([]byte, error) {
+	if n < 0 || n > maxEBMLElement {
+		return nil, fmt.Errorf("%w: element too large (%d bytes)", ErrBadEBML, n)
+	}
+	buf := make([]byte, n)
+	if _, err := e.r.ReadAt(buf, off); err != nil && !(err == io.EOF && n == 0) {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readSeekTarget 根据 SeekHead 读取指定顶层元素
+func (e *ebmlFile) readSeekTarget(seeks map[uint32]int64, want uint32) ([]byte, error) {
+	off, ok := seeks[want]
+	if !ok {
+		return nil, ErrBadEBML
+	}
+	id, dataOff, dataSize, err := e.element(off)
+	if err != nil {
+		return nil, err
+	}
+	if id != want || dataSize == unknownSize {
+		return nil, ErrBadEBML
+	}
+	return e.read(dataOff, dataSize)
+}
+
+// walkElements 遍历内存中的同级元素，fn 返回 false 时停止
+func walkElements(data []byte, fn func(id uint32, body []byte) bool) error {
+	for len(data) > 0 {
+		id, n, err := parseID(data)
+		if err != nil {
+			return err
+		}
+		size, m, err := parseSize(data[n:])
+		if err != nil {
+			return err
+		}
+		start := n + m
+		if size == unknownSize || size > int64(len(data)-start) {
+			return ErrBadEBML
+		}
+		if !fn(id, data[start:start+int(size)]) {
+			return nil
+		}
+		data = data[start+int(size):]
+	}
+	return nil
+}
+
+// findElement 查找同级元素中第一个指定 ID 的元素
+func findElement(data []byte, want uint32) ([]byte, bool) {
+	var found []byte
+	ok := false
+	_ = walkElements(data, func(id uint32, body []byte) bool {
+		if id == want {
+			found, ok = body, true
+			return false
+		}
+		return true
+	})
+	return found, ok
+}
+
+// parseID 解析元素 ID（1~4 字节，保留长度标记位）
+func parseID(b []byte) (uint32, int, error) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0, ErrBadEBML
+	}
+	n := vintLen(b[0])
+	if n > 4 || len(b) < n {
+		return 0, 0, ErrBadEBML
+	}
+	var id uint32
+	for i := 0; i < n; i++ {
+		id = id<<8 | uint32(b[i])
+	}
+	return id, n, nil
+}
+
+// parseSize 解析数据长度（1~8 字节，去掉长度标记位）
+func parseSize(b []byte) (int64, int, error) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0, ErrBadEBML
+	}
+	n := vintLen(b[0])
+	if len(b) < n {
+		return 0, 0, ErrBadEBML
+	}
+	v := uint64(b[0]) & (0xFF >> n)
+	allOnes := v == uint64(0xFF>>n)
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(b[i])
+		allOnes = allOnes && b[i] == 0xFF
+	}
+	if allOnes {
+		return unknownSize, n, nil
+	}
+	if v > math.MaxInt64 {
+		return 0, 0, ErrBadEBML
+	}
+	return int64(v), n, nil
+}
+
+// vintLen 由首字节前导零的个数得出 VINT 长度
+func vintLen(first byte) int {
+	n := 1
+	for mask := byte(0x80); mask != 0 && first&mask == 0; mask >>= 1 {
+		n++
+	}
+	return n
+}
+
+func readUint(b []byte) uint64 {
+	if len(b) > 8 {
+		return 0
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func readFloat(b []byte) float64 {
+	switch len(b) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func trimNull(b []byte) []byte {
+	return bytes.TrimRight(b, "\x00")
+}
@@ -0,0 +1,94 @@
+package probe
+
+import (
+	"io"
+
+	"video-platform/internal/mp4"
+)
+
+// codecNames 样本描述类型到通用编码名的映射
+var codecNames = map[string]string{
+	"avc1": "h264", "avc3": "h264",
+	"hvc1": "hevc", "hev1": "hevc", "dvh1": "hevc", "dvhe": "hevc",
+	"av01": "av1",
+	"vp08": "vp8", "vp09": "vp9",
+	"mp4v": "mpeg4",
+	"jpeg": "mjpeg",
+	"apcn": "prores", "apch": "prores", "apcs": "prores", "apco": "prores", "ap4h": "prores",
+	"mp4a": "aac",
+	"Opus": "opus",
+	"fLaC": "flac",
+	"ac-3": "ac3", "ec-3": "eac3",
+	"alac": "alac",
+	".mp3": "mp3",
+	"lpcm": "pcm", "sowt": "pcm", "twos": "pcm",
+}
+
+// mp4TopBoxes 可能出现在 MP4/MOV 文件开头的 box 类型（老式 QuickTime 文件没有 ftyp）
+var mp4TopBoxes = map[string]bool{
+	"ftyp": true, "moov": true, "mdat": true, "free": true, "skip": true, "wide": true, "pnot": true,
+}
+
+// mp4Prober ISO BMFF（MP4/MOV/3GP）探测器
+type mp4Prober struct{}
+
+func (mp4Prober) Name() string { return "mp4" }
+
+func (mp4Prober) Match(header []byte) bool {
+	return len(header) >= 8 && mp4TopBoxes[string(header[4:8])]
+}
+
+// Probe 读取 MP4/MOV 的 ftyp/moov 头信息
+func (mp4Prober) Probe(r io.ReaderAt, size int64) (*Info, error) {
+	m, err := mp4.ParseHeaders(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{Format: "mp4"}
+	switch m.MajorBrand {
+	case "qt  ", "": // 没有 ftyp 的老式 QuickTime 文件
+		info.Format = "mov"
+	case "3gp4", "3gp5", "3gp6", "3g2a":
+		info.Format = "3gp"
+	}
+
+	seconds := m.DurationSeconds()
+
+	if v := m.VideoTrack(); v != nil {
+		info.VideoCodec = codecName(v.SampleEntry)
+		info.Width = int(v.Width >> 16)
+		info.Height = int(v.Height >> 16)
+		if entry, ok := mp4.FirstSampleEntry(v.Stsd); ok && entry.Width > 0 && entry.Height > 0 {
+			// tkhd 可能带有显示缩放，优先使用编码尺寸
+			info.Width = int(entry.Width)
+			info.Height = int(entry.Height)
+		}
+		if seconds == 0 {
+			seconds = v.DurationSeconds()
+		}
+	}
+	if a := m.AudioTrack(); a != nil {
+		info.AudioCodec = codecName(a.SampleEntry)
+		if info.AudioCodec == "aac" {
+			if entry, ok := mp4.FirstSampleEntry(a.Stsd); ok {
+				if oti, _, ok := entry.AudioObjectType(); ok && (oti == 0x69 || oti == 0x6b) {
+					info.AudioCodec = "mp3"
+				}
+			}
+		}
+		if seconds == 0 {
+			seconds = a.DurationSeconds()
+		}
+	}
+
+	fillTiming(info, seconds, size)
+	return info, nil
+}
+
+func codecName(entryType string) string {
+	if name, ok := codecNames[entryType]; ok {
+		return name
+	}
+	return entryType
+}
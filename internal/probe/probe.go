@@ -0,0 +1,89 @@
+// Package probe 在不依赖 ffprobe 的情况下读取视频容器的元数据。
+//
+// 具体格式通过文件开头的魔数选择对应的 Prober，而不是依赖扩展名。
+package probe
+
+import (
+	"errors"
+	"io"
+	"math"
+	"sync"
+)
+
+// headerLen 选择 Prober 时读取的文件头长度
+const headerLen = 64
+
+// ErrUnknownFormat 没有 Prober 能识别该文件
+var ErrUnknownFormat = errors.New("unknown container format")
+
+// Info 媒体元数据，对应 db.FileMeta 的媒体字段
+type Info struct {
+	Format     string
+	VideoCodec string
+	AudioCodec string
+	Bitrate    int64 // bps
+	Width      int
+	Height     int
+	Duration   int // 秒
+}
+
+// Prober 某一种容器格式的元数据探测器
+type Prober interface {
+	// Name 格式名，用于日志
+	Name() string
+	// Match 根据文件头（最多 headerLen 字节）判断是否属于该格式
+	Match(header []byte) bool
+	// Probe 读取元数据，只应读取容器头部而不是整个文件
+	Probe(r io.ReaderAt, size int64) (*Info, error)
+}
+
+var (
+	probersMu sync.RWMutex
+	probers   = []Prober{mp4Prober{}, matroskaProber{}}
+)
+
+// Register 注册新的 Prober，后注册的优先匹配
+func Register(p Prober) {
+	probersMu.Lock()
+	defer probersMu.Unlock()
+	probers = append([]Prober{p}, probers...)
+}
+
+// Detect 根据文件头选择 Prober
+func Detect(r io.ReaderAt, size int64) (Prober, error) {
+	n := int64(headerLen)
+	if size < n {
+		n = size
+	}
+	header := make([]byte, n)
+	if _, err := r.ReadAt(header, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	probersMu.RLock()
+	defer probersMu.RUnlock()
+	for _, p := range probers {
+		if p.Match(header) {
+			return p, nil
+		}
+	}
+	return nil, ErrUnknownFormat
+}
+
+// Probe 识别文件格式并读取元数据
+func Probe(r io.ReaderAt, size int64) (*Info, error) {
+	p, err := Detect(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return p.Probe(r, size)
+}
+
+// fillTiming 根据时长填充秒数与平均码率
+func fillTiming(info *Info, seconds float64, size int64) {
+	if seconds <= 0 {
+		return
+	}
+	info.Duration = int(math.Round(seconds))
+	info.Bitrate = int64(float64(size*8) / seconds)
+}
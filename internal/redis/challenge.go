@@ -0,0 +1,66 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	ChallengePrefix = "challenge:"
+	ChallengeTTL    = 5 * time.Minute // 秒传挑战有效期
+)
+
+// ChallengeRange 挑战中要求客户端计算摘要的字节区间（闭区间）
+type ChallengeRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// Challenge 秒传持有性证明挑战
+type Challenge struct {
+	ID        string           `json:"id"`
+	UserID    int              `json:"user_id"`
+	ContentID uint             `json:"content_id"`
+	FileHash  string           `json:"file_hash"`
+	Ranges    []ChallengeRange `json:"ranges"`
+}
+
+// SaveChallenge 保存挑战
+func SaveChallenge(ctx context.Context, ch *Challenge) error {
+	key := fmt.Sprintf("%s%d:%s", ChallengePrefix, ch.UserID, ch.ID)
+	data, err := json.Marshal(ch)
+	if err != nil {
+		return err
+	}
+	return Client.Set(ctx, key, data, ChallengeTTL).Err()
+}
+
+// TakeChallenge 取出并删除挑战（一次性使用），不存在或已过期时返回 nil
+func TakeChallenge(ctx context.Context, userID int, challengeID string) (*Challenge, error) {
+	key := fmt.Sprintf("%s%d:%s", ChallengePrefix, userID, challengeID)
+	// MULTI/EXEC 保证同一挑战只能被取出一次（兼容低于 6.2 的 Redis，不用 GETDEL）
+	pipe := Client.TxPipeline()
+	get := pipe.Get(ctx, key)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	data, err := get.Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ch Challenge
+	if err := json.Unmarshal(data, &ch); err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
@@ -0,0 +1,16 @@
+package redis
+
+import "context"
+
+// ScrubSuspectKey 读取时发现副本损坏的文件（集合），由巡检优先校验并修复
+const ScrubSuspectKey = "scrub:suspect"
+
+// AddScrubSuspect 登记待巡检的文件
+func AddScrubSuspect(ctx context.Context, fileHash string) error {
+	return Client.SAdd(ctx, ScrubSuspectKey, fileHash).Err()
+}
+
+// PopScrubSuspects 取出至多 n 个待巡检的文件
+func PopScrubSuspects(ctx context.Context, n int64) ([]string, error) {
+	return Client.SPopN(ctx, ScrubSuspectKey, n).Result()
+}
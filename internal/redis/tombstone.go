@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -129,3 +131,53 @@ func CountTombstones(ctx context.Context) (int64, error) {
 	}
 	return int64(len(keys)), nil
 }
+
+// ListTombstones 用 SCAN 遍历所有墓碑（不阻塞 Redis），UserID 与 FileHash 取自 key
+func ListTombstones(ctx context.Context) ([]TombstoneData, error) {
+	var tombstones []TombstoneData
+	iter := Client.Scan(ctx, 0, TombstonePrefix+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		parts := strings.SplitN(strings.TrimPrefix(key, TombstonePrefix), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		userID, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+		fields, err := Client.HGetAll(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			// 遍历期间过期或被删除
+			continue
+		}
+		t := TombstoneData{UserID: userID, FileHash: parts[1], Status: fields["status"]}
+		if id, err := strconv.ParseUint(fields["content_id"], 10, 64); err == nil {
+			t.ContentID = uint(id)
+		}
+		tombstones = append(tombstones, t)
+	}
+	return tombstones, iter.Err()
+}
+
+// RenameTombstone 文件标识迁移后把墓碑移到新 hash 下，保留状态与过期时间。
+// 新 hash 已有墓碑时以新墓碑为准，只删除旧的
+func RenameTombstone(ctx context.Context, userID int, oldHash, newHash string) error {
+	oldKey := fmt.Sprintf("%s%d:%s", TombstonePrefix, userID, oldHash)
+	newKey := fmt.Sprintf("%s%d:%s", TombstonePrefix, userID, newHash)
+	exists, err := Client.Exists(ctx, oldKey).Result()
+	if err != nil || exists == 0 {
+		return err
+	}
+	renamed, err := Client.RenameNX(ctx, oldKey, newKey).Result()
+	if err != nil {
+		return err
+	}
+	if !renamed {
+		return Client.Del(ctx, oldKey).Err()
+	}
+	return Client.HSet(ctx, newKey, "file_hash", newHash).Err()
+}
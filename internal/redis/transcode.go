@@ -0,0 +1,63 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	TranscodeQueueKey    = "transcode:queue" // 有序集合，score 为可执行时间（毫秒）
+	TranscodeLeasePrefix = "transcode:job:"
+)
+
+// dequeueScript 原子地取出一个已到期的任务
+var dequeueScript = redis.NewScript(`
+	local ids = redis.call("zrangebyscore", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, 1)
+	if #ids == 0 then
+		return false
+	end
+	redis.call("zrem", KEYS[1], ids[1])
+	return ids[1]
+`)
+
+// EnqueueTranscodeJob 把任务放入队列，runAt 之前不会被取出
+func EnqueueTranscodeJob(ctx context.Context, jobID uint, runAt time.Time) error {
+	return Client.ZAdd(ctx, TranscodeQueueKey, &redis.Z{
+		Score:  float64(runAt.UnixMilli()),
+		Member: strconv.FormatUint(uint64(jobID), 10),
+	}).Err()
+}
+
+// DequeueTranscodeJob 取出一个已到期的任务，队列为空时 ok 为 false
+func DequeueTranscodeJob(ctx context.Context, now time.Time) (jobID uint, ok bool, err error) {
+	res, err := dequeueScript.Run(ctx, Client, []string{TranscodeQueueKey}, now.UnixMilli()).Text()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	id, err := strconv.ParseUint(res, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("bad job id in queue: %q", res)
+	}
+	return uint(id), true, nil
+}
+
+// NewTranscodeLease 创建任务租约，worker 执行期间需定期 Extend
+func NewTranscodeLease(jobID uint, ttl time.Duration) *DistributedLock {
+	return NewLock(fmt.Sprintf("%s%d", TranscodeLeasePrefix, jobID), ttl)
+}
+
+// TranscodeLeaseHeld 检查任务租约是否仍被某个 worker 持有
+func TranscodeLeaseHeld(ctx context.Context, jobID uint) (bool, error) {
+	n, err := Client.Exists(ctx, NewTranscodeLease(jobID, 0).key).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,18 @@
+//go:build linux
+
+package store
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// allocateFile 用 fallocate 预留磁盘空间，文件系统不支持时退回稀疏文件
+func allocateFile(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return f.Truncate(size)
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package store
+
+import "os"
+
+// allocateFile 非 Linux 平台创建稀疏文件
+func allocateFile(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
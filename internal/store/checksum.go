@@ -0,0 +1,139 @@
+package store
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrChunkChecksum 分片内容与客户端声明的摘要不一致，客户端重传即可
+	ErrChunkChecksum = errors.New("chunk checksum mismatch")
+	// ErrUnsupportedChecksum 不支持的摘要算法或格式错误
+	ErrUnsupportedChecksum = errors.New("unsupported chunk checksum")
+)
+
+// 支持的分片摘要算法
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// ChunkChecksum 客户端声明的分片摘要
+type ChunkChecksum struct {
+	Algorithm string
+	Sum       []byte
+}
+
+// NewChunkChecksum 校验算法名与摘要长度
+func NewChunkChecksum(algorithm string, sum []byte) (*ChunkChecksum, error) {
+	algorithm = strings.ToLower(algorithm)
+	newHash, ok := checksumAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("%w: algorithm %q", ErrUnsupportedChecksum, algorithm)
+	}
+	if len(sum) != newHash().Size() {
+		return nil, fmt.Errorf("%w: bad %s digest length", ErrUnsupportedChecksum, algorithm)
+	}
+	return &ChunkChecksum{Algorithm: algorithm, Sum: sum}, nil
+}
+
+// ParseChunkChecksum 解析 "算法:十六进制摘要"，省略算法时按长度识别
+// （32 位为 md5，40 位为 sha1，64 位为 sha256）。空字符串返回 nil。
+func ParseChunkChecksum(s string) (*ChunkChecksum, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	algorithm, digest, ok := strings.Cut(s, ":")
+	if !ok {
+		digest = s
+		switch len(s) {
+		case 32:
+			algorithm = "md5"
+		case 40:
+			algorithm = "sha1"
+		case 64:
+			algorithm = "sha256"
+		default:
+			return nil, ErrUnsupportedChecksum
+		}
+	}
+
+	sum, err := hex.DecodeString(digest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedChecksum, err)
+	}
+	return NewChunkChecksum(algorithm, sum)
+}
+
+// New 返回对应算法的摘要器
+func (c *ChunkChecksum) New() hash.Hash {
+	return checksumAlgorithms[c.Algorithm]()
+}
+
+// String 格式化为 "算法:十六进制摘要"
+func (c *ChunkChecksum) String() string {
+	return c.Algorithm + ":" + hex.EncodeToString(c.Sum)
+}
+
+// ChecksumError 分片摘要校验失败详情，errors.Is(err, ErrChunkChecksum) 为真
+type ChecksumError struct {
+	Index    int
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("chunk %d checksum mismatch: expected %s, got %s", e.Index, e.Expected, e.Actual)
+}
+
+func (e *ChecksumError) Is(target error) bool {
+	return target == ErrChunkChecksum
+}
+
+// chunkDigest 写分片时使用的摘要器：有客户端摘要时用其算法，否则用 md5
+func chunkDigest(checksum *ChunkChecksum) (string, hash.Hash) {
+	if checksum != nil {
+		return checksum.Algorithm, checksum.New()
+	}
+	return "md5", md5.New()
+}
+
+// verifyChunk 比较写入内容的摘要与客户端声明值
+func verifyChunk(index int, checksum *ChunkChecksum, digest hash.Hash) error {
+	if checksum == nil {
+		return nil
+	}
+	actual := digest.Sum(nil)
+	if !bytes.Equal(actual, checksum.Sum) {
+		return &ChecksumError{
+			Index:    index,
+			Expected: checksum.String(),
+			Actual:   checksum.Algorithm + ":" + hex.EncodeToString(actual),
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic 先写临时文件再改名，避免留下写了一半的文件
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,397 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"testing/iotest"
+)
+
+// testBlockSize 测试中的平均块大小（允许的最小值）
+const testBlockSize = minDedupBlockSize
+
+// memIndex 内存中的块索引，行为与数据库实现一致
+type memIndex struct {
+	mu       sync.Mutex
+	files    map[string][]BlockRef
+	refs     map[string]int
+	deleting map[string]bool
+
+	// beforeSet 登记文件的块之前调用（不持有锁），用于模拟并发操作
+	beforeSet func(fileHash string, blocks []BlockRef)
+}
+
+func newMemIndex() *memIndex {
+	return &memIndex{
+		files:    make(map[string][]BlockRef),
+		refs:     make(map[string]int),
+		deleting: make(map[string]bool),
+	}
+}
+
+func (x *memIndex) SetFileBlocks(fileHash string, blocks []BlockRef) ([]string, error) {
+	if hook := x.beforeSet; hook != nil {
+		hook(fileHash, blocks)
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, b := range blocks {
+		x.refs[b.Hash]++
+		delete(x.deleting, b.Hash)
+	}
+	var freed []string
+	for _, b := range x.files[fileHash] {
+		x.refs[b.Hash]--
+		if x.refs[b.Hash] == 0 {
+			x.deleting[b.Hash] = true
+			freed = append(freed, b.Hash)
+		}
+	}
+	if blocks == nil {
+		delete(x.files, fileHash)
+	} else {
+		x.files[fileHash] = blocks
+	}
+	return freed, nil
+}
+
+func (x *memIndex) ForgetBlocks(hashes []string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, h := range hashes {
+		if x.deleting[h] {
+			delete(x.deleting, h)
+			delete(x.refs, h)
+		}
+	}
+	return nil
+}
+
+func (x *memIndex) ListIndexedFiles() ([]StoredFile, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var out []StoredFile
+	for h, blocks := range x.files {
+		f := StoredFile{Hash: h}
+		for _, b := range blocks {
+			f.Size += b.Size
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+func (x *memIndex) refCount(hash string) int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.refs[hash]
+}
+
+// newTestDedup 本地存储上的去重存储，块存放在另一个本地存储中
+func newTestDedup(t *testing.T, avgBlockSize int) (*DedupStore, *memIndex) {
+	t.Helper()
+	s, err := NewDedupStore(newTestLocal(t), newTestLocal(t), avgBlockSize)
+	if err != nil {
+		t.Fatalf("NewDedupStore: %v", err)
+	}
+	index := newMemIndex()
+	s.SetBlockIndex(index)
+	return s, index
+}
+
+// randomContent 固定种子生成的伪随机内容，块边界分布接近真实文件
+func randomContent(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// storeDedup 上传并合并 data，返回其 hash 与块清单
+func storeDedup(t *testing.T, s *DedupStore, data []byte) (string, *blockManifest) {
+	t.Helper()
+	hash := testHash(data)
+	writeChunks(t, s, hash, data, 64<<10)
+	m, err := s.loadManifest(hash)
+	if err != nil {
+		t.Fatalf("loadManifest: %v", err)
+	}
+	return hash, m
+}
+
+// blockLengths 按 params 切分 r 得到的各块长度
+func blockLengths(t *testing.T, r io.Reader, params cdcParams) []int {
+	t.Helper()
+	var out []int
+	c := newChunker(r, params)
+	for {
+		block, err := c.next()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+		out = append(out, len(block))
+	}
+}
+
+// blockDigests 各块的 SHA-256
+func blockDigests(data []byte, lengths []int) []string {
+	out := make([]string, len(lengths))
+	for i, n := range lengths {
+		sum := sha256.Sum256(data[:n])
+		out[i] = hex.EncodeToString(sum[:])
+		data = data[n:]
+	}
+	return out
+}
+
+func TestNewCDCParams(t *testing.T) {
+	for _, size := range []int{minDedupBlockSize - 1, maxDedupBlockSize + 1} {
+		if _, err := newCDCParams(size); err == nil {
+			t.Fatalf("newCDCParams(%d) accepted", size)
+		}
+	}
+	p, err := newCDCParams(DefaultDedupBlockSize + 12345)
+	if err != nil {
+		t.Fatalf("newCDCParams: %v", err)
+	}
+	if p.avg != DefaultDedupBlockSize || p.min != p.avg/4 || p.max != p.avg*4 {
+		t.Fatalf("params = %d/%d/%d, want the average rounded down to %d", p.min, p.avg, p.max, DefaultDedupBlockSize)
+	}
+}
+
+func TestFastCDCDeterministicBoundaries(t *testing.T) {
+	params, err := newCDCParams(testBlockSize)
+	if err != nil {
+		t.Fatalf("newCDCParams: %v", err)
+	}
+	data := randomContent(1, 256<<10)
+
+	// 边界与读取方式无关
+	want := blockLengths(t, bytes.NewReader(data), params)
+	for name, r := range map[string]io.Reader{
+		"one byte": iotest.OneByteReader(bytes.NewReader(data)),
+		"half":     iotest.HalfReader(bytes.NewReader(data)),
+	} {
+		if got := blockLengths(t, r, params); !equalInts(got, want) {
+			t.Fatalf("%s reader: boundaries differ", name)
+		}
+	}
+	total := 0
+	for i, n := range want {
+		if n > params.max || (n < params.min && i != len(want)-1) {
+			t.Fatalf("block %d has %d bytes, want within [%d, %d]", i, n, params.min, params.max)
+		}
+		total += n
+	}
+	if total != len(data) || len(want) < 16 {
+		t.Fatalf("%d blocks covering %d bytes, want many blocks covering %d", len(want), total, len(data))
+	}
+
+	// 中间插入数据只影响附近的块
+	at := len(data) / 2
+	edited := append(append(append([]byte{}, data[:at]...), randomContent(2, 100)...), data[at:]...)
+	old := make(map[string]bool)
+	for _, d := range blockDigests(data, want) {
+		old[d] = true
+	}
+	changed := 0
+	for _, d := range blockDigests(edited, blockLengths(t, bytes.NewReader(edited), params)) {
+		if !old[d] {
+			changed++
+		}
+	}
+	if changed == 0 || changed > 3 {
+		t.Fatalf("%d blocks changed after inserting 100 bytes, want 1-3", changed)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDedupRangeReadsAcrossBlocks(t *testing.T) {
+	s, _ := newTestDedup(t, testBlockSize)
+	data := randomContent(3, 100<<10)
+	hash, m := storeDedup(t, s, data)
+	if len(m.Blocks) < 4 {
+		t.Fatalf("file split into %d blocks, want at least 4", len(m.Blocks))
+	}
+	if s.inner.FileExists(hash) {
+		t.Fatal("whole file kept after dedup")
+	}
+	if got, err := readWhole(s, hash); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("whole read = %d bytes, %v", len(got), err)
+	}
+
+	b1, b2 := m.offsets[1], m.offsets[2]
+	size := int64(len(data))
+	for _, tc := range []struct {
+		name       string
+		start, end int64
+	}{
+		{"within first block", 10, 20},
+		{"first byte of block", b1, b1},
+		{"last byte of block", b1 - 1, b1 - 1},
+		{"across one boundary", b1 - 5, b1 + 5},
+		{"whole middle block", b1, b2 - 1},
+		{"across several blocks", b1 - 1, m.offsets[3] + 1},
+		{"last byte", size - 1, size - 1},
+		{"end past size", b2 + 7, size + 100},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := readRange(s, hash, tc.start, tc.end)
+			end := tc.end
+			if end >= size {
+				end = size - 1
+			}
+			if err != nil || !bytes.Equal(got, data[tc.start:end+1]) {
+				t.Fatalf("range read = %d bytes, %v; want %d bytes", len(got), err, end-tc.start+1)
+			}
+		})
+	}
+	if _, err := readRange(s, hash, size, size+10); err == nil {
+		t.Fatal("range starting past the end accepted")
+	}
+}
+
+func TestDedupSharedBlocksSurviveDelete(t *testing.T) {
+	s, index := newTestDedup(t, testBlockSize)
+	a := randomContent(4, 96<<10)
+	// b 修改了开头并追加了内容，其余块与 a 共享
+	b := append(append([]byte{}, a...), randomContent(5, 20<<10)...)
+	copy(b, randomContent(6, 100))
+
+	hashA, ma := storeDedup(t, s, a)
+	hashB, mb := storeDedup(t, s, b)
+
+	inB := make(map[string]bool)
+	for _, blk := range mb.Blocks {
+		inB[blk.id()] = true
+	}
+	var shared, onlyA []string
+	for _, blk := range ma.Blocks {
+		if inB[blk.id()] {
+			shared = append(shared, blk.id())
+		} else {
+			onlyA = append(onlyA, blk.id())
+		}
+	}
+	if len(shared) == 0 || len(onlyA) == 0 {
+		t.Fatalf("%d shared and %d unshared blocks, want both", len(shared), len(onlyA))
+	}
+	for _, id := range shared {
+		if n := index.refCount(id); n != 2 {
+			t.Fatalf("shared block %s has %d refs, want 2", id, n)
+		}
+	}
+
+	if err := s.DeleteFile(hashA); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	if s.FileExists(hashA) {
+		t.Fatal("deleted file still exists")
+	}
+	for _, id := range onlyA {
+		if s.blocks.FileExists(id) || index.refCount(id) != 0 {
+			t.Fatalf("block %s only used by the deleted file was kept", id)
+		}
+	}
+	for _, id := range shared {
+		if !s.blocks.FileExists(id) || index.refCount(id) != 1 {
+			t.Fatalf("shared block %s: exists %v, refs %d; want kept with 1 ref", id, s.blocks.FileExists(id), index.refCount(id))
+		}
+	}
+	if got, err := readWhole(s, hashB); err != nil || !bytes.Equal(got, b) {
+		t.Fatalf("read of remaining file = %d bytes, %v", len(got), err)
+	}
+}
+
+func TestDedupRemergeFreesReplacedBlocks(t *testing.T) {
+	s, index := newTestDedup(t, testBlockSize)
+	data := randomContent(7, 128<<10)
+	hash, before := storeDedup(t, s, data)
+
+	// 调整块大小后重新上传同一文件，旧清单的块全部被替换
+	s2, err := NewDedupStore(s.inner, s.blocks, 4*testBlockSize)
+	if err != nil {
+		t.Fatalf("NewDedupStore: %v", err)
+	}
+	s2.SetBlockIndex(index)
+	_, after := storeDedup(t, s2, data)
+
+	kept := make(map[string]bool)
+	for _, blk := range after.Blocks {
+		kept[blk.id()] = true
+		if !s2.blocks.FileExists(blk.id()) || index.refCount(blk.id()) != 1 {
+			t.Fatalf("new block %s missing or refs %d", blk.id(), index.refCount(blk.id()))
+		}
+	}
+	freed := 0
+	for _, blk := range before.Blocks {
+		if kept[blk.id()] {
+			continue
+		}
+		freed++
+		if s2.blocks.FileExists(blk.id()) || index.refCount(blk.id()) != 0 {
+			t.Fatalf("replaced block %s was not freed", blk.id())
+		}
+	}
+	if freed == 0 {
+		t.Fatal("re-merge with a larger block size replaced no blocks")
+	}
+	if got, err := readWhole(s2, hash); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read after re-merge = %d bytes, %v", len(got), err)
+	}
+}
+
+func TestDedupRestoresBlocksFreedBeforeRegistering(t *testing.T) {
+	s, index := newTestDedup(t, testBlockSize)
+	a := randomContent(8, 64<<10)
+	b := append(append([]byte{}, a...), randomContent(9, 10<<10)...)
+	hashA, ma := storeDedup(t, s, a)
+	hashB := testHash(b)
+
+	// b 切块时 a 的块都已存在；登记前 a 被删除，共享的块随之释放
+	index.beforeSet = func(fileHash string, blocks []BlockRef) {
+		if fileHash != hashB {
+			return
+		}
+		index.beforeSet = nil
+		if err := s.DeleteFile(hashA); err != nil {
+			t.Errorf("DeleteFile: %v", err)
+		}
+		for _, blk := range ma.Blocks {
+			if s.blocks.FileExists(blk.id()) {
+				t.Errorf("block %s survived deleting its only file", blk.id())
+			}
+		}
+	}
+	writeChunks(t, s, hashB, b, 64<<10)
+
+	mb, err := s.loadManifest(hashB)
+	if err != nil {
+		t.Fatalf("file kept whole instead of deduplicated: %v", err)
+	}
+	for _, blk := range mb.Blocks {
+		if !s.blocks.FileExists(blk.id()) {
+			t.Fatalf("block %s not restored", blk.id())
+		}
+	}
+	if got, err := readWhole(s, hashB); err != nil || !bytes.Equal(got, b) {
+		t.Fatalf("read = %d bytes, %v", len(got), err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package store
+
+import "errors"
+
+// diskFree 非 Linux 平台无法查询可用空间，按容量放置时各磁盘视为相同
+func diskFree(path string) (uint64, error) {
+	return 0, errors.New("disk free space is not available on this platform")
+}
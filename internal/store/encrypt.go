@@ -0,0 +1,999 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 静态加密（信封加密）：每次上传生成随机数据密钥（DEK），用主密钥包装后保存在
+// 元数据中，文件内容以 DEK 分段加密。更换主密钥只需重新包装 DEK，不改写文件。
+//
+// 存储格式：头部 + 各分片依次拼接。每个分片的明文按 segSize 切段，
+// 每段为 nonce(12) || 密文 || tag(16)，附加数据为 头部 || 分片序号 || 段序号，
+// 因此段不能被篡改、截断或调换位置。除最后一片外分片大小相同，
+// 任意明文偏移都能直接算出所在段的存储位置，范围读取只需解密涉及的段。
+//
+// 头部（encHeaderSize 字节）：magic "VPE1" | segSize u32 | chunkSize u64 | fileSize u64 | keyID [16]
+//
+// 元数据：
+//   uploads/<user>-<hash>  上传中的布局与包装后的 DEK，由 Preallocate 创建
+//   keys/<hash>            已合并文件的 DEK，删除文件时一并删除
+
+var (
+	// ErrCorrupted 加密内容认证失败或被截断
+	ErrCorrupted = errors.New("encrypted content failed authentication")
+	// ErrUnknownMasterKey 数据密钥由未配置的主密钥包装
+	ErrUnknownMasterKey = errors.New("data key is wrapped by an unknown master key")
+
+	errUploadNotPrepared = errors.New("encrypted upload has no layout, Preallocate must be called first")
+)
+
+const (
+	encMagic       = "VPE1"
+	encHeaderSize  = 40
+	encSegmentSize = 64 << 10
+	encNonceSize   = 12
+	encOverhead    = encNonceSize + 16
+	encMaxSegment  = 16 << 20
+	encKeyIDSize   = 16
+	encKeyCacheMax = 4096
+)
+
+// KeyRing 主密钥集合，第一个为当前主密钥（新数据密钥用它包装），
+// 其余只用于解开旧的数据密钥
+type KeyRing struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// ParseKeyRing 解析 "id:base64密钥,id2:base64密钥"，密钥为 32 字节（AES-256）
+func ParseKeyRing(s string) (*KeyRing, error) {
+	ring := &KeyRing{keys: make(map[string]cipher.AEAD)}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(item, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("master key %q: expected id:base64", item)
+		}
+		if _, dup := ring.keys[id]; dup {
+			return nil, fmt.Errorf("duplicate master key id %q", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("master key %q: %w", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("master key %q: need 32 bytes, got %d", id, len(key))
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		ring.keys[id] = aead
+		if ring.primary == "" {
+			ring.primary = id
+		}
+	}
+	if ring.primary == "" {
+		return nil, errors.New("no master key configured")
+	}
+	return ring, nil
+}
+
+// Primary 当前主密钥 id
+func (r *KeyRing) Primary() string {
+	return r.primary
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrappedKey 被主密钥包装的数据密钥
+type wrappedKey struct {
+	ID  string `json:"id"`  // 数据密钥 id（十六进制），与文件头部中的 keyID 对应
+	KID string `json:"kid"` // 主密钥 id
+	Key []byte `json:"key"` // nonce || 密文
+}
+
+// wrapAAD 包装时绑定文件 hash 与数据密钥 id，密钥不能挪给其他文件使用
+func wrapAAD(fileHash, id string) []byte {
+	return []byte("video-platform/dek|" + fileHash + "|" + id)
+}
+
+func (r *KeyRing) wrap(fileHash, id string, dek []byte) (wrappedKey, error) {
+	nonce := make([]byte, encNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return wrappedKey{}, err
+	}
+	sealed := r.keys[r.primary].Seal(nonce, nonce, dek, wrapAAD(fileHash, id))
+	return wrappedKey{ID: id, KID: r.primary, Key: sealed}, nil
+}
+
+func (r *KeyRing) unwrap(fileHash string, wk wrappedKey) ([]byte, error) {
+	master, ok := r.keys[wk.KID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownMasterKey, wk.KID)
+	}
+	if len(wk.Key) < encNonceSize {
+		return nil, fmt.Errorf("%w: malformed data key %s", ErrCorrupted, wk.ID)
+	}
+	dek, err := master.Open(nil, wk.Key[:encNonceSize], wk.Key[encNonceSize:], wrapAAD(fileHash, wk.ID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: unwrap data key %s", ErrCorrupted, wk.ID)
+	}
+	return dek, nil
+}
+
+// encUpload 上传中的布局，分片按它加密
+type encUpload struct {
+	FileSize  int64      `json:"file_size"`
+	ChunkSize int64      `json:"chunk_size"`
+	SegSize   int64      `json:"seg_size"`
+	Key       wrappedKey `json:"key"`
+}
+
+// keyEnvelope 已合并文件的数据密钥。合并替换文件期间新旧密钥并存，
+// 读取方按文件头部的 keyID 选择
+type keyEnvelope struct {
+	Keys []wrappedKey `json:"keys"`
+}
+
+func (env *keyEnvelope) find(id string) (wrappedKey, bool) {
+	for _, wk := range env.Keys {
+		if wk.ID == id {
+			return wk, true
+		}
+	}
+	return wrappedKey{}, false
+}
+
+// encHeader 文件头部，原始字节作为每一段的附加数据
+type encHeader struct {
+	segSize   int64
+	chunkSize int64
+	fileSize  int64
+	keyID     string
+	raw       []byte
+}
+
+func newEncHeader(up *encUpload) (*encHeader, error) {
+	id, err := hex.DecodeString(up.Key.ID)
+	if err != nil || len(id) != encKeyIDSize {
+		return nil, fmt.Errorf("%w: malformed data key id %q", ErrCorrupted, up.Key.ID)
+	}
+	raw := make([]byte, encHeaderSize)
+	copy(raw, encMagic)
+	binary.BigEndian.PutUint32(raw[4:], uint32(up.SegSize))
+	binary.BigEndian.PutUint64(raw[8:], uint64(up.ChunkSize))
+	binary.BigEndian.PutUint64(raw[16:], uint64(up.FileSize))
+	copy(raw[24:], id)
+	return parseEncHeader(raw)
+}
+
+func parseEncHeader(raw []byte) (*encHeader, error) {
+	if len(raw) != encHeaderSize || string(raw[:4]) != encMagic {
+		return nil, fmt.Errorf("%w: bad header", ErrCorrupted)
+	}
+	h := &encHeader{
+		segSize:   int64(binary.BigEndian.Uint32(raw[4:])),
+		chunkSize: int64(binary.BigEndian.Uint64(raw[8:])),
+		fileSize:  int64(binary.BigEndian.Uint64(raw[16:])),
+		keyID:     hex.EncodeToString(raw[24:40]),
+		raw:       append([]byte(nil), raw...),
+	}
+	if h.segSize <= 0 || h.segSize > encMaxSegment || h.chunkSize <= 0 || h.fileSize < 0 {
+		return nil, fmt.Errorf("%w: bad header", ErrCorrupted)
+	}
+	return h, nil
+}
+
+// chunkLen 第 c 片的明文长度
+func (h *encHeader) chunkLen(c int) int64 {
+	start := int64(c) * h.chunkSize
+	if start+h.chunkSize > h.fileSize {
+		return h.fileSize - start
+	}
+	return h.chunkSize
+}
+
+// segLen 第 c 片第 s 段的明文长度
+func (h *encHeader) segLen(c, s int) int64 {
+	n := h.chunkLen(c) - int64(s)*h.segSize
+	if n > h.segSize {
+		return h.segSize
+	}
+	return n
+}
+
+// next 下一段的位置
+func (h *encHeader) next(c, s int) (int, int) {
+	if int64(s+1)*h.segSize < h.chunkLen(c) {
+		return c, s + 1
+	}
+	return c + 1, 0
+}
+
+// locate 明文偏移 off 所在的段及该段起始的明文偏移
+func (h *encHeader) locate(off int64) (c, s int, segStart int64) {
+	c = int(off / h.chunkSize)
+	s = int(off % h.chunkSize / h.segSize)
+	return c, s, int64(c)*h.chunkSize + int64(s)*h.segSize
+}
+
+// storedOffset 第 c 片第 s 段在存储文件中的偏移
+func (h *encHeader) storedOffset(c, s int) int64 {
+	segs := (h.chunkSize + h.segSize - 1) / h.segSize
+	storedChunk := h.chunkSize + segs*encOverhead
+	return encHeaderSize + int64(c)*storedChunk + int64(s)*(h.segSize+encOverhead)
+}
+
+func (h *encHeader) aad(c, s int) []byte {
+	aad := make([]byte, encHeaderSize+8)
+	copy(aad, h.raw)
+	binary.BigEndian.PutUint32(aad[encHeaderSize:], uint32(c))
+	binary.BigEndian.PutUint32(aad[encHeaderSize+4:], uint32(s))
+	return aad
+}
+
+// openSegment 原地解密一段 nonce || 密文 || tag，返回明文
+func (h *encHeader) openSegment(aead cipher.AEAD, c, s int, record []byte) ([]byte, error) {
+	pt, err := aead.Open(record[encNonceSize:encNonceSize], record[:encNonceSize], record[encNonceSize:], h.aad(c, s))
+	if err != nil {
+		return nil, fmt.Errorf("%w: chunk %d segment %d", ErrCorrupted, c, s)
+	}
+	return pt, nil
+}
+
+// encryptedInner 加密存储包装的后端：需要保存元数据，并允许替换合并校验
+type encryptedInner interface {
+	Uploader
+	MetaStore
+	setContentChecker(contentChecker)
+}
+
+// EncryptedStore 加密存储装饰器。上传必须先调用 Preallocate 确定布局（上传会话建立时调用），
+// 内层存储的预分配因此不再生效。
+type EncryptedStore struct {
+	inner encryptedInner
+	ring  *KeyRing
+
+	mu   sync.Mutex
+	keys map[string]cipher.AEAD // "<hash>/<key id>" -> 解开的数据密钥
+}
+
+// NewEncryptedStore 用 ring 中的主密钥加密 inner 中的内容
+func NewEncryptedStore(inner Uploader, ring *KeyRing) (*EncryptedStore, error) {
+	ei, ok := inner.(encryptedInner)
+	if !ok {
+		return nil, fmt.Errorf("storage backend %T does not support encryption", inner)
+	}
+	s := &EncryptedStore{inner: ei, ring: ring, keys: make(map[string]cipher.AEAD)}
+	ei.setContentChecker(s.newCheck)
+	return s, nil
+}
+
+func uploadMetaName(userID int, hash string) string {
+	return "uploads/" + strconv.Itoa(userID) + "-" + hash
+}
+
+func keyMetaName(hash string) string {
+	return "keys/" + hash
+}
+
+func (s *EncryptedStore) loadUpload(userID int, hash string) (*encUpload, error) {
+	data, err := s.inner.GetMeta(uploadMetaName(userID, hash))
+	if err != nil {
+		if errors.Is(err, ErrMetaNotFound) {
+			return nil, errUploadNotPrepared
+		}
+		return nil, fmt.Errorf("read upload layout failed: %w", err)
+	}
+	var up encUpload
+	if err := json.Unmarshal(data, &up); err != nil {
+		return nil, fmt.Errorf("parse upload layout failed: %w", err)
+	}
+	return &up, nil
+}
+
+func (s *EncryptedStore) loadEnvelope(hash string) (*keyEnvelope, error) {
+	data, err := s.inner.GetMeta(keyMetaName(hash))
+	if err != nil {
+		return nil, err
+	}
+	var env keyEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("parse key envelope failed: %w", err)
+	}
+	return &env, nil
+}
+
+func (s *EncryptedStore) saveEnvelope(hash string, env *keyEnvelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return s.inner.PutMeta(keyMetaName(hash), data)
+}
+
+// openKey 解开数据密钥，结果按 hash 与密钥 id 缓存（密钥内容不会变化）
+func (s *EncryptedStore) openKey(hash string, wk wrappedKey) (cipher.AEAD, error) {
+	cacheKey := hash + "/" + wk.ID
+	s.mu.Lock()
+	aead, ok := s.keys[cacheKey]
+	s.mu.Unlock()
+	if ok {
+		return aead, nil
+	}
+
+	dek, err := s.ring.unwrap(hash, wk)
+	if err != nil {
+		return nil, err
+	}
+	aead, err = newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	if len(s.keys) >= encKeyCacheMax {
+		s.keys = make(map[string]cipher.AEAD)
+	}
+	s.keys[cacheKey] = aead
+	s.mu.Unlock()
+	return aead, nil
+}
+
+// fileKey 已合并文件头部 keyID 对应的数据密钥。密钥缺失（已删除）视为内容损坏。
+func (s *EncryptedStore) fileKey(hash, id string) (cipher.AEAD, error) {
+	s.mu.Lock()
+	aead, ok := s.keys[hash+"/"+id]
+	s.mu.Unlock()
+	if ok {
+		return aead, nil
+	}
+
+	env, err := s.loadEnvelope(hash)
+	if err != nil {
+		if errors.Is(err, ErrMetaNotFound) {
+			return nil, fmt.Errorf("%w: no data key for %s", ErrCorrupted, hash)
+		}
+		return nil, fmt.Errorf("read key envelope failed: %w", err)
+	}
+	wk, ok := env.find(id)
+	if !ok {
+		return nil, fmt.Errorf("%w: data key %s of %s not found", ErrCorrupted, id, hash)
+	}
+	return s.openKey(hash, wk)
+}
+
+func (s *EncryptedStore) forgetKeys(hash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.keys {
+		if strings.HasPrefix(k, hash+"/") {
+			delete(s.keys, k)
+		}
+	}
+}
+
+// Preallocate 生成数据密钥并记录上传布局，布局相同时保留已上传的分片。
+// chunkSize 不超过 fileSize（单片上传时即为文件大小）。
+func (s *EncryptedStore) Preallocate(userID int, hash string, fileSize, chunkSize int64) error {
+	if chunkSize <= 0 || chunkSize > fileSize {
+		chunkSize = fileSize
+	}
+	if chunkSize <= 0 {
+		chunkSize = 1
+	}
+
+	up, err := s.loadUpload(userID, hash)
+	switch {
+	case err == nil && up.FileSize == fileSize && up.ChunkSize == chunkSize:
+		return nil
+	case err != nil && !errors.Is(err, errUploadNotPrepared):
+		return err
+	}
+	// 旧布局（或未加密时）写入的分片无法按新布局读取
+	if err := s.inner.CleanupChunks(userID, hash); err != nil {
+		return fmt.Errorf("cleanup chunks failed: %w", err)
+	}
+
+	id := make([]byte, encKeyIDSize)
+	dek := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	if _, err := rand.Read(dek); err != nil {
+		return err
+	}
+	wk, err := s.ring.wrap(hash, hex.EncodeToString(id), dek)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&encUpload{FileSize: fileSize, ChunkSize: chunkSize, SegSize: encSegmentSize, Key: wk})
+	if err != nil {
+		return err
+	}
+	if err := s.inner.PutMeta(uploadMetaName(userID, hash), data); err != nil {
+		return fmt.Errorf("write upload layout failed: %w", err)
+	}
+	return nil
+}
+
+// WriteChunk 加密后写入内层存储。checksum 针对明文，在加密过程中校验，
+// 不一致时内层写入失败并丢弃分片。
+func (s *EncryptedStore) WriteChunk(userID int, hash string, index int, content io.Reader, checksum *ChunkChecksum) error {
+	up, err := s.loadUpload(userID, hash)
+	if err != nil {
+		return err
+	}
+	hdr, err := newEncHeader(up)
+	if err != nil {
+		return err
+	}
+	if index < 0 || (index > 0 && int64(index)*hdr.chunkSize >= hdr.fileSize) {
+		return fmt.Errorf("chunk index %d out of range for file size %d", index, hdr.fileSize)
+	}
+	aead, err := s.openKey(hash, up.Key)
+	if err != nil {
+		return err
+	}
+
+	er := &encryptReader{
+		r:        content,
+		hdr:      hdr,
+		aead:     aead,
+		chunk:    index,
+		left:     hdr.chunkLen(index),
+		checksum: checksum,
+	}
+	_, er.digest = chunkDigest(checksum)
+	if index == 0 {
+		er.out = append(er.out, hdr.raw...)
+	}
+	return s.inner.WriteChunk(userID, hash, index, er, nil)
+}
+
+// encryptReader 按段读取明文并输出加密后的分片
+type encryptReader struct {
+	r        io.Reader
+	hdr      *encHeader
+	aead     cipher.AEAD
+	chunk    int
+	seg      int
+	left     int64
+	checksum *ChunkChecksum
+	digest   hash.Hash
+	out      []byte
+	done     bool
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		if err := e.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+func (e *encryptReader) fill() error {
+	if e.left == 0 {
+		// 分片长度由布局决定，多余的数据说明客户端与会话不一致
+		var extra [1]byte
+		if n, _ := io.ReadFull(e.r, extra[:]); n > 0 {
+			return fmt.Errorf("chunk %d is longer than %d bytes", e.chunk, e.hdr.chunkLen(e.chunk))
+		}
+		if err := verifyChunk(e.chunk, e.checksum, e.digest); err != nil {
+			return err
+		}
+		e.done = true
+		return nil
+	}
+
+	n := e.hdr.segLen(e.chunk, e.seg)
+	buf := make([]byte, n+encOverhead)
+	pt := buf[encNonceSize : encNonceSize+n]
+	if _, err := io.ReadFull(e.r, pt); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("chunk %d is shorter than %d bytes", e.chunk, e.hdr.chunkLen(e.chunk))
+		}
+		return err
+	}
+	e.digest.Write(pt)
+	if _, err := rand.Read(buf[:encNonceSize]); err != nil {
+		return err
+	}
+	e.aead.Seal(pt[:0], buf[:encNonceSize], pt, e.hdr.aad(e.chunk, e.seg))
+	e.out = buf
+	e.left -= n
+	e.seg++
+	return nil
+}
+
+// MinChunkSize 内层存储的分片下限（密文分片比明文略大）
+func (s *EncryptedStore) MinChunkSize() int64 {
+	if l, ok := s.inner.(ChunkSizeLimiter); ok {
+		return l.MinChunkSize()
+	}
+	return 0
+}
+
+// GetUploadedChunks 已上传的分片
+func (s *EncryptedStore) GetUploadedChunks(userID int, hash string) ([]int, error) {
+	return s.inner.GetUploadedChunks(userID, hash)
+}
+
+// MergeChunks 合并分片。数据密钥先登记到 keys/<hash>（与已有文件的密钥并存），
+// 内层合并时逐段解密校验，成功后只保留新密钥。同一 hash 的合并由调用方互斥。
+func (s *EncryptedStore) MergeChunks(userID int, hash string, totalChunks int, expectedSize int64, strongHash string) (string, int64, error) {
+	up, err := s.loadUpload(userID, hash)
+	if err != nil {
+		return "", 0, err
+	}
+	if up.FileSize != expectedSize {
+		return "", 0, &IntegrityError{
+			Field:    "size",
+			Expected: strconv.FormatInt(expectedSize, 10),
+			Actual:   strconv.FormatInt(up.FileSize, 10),
+		}
+	}
+
+	// 合并后的密钥用当前主密钥包装，轮换前开始的上传也不再依赖旧主密钥
+	dek, err := s.ring.unwrap(hash, up.Key)
+	if err != nil {
+		return "", 0, err
+	}
+	wk, err := s.ring.wrap(hash, up.Key.ID, dek)
+	if err != nil {
+		return "", 0, err
+	}
+
+	prev, err := s.loadEnvelope(hash)
+	if err != nil && !errors.Is(err, ErrMetaNotFound) {
+		return "", 0, fmt.Errorf("read key envelope failed: %w", err)
+	}
+	merged := &keyEnvelope{Keys: []wrappedKey{wk}}
+	if prev != nil {
+		for _, k := range prev.Keys {
+			if k.ID != wk.ID {
+				merged.Keys = append(merged.Keys, k)
+			}
+		}
+	}
+	if err := s.saveEnvelope(hash, merged); err != nil {
+		return "", 0, fmt.Errorf("write key envelope failed: %w", err)
+	}
+
+	filePath, _, err := s.inner.MergeChunks(userID, hash, totalChunks, expectedSize, strongHash)
+	if err != nil {
+		// 已有文件仍使用原来的密钥
+		if prev != nil {
+			_ = s.saveEnvelope(hash, prev)
+		} else {
+			_ = s.inner.DeleteMeta(keyMetaName(hash))
+		}
+		var collision *CollisionError
+		if !errors.As(err, &collision) {
+			return "", 0, err
+		}
+		// md5 碰撞：密文已改存在强摘要下，数据密钥按强摘要重新包装
+		cwk, err := s.ring.wrap(collision.FileHash, up.Key.ID, dek)
+		if err != nil {
+			return "", 0, err
+		}
+		if err := s.saveEnvelope(collision.FileHash, &keyEnvelope{Keys: []wrappedKey{cwk}}); err != nil {
+			return "", 0, fmt.Errorf("write key envelope failed: %w", err)
+		}
+		_ = s.inner.DeleteMeta(uploadMetaName(userID, hash))
+		return "", 0, &CollisionError{FileHash: collision.FileHash, FilePath: collision.FilePath, FileSize: expectedSize}
+	}
+
+	if err := s.saveEnvelope(hash, &keyEnvelope{Keys: []wrappedKey{wk}}); err != nil {
+		return "", 0, fmt.Errorf("write key envelope failed: %w", err)
+	}
+	_ = s.inner.DeleteMeta(uploadMetaName(userID, hash))
+	return filePath, expectedSize, nil
+}
+
+// CleanupChunks 清理分片与上传布局
+func (s *EncryptedStore) CleanupChunks(userID int, hash string) error {
+	if err := s.inner.DeleteMeta(uploadMetaName(userID, hash)); err != nil {
+		return err
+	}
+	return s.inner.CleanupChunks(userID, hash)
+}
+
+// readHeader 读取并解析文件头部
+func (s *EncryptedStore) readHeader(hash string) (*encHeader, error) {
+	rc, err := s.inner.GetFileRange(hash, 0, encHeaderSize-1)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	raw := make([]byte, encHeaderSize)
+	if _, err := io.ReadFull(rc, raw); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%w: truncated header", ErrCorrupted)
+		}
+		return nil, err
+	}
+	return parseEncHeader(raw)
+}
+
+// GetFile 返回解密后的内容与明文大小，读到末尾时确认没有被截断或追加
+func (s *EncryptedStore) GetFile(hash string) (io.ReadCloser, int64, error) {
+	rc, _, err := s.inner.GetFile(hash)
+	if err != nil {
+		return nil, 0, err
+	}
+	raw := make([]byte, encHeaderSize)
+	if _, err := io.ReadFull(rc, raw); err != nil {
+		rc.Close()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, 0, fmt.Errorf("%w: truncated header", ErrCorrupted)
+		}
+		return nil, 0, err
+	}
+	hdr, err := parseEncHeader(raw)
+	if err != nil {
+		rc.Close()
+		return nil, 0, err
+	}
+	aead, err := s.fileKey(hash, hdr.keyID)
+	if err != nil {
+		rc.Close()
+		return nil, 0, err
+	}
+	return &decryptReader{rc: rc, hdr: hdr, aead: aead, left: hdr.fileSize, whole: true}, hdr.fileSize, nil
+}
+
+// GetFileRange 返回明文闭区间 [start, end]，只读取并解密涉及的段
+func (s *EncryptedStore) GetFileRange(hash string, start, end int64) (io.ReadCloser, error) {
+	hdr, err := s.readHeader(hash)
+	if err != nil {
+		return nil, err
+	}
+	if end >= hdr.fileSize {
+		end = hdr.fileSize - 1
+	}
+	if start < 0 || start > end {
+		return nil, fmt.Errorf("range %d-%d out of bounds for size %d", start, end, hdr.fileSize)
+	}
+	aead, err := s.fileKey(hash, hdr.keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	c, seg, segStart := hdr.locate(start)
+	lastC, lastS, _ := hdr.locate(end)
+	storedEnd := hdr.storedOffset(lastC, lastS) + hdr.segLen(lastC, lastS) + encOverhead - 1
+	rc, err := s.inner.GetFileRange(hash, hdr.storedOffset(c, seg), storedEnd)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		rc:    rc,
+		hdr:   hdr,
+		aead:  aead,
+		chunk: c,
+		seg:   seg,
+		skip:  start - segStart,
+		left:  end - start + 1,
+	}, nil
+}
+
+// decryptReader 从某一段开始逐段解密，跳过 skip 字节后输出 left 字节
+type decryptReader struct {
+	rc    io.ReadCloser
+	hdr   *encHeader
+	aead  cipher.AEAD
+	chunk int
+	seg   int
+	skip  int64
+	left  int64
+	whole bool // 读取整个文件：结束时确认没有多余数据
+	buf   []byte
+	out   []byte
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.left == 0 {
+			if d.whole {
+				var extra [1]byte
+				if n, _ := io.ReadFull(d.rc, extra[:]); n > 0 {
+					return 0, fmt.Errorf("%w: trailing data", ErrCorrupted)
+				}
+				d.whole = false
+			}
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+func (d *decryptReader) next() error {
+	n := d.hdr.segLen(d.chunk, d.seg)
+	if cap(d.buf) < int(d.hdr.segSize)+encOverhead {
+		d.buf = make([]byte, d.hdr.segSize+encOverhead)
+	}
+	record := d.buf[:n+encOverhead]
+	if _, err := io.ReadFull(d.rc, record); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("%w: truncated at chunk %d segment %d", ErrCorrupted, d.chunk, d.seg)
+		}
+		return err
+	}
+	pt, err := d.hdr.openSegment(d.aead, d.chunk, d.seg, record)
+	if err != nil {
+		return err
+	}
+	pt = pt[d.skip:]
+	d.skip = 0
+	if int64(len(pt)) > d.left {
+		pt = pt[:d.left]
+	}
+	d.left -= int64(len(pt))
+	d.out = pt
+	d.chunk, d.seg = d.hdr.next(d.chunk, d.seg)
+	return nil
+}
+
+func (d *decryptReader) Close() error {
+	return d.rc.Close()
+}
+
+// DeleteFile 删除文件及其数据密钥。密钥删除后即使存储中残留副本也无法解密。
+func (s *EncryptedStore) DeleteFile(hash string) error {
+	if err := s.inner.DeleteFile(hash); err != nil {
+		return err
+	}
+	s.forgetKeys(hash)
+	return s.inner.DeleteMeta(keyMetaName(hash))
+}
+
+// FileExists 检查文件是否存在
+func (s *EncryptedStore) FileExists(hash string) bool {
+	return s.inner.FileExists(hash)
+}
+
+// ListFiles 列出已合并的文件（大小为存储中的密文大小）
+func (s *EncryptedStore) ListFiles() ([]StoredFile, error) {
+	lister, ok := s.inner.(Lister)
+	if !ok {
+		return nil, fmt.Errorf("storage backend %T cannot list files", s.inner)
+	}
+	return lister.ListFiles()
+}
+
+// ListUploads 列出上传中的分片数据
+func (s *EncryptedStore) ListUploads() ([]PendingUpload, error) {
+	lister, ok := s.inner.(Lister)
+	if !ok {
+		return nil, fmt.Errorf("storage backend %T cannot list uploads", s.inner)
+	}
+	return lister.ListUploads()
+}
+
+// layoutMigrator 内层存储的目录布局迁移（只移动文件，不涉及内容）
+func (s *EncryptedStore) layoutMigrator() (LayoutMigrator, error) {
+	m, ok := s.inner.(LayoutMigrator)
+	if !ok {
+		return nil, fmt.Errorf("storage backend %T does not support directory layouts", s.inner)
+	}
+	return m, nil
+}
+
+// MisplacedFiles 列出不在当前布局位置上的文件
+func (s *EncryptedStore) MisplacedFiles() ([]string, error) {
+	m, err := s.layoutMigrator()
+	if err != nil {
+		return nil, err
+	}
+	return m.MisplacedFiles()
+}
+
+// MigrateFile 把文件移动到当前布局位置
+func (s *EncryptedStore) MigrateFile(hash string) (string, error) {
+	m, err := s.layoutMigrator()
+	if err != nil {
+		return "", err
+	}
+	return m.MigrateFile(hash)
+}
+
+// FilePath 文件在当前布局下的路径
+func (s *EncryptedStore) FilePath(hash string) string {
+	if m, err := s.layoutMigrator(); err == nil {
+		return m.FilePath(hash)
+	}
+	return ""
+}
+
+// replicaRepairer 内层存储的副本修复（按密文校验与复制）
+func (s *EncryptedStore) replicaRepairer() (ReplicaRepairer, error) {
+	r, ok := s.inner.(ReplicaRepairer)
+	if !ok {
+		return nil, fmt.Errorf("storage backend %T does not keep replicas", s.inner)
+	}
+	return r, nil
+}
+
+// RepairFile 补齐文件的副本
+func (s *EncryptedStore) RepairFile(hash string, verify bool) (*ReplicaRepair, error) {
+	r, err := s.replicaRepairer()
+	if err != nil {
+		return nil, err
+	}
+	return r.RepairFile(hash, verify)
+}
+
+// RepairMeta 补齐元数据（含数据密钥）的副本
+func (s *EncryptedStore) RepairMeta() (int, error) {
+	r, err := s.replicaRepairer()
+	if err != nil {
+		return 0, err
+	}
+	return r.RepairMeta()
+}
+
+// SetCorruptionHandler 内层存储读取时发现副本损坏后回调
+func (s *EncryptedStore) SetCorruptionHandler(fn func(hash string)) {
+	if r, ok := s.inner.(CorruptionReporter); ok {
+		r.SetCorruptionHandler(fn)
+	}
+}
+
+// KeyRotator 可选接口：更换主密钥后用当前主密钥重新包装数据密钥，不改写文件内容
+type KeyRotator interface {
+	// KeyedFiles 列出保存了数据密钥的文件 hash
+	KeyedFiles() ([]string, error)
+	// RewrapFileKey 重新包装文件的数据密钥，已是当前主密钥时返回 false
+	RewrapFileKey(hash string) (bool, error)
+}
+
+// KeyedFiles 列出 keys/ 下的文件 hash
+func (s *EncryptedStore) KeyedFiles() ([]string, error) {
+	names, err := s.inner.ListMeta("keys/")
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(names))
+	for _, name := range names {
+		hashes = append(hashes, strings.TrimPrefix(name, "keys/"))
+	}
+	return hashes, nil
+}
+
+// RewrapFileKey 用当前主密钥重新包装文件的数据密钥。调用方需与同一 hash 的合并互斥。
+func (s *EncryptedStore) RewrapFileKey(hash string) (bool, error) {
+	env, err := s.loadEnvelope(hash)
+	if err != nil {
+		if errors.Is(err, ErrMetaNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	changed := false
+	for i, wk := range env.Keys {
+		if wk.KID == s.ring.primary {
+			continue
+		}
+		dek, err := s.ring.unwrap(hash, wk)
+		if err != nil {
+			return false, err
+		}
+		if env.Keys[i], err = s.ring.wrap(hash, wk.ID, dek); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	if !changed {
+		return false, nil
+	}
+	return true, s.saveEnvelope(hash, env)
+}
+
+// newCheck 内层合并时的校验：逐段解密，比较明文的摘要与大小
+func (s *EncryptedStore) newCheck(fileHash string, expectedSize int64, strongHash string) contentCheck {
+	return &decryptCheck{store: s, fileHash: fileHash, expectedSize: expectedSize, digest: newContentDigest(fileHash, strongHash)}
+}
+
+// decryptCheck 以写入方式接收合并后的密文，按段解密。
+// 解密失败只记录下来，由 Verify 报告为 ErrIntegrity。
+type decryptCheck struct {
+	store        *EncryptedStore
+	fileHash     string
+	expectedSize int64
+
+	hdr   *encHeader
+	aead  cipher.AEAD
+	chunk int
+	seg   int
+	size  int64
+	buf   []byte
+	err   error
+
+	digest *contentDigest
+}
+
+func (c *decryptCheck) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return len(p), nil
+	}
+	c.buf = append(c.buf, p...)
+	if c.hdr == nil {
+		if len(c.buf) < encHeaderSize {
+			return len(p), nil
+		}
+		if c.hdr, c.err = parseEncHeader(c.buf[:encHeaderSize]); c.err != nil {
+			return len(p), nil
+		}
+		if c.aead, c.err = c.store.fileKey(c.fileHash, c.hdr.keyID); c.err != nil {
+			return len(p), nil
+		}
+		c.buf = c.buf[encHeaderSize:]
+	}
+
+	off := 0
+	for c.size < c.hdr.fileSize {
+		n := int(c.hdr.segLen(c.chunk, c.seg)) + encOverhead
+		if len(c.buf)-off < n {
+			break
+		}
+		pt, err := c.hdr.openSegment(c.aead, c.chunk, c.seg, c.buf[off:off+n])
+		if err != nil {
+			c.err = err
+			return len(p), nil
+		}
+		c.digest.Write(pt)
+		c.size += int64(len(pt))
+		off += n
+		c.chunk, c.seg = c.hdr.next(c.chunk, c.seg)
+	}
+	c.buf = append(c.buf[:0], c.buf[off:]...)
+	if c.size == c.hdr.fileSize && len(c.buf) > 0 {
+		c.err = fmt.Errorf("%w: trailing data", ErrCorrupted)
+	}
+	return len(p), nil
+}
+
+func (c *decryptCheck) Verify(written int64) error {
+	if c.err == nil && (c.hdr == nil || c.size < c.hdr.fileSize || len(c.buf) > 0) {
+		c.err = fmt.Errorf("%w: truncated", ErrCorrupted)
+	}
+	if c.err != nil {
+		return fmt.Errorf("%w: %v", ErrIntegrity, c.err)
+	}
+	return c.digest.verify(c.expectedSize, c.size)
+}
@@ -0,0 +1,417 @@
+package store
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 测试布局：分片 100KiB（两段：64KiB + 36KiB），文件 250KiB（最后一片 50KiB）
+const (
+	testEncChunk = 100 << 10
+	testEncSize  = 250 << 10
+)
+
+// testMasterKey 随机主密钥，格式同 STORAGE_ENCRYPTION_KEYS 的一项
+func testMasterKey(t *testing.T, id string) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("rand: %v", err)
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(key)
+}
+
+// newTestEncrypted 以 keys 为主密钥加密 inner
+func newTestEncrypted(t *testing.T, inner *LocalStore, keys string) *EncryptedStore {
+	t.Helper()
+	ring, err := ParseKeyRing(keys)
+	if err != nil {
+		t.Fatalf("ParseKeyRing: %v", err)
+	}
+	s, err := NewEncryptedStore(inner, ring)
+	if err != nil {
+		t.Fatalf("NewEncryptedStore: %v", err)
+	}
+	return s
+}
+
+// newTestLocal 临时目录下的本地存储
+func newTestLocal(t *testing.T) *LocalStore {
+	t.Helper()
+	dir := t.TempDir()
+	return NewLocalStore(filepath.Join(dir, "files"), filepath.Join(dir, "tmp"))
+}
+
+// testContent 长度为 n、各处内容不同的数据
+func testContent(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*31 + i>>8)
+	}
+	return data
+}
+
+// writeChunks 按 chunkSize 切分 data 写入并合并
+func writeChunks(t *testing.T, s Uploader, hash string, data []byte, chunkSize int) {
+	t.Helper()
+	if p, ok := s.(Preallocator); ok {
+		if err := p.Preallocate(1, hash, int64(len(data)), int64(chunkSize)); err != nil {
+			t.Fatalf("Preallocate: %v", err)
+		}
+	}
+	total := 0
+	for off := 0; off < len(data) || total == 0; off += chunkSize {
+		end := off + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := s.WriteChunk(1, hash, total, bytes.NewReader(data[off:end]), nil); err != nil {
+			t.Fatalf("WriteChunk %d: %v", total, err)
+		}
+		total++
+	}
+	if _, _, err := s.MergeChunks(1, hash, total, int64(len(data)), ""); err != nil {
+		t.Fatalf("MergeChunks: %v", err)
+	}
+}
+
+// storeEncrypted 以测试布局写入一个加密文件
+func storeEncrypted(t *testing.T, s *EncryptedStore) ([]byte, string) {
+	t.Helper()
+	data := testContent(testEncSize)
+	hash := testHash(data)
+	writeChunks(t, s, hash, data, testEncChunk)
+	return data, hash
+}
+
+// readRange 读取明文闭区间
+func readRange(s Uploader, hash string, start, end int64) ([]byte, error) {
+	rc, err := s.GetFileRange(hash, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// readWhole 读取整个文件
+func readWhole(s Uploader, hash string) ([]byte, error) {
+	rc, _, err := s.GetFile(hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func TestEncHeaderLayout(t *testing.T) {
+	up := &encUpload{FileSize: testEncSize, ChunkSize: testEncChunk, SegSize: encSegmentSize, Key: wrappedKey{ID: "00112233445566778899aabbccddeeff"}}
+	h, err := newEncHeader(up)
+	if err != nil {
+		t.Fatalf("newEncHeader: %v", err)
+	}
+	if string(h.raw[:4]) != "VPE1" ||
+		binary.BigEndian.Uint32(h.raw[4:]) != encSegmentSize ||
+		binary.BigEndian.Uint64(h.raw[8:]) != testEncChunk ||
+		binary.BigEndian.Uint64(h.raw[16:]) != testEncSize ||
+		h.keyID != up.Key.ID {
+		t.Fatalf("header = %x", h.raw)
+	}
+
+	// 附加数据：头部 || 分片序号 || 段序号
+	aad := h.aad(2, 1)
+	if !bytes.Equal(aad[:encHeaderSize], h.raw) || binary.BigEndian.Uint32(aad[encHeaderSize:]) != 2 || binary.BigEndian.Uint32(aad[encHeaderSize+4:]) != 1 {
+		t.Fatalf("aad = %x", aad)
+	}
+
+	const seg = encSegmentSize
+	const storedChunk = testEncChunk + 2*encOverhead
+	for _, tc := range []struct {
+		off      int64
+		c, s     int
+		segStart int64
+		stored   int64
+	}{
+		{0, 0, 0, 0, encHeaderSize},
+		{seg - 1, 0, 0, 0, encHeaderSize},
+		{seg, 0, 1, seg, encHeaderSize + seg + encOverhead},
+		{testEncChunk - 1, 0, 1, seg, encHeaderSize + seg + encOverhead},
+		{testEncChunk, 1, 0, testEncChunk, encHeaderSize + storedChunk},
+		{testEncChunk + seg + 5, 1, 1, testEncChunk + seg, encHeaderSize + storedChunk + seg + encOverhead},
+		{2 * testEncChunk, 2, 0, 2 * testEncChunk, encHeaderSize + 2*storedChunk},
+		{testEncSize - 1, 2, 0, 2 * testEncChunk, encHeaderSize + 2*storedChunk},
+	} {
+		c, s, segStart := h.locate(tc.off)
+		if c != tc.c || s != tc.s || segStart != tc.segStart {
+			t.Errorf("locate(%d) = %d, %d, %d; want %d, %d, %d", tc.off, c, s, segStart, tc.c, tc.s, tc.segStart)
+		}
+		if got := h.storedOffset(c, s); got != tc.stored {
+			t.Errorf("storedOffset(%d, %d) = %d, want %d", c, s, got, tc.stored)
+		}
+	}
+	if h.chunkLen(2) != 50<<10 || h.segLen(0, 1) != testEncChunk-seg || h.segLen(2, 0) != 50<<10 {
+		t.Fatalf("chunkLen(2) = %d, segLen(0,1) = %d, segLen(2,0) = %d", h.chunkLen(2), h.segLen(0, 1), h.segLen(2, 0))
+	}
+
+	parsed, err := parseEncHeader(h.raw)
+	if err != nil || parsed.fileSize != testEncSize || parsed.chunkSize != testEncChunk || parsed.segSize != encSegmentSize {
+		t.Fatalf("parseEncHeader = %+v, %v", parsed, err)
+	}
+	for name, mutate := range map[string]func([]byte){
+		"magic":    func(b []byte) { b[0] = 'X' },
+		"segment":  func(b []byte) { binary.BigEndian.PutUint32(b[4:], 0) },
+		"too long": func(b []byte) { binary.BigEndian.PutUint32(b[4:], encMaxSegment+1) },
+		"chunk":    func(b []byte) { binary.BigEndian.PutUint64(b[8:], 0) },
+	} {
+		raw := append([]byte(nil), h.raw...)
+		mutate(raw)
+		if _, err := parseEncHeader(raw); !errors.Is(err, ErrCorrupted) {
+			t.Errorf("%s: parseEncHeader = %v, want ErrCorrupted", name, err)
+		}
+	}
+}
+
+func TestEncryptedStoredSize(t *testing.T) {
+	inner := newTestLocal(t)
+	s := newTestEncrypted(t, inner, testMasterKey(t, "k1"))
+	_, hash := storeEncrypted(t, s)
+
+	fi, err := os.Stat(inner.getFilePath(hash))
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	hdr, err := s.readHeader(hash)
+	if err != nil {
+		t.Fatalf("readHeader: %v", err)
+	}
+	// 最后一段之后就是文件末尾
+	want := hdr.storedOffset(2, 0) + hdr.segLen(2, 0) + encOverhead
+	if fi.Size() != want {
+		t.Fatalf("stored size = %d, want %d", fi.Size(), want)
+	}
+}
+
+func TestEncryptedRanges(t *testing.T) {
+	s := newTestEncrypted(t, newTestLocal(t), testMasterKey(t, "k1"))
+	data, hash := storeEncrypted(t, s)
+
+	got, err := readWhole(s, hash)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("GetFile = %d bytes, %v", len(got), err)
+	}
+
+	const seg = encSegmentSize
+	for _, tc := range []struct {
+		name       string
+		start, end int64
+	}{
+		{"first byte", 0, 0},
+		{"within segment", 100, 200},
+		{"segment boundary", seg - 2, seg + 2},
+		{"chunk boundary", testEncChunk - 2, testEncChunk + 2},
+		{"several chunks", seg + 7, 2*testEncChunk + 9},
+		{"last short chunk", 2*testEncChunk + 1, testEncSize - 1},
+		{"last byte", testEncSize - 1, testEncSize - 1},
+		{"end past size", testEncSize - 10, testEncSize + 100},
+		{"whole file", 0, testEncSize - 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			end := tc.end
+			if end >= testEncSize {
+				end = testEncSize - 1
+			}
+			got, err := readRange(s, hash, tc.start, tc.end)
+			if err != nil || !bytes.Equal(got, data[tc.start:end+1]) {
+				t.Fatalf("range %d-%d = %d bytes, %v", tc.start, tc.end, len(got), err)
+			}
+		})
+	}
+	if _, err := readRange(s, hash, testEncSize, testEncSize+1); err == nil {
+		t.Fatal("range past the end accepted")
+	}
+}
+
+func TestEncryptedDetectsTampering(t *testing.T) {
+	const seg = encSegmentSize
+	for _, tc := range []struct {
+		name   string
+		mutate func(h *encHeader, b []byte) []byte
+		// 损坏之外、仍应可读的范围与涉及损坏的范围（为空时不检查）
+		intact, broken []int64
+	}{
+		{
+			name: "flipped ciphertext",
+			mutate: func(h *encHeader, b []byte) []byte {
+				b[h.storedOffset(1, 1)+encNonceSize+3] ^= 1
+				return b
+			},
+			intact: []int64{0, testEncChunk + seg - 1},
+			broken: []int64{testEncChunk + seg, testEncChunk + seg},
+		},
+		{
+			name: "truncated",
+			mutate: func(h *encHeader, b []byte) []byte {
+				return b[:len(b)-10]
+			},
+			intact: []int64{0, 2*testEncChunk - 1},
+			broken: []int64{testEncSize - 1, testEncSize - 1},
+		},
+		{
+			name: "trailing data",
+			mutate: func(h *encHeader, b []byte) []byte {
+				return append(b, 0)
+			},
+		},
+		{
+			name: "reordered segments",
+			mutate: func(h *encHeader, b []byte) []byte {
+				// 两片的第一段长度相同，互换位置
+				a, c := h.storedOffset(0, 0), h.storedOffset(1, 0)
+				n := seg + encOverhead
+				tmp := append([]byte(nil), b[a:a+int64(n)]...)
+				copy(b[a:], b[c:c+int64(n)])
+				copy(b[c:], tmp)
+				return b
+			},
+			intact: []int64{seg, testEncChunk - 1},
+			broken: []int64{0, 0},
+		},
+		{
+			name: "header",
+			mutate: func(h *encHeader, b []byte) []byte {
+				// 改小文件长度：头部是每段附加数据的一部分
+				binary.BigEndian.PutUint64(b[16:], testEncSize-1)
+				return b
+			},
+			broken: []int64{0, 0},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inner := newTestLocal(t)
+			s := newTestEncrypted(t, inner, testMasterKey(t, "k1"))
+			data, hash := storeEncrypted(t, s)
+			hdr, err := s.readHeader(hash)
+			if err != nil {
+				t.Fatalf("readHeader: %v", err)
+			}
+			path := inner.getFilePath(hash)
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read stored file: %v", err)
+			}
+			if err := os.WriteFile(path, tc.mutate(hdr, raw), 0644); err != nil {
+				t.Fatalf("write stored file: %v", err)
+			}
+
+			if _, err := readWhole(s, hash); !errors.Is(err, ErrCorrupted) {
+				t.Fatalf("GetFile err = %v, want ErrCorrupted", err)
+			}
+			if tc.intact != nil {
+				got, err := readRange(s, hash, tc.intact[0], tc.intact[1])
+				if err != nil || !bytes.Equal(got, data[tc.intact[0]:tc.intact[1]+1]) {
+					t.Fatalf("intact range %v = %d bytes, %v", tc.intact, len(got), err)
+				}
+			}
+			if tc.broken != nil {
+				if _, err := readRange(s, hash, tc.broken[0], tc.broken[1]); !errors.Is(err, ErrCorrupted) {
+					t.Fatalf("range %v err = %v, want ErrCorrupted", tc.broken, err)
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptedKeyRotation(t *testing.T) {
+	inner := newTestLocal(t)
+	k1, k2 := testMasterKey(t, "k1"), testMasterKey(t, "k2")
+	old := newTestEncrypted(t, inner, k1)
+	data, hash := storeEncrypted(t, old)
+
+	// 只有新主密钥时无法解开旧的数据密钥
+	if _, err := readWhole(newTestEncrypted(t, inner, k2), hash); !errors.Is(err, ErrUnknownMasterKey) {
+		t.Fatalf("read with k2 only = %v, want ErrUnknownMasterKey", err)
+	}
+
+	// 轮换后旧主密钥仍在时照常读取
+	rotated := newTestEncrypted(t, inner, k2+","+k1)
+	if got, err := readWhole(rotated, hash); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read after rotation = %d bytes, %v", len(got), err)
+	}
+
+	changed, err := rotated.RewrapFileKey(hash)
+	if err != nil || !changed {
+		t.Fatalf("RewrapFileKey = %v, %v; want rewrapped", changed, err)
+	}
+	if changed, err := rotated.RewrapFileKey(hash); err != nil || changed {
+		t.Fatalf("second RewrapFileKey = %v, %v; want unchanged", changed, err)
+	}
+	env, err := rotated.loadEnvelope(hash)
+	if err != nil || len(env.Keys) != 1 || env.Keys[0].KID != "k2" {
+		t.Fatalf("envelope = %+v, %v; want one key wrapped by k2", env, err)
+	}
+
+	// 重新包装后去掉旧主密钥仍可读取，密文没有改写
+	if got, err := readRange(newTestEncrypted(t, inner, k2), hash, testEncChunk-5, testEncChunk+5); err != nil || !bytes.Equal(got, data[testEncChunk-5:testEncChunk+6]) {
+		t.Fatalf("range read with k2 only = %d bytes, %v", len(got), err)
+	}
+}
+
+func TestEncryptedMergeAfterRotationUsesPrimary(t *testing.T) {
+	inner := newTestLocal(t)
+	k1, k2 := testMasterKey(t, "k1"), testMasterKey(t, "k2")
+	data := testContent(testEncSize)
+	hash := testHash(data)
+
+	// 上传在轮换前开始，轮换后合并
+	before := newTestEncrypted(t, inner, k1)
+	if err := before.Preallocate(1, hash, int64(len(data)), testEncChunk); err != nil {
+		t.Fatalf("Preallocate: %v", err)
+	}
+	after := newTestEncrypted(t, inner, k2+","+k1)
+	writeChunks(t, after, hash, data, testEncChunk)
+
+	env, err := after.loadEnvelope(hash)
+	if err != nil || len(env.Keys) != 1 || env.Keys[0].KID != "k2" {
+		t.Fatalf("envelope = %+v, %v; want one key wrapped by k2", env, err)
+	}
+	if got, err := readWhole(newTestEncrypted(t, inner, k2), hash); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read with k2 only = %d bytes, %v", len(got), err)
+	}
+}
+
+func TestEncryptedLinkFileRewrapsKey(t *testing.T) {
+	inner := newTestLocal(t)
+	s := newTestEncrypted(t, inner, testMasterKey(t, "k1"))
+	data, hash := storeEncrypted(t, s)
+	newHash := FormatFileHash(HashMD5, make([]byte, 16))
+
+	if _, err := s.LinkFile(hash, newHash); err != nil {
+		t.Fatalf("LinkFile: %v", err)
+	}
+	// 数据密钥的包装绑定了 hash：原样复制的信封不能用于新 hash
+	oldEnv, _ := s.loadEnvelope(hash)
+	newEnv, err := s.loadEnvelope(newHash)
+	if err != nil || len(newEnv.Keys) != 1 || newEnv.Keys[0].ID != oldEnv.Keys[0].ID || bytes.Equal(newEnv.Keys[0].Key, oldEnv.Keys[0].Key) {
+		t.Fatalf("linked envelope = %+v, %v; want the same data key rewrapped", newEnv, err)
+	}
+	if _, err := s.ring.unwrap(newHash, oldEnv.Keys[0]); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("unwrap old envelope for new hash = %v, want ErrCorrupted", err)
+	}
+
+	if err := s.DeleteFile(hash); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	if got, err := readWhole(s, newHash); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read linked file = %d bytes, %v", len(got), err)
+	}
+	if _, err := readWhole(s, hash); err == nil {
+		t.Fatal("deleted file still readable")
+	}
+}
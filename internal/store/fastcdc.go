@@ -0,0 +1,136 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// FastCDC 内容定义分块：块边界由内容决定（滚动 gear 哈希命中掩码处切分），
+// 文件中间插入或删除数据只影响附近的块，剪辑、重新封装后的视频仍能复用大部分块。
+// 平均块大小之前使用位数更多的掩码、之后使用位数更少的掩码（归一化分块），块大小更集中。
+
+// 块大小范围
+const (
+	DefaultDedupBlockSize = 1 << 20
+	minDedupBlockSize     = 4 << 10
+	maxDedupBlockSize     = 64 << 20
+)
+
+// gearTable 每个字节值对应的随机数，由固定种子生成。修改种子会改变块边界，
+// 已存储的块不再被新文件复用（内容仍可正常读取）
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	seed := uint64(0x5644504c41544652) // "VDPLATFR"
+	for i := range table {
+		// splitmix64
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// cdcParams 分块参数：块大小在 [min, max] 内，平均约为 avg
+type cdcParams struct {
+	min, avg, max int
+	maskS, maskL  uint64
+}
+
+// newCDCParams 按平均块大小（向下取 2 的幂）计算分块参数
+func newCDCParams(avgSize int) (cdcParams, error) {
+	if avgSize < minDedupBlockSize || avgSize > maxDedupBlockSize {
+		return cdcParams{}, fmt.Errorf("dedup block size must be between %d and %d bytes, got %d",
+			minDedupBlockSize, maxDedupBlockSize, avgSize)
+	}
+	n := bits.Len(uint(avgSize)) - 1
+	avg := 1 << n
+	return cdcParams{
+		min:   avg / 4,
+		avg:   avg,
+		max:   avg * 4,
+		maskS: ^uint64(0) << (64 - (n + 2)),
+		maskL: ^uint64(0) << (64 - (n - 2)),
+	}, nil
+}
+
+// cut 返回 data 中第一个块的长度。data 不足 max 时只有到达输入末尾才能调用
+func (p *cdcParams) cut(data []byte) int {
+	n := len(data)
+	if n <= p.min {
+		return n
+	}
+	if n > p.max {
+		n = p.max
+	}
+	normal := p.avg
+	if normal > n {
+		normal = n
+	}
+
+	var h uint64
+	i := p.min
+	for ; i < normal; i++ {
+		h = (h << 1) + gearTable[data[i]]
+		if h&p.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		h = (h << 1) + gearTable[data[i]]
+		if h&p.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// chunker 从 r 中依次切出块
+type chunker struct {
+	r      io.Reader
+	params cdcParams
+	buf    []byte
+	start  int // buf[start:end] 为尚未切分的数据
+	end    int
+	eof    bool
+}
+
+func newChunker(r io.Reader, params cdcParams) *chunker {
+	return &chunker{r: r, params: params, buf: make([]byte, params.max)}
+}
+
+// next 返回下一个块，数据在下次调用前有效；没有更多数据时返回 io.EOF
+func (c *chunker) next() ([]byte, error) {
+	if c.end-c.start < c.params.max && !c.eof {
+		if err := c.fill(); err != nil {
+			return nil, err
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	n := c.params.cut(c.buf[c.start:c.end])
+	block := c.buf[c.start : c.start+n]
+	c.start += n
+	return block, nil
+}
+
+// fill 把剩余数据移到缓冲区开头并读满
+func (c *chunker) fill() error {
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+	for c.end < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// 文件 hash 即内容摘要，规范形式为 "算法:十六进制摘要"，新文件使用 sha256。
+// md5 只为兼容旧客户端；客户端仍可提交不带前缀的十六进制摘要，入库前统一转为规范形式。
+
+// 文件 hash 算法
+const (
+	HashSHA256 = "sha256"
+	HashMD5    = "md5"
+)
+
+// ErrInvalidFileHash 文件 hash 格式不正确或算法不受支持
+var ErrInvalidFileHash = errors.New("invalid file hash")
+
+var fileHashDigestLen = map[string]int{
+	HashSHA256: sha256.Size * 2,
+	HashMD5:    md5.Size * 2,
+}
+
+// ParseFileHash 解析 "算法:十六进制摘要"；省略算法时按长度识别（32 位为 md5，64 位为 sha256），
+// 仅作为输入的别名，规范形式总是带算法前缀
+func ParseFileHash(s string) (algorithm, digest string, err error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	algorithm, digest, found := strings.Cut(s, ":")
+	if !found {
+		digest = s
+		switch len(digest) {
+		case md5.Size * 2:
+			algorithm = HashMD5
+		case sha256.Size * 2:
+			algorithm = HashSHA256
+		}
+	}
+	n, ok := fileHashDigestLen[algorithm]
+	if !ok {
+		return "", "", fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidFileHash, algorithm)
+	}
+	if len(digest) != n {
+		return "", "", fmt.Errorf("%w: %s digest must be %d hex characters", ErrInvalidFileHash, algorithm, n)
+	}
+	if _, err := hex.DecodeString(digest); err != nil {
+		return "", "", fmt.Errorf("%w: digest is not hex", ErrInvalidFileHash)
+	}
+	return algorithm, digest, nil
+}
+
+// NormalizeFileHash 返回文件 hash 的规范形式
+func NormalizeFileHash(s string) (string, error) {
+	algorithm, digest, err := ParseFileHash(s)
+	if err != nil {
+		return "", err
+	}
+	return formatFileHash(algorithm, digest), nil
+}
+
+// FormatFileHash 由算法与摘要组成规范形式的文件 hash
+func FormatFileHash(algorithm string, sum []byte) string {
+	return formatFileHash(algorithm, hex.EncodeToString(sum))
+}
+
+func formatFileHash(algorithm, digest string) string {
+	return algorithm + ":" + digest
+}
+
+// FileHashAlgorithm 文件 hash 使用的算法，无法识别时按旧格式视为 md5
+func FileHashAlgorithm(fileHash string) string {
+	if algorithm, _, err := ParseFileHash(fileHash); err == nil {
+		return algorithm
+	}
+	return HashMD5
+}
+
+// IsStrongFileHash 文件 hash 是否足以单独标识内容（可用于去重与秒传）
+func IsStrongFileHash(fileHash string) bool {
+	return FileHashAlgorithm(fileHash) == HashSHA256
+}
+
+// NewFileDigest 返回与文件 hash 算法对应的摘要器
+func NewFileDigest(fileHash string) hash.Hash {
+	if FileHashAlgorithm(fileHash) == HashSHA256 {
+		return sha256.New()
+	}
+	return md5.New()
+}
+
+// fileHashDigest 文件 hash 中的十六进制摘要部分，用于比较与分层目录
+func fileHashDigest(fileHash string) string {
+	if _, digest, err := ParseFileHash(fileHash); err == nil {
+		return digest
+	}
+	return fileHash
+}
@@ -0,0 +1,185 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 分层目录布局：文件放在 BasePath/ab/cd/abcd... 下，避免单个目录中条目过多。
+// 切换布局后，平铺在 BasePath 下的旧文件仍可读取，由 MigrateFile 逐个迁移。
+
+// ParseShardLayout 解析目录布局："2/2" 表示两层、每层取 hash 的 2 个字符；
+// 空字符串或 "flat" 表示平铺
+func ParseShardLayout(s string) ([]int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "flat" {
+		return nil, nil
+	}
+	var layout []int
+	total := 0
+	for _, part := range strings.Split(s, "/") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 1 || n > 4 {
+			return nil, fmt.Errorf("invalid shard layout %q: each level must be 1-4 characters", s)
+		}
+		layout = append(layout, n)
+		total += n
+	}
+	if len(layout) > 4 || total > 8 {
+		return nil, fmt.Errorf("invalid shard layout %q: at most 4 levels and 8 characters", s)
+	}
+	return layout, nil
+}
+
+// LayoutMigrator 可选接口：把按旧布局存放的文件迁移到当前布局
+type LayoutMigrator interface {
+	// MisplacedFiles 列出不在当前布局位置上的文件
+	MisplacedFiles() ([]string, error)
+	// MigrateFile 把文件移动到当前布局位置，返回新路径。文件已在新位置时只清理旧副本。
+	MigrateFile(hash string) (string, error)
+	// FilePath 文件在当前布局下的路径
+	FilePath(hash string) string
+}
+
+// shardedPath 按布局计算文件路径（分层取 hash 的摘要部分），摘要长度不足时平铺
+func shardedPath(base, hash string, layout []int) string {
+	parts := []string{base}
+	digest := fileHashDigest(hash)
+	off := 0
+	for _, n := range layout {
+		if off+n >= len(digest) {
+			return filepath.Join(base, hash)
+		}
+		parts = append(parts, digest[off:off+n])
+		off += n
+	}
+	return filepath.Join(append(parts, hash)...)
+}
+
+// getFilePath 文件在当前布局下的路径
+func (s *LocalStore) getFilePath(hash string) string {
+	return shardedPath(s.BasePath, hash, s.ShardLayout)
+}
+
+// getLegacyPath 切换布局前平铺存放的路径，未分层时为空
+func (s *LocalStore) getLegacyPath(hash string) string {
+	if len(s.ShardLayout) == 0 {
+		return ""
+	}
+	return filepath.Join(s.BasePath, hash)
+}
+
+// filePaths 读取时依次尝试的路径
+func (s *LocalStore) filePaths(hash string) []string {
+	if legacy := s.getLegacyPath(hash); legacy != "" {
+		return []string{s.getFilePath(hash), legacy}
+	}
+	return []string{s.getFilePath(hash)}
+}
+
+// openFile 按当前布局、旧位置的顺序打开文件。迁移可能恰好在两次尝试之间移动了文件，
+// 此时重新查找一次。
+func (s *LocalStore) openFile(hash string) (*os.File, error) {
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		for _, path := range s.filePaths(hash) {
+			f, err := os.Open(path)
+			if err == nil {
+				return f, nil
+			}
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
+
+// prepareFilePath 创建文件所在的分层目录，返回目标路径
+func (s *LocalStore) prepareFilePath(hash string) (string, error) {
+	path := s.getFilePath(hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("create shard dir failed: %w", err)
+	}
+	return path, nil
+}
+
+// removeLegacyCopy 新文件写到当前布局后删除旧位置上的副本
+func (s *LocalStore) removeLegacyCopy(hash string) {
+	if legacy := s.getLegacyPath(hash); legacy != "" {
+		os.Remove(legacy)
+	}
+}
+
+// walkFiles 遍历 BasePath 下已合并的文件（含分层目录），跳过临时文件与 .partial、.meta 等目录
+func (s *LocalStore) walkFiles(fn func(path string, d fs.DirEntry) error) error {
+	err := filepath.WalkDir(s.BasePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == s.BasePath && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path != s.BasePath && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.Contains(d.Name(), ".") {
+			return nil
+		}
+		return fn(path, d)
+	})
+	return err
+}
+
+// FilePath 文件在当前布局下的路径
+func (s *LocalStore) FilePath(hash string) string {
+	return s.getFilePath(hash)
+}
+
+// MisplacedFiles 列出仍平铺在 BasePath 下的文件
+func (s *LocalStore) MisplacedFiles() ([]string, error) {
+	var hashes []string
+	err := s.walkFiles(func(path string, d fs.DirEntry) error {
+		if path != s.getFilePath(d.Name()) && path == s.getLegacyPath(d.Name()) {
+			hashes = append(hashes, d.Name())
+		}
+		return nil
+	})
+	return hashes, err
+}
+
+// MigrateFile 把平铺的文件改名到分层目录。改名是原子的，读取方打开的文件句柄不受影响。
+func (s *LocalStore) MigrateFile(hash string) (string, error) {
+	legacy := s.getLegacyPath(hash)
+	if legacy == "" {
+		return s.getFilePath(hash), nil
+	}
+	dest, err := s.prepareFilePath(hash)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(dest); err == nil {
+		// 迁移期间重新合并过，新位置的内容与旧副本相同
+		if err := os.Remove(legacy); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		return dest, nil
+	}
+	if err := os.Rename(legacy, dest); err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("file %s not found: %w", hash, err)
+		}
+		return "", err
+	}
+	return dest, nil
+}
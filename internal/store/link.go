@@ -0,0 +1,196 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// FileLinker 可选接口：让已存储的文件以另一个 hash 也能读取（迁移文件标识时使用），
+// 内容不变。旧 hash 由调用方在更新完所有引用后删除。
+type FileLinker interface {
+	// LinkFile 让 newHash 指向 oldHash 的内容，返回新路径。newHash 已存在时不做改动，
+	// 因此中途退出后可以重复执行
+	LinkFile(oldHash, newHash string) (string, error)
+}
+
+// LinkFile 在当前布局下为文件建立硬链接，不支持硬链接时复制
+func (s *LocalStore) LinkFile(oldHash, newHash string) (string, error) {
+	if s.FileExists(newHash) {
+		return s.getFilePath(newHash), nil
+	}
+	src := ""
+	for _, path := range s.filePaths(oldHash) {
+		if _, err := os.Stat(path); err == nil {
+			src = path
+			break
+		}
+	}
+	if src == "" {
+		return "", &fs.PathError{Op: "link", Path: oldHash, Err: fs.ErrNotExist}
+	}
+
+	dest, err := s.prepareFilePath(newHash)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Link(src, dest); err == nil {
+		return dest, nil
+	}
+	if err := copyFileAtomic(src, dest); err != nil {
+		return "", fmt.Errorf("copy %s to %s failed: %w", oldHash, newHash, err)
+	}
+	return dest, nil
+}
+
+// copyFileAtomic 复制到临时文件后改名
+func copyFileAtomic(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dest + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dest)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// LinkFile 在每块存有副本的磁盘上建立链接（内容相同，分段校验和一并复制），副本位置不变
+func (s *MultiDiskStore) LinkFile(oldHash, newHash string) (string, error) {
+	linked := false
+	for _, d := range s.disks {
+		if !d.FileExists(oldHash) {
+			continue
+		}
+		if _, err := d.LinkFile(oldHash, newHash); err != nil {
+			return "", fmt.Errorf("%s: %w", d.BasePath, err)
+		}
+		if sums, err := d.readSums(oldHash); err == nil {
+			_ = d.writeSums(newHash, sums)
+		}
+		linked = true
+	}
+	if !linked && !s.FileExists(newHash) {
+		return "", &fs.PathError{Op: "link", Path: oldHash, Err: fs.ErrNotExist}
+	}
+	return s.FilePath(newHash), nil
+}
+
+// LinkFile 服务端拷贝到 objects/<newHash>，超过 5GiB 时分段拷贝
+func (s *S3Store) LinkFile(oldHash, newHash string) (string, error) {
+	dest := s.objectKey(newHash)
+	path := fmt.Sprintf("s3://%s/%s", s.cfg.Bucket, dest)
+	if s.FileExists(newHash) {
+		return path, nil
+	}
+	size, exists, err := s.headObject(s.objectKey(oldHash))
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", &fs.PathError{Op: "link", Path: oldHash, Err: fs.ErrNotExist}
+	}
+	if err := s.copyObject(s.objectKey(oldHash), dest, size); err != nil {
+		return "", fmt.Errorf("copy %s to %s failed: %w", oldHash, newHash, err)
+	}
+	return path, nil
+}
+
+// LinkFile 数据密钥按新 hash 重新包装（包装绑定了文件 hash），再链接内层存储中的密文。
+// 段的附加数据不含 hash，密文不需要改写。
+func (s *EncryptedStore) LinkFile(oldHash, newHash string) (string, error) {
+	linker, ok := s.inner.(FileLinker)
+	if !ok {
+		return "", fmt.Errorf("storage backend %T cannot link files", s.inner)
+	}
+	if _, err := s.loadEnvelope(newHash); err != nil {
+		if !errors.Is(err, ErrMetaNotFound) {
+			return "", fmt.Errorf("read key envelope failed: %w", err)
+		}
+		env, err := s.loadEnvelope(oldHash)
+		if err != nil {
+			return "", fmt.Errorf("read key envelope of %s failed: %w", oldHash, err)
+		}
+		rewrapped := &keyEnvelope{}
+		for _, wk := range env.Keys {
+			dek, err := s.ring.unwrap(oldHash, wk)
+			if err != nil {
+				return "", err
+			}
+			nk, err := s.ring.wrap(newHash, wk.ID, dek)
+			if err != nil {
+				return "", err
+			}
+			rewrapped.Keys = append(rewrapped.Keys, nk)
+		}
+		if err := s.saveEnvelope(newHash, rewrapped); err != nil {
+			return "", fmt.Errorf("write key envelope failed: %w", err)
+		}
+	}
+	return linker.LinkFile(oldHash, newHash)
+}
+
+// LinkFile 有块清单时复制清单并登记块引用，否则链接内层存储中的整个文件
+func (s *DedupStore) LinkFile(oldHash, newHash string) (string, error) {
+	if s.FileExists(newHash) {
+		if _, err := s.loadManifest(newHash); err == nil {
+			return manifestMetaName(newHash), nil
+		}
+		return s.linkInner(oldHash, newHash)
+	}
+
+	data, err := s.inner.GetMeta(manifestMetaName(oldHash))
+	if errors.Is(err, ErrMetaNotFound) {
+		return s.linkInner(oldHash, newHash)
+	}
+	if err != nil {
+		return "", err
+	}
+	m, err := s.loadManifest(oldHash)
+	if err != nil {
+		return "", err
+	}
+	index, err := s.blockIndex()
+	if err != nil {
+		return "", err
+	}
+	refs := make([]BlockRef, 0, len(m.Blocks))
+	for _, b := range m.Blocks {
+		refs = append(refs, BlockRef{Hash: b.id(), Size: b.Size})
+	}
+	// 先登记引用再写清单：旧文件此后被删除也不会释放这些块
+	if _, err := index.SetFileBlocks(newHash, refs); err != nil {
+		return "", fmt.Errorf("register blocks failed: %w", err)
+	}
+	if err := s.inner.PutMeta(manifestMetaName(newHash), data); err != nil {
+		return "", fmt.Errorf("write manifest failed: %w", err)
+	}
+	return manifestMetaName(newHash), nil
+}
+
+func (s *DedupStore) linkInner(oldHash, newHash string) (string, error) {
+	linker, ok := s.inner.(FileLinker)
+	if !ok {
+		return "", fmt.Errorf("storage backend %T cannot link files", s.inner)
+	}
+	return linker.LinkFile(oldHash, newHash)
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// StoredFile 存储中已合并的文件
+type StoredFile struct {
+	Hash    string
+	Size    int64
+	ModTime time.Time
+}
+
+// PendingUpload 存储中上传中的分片数据
+type PendingUpload struct {
+	UserID  int
+	Hash    string
+	ModTime time.Time // 最后一次写入的时间
+}
+
+// Lister 可选接口：枚举存储内容，供垃圾回收等维护任务使用
+type Lister interface {
+	ListFiles() ([]StoredFile, error)
+	ListUploads() ([]PendingUpload, error)
+}
+
+// ListFiles 列出 BasePath 下（含分层目录）已合并的文件，跳过临时文件与 .partial 等目录。
+// 迁移布局期间同一文件可能短暂同时出现在新旧位置，只列出一次。
+func (s *LocalStore) ListFiles() ([]StoredFile, error) {
+	var files []StoredFile
+	seen := make(map[string]bool)
+	err := s.walkFiles(func(path string, entry fs.DirEntry) error {
+		if seen[entry.Name()] {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		seen[entry.Name()] = true
+		files = append(files, StoredFile{Hash: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+// ListUploads 列出 TempPath/<user>/<hash> 分片目录与预分配的数据文件。
+// TempPath 下非数字命名的目录（如 tus 暂存目录）不属于分片上传，跳过。
+func (s *LocalStore) ListUploads() ([]PendingUpload, error) {
+	latest := make(map[string]*PendingUpload)
+	add := func(userID int, hash string, modTime time.Time) {
+		key := uploadCacheKey(userID, hash)
+		if u, ok := latest[key]; ok {
+			if modTime.After(u.ModTime) {
+				u.ModTime = modTime
+			}
+			return
+		}
+		latest[key] = &PendingUpload{UserID: userID, Hash: hash, ModTime: modTime}
+	}
+
+	for _, root := range []string{s.TempPath, filepath.Join(s.BasePath, ".partial")} {
+		users, err := os.ReadDir(root)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, u := range users {
+			userID, err := strconv.Atoi(u.Name())
+			if err != nil || !u.IsDir() {
+				continue
+			}
+			uploads, err := os.ReadDir(filepath.Join(root, u.Name()))
+			if err != nil {
+				continue
+			}
+			for _, up := range uploads {
+				add(userID, up.Name(), lastModified(filepath.Join(root, u.Name(), up.Name())))
+			}
+		}
+	}
+
+	pending := make([]PendingUpload, 0, len(latest))
+	for _, u := range latest {
+		pending = append(pending, *u)
+	}
+	return pending, nil
+}
+
+// lastModified 路径本身与其直接子项中最新的修改时间
+func lastModified(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	latest := info.ModTime()
+	if !info.IsDir() {
+		return latest
+	}
+	entries, _ := os.ReadDir(path)
+	for _, entry := range entries {
+		if fi, err := entry.Info(); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest
+}
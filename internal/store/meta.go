@@ -0,0 +1,117 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrMetaNotFound = errors.New("meta object not found")
+
+// maxMetaSize 元数据对象的大小上限（块级去重的块清单每块约 90 字节）
+const maxMetaSize = 16 << 20
+
+// MetaStore 可选接口：与文件保存在同一后端的小对象（如加密存储的数据密钥、上传布局），
+// name 为 "/" 分隔的相对路径
+type MetaStore interface {
+	// GetMeta 读取元数据，不存在时返回 ErrMetaNotFound
+	GetMeta(name string) ([]byte, error)
+	// PutMeta 写入（覆盖）元数据
+	PutMeta(name string, data []byte) error
+	DeleteMeta(name string) error
+	// ListMeta 列出 prefix（以 "/" 结尾的目录）下的元数据名
+	ListMeta(prefix string) ([]string, error)
+}
+
+// getMetaPath 元数据放在 BasePath/.meta 下，不会被当作已合并的文件列出
+func (s *LocalStore) getMetaPath(name string) string {
+	return filepath.Join(s.BasePath, ".meta", filepath.FromSlash(name))
+}
+
+// GetMeta 读取元数据
+func (s *LocalStore) GetMeta(name string) ([]byte, error) {
+	data, err := os.ReadFile(s.getMetaPath(name))
+	if os.IsNotExist(err) {
+		return nil, ErrMetaNotFound
+	}
+	return data, err
+}
+
+// PutMeta 原子写入元数据
+func (s *LocalStore) PutMeta(name string, data []byte) error {
+	path := s.getMetaPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// DeleteMeta 删除元数据，不存在时不报错
+func (s *LocalStore) DeleteMeta(name string) error {
+	if err := os.Remove(s.getMetaPath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ListMeta 列出目录下的元数据名，跳过写入中的临时文件
+func (s *LocalStore) ListMeta(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(s.getMetaPath(prefix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		names = append(names, prefix+entry.Name())
+	}
+	return names, nil
+}
+
+func (s *S3Store) metaKey(name string) string {
+	return s.cfg.Prefix + "meta/" + name
+}
+
+// GetMeta 读取 meta/<name> 对象
+func (s *S3Store) GetMeta(name string) ([]byte, error) {
+	resp, err := s.do(http.MethodGet, s.metaKey(name), nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, ErrMetaNotFound
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(io.LimitReader(resp.Body, maxMetaSize))
+}
+
+// PutMeta 写入 meta/<name> 对象
+func (s *S3Store) PutMeta(name string, data []byte) error {
+	return s.putSmallObject(s.metaKey(name), data, false)
+}
+
+// DeleteMeta 删除 meta/<name> 对象
+func (s *S3Store) DeleteMeta(name string) error {
+	return s.deleteObject(s.metaKey(name))
+}
+
+// ListMeta 列出 meta/<prefix> 下的对象
+func (s *S3Store) ListMeta(prefix string) ([]string, error) {
+	objects, err := s.listObjects(s.metaKey(prefix))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(objects))
+	for _, o := range objects {
+		names = append(names, strings.TrimPrefix(o.Key, s.metaKey("")))
+	}
+	return names, nil
+}
@@ -0,0 +1,849 @@
+package store
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 多磁盘存储：每个文件按放置策略写到若干目录（通常各挂一块磁盘）中，保留 replicas 份副本。
+// 读取时按固定顺序尝试各副本：副本缺失或读取出错时从当前偏移切换到下一个副本。
+// 有分段校验和（见 segsum.go）的文件逐段校验后才交给调用方，某段损坏时把该副本移到一旁
+// （<文件>.corrupt）、报告给完整性巡检，并从下一个副本重读该段；整文件与范围读取相同。
+// 没有校验和的旧文件只在从头读完整个文件时校验，此时数据已交给调用方，只能返回错误。
+// 上传中的分片写在共享的 TempPath 中，合并到第一个目标磁盘后再复制到其余目标。
+
+var (
+	// ErrReplicaCorrupt 副本内容校验失败：有分段校验和时为所有副本的同一段都损坏（不会交出损坏的数据），
+	// 没有时为整文件读取结束时校验失败，已读出的数据不可信
+	ErrReplicaCorrupt = errors.New("replica content is corrupt")
+
+	errNoIntactReplica = errors.New("no intact replica")
+)
+
+// 放置策略
+const (
+	PlacementHash     = "hash"     // rendezvous 哈希：位置只由 hash 与磁盘路径决定，增减磁盘只影响少量文件
+	PlacementCapacity = "capacity" // 写到可用空间最多的磁盘
+)
+
+// CorruptionReporter 可选接口：读取时发现副本损坏后通知上层（如交给完整性巡检修复）
+type CorruptionReporter interface {
+	SetCorruptionHandler(fn func(hash string))
+}
+
+// ReplicaRepairer 可选接口：补齐缺失或损坏的副本（如更换磁盘后）
+type ReplicaRepairer interface {
+	// RepairFile 确保文件有 replicas 份副本，verify 时先逐个校验现有副本的内容
+	RepairFile(hash string, verify bool) (*ReplicaRepair, error)
+	// RepairMeta 把元数据复制到缺少它的磁盘，返回复制的数量
+	RepairMeta() (int, error)
+}
+
+// ReplicaRepair 一个文件的副本修复结果
+type ReplicaRepair struct {
+	Intact  int // 修复前完好（未校验时为存在）的副本数
+	Corrupt int // 校验失败并移到一旁的副本数
+	Copied  int // 新复制的副本数
+}
+
+// MultiDiskStore 多磁盘本地存储
+type MultiDiskStore struct {
+	disks     []*LocalStore
+	replicas  int
+	placement string
+	onCorrupt func(hash string)
+}
+
+// NewMultiDiskStore 创建多磁盘存储。replicas 为 0 时取 2（不超过磁盘数），
+// 每块磁盘使用相同的目录布局。
+func NewMultiDiskStore(paths []string, tempPath string, replicas int, placement string, layout []int) (*MultiDiskStore, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no storage disks configured")
+	}
+	switch placement {
+	case "":
+		placement = PlacementHash
+	case PlacementHash, PlacementCapacity:
+	default:
+		return nil, fmt.Errorf("unknown placement policy: %s", placement)
+	}
+	if replicas <= 0 {
+		replicas = 2
+		if replicas > len(paths) {
+			replicas = len(paths)
+		}
+	}
+	if replicas > len(paths) {
+		return nil, fmt.Errorf("%d replicas need at least %d disks, got %d", replicas, replicas, len(paths))
+	}
+
+	s := &MultiDiskStore{replicas: replicas, placement: placement}
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		if seen[p] {
+			return nil, fmt.Errorf("duplicate storage disk %s", p)
+		}
+		seen[p] = true
+		d := NewLocalStore(p, tempPath)
+		d.ShardLayout = layout
+		s.disks = append(s.disks, d)
+	}
+	return s, nil
+}
+
+// readOrder 读取副本的顺序：rendezvous 哈希，与放置策略无关，保证同一文件总是先读同一块磁盘
+func (s *MultiDiskStore) readOrder(hash string) []int {
+	score := make([]uint64, len(s.disks))
+	for i, d := range s.disks {
+		sum := md5.Sum([]byte(d.BasePath + "\x00" + hash))
+		score[i] = binary.BigEndian.Uint64(sum[:8])
+	}
+	return sortedDisks(len(s.disks), func(a, b int) bool { return score[a] > score[b] })
+}
+
+// writeOrder 按放置策略排列的磁盘，依次作为写入目标
+func (s *MultiDiskStore) writeOrder(hash string) []int {
+	if s.placement != PlacementCapacity {
+		return s.readOrder(hash)
+	}
+	free := make([]uint64, len(s.disks))
+	for i, d := range s.disks {
+		free[i], _ = diskFree(d.BasePath)
+	}
+	return sortedDisks(len(s.disks), func(a, b int) bool { return free[a] > free[b] })
+}
+
+func sortedDisks(n int, less func(a, b int) bool) []int {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return less(order[i], order[j]) })
+	return order
+}
+
+// replicaDisks 存有该文件的磁盘，按读取顺序排列
+func (s *MultiDiskStore) replicaDisks(hash string) []int {
+	var found []int
+	for _, i := range s.readOrder(hash) {
+		if s.disks[i].FileExists(hash) {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+// staging 上传中的分片写在共享的 TempPath，由第一块磁盘的实例管理
+func (s *MultiDiskStore) staging() *LocalStore {
+	return s.disks[0]
+}
+
+// WriteChunk 写入分片
+func (s *MultiDiskStore) WriteChunk(userID int, hash string, index int, content io.Reader, checksum *ChunkChecksum) error {
+	return s.staging().WriteChunk(userID, hash, index, content, checksum)
+}
+
+// GetUploadedChunks 获取已上传的分片索引
+func (s *MultiDiskStore) GetUploadedChunks(userID int, hash string) ([]int, error) {
+	return s.staging().GetUploadedChunks(userID, hash)
+}
+
+// CleanupChunks 清理分片临时文件
+func (s *MultiDiskStore) CleanupChunks(userID int, hash string) error {
+	return s.staging().CleanupChunks(userID, hash)
+}
+
+// MergeChunks 合并到第一个目标磁盘并校验，再复制到其余目标。复制失败时依次尝试后面的磁盘，
+// 至少合并成功的一份可用即返回成功，缺少的副本由 RepairFile 补齐。
+func (s *MultiDiskStore) MergeChunks(userID int, hash string, totalChunks int, expectedSize int64, strongHash string) (string, int64, error) {
+	order := s.writeOrder(hash)
+	filePath, fileSize, err := s.disks[order[0]].MergeChunks(userID, hash, totalChunks, expectedSize, strongHash)
+	var collision *CollisionError
+	if errors.As(err, &collision) {
+		// md5 碰撞：内容已改存在强摘要下，按该标识补齐副本
+		s.replicate(order[0], collision.FileHash)
+		return "", 0, collision
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	s.replicate(order[0], hash)
+	return filePath, fileSize, nil
+}
+
+// replicate 把 src 磁盘上刚合并的文件复制到其余目标磁盘，并为各副本写入分段校验和
+func (s *MultiDiskStore) replicate(src int, hash string) {
+	written := map[int]bool{src: true}
+	for _, i := range s.writeOrder(hash) {
+		if len(written) == s.replicas {
+			break
+		}
+		if written[i] {
+			continue
+		}
+		if err := s.copyReplica(s.disks[src], s.disks[i], hash); err != nil {
+			log.Printf("Warning: replicate %s to %s failed: %v", hash, s.disks[i].BasePath, err)
+			continue
+		}
+		written[i] = true
+	}
+	if len(written) == 1 {
+		// 没有复制，复制时顺带生成的校验和需要单独读一遍生成
+		if err := s.verifyReplica(s.disks[src], hash); err != nil {
+			log.Printf("Warning: write segment checksums of %s failed: %v", hash, err)
+		}
+	}
+	if len(written) < s.replicas {
+		log.Printf("Warning: %s stored with %d of %d replicas", hash, len(written), s.replicas)
+		return
+	}
+
+	// 目标之外的旧副本（重新上传前可能已损坏）不再保留
+	for i, d := range s.disks {
+		if !written[i] && d.FileExists(hash) {
+			_ = d.DeleteFile(hash)
+			_ = d.removeSums(hash)
+		}
+	}
+}
+
+// copyReplica 把 src 上的文件复制到 dst，复制时校验内容，通过后写入两边的分段校验和再改名
+func (s *MultiDiskStore) copyReplica(src, dst *LocalStore, hash string) error {
+	rc, _, err := src.GetFile(hash)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dest, err := dst.prepareFilePath(hash)
+	if err != nil {
+		return err
+	}
+	tmp := dest + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create replica failed: %w", err)
+	}
+	check := src.contentCheck(hash, 0, "")
+	sums := newSumWriter()
+	n, err := io.Copy(io.MultiWriter(out, check, sums), rc)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("copy replica failed: %w", err)
+	}
+	if err := check.Verify(n); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.writeSums(hash, sums.result()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := src.writeSums(hash, sums.result()); err != nil {
+		log.Printf("Warning: %s on %s: %v", hash, src.BasePath, err)
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("rename replica failed: %w", err)
+	}
+	dst.removeLegacyCopy(hash)
+	return nil
+}
+
+// verifyReplica 读取整个副本并校验内容，内容不符时返回的错误满足 errors.Is(err, ErrIntegrity)。
+// 校验通过后重写该副本的分段校验和（补齐升级前存储的文件）
+func (s *MultiDiskStore) verifyReplica(d *LocalStore, hash string) error {
+	rc, _, err := d.GetFile(hash)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	check := d.contentCheck(hash, 0, "")
+	sums := newSumWriter()
+	n, err := io.Copy(io.MultiWriter(check, sums), rc)
+	if err != nil {
+		return fmt.Errorf("read replica on %s failed: %w", d.BasePath, err)
+	}
+	if err := check.Verify(n); err != nil {
+		return err
+	}
+	return d.writeSums(hash, sums.result())
+}
+
+// setAside 把损坏的副本改名为 <文件>.corrupt，不再被读取或列出，留作排查
+func (s *MultiDiskStore) setAside(d *LocalStore, hash string) {
+	for _, path := range d.filePaths(hash) {
+		if err := os.Rename(path, path+".corrupt"); err == nil {
+			log.Printf("Warning: replica %s is corrupt, moved aside", path)
+			return
+		}
+	}
+}
+
+// GetFile 按读取顺序打开第一个可用的副本
+func (s *MultiDiskStore) GetFile(hash string) (io.ReadCloser, int64, error) {
+	disks := s.replicaDisks(hash)
+	if sums := s.loadSums(hash); sums != nil {
+		r, err := s.openSegments(hash, disks, sums, 0, sums.size-1)
+		if err != nil {
+			return nil, 0, err
+		}
+		return r, sums.size, nil
+	}
+	var lastErr error = &fs.PathError{Op: "open", Path: hash, Err: fs.ErrNotExist}
+	for n, i := range disks {
+		rc, size, err := s.disks[i].GetFile(hash)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return &replicaReader{
+			store:   s,
+			hash:    hash,
+			rest:    disks[n+1:],
+			cur:     i,
+			rc:      rc,
+			end:     size - 1,
+			check:   s.disks[i].contentCheck(hash, 0, ""),
+			replica: len(disks) > 1,
+		}, size, nil
+	}
+	return nil, 0, lastErr
+}
+
+// GetFileRange 按读取顺序打开第一个可用的副本
+func (s *MultiDiskStore) GetFileRange(hash string, start, end int64) (io.ReadCloser, error) {
+	disks := s.replicaDisks(hash)
+	if sums := s.loadSums(hash); sums != nil {
+		if end >= sums.size {
+			end = sums.size - 1
+		}
+		return s.openSegments(hash, disks, sums, start, end)
+	}
+	var lastErr error = &fs.PathError{Op: "open", Path: hash, Err: fs.ErrNotExist}
+	for n, i := range disks {
+		rc, err := s.disks[i].GetFileRange(hash, start, end)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return &replicaReader{store: s, hash: hash, rest: disks[n+1:], cur: i, rc: rc, pos: start, end: end}, nil
+	}
+	return nil, lastErr
+}
+
+// replicaReader 没有分段校验和的文件的读取：读取出错或副本提前结束时，从当前偏移切换到下一个副本继续读取。
+// 整文件读取且未切换过副本时，结束时校验内容：不一致时报告损坏，还有其他副本时把该副本移到一旁
+// 并返回 ErrReplicaCorrupt（只有一份时交给上层判断，如完整性巡检）。已读出的数据无法撤回，
+// 也不会改读其他副本。
+type replicaReader struct {
+	store   *MultiDiskStore
+	hash    string
+	rest    []int // 尚未尝试的副本
+	cur     int
+	rc      io.ReadCloser
+	pos     int64 // 下一个要读取的偏移
+	end     int64 // 闭区间终点
+	check   contentCheck
+	replica bool // 是否有其他副本
+}
+
+func (r *replicaReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.rc.Read(p)
+		r.pos += int64(n)
+		if r.check != nil && n > 0 {
+			r.check.Write(p[:n])
+		}
+		switch {
+		case err == nil:
+			return n, nil
+		case err == io.EOF && r.pos > r.end:
+			return n, r.finish()
+		}
+
+		// 读取出错或副本比预期短
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if !r.failover() {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// finish 整文件读取结束时校验内容
+func (r *replicaReader) finish() error {
+	if r.check == nil {
+		return io.EOF
+	}
+	err := r.check.Verify(r.pos)
+	r.check = nil
+	if err == nil {
+		return io.EOF
+	}
+	if r.store.onCorrupt != nil {
+		r.store.onCorrupt(r.hash)
+	}
+	if !r.replica {
+		return io.EOF
+	}
+	r.store.setAside(r.store.disks[r.cur], r.hash)
+	return fmt.Errorf("%w: %s on %s: %v", ErrReplicaCorrupt, r.hash, r.store.disks[r.cur].BasePath, err)
+}
+
+// failover 从当前偏移打开下一个副本，切换后不再做整文件校验
+func (r *replicaReader) failover() bool {
+	r.rc.Close()
+	r.check = nil
+	for len(r.rest) > 0 {
+		i := r.rest[0]
+		r.rest = r.rest[1:]
+		rc, err := r.store.disks[i].GetFileRange(r.hash, r.pos, r.end)
+		if err != nil {
+			continue
+		}
+		r.cur, r.rc = i, rc
+		return true
+	}
+	r.rc = io.NopCloser(strings.NewReader(""))
+	return false
+}
+
+func (r *replicaReader) Close() error {
+	return r.rc.Close()
+}
+
+// loadSums 读取文件的分段校验和，任一磁盘上完好的都可以用（只描述内容，与副本无关）
+func (s *MultiDiskStore) loadSums(hash string) *segmentSums {
+	for _, i := range s.readOrder(hash) {
+		if sums, err := s.disks[i].readSums(hash); err == nil {
+			return sums
+		}
+	}
+	return nil
+}
+
+// openSegments 打开按段校验的读取器，没有可用的副本时返回错误
+func (s *MultiDiskStore) openSegments(hash string, disks []int, sums *segmentSums, start, end int64) (*segmentReader, error) {
+	r := &segmentReader{
+		store: s,
+		hash:  hash,
+		sums:  sums,
+		rest:  disks,
+		pos:   start,
+		end:   end,
+		err:   &fs.PathError{Op: "open", Path: hash, Err: fs.ErrNotExist},
+	}
+	if !r.next() {
+		return nil, r.err
+	}
+	return r, nil
+}
+
+// segmentReader 按段读取并校验副本，交给调用方的数据都已通过校验。某段读取出错或校验失败时
+// 换下一个副本重读该段；损坏的副本报告给上层，后面还有副本时移到一旁。所有副本都不可用时
+// 返回最后一个错误（校验失败时满足 errors.Is(err, ErrReplicaCorrupt)）
+type segmentReader struct {
+	store *MultiDiskStore
+	hash  string
+	sums  *segmentSums
+	rest  []int // 尚未尝试的副本
+	cur   int
+	f     *os.File
+	err   error // 最近一次切换副本的原因
+	pos   int64 // 下一个要读取的偏移
+	end   int64 // 闭区间终点
+	buf   []byte
+	seg   []byte // 已校验的当前段
+	off   int64  // 当前段的起始偏移
+}
+
+func (r *segmentReader) Read(p []byte) (int, error) {
+	if r.pos > r.end {
+		return 0, io.EOF
+	}
+	if r.seg == nil || r.pos < r.off || r.pos >= r.off+int64(len(r.seg)) {
+		if err := r.load(r.pos / r.sums.segment); err != nil {
+			return 0, err
+		}
+	}
+	data := r.seg[r.pos-r.off:]
+	if rest := r.end - r.pos + 1; int64(len(data)) > rest {
+		data = data[:rest]
+	}
+	n := copy(p, data)
+	r.pos += int64(n)
+	return n, nil
+}
+
+// load 读取并校验第 index 段
+func (r *segmentReader) load(index int64) error {
+	r.seg = nil
+	if r.buf == nil {
+		r.buf = make([]byte, r.sums.segment)
+	}
+	off := index * r.sums.segment
+	buf := r.buf[:r.sums.segmentLen(index)]
+	for r.f != nil {
+		_, err := r.f.ReadAt(buf, off)
+		switch {
+		case err == nil && r.sums.verify(index, buf):
+			r.seg, r.off = buf, off
+			return nil
+		case err == nil:
+			r.corrupt(fmt.Sprintf("segment %d", index))
+		default:
+			r.err = err
+		}
+		r.next()
+	}
+	return r.err
+}
+
+// next 关闭当前副本并打开下一个，长度与校验和不符的副本按损坏处理
+func (r *segmentReader) next() bool {
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+	for len(r.rest) > 0 {
+		i := r.rest[0]
+		r.rest = r.rest[1:]
+		f, err := r.store.disks[i].openFile(r.hash)
+		if err != nil {
+			r.err = err
+			continue
+		}
+		r.cur, r.f = i, f
+		if fi, err := f.Stat(); err != nil || fi.Size() != r.sums.size {
+			r.corrupt("size mismatch")
+			f.Close()
+			r.f = nil
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// corrupt 报告当前副本损坏，后面还有副本时把它移到一旁（只剩一份时留给巡检判断）
+func (r *segmentReader) corrupt(detail string) {
+	d := r.store.disks[r.cur]
+	r.err = fmt.Errorf("%w: %s on %s: %s", ErrReplicaCorrupt, r.hash, d.BasePath, detail)
+	log.Printf("Warning: %v", r.err)
+	if r.store.onCorrupt != nil {
+		r.store.onCorrupt(r.hash)
+	}
+	if len(r.rest) > 0 {
+		r.store.setAside(d, r.hash)
+	}
+}
+
+func (r *segmentReader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Close()
+}
+
+// DeleteFile 删除所有磁盘上的副本及其分段校验和
+func (s *MultiDiskStore) DeleteFile(hash string) error {
+	var firstErr error
+	for _, d := range s.disks {
+		if err := d.DeleteFile(hash); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := d.removeSums(hash); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// FileExists 任一磁盘上存在副本
+func (s *MultiDiskStore) FileExists(hash string) bool {
+	for _, d := range s.disks {
+		if d.FileExists(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListFiles 列出所有磁盘上的文件，每个 hash 只列一次
+func (s *MultiDiskStore) ListFiles() ([]StoredFile, error) {
+	var files []StoredFile
+	seen := make(map[string]bool)
+	for _, d := range s.disks {
+		list, err := d.ListFiles()
+		if err != nil {
+			return nil, fmt.Errorf("list %s failed: %w", d.BasePath, err)
+		}
+		for _, f := range list {
+			if !seen[f.Hash] {
+				seen[f.Hash] = true
+				files = append(files, f)
+			}
+		}
+	}
+	return files, nil
+}
+
+// ListUploads 列出上传中的分片数据
+func (s *MultiDiskStore) ListUploads() ([]PendingUpload, error) {
+	return s.staging().ListUploads()
+}
+
+// RepairFile 校验（verify 时）并补齐副本：损坏的副本移到一旁，从完好的副本复制到
+// 按放置策略排在前面、尚无副本的磁盘。没有完好副本时不做改动并返回错误。
+func (s *MultiDiskStore) RepairFile(hash string, verify bool) (*ReplicaRepair, error) {
+	var good, bad []int
+	for _, i := range s.readOrder(hash) {
+		d := s.disks[i]
+		if !d.FileExists(hash) {
+			continue
+		}
+		if verify {
+			if err := s.verifyReplica(d, hash); err != nil {
+				if !errors.Is(err, ErrIntegrity) {
+					return nil, err
+				}
+				bad = append(bad, i)
+				continue
+			}
+		}
+		good = append(good, i)
+	}
+
+	result := &ReplicaRepair{Intact: len(good)}
+	if len(good) == 0 {
+		return result, fmt.Errorf("%s: %w", hash, errNoIntactReplica)
+	}
+	for _, i := range bad {
+		s.setAside(s.disks[i], hash)
+		result.Corrupt++
+	}
+
+	has := make(map[int]bool)
+	for _, i := range good {
+		has[i] = true
+	}
+	src := s.disks[good[0]]
+	for _, i := range s.writeOrder(hash) {
+		if len(has) >= s.replicas {
+			break
+		}
+		if has[i] {
+			continue
+		}
+		if err := s.copyReplica(src, s.disks[i], hash); err != nil {
+			return result, fmt.Errorf("copy %s to %s failed: %w", hash, s.disks[i].BasePath, err)
+		}
+		has[i] = true
+		result.Copied++
+	}
+	return result, nil
+}
+
+// metaNames 列出磁盘 .meta 下的所有元数据名
+func metaNames(d *LocalStore) ([]string, error) {
+	root := d.getMetaPath("")
+	var names []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") || strings.HasSuffix(entry.Name(), ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	return names, err
+}
+
+// RepairMeta 把任一磁盘上存在的元数据复制到缺少它的磁盘
+func (s *MultiDiskStore) RepairMeta() (int, error) {
+	owner := make(map[string]*LocalStore)
+	var names []string
+	for _, d := range s.disks {
+		list, err := metaNames(d)
+		if err != nil {
+			return 0, fmt.Errorf("list meta on %s failed: %w", d.BasePath, err)
+		}
+		for _, name := range list {
+			if owner[name] == nil {
+				owner[name] = d
+				names = append(names, name)
+			}
+		}
+	}
+
+	copied := 0
+	for _, name := range names {
+		data, err := owner[name].GetMeta(name)
+		if err != nil {
+			continue
+		}
+		for _, d := range s.disks {
+			if _, err := d.GetMeta(name); !errors.Is(err, ErrMetaNotFound) {
+				continue
+			}
+			if err := d.PutMeta(name, data); err != nil {
+				return copied, fmt.Errorf("copy meta %s to %s failed: %w", name, d.BasePath, err)
+			}
+			copied++
+		}
+	}
+	return copied, nil
+}
+
+// SetCorruptionHandler 设置读取时发现副本损坏的回调，须在读取前调用
+func (s *MultiDiskStore) SetCorruptionHandler(fn func(hash string)) {
+	s.onCorrupt = fn
+}
+
+// GetMeta 依次从各磁盘读取元数据，跳过读取出错的磁盘
+func (s *MultiDiskStore) GetMeta(name string) ([]byte, error) {
+	var lastErr error = ErrMetaNotFound
+	for _, d := range s.disks {
+		data, err := d.GetMeta(name)
+		if err == nil {
+			return data, nil
+		}
+		if !errors.Is(err, ErrMetaNotFound) {
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
+
+// PutMeta 写入所有磁盘，至少一块成功即可。写入失败的磁盘删除旧内容，避免读到过期数据。
+func (s *MultiDiskStore) PutMeta(name string, data []byte) error {
+	var firstErr error
+	ok := 0
+	for _, d := range s.disks {
+		if err := d.PutMeta(name, data); err != nil {
+			_ = d.DeleteMeta(name)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		ok++
+	}
+	if ok == 0 {
+		return firstErr
+	}
+	return nil
+}
+
+// DeleteMeta 从所有磁盘删除元数据
+func (s *MultiDiskStore) DeleteMeta(name string) error {
+	var firstErr error
+	for _, d := range s.disks {
+		if err := d.DeleteMeta(name); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ListMeta 合并各磁盘的列表
+func (s *MultiDiskStore) ListMeta(prefix string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, d := range s.disks {
+		list, err := d.ListMeta(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range list {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
+
+func (s *MultiDiskStore) contentCheck(fileHash string, expectedSize int64, strongHash string) contentCheck {
+	return s.disks[0].contentCheck(fileHash, expectedSize, strongHash)
+}
+
+func (s *MultiDiskStore) setContentChecker(c contentChecker) {
+	for _, d := range s.disks {
+		d.setContentChecker(c)
+	}
+}
+
+// MisplacedFiles 列出各磁盘上不在当前布局位置的文件
+func (s *MultiDiskStore) MisplacedFiles() ([]string, error) {
+	var hashes []string
+	seen := make(map[string]bool)
+	for _, d := range s.disks {
+		list, err := d.MisplacedFiles()
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range list {
+			if !seen[h] {
+				seen[h] = true
+				hashes = append(hashes, h)
+			}
+		}
+	}
+	return hashes, nil
+}
+
+// MigrateFile 在每块存有副本的磁盘上迁移（连同分段校验和），返回 FilePath 对应的路径
+func (s *MultiDiskStore) MigrateFile(hash string) (string, error) {
+	for _, d := range s.disks {
+		if !d.FileExists(hash) {
+			continue
+		}
+		if _, err := d.MigrateFile(hash); err != nil {
+			return "", fmt.Errorf("%s: %w", d.BasePath, err)
+		}
+		if sums, err := d.readSums(hash); err == nil {
+			_ = d.writeSums(hash, sums)
+		}
+	}
+	return s.FilePath(hash), nil
+}
+
+// FilePath 按读取顺序第一个副本的路径，没有副本时为首选磁盘上的路径
+func (s *MultiDiskStore) FilePath(hash string) string {
+	order := s.readOrder(hash)
+	for _, i := range order {
+		if s.disks[i].FileExists(hash) {
+			return s.disks[i].getFilePath(hash)
+		}
+	}
+	return s.disks[order[0]].getFilePath(hash)
+}
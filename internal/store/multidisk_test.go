@@ -0,0 +1,293 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testSegment 测试中的分段长度
+const testSegment = 1024
+
+// newTestMultiDisk 两块磁盘、两份副本，写入 data 并返回其 hash
+func newTestMultiDisk(t *testing.T, data []byte) (*MultiDiskStore, string) {
+	t.Helper()
+	prev := sumSegmentSize
+	sumSegmentSize = testSegment
+	t.Cleanup(func() { sumSegmentSize = prev })
+
+	dir := t.TempDir()
+	s, err := NewMultiDiskStore([]string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}, filepath.Join(dir, "tmp"), 2, PlacementHash, nil)
+	if err != nil {
+		t.Fatalf("NewMultiDiskStore: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	hash := FormatFileHash(HashSHA256, sum[:])
+	if err := s.WriteChunk(1, hash, 0, bytes.NewReader(data), nil); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+	if _, _, err := s.MergeChunks(1, hash, 1, int64(len(data)), ""); err != nil {
+		t.Fatalf("MergeChunks: %v", err)
+	}
+	return s, hash
+}
+
+// testData 若干段长、最后一段较短的内容
+func testData() []byte {
+	data := make([]byte, 5*testSegment+100)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+// corruptReplica 改写磁盘 d 上副本在 off 处的字节
+func corruptReplica(t *testing.T, d *LocalStore, hash string, off int) {
+	t.Helper()
+	path := d.getFilePath(hash)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read replica: %v", err)
+	}
+	data[off] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("write replica: %v", err)
+	}
+}
+
+// firstReplica 读取顺序中的第一份副本
+func firstReplica(s *MultiDiskStore, hash string) *LocalStore {
+	return s.disks[s.replicaDisks(hash)[0]]
+}
+
+// readAll 读完整个文件
+func readAll(t *testing.T, s *MultiDiskStore, hash string) ([]byte, error) {
+	t.Helper()
+	rc, _, err := s.GetFile(hash)
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func TestMultiDiskMergeWritesSums(t *testing.T) {
+	data := testData()
+	s, hash := newTestMultiDisk(t, data)
+	for _, d := range s.disks {
+		sums, err := d.readSums(hash)
+		if err != nil {
+			t.Fatalf("readSums on %s: %v", d.BasePath, err)
+		}
+		if sums.size != int64(len(data)) || sums.segment != testSegment || len(sums.sums) != 6 {
+			t.Fatalf("sums = size %d segment %d count %d, want %d/%d/6", sums.size, sums.segment, len(sums.sums), len(data), testSegment)
+		}
+	}
+}
+
+func TestMultiDiskWholeReadFailsOverOnCorruptSegment(t *testing.T) {
+	data := testData()
+	s, hash := newTestMultiDisk(t, data)
+	var reported []string
+	s.SetCorruptionHandler(func(h string) { reported = append(reported, h) })
+	d := firstReplica(s, hash)
+	corruptReplica(t, d, hash, 3*testSegment+5)
+
+	got, err := readAll(t, s, hash)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read = %d bytes, %v; want the intact content", len(got), err)
+	}
+	if len(reported) != 1 || reported[0] != hash {
+		t.Fatalf("reported = %v, want [%s]", reported, hash)
+	}
+	if d.FileExists(hash) {
+		t.Fatal("corrupt replica still readable")
+	}
+
+	// 巡检补齐副本后两份都完好
+	result, err := s.RepairFile(hash, true)
+	if err != nil {
+		t.Fatalf("RepairFile: %v", err)
+	}
+	if result.Intact != 1 || result.Copied != 1 {
+		t.Fatalf("repair = %+v, want 1 intact, 1 copied", result)
+	}
+	if got, err := readAll(t, s, hash); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read after repair: %v", err)
+	}
+}
+
+func TestMultiDiskRangeReadFailsOverOnCorruptSegment(t *testing.T) {
+	data := testData()
+	for _, tc := range []struct {
+		name       string
+		start, end int64
+	}{
+		{"within segment", 2*testSegment + 10, 2*testSegment + 20},
+		{"across segments", testSegment - 3, 4*testSegment + 3},
+		{"last short segment", 5*testSegment - 1, int64(len(data)) - 1},
+		{"end past size", 4 * testSegment, int64(len(data)) + 50},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, hash := newTestMultiDisk(t, data)
+			d := firstReplica(s, hash)
+			corruptReplica(t, d, hash, int(tc.start))
+
+			rc, err := s.GetFileRange(hash, tc.start, tc.end)
+			if err != nil {
+				t.Fatalf("GetFileRange: %v", err)
+			}
+			got, err := io.ReadAll(rc)
+			rc.Close()
+			end := tc.end
+			if end >= int64(len(data)) {
+				end = int64(len(data)) - 1
+			}
+			if err != nil || !bytes.Equal(got, data[tc.start:end+1]) {
+				t.Fatalf("range read = %d bytes, %v; want %d intact bytes", len(got), err, end-tc.start+1)
+			}
+			if d.FileExists(hash) {
+				t.Fatal("corrupt replica still readable")
+			}
+		})
+	}
+}
+
+func TestMultiDiskAllReplicasCorrupt(t *testing.T) {
+	data := testData()
+	s, hash := newTestMultiDisk(t, data)
+	for _, d := range s.disks {
+		corruptReplica(t, d, hash, 2*testSegment)
+	}
+
+	got, err := readAll(t, s, hash)
+	if !errors.Is(err, ErrReplicaCorrupt) {
+		t.Fatalf("read err = %v, want ErrReplicaCorrupt", err)
+	}
+	if !bytes.Equal(got, data[:2*testSegment]) {
+		t.Fatalf("read returned %d bytes, want only the %d verified bytes before the corrupt segment", len(got), 2*testSegment)
+	}
+	// 最后一份留给巡检判断
+	if !s.FileExists(hash) {
+		t.Fatal("last replica moved aside")
+	}
+}
+
+func TestMultiDiskTruncatedReplica(t *testing.T) {
+	data := testData()
+	s, hash := newTestMultiDisk(t, data)
+	d := firstReplica(s, hash)
+	if err := os.Truncate(d.getFilePath(hash), 2*testSegment); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	if got, err := readAll(t, s, hash); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read = %d bytes, %v; want the intact content", len(got), err)
+	}
+	if d.FileExists(hash) {
+		t.Fatal("truncated replica still readable")
+	}
+}
+
+func TestMultiDiskCorruptSumsFile(t *testing.T) {
+	data := testData()
+	s, hash := newTestMultiDisk(t, data)
+	d := firstReplica(s, hash)
+	if err := os.WriteFile(d.getFilePath(hash)+".sum", []byte("VPS1 garbage"), 0644); err != nil {
+		t.Fatalf("write sums: %v", err)
+	}
+	corruptReplica(t, d, hash, 10)
+
+	// 另一块磁盘上的校验和同样描述该内容
+	if got, err := readAll(t, s, hash); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read = %d bytes, %v; want the intact content", len(got), err)
+	}
+}
+
+func TestMultiDiskLegacyFileWithoutSums(t *testing.T) {
+	data := testData()
+	s, hash := newTestMultiDisk(t, data)
+	for _, d := range s.disks {
+		if err := d.removeSums(hash); err != nil {
+			t.Fatalf("removeSums: %v", err)
+		}
+	}
+	var reported []string
+	s.SetCorruptionHandler(func(h string) { reported = append(reported, h) })
+	d := firstReplica(s, hash)
+	corruptReplica(t, d, hash, 0)
+
+	// 没有校验和时只能在读完后发现
+	if _, err := readAll(t, s, hash); !errors.Is(err, ErrReplicaCorrupt) {
+		t.Fatalf("read err = %v, want ErrReplicaCorrupt", err)
+	}
+	if len(reported) != 1 || d.FileExists(hash) {
+		t.Fatalf("reported = %v, corrupt replica readable = %v", reported, d.FileExists(hash))
+	}
+
+	// 校验修复后补齐校验和
+	if _, err := s.RepairFile(hash, true); err != nil {
+		t.Fatalf("RepairFile: %v", err)
+	}
+	for _, d := range s.disks {
+		if _, err := d.readSums(hash); err != nil {
+			t.Fatalf("sums on %s after repair: %v", d.BasePath, err)
+		}
+	}
+}
+
+func TestMultiDiskDeleteAndLinkSums(t *testing.T) {
+	data := testData()
+	s, hash := newTestMultiDisk(t, data)
+	newHash := FormatFileHash(HashMD5, make([]byte, 16))
+
+	if _, err := s.LinkFile(hash, newHash); err != nil {
+		t.Fatalf("LinkFile: %v", err)
+	}
+	if err := s.DeleteFile(hash); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	for _, d := range s.disks {
+		if _, err := os.Stat(d.getFilePath(hash) + ".sum"); !os.IsNotExist(err) {
+			t.Fatalf("sums of deleted file on %s: %v", d.BasePath, err)
+		}
+		if _, err := d.readSums(newHash); err != nil {
+			t.Fatalf("sums of linked file on %s: %v", d.BasePath, err)
+		}
+	}
+}
+
+func TestSegmentSumsRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, testSegment, testSegment + 1, 3 * testSegment} {
+		w := &sumWriter{segment: testSegment}
+		// 分多次写入，跨越段边界
+		data := bytes.Repeat([]byte{0x5a}, size)
+		for p := data; len(p) > 0; {
+			n := 300
+			if n > len(p) {
+				n = len(p)
+			}
+			w.Write(p[:n])
+			p = p[n:]
+		}
+		sums, err := parseSegmentSums(w.result().marshal())
+		if err != nil {
+			t.Fatalf("size %d: parse: %v", size, err)
+		}
+		for i := int64(0); i*testSegment < int64(size); i++ {
+			seg := data[i*testSegment : i*testSegment+sums.segmentLen(i)]
+			if !sums.verify(i, seg) {
+				t.Fatalf("size %d: segment %d does not verify", size, i)
+			}
+		}
+		raw := w.result().marshal()
+		raw[len(raw)-5] ^= 1
+		if _, err := parseSegmentSums(raw); !errors.Is(err, errBadSums) {
+			t.Fatalf("size %d: tampered sums parsed: %v", size, err)
+		}
+	}
+}
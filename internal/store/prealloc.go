@@ -0,0 +1,387 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// 预分配模式：上传会话建立时按文件大小创建目标文件，分片直接写到 index*chunkSize 处。
+// 合并时只需检查分片位图、补算尚未计入的摘要并改名，省去逐片拷贝。
+// 位图与摘要进度保存在分片目录中，进程重启后可以继续续传。
+
+var errChunkOverflow = errors.New("chunk data exceeds chunk size")
+
+// Preallocator 可选接口：支持预分配的存储在上传会话建立时调用
+type Preallocator interface {
+	// Preallocate 为上传预分配目标文件，布局相同时重复调用不影响已写入的分片
+	Preallocate(userID int, hash string, fileSize, chunkSize int64) error
+}
+
+// preallocLayout 预分配文件的布局，写在分片目录的 prealloc 文件中
+type preallocLayout struct {
+	FileSize  int64
+	ChunkSize int64
+}
+
+func (l *preallocLayout) totalChunks() int {
+	return int((l.FileSize + l.ChunkSize - 1) / l.ChunkSize)
+}
+
+func (l *preallocLayout) chunkLength(index int) int64 {
+	start := int64(index) * l.ChunkSize
+	if start+l.ChunkSize > l.FileSize {
+		return l.FileSize - start
+	}
+	return l.ChunkSize
+}
+
+func (s *LocalStore) getLayoutPath(userID int, hash string) string {
+	return filepath.Join(s.getChunkDir(userID, hash), "prealloc")
+}
+
+func (s *LocalStore) getBitmapPath(userID int, hash string) string {
+	return filepath.Join(s.getChunkDir(userID, hash), "bitmap")
+}
+
+// getHashStatePath 已计入摘要的分片数与 MD5 中间状态
+func (s *LocalStore) getHashStatePath(userID int, hash string) string {
+	return filepath.Join(s.getChunkDir(userID, hash), "hashstate")
+}
+
+// getPartialPath 预分配的数据文件放在 BasePath 下，合并时同一文件系统内改名即可
+func (s *LocalStore) getPartialPath(userID int, hash string) string {
+	return filepath.Join(s.BasePath, ".partial", strconv.Itoa(userID), hash)
+}
+
+// uploadLock 同一上传的进程内互斥锁，保护位图与摘要进度的读改写
+func (s *LocalStore) uploadLock(userID int, hash string) *sync.Mutex {
+	key := uploadCacheKey(userID, hash)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[key] = l
+	}
+	return l
+}
+
+// loadLayout 读取预分配布局，未预分配时返回 nil
+func (s *LocalStore) loadLayout(userID int, hash string) (*preallocLayout, error) {
+	data, err := os.ReadFile(s.getLayoutPath(userID, hash))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var layout preallocLayout
+	if _, err := fmt.Sscanf(string(data), "%d %d", &layout.FileSize, &layout.ChunkSize); err != nil ||
+		layout.FileSize <= 0 || layout.ChunkSize <= 0 {
+		return nil, fmt.Errorf("invalid prealloc layout for %s", hash)
+	}
+	return &layout, nil
+}
+
+// Preallocate 创建目标文件与空位图。未开启预分配、或已有按分片文件保存的数据
+// （开启预分配前开始的上传）时不做处理，继续使用分片文件。
+func (s *LocalStore) Preallocate(userID int, hash string, fileSize, chunkSize int64) error {
+	if !s.PreallocateUploads || fileSize <= 0 || chunkSize <= 0 {
+		return nil
+	}
+
+	layout, err := s.loadLayout(userID, hash)
+	if err != nil {
+		return err
+	}
+	if layout != nil && layout.FileSize == fileSize && layout.ChunkSize == chunkSize {
+		return nil
+	}
+	if layout == nil {
+		chunks, err := s.GetUploadedChunks(userID, hash)
+		if err != nil {
+			return err
+		}
+		if len(chunks) > 0 {
+			return nil
+		}
+	}
+
+	if err := s.CleanupChunks(userID, hash); err != nil {
+		return fmt.Errorf("cleanup chunks failed: %w", err)
+	}
+	partialPath := s.getPartialPath(userID, hash)
+	if err := os.MkdirAll(s.getChunkDir(userID, hash), 0755); err != nil {
+		return fmt.Errorf("create chunk dir failed: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(partialPath), 0755); err != nil {
+		return fmt.Errorf("create partial dir failed: %w", err)
+	}
+
+	f, err := os.OpenFile(partialPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("create partial file failed: %w", err)
+	}
+	if err := allocateFile(f, fileSize); err != nil {
+		f.Close()
+		os.Remove(partialPath)
+		return fmt.Errorf("preallocate %d bytes failed: %w", fileSize, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(partialPath)
+		return fmt.Errorf("close partial file failed: %w", err)
+	}
+
+	layout = &preallocLayout{FileSize: fileSize, ChunkSize: chunkSize}
+	bitmap := make([]byte, (layout.totalChunks()+7)/8)
+	if err := writeFileAtomic(s.getBitmapPath(userID, hash), bitmap); err != nil {
+		return fmt.Errorf("write chunk bitmap failed: %w", err)
+	}
+	// 布局文件最后写入，存在即表示预分配已完成
+	data := fmt.Sprintf("%d %d\n", fileSize, chunkSize)
+	if err := writeFileAtomic(s.getLayoutPath(userID, hash), []byte(data)); err != nil {
+		return fmt.Errorf("write prealloc layout failed: %w", err)
+	}
+	return nil
+}
+
+// writeChunkAt 把分片写到预分配文件的对应位置，校验通过且数据落盘后再置位
+func (s *LocalStore) writeChunkAt(userID int, hash string, index int, layout *preallocLayout, content io.Reader, checksum *ChunkChecksum) error {
+	if index < 0 || index >= layout.totalChunks() {
+		return fmt.Errorf("chunk index %d out of range [0, %d)", index, layout.totalChunks())
+	}
+	lock := s.uploadLock(userID, hash)
+
+	// 重写分片期间不再报告为已上传
+	lock.Lock()
+	err := s.markChunk(userID, hash, index, false)
+	lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("update chunk bitmap failed: %w", err)
+	}
+
+	f, err := os.OpenFile(s.getPartialPath(userID, hash), os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open partial file failed: %w", err)
+	}
+	length := layout.chunkLength(index)
+	w := &sectionWriter{f: f, off: int64(index) * layout.ChunkSize, remaining: length}
+	_, digest := chunkDigest(checksum)
+	written, err := io.Copy(io.MultiWriter(w, digest), content)
+	if err == nil && written != length {
+		err = fmt.Errorf("chunk %d has %d bytes, expected %d", index, written, length)
+	}
+	if err == nil {
+		// 位图置位前确保数据已落盘，断电后不会把未写完的分片当作已上传
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("write chunk failed: %w", err)
+	}
+
+	if err := verifyChunk(index, checksum, digest); err != nil {
+		return err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if err := s.markChunk(userID, hash, index, true); err != nil {
+		return fmt.Errorf("update chunk bitmap failed: %w", err)
+	}
+	// 顺带推进摘要进度，合并时只需补算剩余部分；失败不影响分片本身
+	_, _, _ = s.advanceHash(userID, hash, layout)
+	return nil
+}
+
+// uploadedFromBitmap 按位图报告已上传的分片
+func (s *LocalStore) uploadedFromBitmap(userID int, hash string, layout *preallocLayout) ([]int, error) {
+	bitmap, err := os.ReadFile(s.getBitmapPath(userID, hash))
+	if err != nil {
+		return nil, err
+	}
+	chunks := []int{}
+	for i := 0; i < layout.totalChunks(); i++ {
+		if bitSet(bitmap, i) {
+			chunks = append(chunks, i)
+		}
+	}
+	return chunks, nil
+}
+
+// mergePreallocated 检查位图并补算摘要，校验通过后把预分配文件改名为正式文件
+func (s *LocalStore) mergePreallocated(userID int, hash string, totalChunks int, expectedSize int64, strongHash string, layout *preallocLayout) (string, int64, error) {
+	if totalChunks != layout.totalChunks() {
+		return "", 0, fmt.Errorf("total chunks %d does not match preallocated layout (%d)", totalChunks, layout.totalChunks())
+	}
+
+	lock := s.uploadLock(userID, hash)
+	lock.Lock()
+	defer lock.Unlock()
+
+	bitmap, err := os.ReadFile(s.getBitmapPath(userID, hash))
+	if err != nil {
+		return "", 0, fmt.Errorf("read chunk bitmap failed: %w", err)
+	}
+	for i := 0; i < totalChunks; i++ {
+		if !bitSet(bitmap, i) {
+			return "", 0, fmt.Errorf("open chunk %d failed: chunk not uploaded", i)
+		}
+	}
+
+	hashed, digest, err := s.advanceHash(userID, hash, layout)
+	if err != nil {
+		return "", 0, fmt.Errorf("hash partial file failed: %w", err)
+	}
+	if hashed != totalChunks {
+		return "", 0, fmt.Errorf("hash partial file failed: stopped at chunk %d", hashed)
+	}
+	if err := verifyContent(hash, expectedSize, digest, layout.FileSize); err != nil {
+		return "", 0, err
+	}
+	if strongHash != "" && strongHash != hash {
+		// 摘要进度只覆盖文件 hash 的算法，强摘要需要重新读一遍
+		if err := s.verifyPartialStrongHash(userID, hash, strongHash); err != nil {
+			if strong := collisionHash(err); strong != "" {
+				return s.storeCollided(userID, hash, s.getPartialPath(userID, hash), strong, layout.FileSize)
+			}
+			return "", 0, err
+		}
+	}
+
+	destPath, err := s.prepareFilePath(hash)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(s.getPartialPath(userID, hash), destPath); err != nil {
+		return "", 0, fmt.Errorf("rename to dest failed: %w", err)
+	}
+	s.removeLegacyCopy(hash)
+	os.RemoveAll(s.getChunkDir(userID, hash))
+	return destPath, layout.FileSize, nil
+}
+
+// verifyPartialStrongHash 计算预分配文件的 SHA-256 并与强摘要比较
+func (s *LocalStore) verifyPartialStrongHash(userID int, hash, strongHash string) error {
+	f, err := os.Open(s.getPartialPath(userID, hash))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	digest := sha256.New()
+	if _, err := io.Copy(digest, f); err != nil {
+		return fmt.Errorf("hash partial file failed: %w", err)
+	}
+	return verifyStrongHash(strongHash, digest)
+}
+
+// markChunk 设置或清除分片位；清除已计入摘要的分片时摘要进度作废
+func (s *LocalStore) markChunk(userID int, hash string, index int, set bool) error {
+	path := s.getBitmapPath(userID, hash)
+	bitmap, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if index/8 >= len(bitmap) {
+		return fmt.Errorf("chunk index %d exceeds bitmap", index)
+	}
+	if set {
+		bitmap[index/8] |= 1 << (index % 8)
+	} else {
+		if bitSet(bitmap, index) {
+			if hashed, _ := s.loadHashState(userID, hash); index < hashed {
+				os.Remove(s.getHashStatePath(userID, hash))
+			}
+		}
+		bitmap[index/8] &^= 1 << (index % 8)
+	}
+	return writeFileAtomic(path, bitmap)
+}
+
+// advanceHash 从已计入的位置起把连续已写入的分片计入摘要并保存进度，
+// 返回已计入的分片数与当前摘要。调用方需持有上传锁。
+func (s *LocalStore) advanceHash(userID int, hash string, layout *preallocLayout) (int, hash.Hash, error) {
+	hashed, digest := s.loadHashState(userID, hash)
+	if hashed > layout.totalChunks() {
+		hashed, digest = 0, NewFileDigest(hash)
+	}
+	bitmap, err := os.ReadFile(s.getBitmapPath(userID, hash))
+	if err != nil {
+		return hashed, digest, err
+	}
+
+	start := hashed
+	f, err := os.Open(s.getPartialPath(userID, hash))
+	if err != nil {
+		return hashed, digest, err
+	}
+	defer f.Close()
+	for hashed < layout.totalChunks() && bitSet(bitmap, hashed) {
+		section := io.NewSectionReader(f, int64(hashed)*layout.ChunkSize, layout.chunkLength(hashed))
+		if _, err := io.Copy(digest, section); err != nil {
+			// 摘要已部分更新，丢弃进度从头再算
+			os.Remove(s.getHashStatePath(userID, hash))
+			return 0, NewFileDigest(hash), err
+		}
+		hashed++
+	}
+
+	if hashed > start {
+		if state, err := digest.(encoding.BinaryMarshaler).MarshalBinary(); err == nil {
+			buf := binary.BigEndian.AppendUint64(nil, uint64(hashed))
+			_ = writeFileAtomic(s.getHashStatePath(userID, hash), append(buf, state...))
+		}
+	}
+	return hashed, digest, nil
+}
+
+// loadHashState 读取摘要进度，不存在或无法解析时从头开始
+func (s *LocalStore) loadHashState(userID int, hash string) (int, hash.Hash) {
+	digest := NewFileDigest(hash)
+	data, err := os.ReadFile(s.getHashStatePath(userID, hash))
+	if err != nil || len(data) < 8 {
+		return 0, digest
+	}
+	if err := digest.(encoding.BinaryUnmarshaler).UnmarshalBinary(data[8:]); err != nil {
+		return 0, NewFileDigest(hash)
+	}
+	return int(binary.BigEndian.Uint64(data[:8])), digest
+}
+
+// removePartial 删除预分配的数据文件
+func (s *LocalStore) removePartial(userID int, hash string) {
+	os.Remove(s.getPartialPath(userID, hash))
+	s.mu.Lock()
+	delete(s.locks, uploadCacheKey(userID, hash))
+	s.mu.Unlock()
+}
+
+func bitSet(bitmap []byte, index int) bool {
+	return index/8 < len(bitmap) && bitmap[index/8]&(1<<(index%8)) != 0
+}
+
+// sectionWriter 从 off 起顺序写入，超过 remaining 时报错，避免写进相邻分片
+type sectionWriter struct {
+	f         *os.File
+	off       int64
+	remaining int64
+}
+
+func (w *sectionWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.remaining {
+		return 0, errChunkOverflow
+	}
+	n, err := w.f.WriteAt(p, w.off)
+	w.off += int64(n)
+	w.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,995 @@
+package store
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 分片编号从 1 开始，最多 10000 个
+	s3MaxParts = 10000
+	// 除最后一个外每个 part 不小于 5MiB，否则 CompleteMultipartUpload 返回 EntityTooSmall
+	s3MinPartSize = 5 << 20
+
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3 单次 CopyObject 的上限为 5GiB，超过时改用分段拷贝（测试中调小）
+var (
+	s3MaxCopySize  int64 = 5 << 30
+	s3CopyPartSize int64 = 1 << 30
+)
+
+// ChunkSizeLimiter 可选接口：后端要求除最后一片外的分片不小于某个大小
+type ChunkSizeLimiter interface {
+	MinChunkSize() int64
+}
+
+// S3Config S3 兼容对象存储配置（AWS S3 / MinIO / OSS 等）
+type S3Config struct {
+	Endpoint  string // 例如 https://s3.amazonaws.com 或 http://127.0.0.1:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string // 对象 key 前缀，可为空
+	PathStyle bool   // MinIO 等本地替身通常需要 path-style 寻址
+	TempPath  string // 分片上传前的本地缓冲目录
+}
+
+// S3Store S3 兼容对象存储实现
+//
+// 分片对应 multipart upload 的 part（part 编号 = 分片索引 + 1），
+// MergeChunks 对应 CompleteMultipartUpload，GetFileRange 使用 Range GET。
+// 上传中的对象写在 uploads/<user>/<hash>，完成后拷贝到 objects/<hash>，
+// 这样失败或取消的上传不会覆盖已存在的文件。
+type S3Store struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+
+	mu      sync.Mutex
+	uploads map[string]string // "<user>/<hash>" -> uploadId 缓存
+	locks   map[string]*sync.Mutex
+
+	newCheck contentChecker // 合并时的内容校验，nil 时直接比较 MD5
+}
+
+// NewS3Store 创建 S3 存储
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 endpoint and bucket are required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.TempPath == "" {
+		cfg.TempPath = os.TempDir()
+	}
+	if cfg.Prefix != "" && !strings.HasSuffix(cfg.Prefix, "/") {
+		cfg.Prefix += "/"
+	}
+
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint: %s", cfg.Endpoint)
+	}
+
+	os.MkdirAll(cfg.TempPath, 0755)
+
+	return &S3Store{
+		cfg:      cfg,
+		endpoint: u,
+		client:   &http.Client{Timeout: 10 * time.Minute},
+		uploads:  make(map[string]string),
+		locks:    make(map[string]*sync.Mutex),
+	}, nil
+}
+
+func (s *S3Store) objectKey(hash string) string {
+	return s.cfg.Prefix + "objects/" + hash
+}
+
+func (s *S3Store) stagingKey(userID int, hash string) string {
+	return fmt.Sprintf("%suploads/%d/%s", s.cfg.Prefix, userID, hash)
+}
+
+func (s *S3Store) uploadIDKey(userID int, hash string) string {
+	return s.stagingKey(userID, hash) + ".uploadid"
+}
+
+// partsKey 记录完成 multipart upload 时的分片数：完成后 ListParts 不再可用，
+// 合并中途失败重试时据此报告已上传的分片
+func (s *S3Store) partsKey(userID int, hash string) string {
+	return s.stagingKey(userID, hash) + ".parts"
+}
+
+func uploadCacheKey(userID int, hash string) string {
+	return fmt.Sprintf("%d/%s", userID, hash)
+}
+
+// keyLock 返回同一上传的进程内互斥锁，避免并发分片各自创建 multipart upload
+func (s *S3Store) keyLock(key string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[key] = l
+	}
+	return l
+}
+
+// getUploadID 获取（可选创建）用户上传对应的 multipart uploadId
+func (s *S3Store) getUploadID(userID int, hash string, create bool) (string, error) {
+	cacheKey := uploadCacheKey(userID, hash)
+
+	s.mu.Lock()
+	id, ok := s.uploads[cacheKey]
+	s.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	l := s.keyLock(cacheKey)
+	l.Lock()
+	defer l.Unlock()
+
+	s.mu.Lock()
+	id, ok = s.uploads[cacheKey]
+	s.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	id, err := s.readSmallObject(s.uploadIDKey(userID, hash))
+	if err == nil && id != "" {
+		s.cacheUploadID(cacheKey, id)
+		return id, nil
+	}
+	if err != nil && !isS3NotFound(err) {
+		return "", err
+	}
+	if !create {
+		return "", nil
+	}
+
+	id, err = s.createMultipartUpload(s.stagingKey(userID, hash))
+	if err != nil {
+		return "", err
+	}
+
+	// If-None-Match 保证多节点并发初始化时只有一个 uploadId 生效
+	if err := s.putSmallObject(s.uploadIDKey(userID, hash), []byte(id), true); err != nil {
+		var se *s3Error
+		if errors.As(err, &se) && se.StatusCode == http.StatusPreconditionFailed {
+			_ = s.abortMultipartUpload(s.stagingKey(userID, hash), id)
+			existing, err := s.readSmallObject(s.uploadIDKey(userID, hash))
+			if err != nil {
+				return "", err
+			}
+			s.cacheUploadID(cacheKey, existing)
+			return existing, nil
+		}
+		_ = s.abortMultipartUpload(s.stagingKey(userID, hash), id)
+		return "", err
+	}
+
+	s.cacheUploadID(cacheKey, id)
+	return id, nil
+}
+
+func (s *S3Store) cacheUploadID(cacheKey, id string) {
+	s.mu.Lock()
+	s.uploads[cacheKey] = id
+	s.mu.Unlock()
+}
+
+func (s *S3Store) forgetUploadID(userID int, hash string) {
+	s.mu.Lock()
+	delete(s.uploads, uploadCacheKey(userID, hash))
+	s.mu.Unlock()
+}
+
+// MinChunkSize multipart upload 的最小 part 大小
+func (s *S3Store) MinChunkSize() int64 {
+	return s3MinPartSize
+}
+
+// WriteChunk 写入分片（作为 multipart upload 的一个 part），校验通过后才上传，
+// 因此 ListParts 列出的分片都已通过校验
+func (s *S3Store) WriteChunk(userID int, hash string, index int, content io.Reader, checksum *ChunkChecksum) error {
+	if index < 0 || index >= s3MaxParts {
+		return fmt.Errorf("chunk index %d out of range for s3", index)
+	}
+
+	// 先落到本地缓冲文件：UploadPart 需要确定的 Content-Length 和签名摘要
+	tmp, err := os.CreateTemp(s.cfg.TempPath, "s3part-*")
+	if err != nil {
+		return fmt.Errorf("create chunk buffer failed: %w", err)
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	sha := sha256.New()
+	md := md5.New()
+	_, digest := chunkDigest(checksum)
+	written, err := io.Copy(io.MultiWriter(tmp, sha, md, digest), content)
+	if err != nil {
+		return fmt.Errorf("write chunk failed: %w", err)
+	}
+	if written == 0 {
+		return fmt.Errorf("empty chunk data")
+	}
+	if err := verifyChunk(index, checksum, digest); err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	uploadID, err := s.getUploadID(userID, hash, true)
+	if err != nil {
+		return fmt.Errorf("init multipart upload failed: %w", err)
+	}
+
+	query := url.Values{}
+	query.Set("partNumber", strconv.Itoa(index+1))
+	query.Set("uploadId", uploadID)
+
+	headers := http.Header{}
+	headers.Set("Content-MD5", base64.StdEncoding.EncodeToString(md.Sum(nil)))
+
+	resp, err := s.do(http.MethodPut, s.stagingKey(userID, hash), query, headers,
+		tmp, written, hex.EncodeToString(sha.Sum(nil)))
+	if err != nil {
+		if isS3NotFound(err) {
+			// uploadId 已失效（被中止或过期），下次重新创建
+			s.forgetUploadID(userID, hash)
+		}
+		return fmt.Errorf("upload part %d failed: %w", index, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// GetUploadedChunks 获取已上传的分片索引
+func (s *S3Store) GetUploadedChunks(userID int, hash string) ([]int, error) {
+	uploadID, err := s.getUploadID(userID, hash, false)
+	if err != nil {
+		return nil, err
+	}
+	if uploadID == "" {
+		return []int{}, nil
+	}
+
+	parts, err := s.listParts(s.stagingKey(userID, hash), uploadID)
+	if isS3NotFound(err) {
+		return s.completedChunks(userID, hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]int, 0, len(parts))
+	for _, p := range parts {
+		chunks = append(chunks, p.PartNumber-1)
+	}
+	sort.Ints(chunks)
+	return chunks, nil
+}
+
+// completedChunks multipart upload 已不存在时：上次合并已完成它则报告当时的全部分片，
+// 否则（被中止或过期）没有分片
+func (s *S3Store) completedChunks(userID int, hash string) ([]int, error) {
+	s.forgetUploadID(userID, hash)
+	_, exists, err := s.headObject(s.stagingKey(userID, hash))
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return []int{}, nil
+	}
+	data, err := s.readSmallObject(s.partsKey(userID, hash))
+	if isS3NotFound(err) {
+		return []int{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(data)
+	if err != nil {
+		return nil, fmt.Errorf("bad parts record for %s: %q", hash, data)
+	}
+	chunks := make([]int, n)
+	for i := range chunks {
+		chunks[i] = i
+	}
+	return chunks, nil
+}
+
+// MergeChunks 合并分片（CompleteMultipartUpload 后校验内容，再拷贝到最终位置）
+func (s *S3Store) MergeChunks(userID int, hash string, totalChunks int, expectedSize int64, strongHash string) (string, int64, error) {
+	uploadID, err := s.getUploadID(userID, hash, false)
+	if err != nil {
+		return "", 0, err
+	}
+	if uploadID == "" {
+		return "", 0, fmt.Errorf("no multipart upload for %s", hash)
+	}
+
+	// 上次合并已完成 multipart upload（之后的步骤失败）时从上传中的对象继续
+	staging := s.stagingKey(userID, hash)
+	size, exists, err := s.headObject(staging)
+	if err != nil {
+		return "", 0, fmt.Errorf("stat merged object failed: %w", err)
+	}
+	if !exists {
+		if size, err = s.completeUpload(userID, hash, uploadID, totalChunks); err != nil {
+			return "", 0, err
+		}
+	}
+
+	// 流式读取合并结果并计算摘要，内容不符时删除上传中的对象，客户端需重新上传；
+	// 读取出错时保留，重试会从这里继续。md5 碰撞时改存在强摘要下
+	destHash := hash
+	if err := s.verifyObject(staging, hash, expectedSize, strongHash); err != nil {
+		destHash = collisionHash(err)
+		if destHash == "" {
+			if errors.Is(err, ErrIntegrity) {
+				s.removeUpload(userID, hash)
+			}
+			return "", 0, err
+		}
+	}
+
+	dest := s.objectKey(destHash)
+	if err := s.copyObject(staging, dest, size); err != nil {
+		return "", 0, fmt.Errorf("copy to dest failed: %w", err)
+	}
+	s.removeUpload(userID, hash)
+
+	path := fmt.Sprintf("s3://%s/%s", s.cfg.Bucket, dest)
+	if destHash != hash {
+		return "", 0, &CollisionError{FileHash: destHash, FilePath: path, FileSize: size}
+	}
+	return path, size, nil
+}
+
+// completeUpload 完成 multipart upload，返回合并后对象的大小
+func (s *S3Store) completeUpload(userID int, hash, uploadID string, totalChunks int) (int64, error) {
+	staging := s.stagingKey(userID, hash)
+	parts, err := s.listParts(staging, uploadID)
+	if err != nil {
+		return 0, fmt.Errorf("list parts failed: %w", err)
+	}
+
+	byNumber := make(map[int]s3Part, len(parts))
+	for _, p := range parts {
+		byNumber[p.PartNumber] = p
+	}
+	complete := make([]s3Part, 0, totalChunks)
+	for i := 1; i <= totalChunks; i++ {
+		p, ok := byNumber[i]
+		if !ok {
+			return 0, fmt.Errorf("open chunk %d failed: part missing", i-1)
+		}
+		complete = append(complete, p)
+	}
+
+	if err := s.putSmallObject(s.partsKey(userID, hash), []byte(strconv.Itoa(totalChunks)), false); err != nil {
+		return 0, fmt.Errorf("record parts failed: %w", err)
+	}
+	if err := s.completeMultipartUpload(staging, uploadID, complete); err != nil {
+		return 0, fmt.Errorf("complete multipart upload failed: %w", err)
+	}
+
+	size, exists, err := s.headObject(staging)
+	if err != nil || !exists {
+		return 0, fmt.Errorf("stat merged object failed: %v", err)
+	}
+	return size, nil
+}
+
+// removeUpload 删除上传中的对象与记录（合并完成或内容校验失败后）
+func (s *S3Store) removeUpload(userID int, hash string) {
+	s.forgetUploadID(userID, hash)
+	_ = s.deleteObject(s.stagingKey(userID, hash))
+	_ = s.deleteObject(s.partsKey(userID, hash))
+	_ = s.deleteObject(s.uploadIDKey(userID, hash))
+}
+
+func (s *S3Store) verifyObject(key, hash string, expectedSize int64, strongHash string) error {
+	resp, err := s.do(http.MethodGet, key, nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return fmt.Errorf("read merged object failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	check := s.contentCheck(hash, expectedSize, strongHash)
+	n, err := io.Copy(check, resp.Body)
+	if err != nil {
+		return fmt.Errorf("read merged object failed: %w", err)
+	}
+	return check.Verify(n)
+}
+
+func (s *S3Store) contentCheck(fileHash string, expectedSize int64, strongHash string) contentCheck {
+	if s.newCheck != nil {
+		return s.newCheck(fileHash, expectedSize, strongHash)
+	}
+	return newPlainCheck(fileHash, expectedSize, strongHash)
+}
+
+func (s *S3Store) setContentChecker(c contentChecker) {
+	s.newCheck = c
+}
+
+// CleanupChunks 中止 multipart upload 并清理上传中的对象
+func (s *S3Store) CleanupChunks(userID int, hash string) error {
+	uploadID, err := s.getUploadID(userID, hash, false)
+	if err != nil {
+		return err
+	}
+	s.forgetUploadID(userID, hash)
+
+	if uploadID != "" {
+		if err := s.abortMultipartUpload(s.stagingKey(userID, hash), uploadID); err != nil && !isS3NotFound(err) {
+			return err
+		}
+	}
+	if err := s.deleteObject(s.uploadIDKey(userID, hash)); err != nil {
+		return err
+	}
+	if err := s.deleteObject(s.partsKey(userID, hash)); err != nil {
+		return err
+	}
+	return s.deleteObject(s.stagingKey(userID, hash))
+}
+
+// GetFile 获取文件
+func (s *S3Store) GetFile(hash string) (io.ReadCloser, int64, error) {
+	resp, err := s.do(http.MethodGet, s.objectKey(hash), nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// GetFileRange 获取文件指定范围（闭区间）
+func (s *S3Store) GetFileRange(hash string, start, end int64) (io.ReadCloser, error) {
+	headers := http.Header{}
+	headers.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := s.do(http.MethodGet, s.objectKey(hash), nil, headers, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		// 个别实现会忽略 Range，这里手动截取
+		if _, err := io.CopyN(io.Discard, resp.Body, start); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		return &limitedReadCloser{
+			Reader: io.LimitReader(resp.Body, end-start+1),
+			Closer: resp.Body,
+		}, nil
+	}
+	return resp.Body, nil
+}
+
+// DeleteFile 删除文件
+func (s *S3Store) DeleteFile(hash string) error {
+	return s.deleteObject(s.objectKey(hash))
+}
+
+// FileExists 检查文件是否存在
+func (s *S3Store) FileExists(hash string) bool {
+	_, exists, err := s.headObject(s.objectKey(hash))
+	return err == nil && exists
+}
+
+// ListFiles 列出 objects/ 下已合并的文件
+func (s *S3Store) ListFiles() ([]StoredFile, error) {
+	prefix := s.cfg.Prefix + "objects/"
+	objects, err := s.listObjects(prefix)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]StoredFile, 0, len(objects))
+	for _, obj := range objects {
+		hash := strings.TrimPrefix(obj.Key, prefix)
+		if hash == "" || strings.Contains(hash, "/") {
+			continue
+		}
+		files = append(files, StoredFile{Hash: hash, Size: obj.Size, ModTime: obj.LastModified})
+	}
+	return files, nil
+}
+
+// ListUploads 以 uploads/<user>/<hash>.uploadid 列出上传中的 multipart upload，
+// 时间取 uploadId 记录的创建时间
+func (s *S3Store) ListUploads() ([]PendingUpload, error) {
+	prefix := s.cfg.Prefix + "uploads/"
+	objects, err := s.listObjects(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var pending []PendingUpload
+	for _, obj := range objects {
+		rest, ok := strings.CutSuffix(strings.TrimPrefix(obj.Key, prefix), ".uploadid")
+		if !ok {
+			continue
+		}
+		user, hash, ok := strings.Cut(rest, "/")
+		userID, err := strconv.Atoi(user)
+		if !ok || err != nil || hash == "" {
+			continue
+		}
+		pending = append(pending, PendingUpload{UserID: userID, Hash: hash, ModTime: obj.LastModified})
+	}
+	return pending, nil
+}
+
+// ======================== S3 REST 调用 ========================
+
+type s3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: http %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3: http %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func isS3NotFound(err error) bool {
+	var se *s3Error
+	return errors.As(err, &se) && se.StatusCode == http.StatusNotFound
+}
+
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+	Size       int64  `xml:"Size"`
+}
+
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// listObjects ListObjectsV2 列出 prefix 下的所有对象
+func (s *S3Store) listObjects(prefix string) ([]s3Object, error) {
+	var objects []s3Object
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(http.MethodGet, "", query, nil, nil, 0, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents              []s3Object `xml:"Contents"`
+			IsTruncated           bool       `xml:"IsTruncated"`
+			NextContinuationToken string     `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode list objects response failed: %w", err)
+		}
+
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3Store) createMultipartUpload(key string) (string, error) {
+	query := url.Values{}
+	query.Set("uploads", "")
+
+	resp, err := s.do(http.MethodPost, key, query, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("decode create multipart upload response failed: %w", err)
+	}
+	if result.UploadID == "" {
+		return "", fmt.Errorf("empty upload id")
+	}
+	return result.UploadID, nil
+}
+
+func (s *S3Store) listParts(key, uploadID string) ([]s3Part, error) {
+	var parts []s3Part
+	marker := ""
+	for {
+		query := url.Values{}
+		query.Set("uploadId", uploadID)
+		if marker != "" {
+			query.Set("part-number-marker", marker)
+		}
+
+		resp, err := s.do(http.MethodGet, key, query, nil, nil, 0, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Parts                []s3Part `xml:"Part"`
+			IsTruncated          bool     `xml:"IsTruncated"`
+			NextPartNumberMarker string   `xml:"NextPartNumberMarker"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode list parts response failed: %w", err)
+		}
+
+		parts = append(parts, result.Parts...)
+		if !result.IsTruncated || result.NextPartNumberMarker == "" {
+			return parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+func (s *S3Store) completeMultipartUpload(key, uploadID string, parts []s3Part) error {
+	type completePart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	body := struct {
+		XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+		Parts   []completePart `xml:"Part"`
+	}{}
+	for _, p := range parts {
+		body.Parts = append(body.Parts, completePart{PartNumber: p.PartNumber, ETag: p.ETag})
+	}
+
+	payload, err := xml.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("uploadId", uploadID)
+	resp, err := s.doBytes(http.MethodPost, key, query, nil, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// CompleteMultipartUpload 可能返回 200 但 body 中携带错误
+	return checkEmbeddedError(resp)
+}
+
+func (s *S3Store) abortMultipartUpload(key, uploadID string) error {
+	query := url.Values{}
+	query.Set("uploadId", uploadID)
+	resp, err := s.do(http.MethodDelete, key, query, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Store) copyObject(src, dst string, size int64) error {
+	source := "/" + s.cfg.Bucket + "/" + escapePath(src)
+
+	if size <= s3MaxCopySize {
+		headers := http.Header{}
+		headers.Set("x-amz-copy-source", source)
+		resp, err := s.do(http.MethodPut, dst, nil, headers, nil, 0, emptyPayloadHash)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		return checkEmbeddedError(resp)
+	}
+
+	// 大对象：UploadPartCopy 分段拷贝
+	uploadID, err := s.createMultipartUpload(dst)
+	if err != nil {
+		return err
+	}
+
+	var parts []s3Part
+	for partNumber, offset := 1, int64(0); offset < size; partNumber, offset = partNumber+1, offset+s3CopyPartSize {
+		end := offset + s3CopyPartSize - 1
+		if end >= size {
+			end = size - 1
+		}
+
+		query := url.Values{}
+		query.Set("partNumber", strconv.Itoa(partNumber))
+		query.Set("uploadId", uploadID)
+		headers := http.Header{}
+		headers.Set("x-amz-copy-source", source)
+		headers.Set("x-amz-copy-source-range", fmt.Sprintf("bytes=%d-%d", offset, end))
+
+		resp, err := s.do(http.MethodPut, dst, query, headers, nil, 0, emptyPayloadHash)
+		if err != nil {
+			_ = s.abortMultipartUpload(dst, uploadID)
+			return err
+		}
+		var result struct {
+			ETag string `xml:"ETag"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			_ = s.abortMultipartUpload(dst, uploadID)
+			return fmt.Errorf("decode upload part copy response failed: %w", err)
+		}
+		parts = append(parts, s3Part{PartNumber: partNumber, ETag: result.ETag})
+	}
+
+	if err := s.completeMultipartUpload(dst, uploadID, parts); err != nil {
+		_ = s.abortMultipartUpload(dst, uploadID)
+		return err
+	}
+	return nil
+}
+
+func (s *S3Store) headObject(key string) (int64, bool, error) {
+	resp, err := s.do(http.MethodHead, key, nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		if isS3NotFound(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, true, nil
+}
+
+func (s *S3Store) deleteObject(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Store) readSmallObject(key string) (string, error) {
+	resp, err := s.do(http.MethodGet, key, nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (s *S3Store) putSmallObject(key string, data []byte, ifNoneMatch bool) error {
+	headers := http.Header{}
+	if ifNoneMatch {
+		headers.Set("If-None-Match", "*")
+	}
+	resp, err := s.doBytes(http.MethodPut, key, nil, headers, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Store) doBytes(method, key string, query url.Values, headers http.Header, payload []byte) (*http.Response, error) {
+	sum := sha256.Sum256(payload)
+	return s.do(method, key, query, headers, bytes.NewReader(payload), int64(len(payload)), hex.EncodeToString(sum[:]))
+}
+
+// do 发送签名后的请求，非 2xx 响应转换为 *s3Error
+func (s *S3Store) do(method, key string, query url.Values, headers http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u := *s.endpoint
+	escapedKey := escapePath(key)
+	if s.cfg.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket + "/" + key
+		u.RawPath = strings.TrimSuffix(u.EscapedPath(), "/") + "/" + escapePath(s.cfg.Bucket) + "/" + escapedKey
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+		u.RawPath = strings.TrimSuffix(s.endpoint.EscapedPath(), "/") + "/" + escapedKey
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range headers {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	s.sign(req, payloadHash, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		se := &s3Error{StatusCode: resp.StatusCode}
+		if method != http.MethodHead {
+			data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+			_ = xml.Unmarshal(data, se)
+		}
+		return nil, se
+	}
+	return resp, nil
+}
+
+func checkEmbeddedError(resp *http.Response) error {
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		se := &s3Error{StatusCode: resp.StatusCode}
+		_ = xml.Unmarshal(data, se)
+		return se
+	}
+	return nil
+}
+
+// ======================== AWS Signature V4 ========================
+
+func (s *S3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	host := req.URL.Host
+	var names []string
+	values := map[string]string{"host": host}
+	names = append(names, "host")
+	for k, vs := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "content-md5" || lk == "content-type" || strings.HasPrefix(lk, "x-amz-") || lk == "range" || lk == "if-none-match" {
+			values[lk] = strings.TrimSpace(strings.Join(vs, ","))
+			names = append(names, lk)
+		}
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, n := range names {
+		canonicalHeaders.WriteString(n)
+		canonicalHeaders.WriteByte(':')
+		canonicalHeaders.WriteString(values[n])
+		canonicalHeaders.WriteByte('\n')
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crHash[:])
+
+	kDate := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), date)
+	kRegion := hmacSHA256(kDate, s.cfg.Region)
+	kService := hmacSHA256(kRegion, "s3")
+	kSigning := hmacSHA256(kService, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(kSigning, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// escapePath 按 SigV4 规则编码对象 key（保留 /）
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, seg := range segments {
+		segments[i] = uriEncode(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), query[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
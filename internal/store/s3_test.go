@@ -0,0 +1,543 @@
+package store
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const fakeBucket = "bucket"
+
+// fakeS3 内存中的 S3 替身，只实现 S3Store 用到的接口
+type fakeS3 struct {
+	mu         sync.Mutex
+	objects    map[string][]byte
+	uploads    map[string]*fakeMultipart
+	nextID     int
+	copies     int            // CopyObject 次数
+	partCopies int            // UploadPartCopy 次数
+	aborted    []string       // 被中止的 multipart upload 的 key
+	failGets   map[string]int // key 接下来若干次 GET 返回 500
+}
+
+type fakeMultipart struct {
+	key   string
+	parts map[int][]byte
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3Store) {
+	t.Helper()
+	f := &fakeS3{objects: make(map[string][]byte), uploads: make(map[string]*fakeMultipart), failGets: make(map[string]int)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	s, err := NewS3Store(S3Config{
+		Endpoint:  srv.URL,
+		Bucket:    fakeBucket,
+		AccessKey: "test",
+		SecretKey: "secret",
+		PathStyle: true,
+		TempPath:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+	return f, s
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		f.fail(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+fakeBucket), "/")
+	q := r.URL.Query()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && q.Get("list-type") == "2":
+		f.listObjects(w, q.Get("prefix"))
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		f.nextID++
+		id := "upload-" + strconv.Itoa(f.nextID)
+		f.uploads[id] = &fakeMultipart{key: key, parts: make(map[int][]byte)}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			UploadID string   `xml:"UploadId"`
+		}{UploadID: id})
+	case q.Has("uploadId"):
+		f.multipart(w, r, key, q)
+	case r.Method == http.MethodPut && r.Header.Get("x-amz-copy-source") != "":
+		src, ok := f.copySource(r)
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		f.copies++
+		f.objects[key] = append([]byte(nil), src...)
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CopyObjectResult"`
+		}{})
+	case r.Method == http.MethodPut:
+		if _, exists := f.objects[key]; exists && r.Header.Get("If-None-Match") == "*" {
+			f.fail(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		f.objects[key] = data
+	case r.Method == http.MethodGet && f.failGets[key] > 0:
+		f.failGets[key]--
+		f.fail(w, http.StatusInternalServerError, "InternalError")
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		status := http.StatusOK
+		if start, end, ok := parseRange(r.Header.Get("Range"), int64(len(data))); ok {
+			data = data[start : end+1]
+			status = http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// multipart UploadPart / UploadPartCopy / ListParts / Complete / Abort
+func (f *fakeS3) multipart(w http.ResponseWriter, r *http.Request, key string, q url.Values) {
+	id := q.Get("uploadId")
+	up, ok := f.uploads[id]
+	if !ok || up.key != key {
+		f.fail(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		n, err := strconv.Atoi(q.Get("partNumber"))
+		if err != nil || n < 1 {
+			f.fail(w, http.StatusBadRequest, "InvalidArgument")
+			return
+		}
+		if r.Header.Get("x-amz-copy-source") == "" {
+			data, _ := io.ReadAll(r.Body)
+			up.parts[n] = data
+			w.Header().Set("ETag", etag(data))
+			return
+		}
+		src, ok := f.copySource(r)
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		start, end, ok := parseRange(r.Header.Get("x-amz-copy-source-range"), int64(len(src)))
+		if !ok {
+			f.fail(w, http.StatusBadRequest, "InvalidRange")
+			return
+		}
+		f.partCopies++
+		up.parts[n] = append([]byte(nil), src[start:end+1]...)
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CopyPartResult"`
+			ETag    string   `xml:"ETag"`
+		}{ETag: etag(up.parts[n])})
+	case http.MethodGet:
+		type part struct {
+			PartNumber int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+			Size       int    `xml:"Size"`
+		}
+		result := struct {
+			XMLName xml.Name `xml:"ListPartsResult"`
+			Parts   []part   `xml:"Part"`
+		}{}
+		for _, n := range sortedParts(up.parts) {
+			result.Parts = append(result.Parts, part{PartNumber: n, ETag: etag(up.parts[n]), Size: len(up.parts[n])})
+		}
+		writeXML(w, result)
+	case http.MethodPost:
+		var body struct {
+			Parts []struct {
+				PartNumber int    `xml:"PartNumber"`
+				ETag       string `xml:"ETag"`
+			} `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&body); err != nil {
+			f.fail(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var data []byte
+		for _, p := range body.Parts {
+			part, ok := up.parts[p.PartNumber]
+			if !ok || etag(part) != p.ETag {
+				f.fail(w, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			data = append(data, part...)
+		}
+		f.objects[key] = data
+		delete(f.uploads, id)
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		}{})
+	case http.MethodDelete:
+		delete(f.uploads, id)
+		f.aborted = append(f.aborted, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeS3) copySource(r *http.Request) ([]byte, bool) {
+	src, err := url.PathUnescape(r.Header.Get("x-amz-copy-source"))
+	if err != nil {
+		return nil, false
+	}
+	data, ok := f.objects[strings.TrimPrefix(src, "/"+fakeBucket+"/")]
+	return data, ok
+}
+
+func (f *fakeS3) listObjects(w http.ResponseWriter, prefix string) {
+	type object struct {
+		Key          string    `xml:"Key"`
+		Size         int       `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	}
+	result := struct {
+		XMLName  xml.Name `xml:"ListBucketResult"`
+		Contents []object `xml:"Contents"`
+	}{}
+	for key, data := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, object{Key: key, Size: len(data), LastModified: time.Now()})
+		}
+	}
+	writeXML(w, result)
+}
+
+func (f *fakeS3) fail(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (f *fakeS3) object(key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[key]
+	return data, ok
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(v)
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func sortedParts(parts map[int][]byte) []int {
+	numbers := make([]int, 0, len(parts))
+	for n := range parts {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+// parseRange 解析 "bytes=start-end"（闭区间）
+func parseRange(header string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return 0, 0, false
+	}
+	a, b, ok := strings.Cut(spec, "-")
+	start, err1 := strconv.ParseInt(a, 10, 64)
+	end, err2 := strconv.ParseInt(b, 10, 64)
+	if !ok || err1 != nil || err2 != nil || start > end || end >= size {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// testHash 内容的文件 hash
+func testHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return FormatFileHash(HashSHA256, sum[:])
+}
+
+// uploadChunks 按 chunkSize 切分 data 并乱序上传
+func uploadChunks(t *testing.T, s *S3Store, userID int, hash string, data []byte, chunkSize int) int {
+	t.Helper()
+	var chunks [][]byte
+	for off := 0; off < len(data); off += chunkSize {
+		end := off + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunks = append(chunks, data[off:end])
+	}
+	for i := len(chunks) - 1; i >= 0; i-- {
+		sum := md5.Sum(chunks[i])
+		checksum, err := ParseChunkChecksum("md5:" + hex.EncodeToString(sum[:]))
+		if err != nil {
+			t.Fatalf("ParseChunkChecksum: %v", err)
+		}
+		if err := s.WriteChunk(userID, hash, i, bytes.NewReader(chunks[i]), checksum); err != nil {
+			t.Fatalf("WriteChunk %d: %v", i, err)
+		}
+	}
+	return len(chunks)
+}
+
+func TestS3WriteChunkAndMerge(t *testing.T) {
+	f, s := newFakeS3(t)
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	hash := testHash(data)
+
+	total := uploadChunks(t, s, 1, hash, data, 10)
+	chunks, err := s.GetUploadedChunks(1, hash)
+	if err != nil {
+		t.Fatalf("GetUploadedChunks: %v", err)
+	}
+	if fmt.Sprint(chunks) != "[0 1 2 3]" {
+		t.Fatalf("uploaded chunks = %v, want [0 1 2 3]", chunks)
+	}
+
+	// 分片内容与声明的摘要不符时不上传
+	bad, _ := ParseChunkChecksum("md5:" + strings.Repeat("0", 32))
+	if err := s.WriteChunk(1, hash, 0, strings.NewReader("tampered"), bad); !errors.Is(err, ErrChunkChecksum) {
+		t.Fatalf("WriteChunk with wrong checksum: err = %v, want ErrChunkChecksum", err)
+	}
+
+	path, size, err := s.MergeChunks(1, hash, total, int64(len(data)), "")
+	if err != nil {
+		t.Fatalf("MergeChunks: %v", err)
+	}
+	if want := "s3://" + fakeBucket + "/objects/" + hash; path != want || size != int64(len(data)) {
+		t.Fatalf("MergeChunks = %s, %d; want %s, %d", path, size, want, len(data))
+	}
+	if f.copies != 1 || f.partCopies != 0 {
+		t.Fatalf("copies = %d, part copies = %d; want a single CopyObject", f.copies, f.partCopies)
+	}
+	if _, ok := f.object(s.stagingKey(1, hash)); ok {
+		t.Fatal("staging object not deleted after merge")
+	}
+	for _, key := range []string{s.uploadIDKey(1, hash), s.partsKey(1, hash)} {
+		if _, ok := f.object(key); ok {
+			t.Fatalf("%s not deleted after merge", key)
+		}
+	}
+	if got, _ := f.object(s.objectKey(hash)); !bytes.Equal(got, data) {
+		t.Fatalf("stored object = %q, want %q", got, data)
+	}
+	if chunks, err := s.GetUploadedChunks(1, hash); err != nil || len(chunks) != 0 {
+		t.Fatalf("GetUploadedChunks after merge = %v, %v; want none", chunks, err)
+	}
+
+	rc, n, err := s.GetFile(hash)
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if n != int64(len(data)) || !bytes.Equal(got, data) {
+		t.Fatalf("GetFile = %q (%d), want %q", got, n, data)
+	}
+}
+
+func TestS3MergeLargeObjectUsesPartCopy(t *testing.T) {
+	maxCopy, partSize := s3MaxCopySize, s3CopyPartSize
+	s3MaxCopySize, s3CopyPartSize = 16, 7
+	t.Cleanup(func() { s3MaxCopySize, s3CopyPartSize = maxCopy, partSize })
+
+	f, s := newFakeS3(t)
+	data := []byte("a file larger than the single copy limit")
+	hash := testHash(data)
+
+	total := uploadChunks(t, s, 1, hash, data, 16)
+	if _, _, err := s.MergeChunks(1, hash, total, int64(len(data)), ""); err != nil {
+		t.Fatalf("MergeChunks: %v", err)
+	}
+	if want := (len(data) + 6) / 7; f.copies != 0 || f.partCopies != want {
+		t.Fatalf("copies = %d, part copies = %d; want 0, %d", f.copies, f.partCopies, want)
+	}
+	if got, _ := f.object(s.objectKey(hash)); !bytes.Equal(got, data) {
+		t.Fatalf("stored object = %q, want %q", got, data)
+	}
+	if len(f.uploads) != 0 {
+		t.Fatalf("%d multipart uploads left open", len(f.uploads))
+	}
+}
+
+func TestS3MergeVerifiesContent(t *testing.T) {
+	f, s := newFakeS3(t)
+	data := []byte("declared hash does not match")
+	hash := testHash([]byte("something else"))
+
+	total := uploadChunks(t, s, 1, hash, data, 8)
+	_, _, err := s.MergeChunks(1, hash, total, int64(len(data)), "")
+	var ie *IntegrityError
+	if !errors.As(err, &ie) || ie.Field != "hash" {
+		t.Fatalf("MergeChunks: err = %v, want hash IntegrityError", err)
+	}
+	if _, ok := f.object(s.objectKey(hash)); ok {
+		t.Fatal("object stored despite failed verification")
+	}
+	for _, key := range []string{s.stagingKey(1, hash), s.partsKey(1, hash), s.uploadIDKey(1, hash)} {
+		if _, ok := f.object(key); ok {
+			t.Fatalf("%s not deleted after failed verification", key)
+		}
+	}
+}
+
+func TestS3MergeResumesAfterReadError(t *testing.T) {
+	f, s := newFakeS3(t)
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	hash := testHash(data)
+	total := uploadChunks(t, s, 1, hash, data, 10)
+
+	// 校验时读取合并结果失败：上传中的对象与记录保留
+	f.failGets[s.stagingKey(1, hash)] = 1
+	if _, _, err := s.MergeChunks(1, hash, total, int64(len(data)), ""); err == nil || errors.Is(err, ErrIntegrity) {
+		t.Fatalf("MergeChunks: err = %v, want read error", err)
+	}
+	if _, ok := f.object(s.stagingKey(1, hash)); !ok {
+		t.Fatal("staging object deleted after read error")
+	}
+	if len(f.uploads) != 0 {
+		t.Fatalf("%d multipart uploads open, want the upload completed", len(f.uploads))
+	}
+
+	// multipart upload 已完成，仍报告全部分片
+	chunks, err := s.GetUploadedChunks(1, hash)
+	if err != nil || fmt.Sprint(chunks) != "[0 1 2 3]" {
+		t.Fatalf("GetUploadedChunks = %v, %v; want [0 1 2 3]", chunks, err)
+	}
+
+	path, size, err := s.MergeChunks(1, hash, total, int64(len(data)), "")
+	if err != nil {
+		t.Fatalf("retry MergeChunks: %v", err)
+	}
+	if want := "s3://" + fakeBucket + "/" + s.objectKey(hash); path != want || size != int64(len(data)) {
+		t.Fatalf("MergeChunks = %s, %d; want %s, %d", path, size, want, len(data))
+	}
+	if got, _ := f.object(s.objectKey(hash)); !bytes.Equal(got, data) {
+		t.Fatalf("stored object = %q, want %q", got, data)
+	}
+	for _, key := range []string{s.stagingKey(1, hash), s.partsKey(1, hash), s.uploadIDKey(1, hash)} {
+		if _, ok := f.object(key); ok {
+			t.Fatalf("%s not deleted after merge", key)
+		}
+	}
+}
+
+func TestS3MergeStoresCollisionUnderStrongHash(t *testing.T) {
+	f, s := newFakeS3(t)
+	data := []byte("same md5, different content")
+	sum := md5.Sum(data)
+	hash := FormatFileHash(HashMD5, sum[:])
+	existing := testHash([]byte("the file already stored under this md5"))
+
+	total := uploadChunks(t, s, 1, hash, data, 8)
+	_, _, err := s.MergeChunks(1, hash, total, int64(len(data)), existing)
+	var collision *CollisionError
+	if !errors.As(err, &collision) || !errors.Is(err, ErrHashCollision) {
+		t.Fatalf("MergeChunks: err = %v, want *CollisionError", err)
+	}
+	strong := testHash(data)
+	if collision.FileHash != strong || collision.FileSize != int64(len(data)) {
+		t.Fatalf("collision = %+v, want %s (%d bytes)", collision, strong, len(data))
+	}
+	if _, ok := f.object(s.objectKey(hash)); ok {
+		t.Fatal("colliding content stored under the md5 hash")
+	}
+	if got, _ := f.object(s.objectKey(strong)); !bytes.Equal(got, data) {
+		t.Fatalf("object under strong hash = %q, want %q", got, data)
+	}
+}
+
+func TestS3GetFileRange(t *testing.T) {
+	f, s := newFakeS3(t)
+	data := []byte("0123456789")
+	hash := testHash(data)
+	f.objects[s.objectKey(hash)] = data
+
+	for _, tc := range []struct {
+		start, end int64
+		want       string
+	}{
+		{0, 0, "0"},
+		{2, 5, "2345"},
+		{7, 9, "789"},
+	} {
+		rc, err := s.GetFileRange(hash, tc.start, tc.end)
+		if err != nil {
+			t.Fatalf("GetFileRange(%d, %d): %v", tc.start, tc.end, err)
+		}
+		got, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(got) != tc.want {
+			t.Fatalf("GetFileRange(%d, %d) = %q, want %q", tc.start, tc.end, got, tc.want)
+		}
+	}
+
+	if _, err := s.GetFileRange(testHash([]byte("missing")), 0, 1); !isS3NotFound(err) {
+		t.Fatalf("GetFileRange of missing object: err = %v, want not found", err)
+	}
+}
+
+func TestS3CleanupChunksAbortsUpload(t *testing.T) {
+	f, s := newFakeS3(t)
+	data := []byte("upload that gets cancelled")
+	hash := testHash(data)
+
+	uploadChunks(t, s, 7, hash, data, 10)
+	if len(f.uploads) != 1 {
+		t.Fatalf("%d multipart uploads, want 1", len(f.uploads))
+	}
+
+	if err := s.CleanupChunks(7, hash); err != nil {
+		t.Fatalf("CleanupChunks: %v", err)
+	}
+	if len(f.uploads) != 0 || len(f.aborted) != 1 || f.aborted[0] != s.stagingKey(7, hash) {
+		t.Fatalf("uploads = %d, aborted = %v; want the staging upload aborted", len(f.uploads), f.aborted)
+	}
+	if _, ok := f.object(s.uploadIDKey(7, hash)); ok {
+		t.Fatal("upload id record not deleted")
+	}
+	if chunks, err := s.GetUploadedChunks(7, hash); err != nil || len(chunks) != 0 {
+		t.Fatalf("GetUploadedChunks after cleanup = %v, %v; want none", chunks, err)
+	}
+
+	// 之后重新上传会创建新的 multipart upload
+	total := uploadChunks(t, s, 7, hash, data, 10)
+	if _, _, err := s.MergeChunks(7, hash, total, int64(len(data)), ""); err != nil {
+		t.Fatalf("MergeChunks after cleanup: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+// 副本分段校验和：多磁盘存储在每块磁盘上为文件保存 <文件>.sum，记录每 sumSegmentSize 字节
+// 存储内容的 CRC-32C。读取时逐段校验，损坏的段不会交给调用方，而是换下一个副本重读该段。
+// 校验和只在内容刚通过整文件校验时生成（合并后复制副本、副本修复），只描述正确的内容，
+// 因此同一文件各磁盘上的 .sum 可以互相替代。升级前存储的文件没有 .sum，读取时不分段校验，
+// 执行副本修复后补齐。
+//
+// 格式："VPS1" | 段长 uint32 | 文件长度 uint64 | 每段 CRC-32C uint32 ... | 以上内容的 CRC-32C uint32
+
+// sumSegmentSize 分段长度（测试中调小）
+var sumSegmentSize int64 = 1 << 20
+
+const sumMagic = "VPS1"
+
+var (
+	errBadSums = errors.New("invalid segment checksum file")
+
+	crc32c = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// segmentSums 一个文件的分段校验和
+type segmentSums struct {
+	segment int64
+	size    int64
+	sums    []uint32
+}
+
+// segmentLen 第 index 段的长度，最后一段可能较短
+func (s *segmentSums) segmentLen(index int64) int64 {
+	n := s.size - index*s.segment
+	if n > s.segment {
+		n = s.segment
+	}
+	return n
+}
+
+// verify 校验第 index 段的内容
+func (s *segmentSums) verify(index int64, data []byte) bool {
+	return index < int64(len(s.sums)) && crc32.Checksum(data, crc32c) == s.sums[index]
+}
+
+func (s *segmentSums) marshal() []byte {
+	buf := make([]byte, 0, 16+4*len(s.sums)+4)
+	buf = append(buf, sumMagic...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(s.segment))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(s.size))
+	for _, sum := range s.sums {
+		buf = binary.BigEndian.AppendUint32(buf, sum)
+	}
+	return binary.BigEndian.AppendUint32(buf, crc32.Checksum(buf, crc32c))
+}
+
+func parseSegmentSums(data []byte) (*segmentSums, error) {
+	if len(data) < 20 || string(data[:4]) != sumMagic || (len(data)-20)%4 != 0 {
+		return nil, errBadSums
+	}
+	body, tail := data[:len(data)-4], data[len(data)-4:]
+	if crc32.Checksum(body, crc32c) != binary.BigEndian.Uint32(tail) {
+		return nil, errBadSums
+	}
+	s := &segmentSums{
+		segment: int64(binary.BigEndian.Uint32(body[4:8])),
+		size:    int64(binary.BigEndian.Uint64(body[8:16])),
+	}
+	for p := body[16:]; len(p) > 0; p = p[4:] {
+		s.sums = append(s.sums, binary.BigEndian.Uint32(p))
+	}
+	if s.segment <= 0 || s.size < 0 || int64(len(s.sums)) != (s.size+s.segment-1)/s.segment {
+		return nil, errBadSums
+	}
+	return s, nil
+}
+
+// sumWriter 写入存储内容的同时计算分段校验和
+type sumWriter struct {
+	segment int64
+	size    int64
+	cur     uint32 // 当前未写满的段
+	sums    []uint32
+}
+
+func newSumWriter() *sumWriter {
+	return &sumWriter{segment: sumSegmentSize}
+}
+
+func (w *sumWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		room := w.segment - w.size%w.segment
+		chunk := p
+		if int64(len(chunk)) > room {
+			chunk = chunk[:room]
+		}
+		w.cur = crc32.Update(w.cur, crc32c, chunk)
+		w.size += int64(len(chunk))
+		p = p[len(chunk):]
+		if w.size%w.segment == 0 {
+			w.sums = append(w.sums, w.cur)
+			w.cur = 0
+		}
+	}
+	return n, nil
+}
+
+// result 写入结束后的校验和
+func (w *sumWriter) result() *segmentSums {
+	sums := w.sums
+	if w.size%w.segment != 0 {
+		sums = append(sums, w.cur)
+	}
+	return &segmentSums{segment: w.segment, size: w.size, sums: sums}
+}
+
+// sumPaths 校验和文件依次尝试的位置，与 filePaths 对应（当前布局在前）
+func (s *LocalStore) sumPaths(hash string) []string {
+	paths := s.filePaths(hash)
+	for i := range paths {
+		paths[i] += ".sum"
+	}
+	return paths
+}
+
+// writeSums 在当前布局下写入文件的分段校验和，并删除旧位置上的
+func (s *LocalStore) writeSums(hash string, sums *segmentSums) error {
+	path, err := s.prepareFilePath(hash)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path+".sum", sums.marshal()); err != nil {
+		return fmt.Errorf("write segment checksums failed: %w", err)
+	}
+	if legacy := s.getLegacyPath(hash); legacy != "" {
+		os.Remove(legacy + ".sum")
+	}
+	return nil
+}
+
+// readSums 读取文件的分段校验和，不存在或已损坏时返回错误
+func (s *LocalStore) readSums(hash string) (*segmentSums, error) {
+	var lastErr error
+	for _, path := range s.sumPaths(hash) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return parseSegmentSums(data)
+	}
+	return nil, lastErr
+}
+
+// removeSums 删除文件的分段校验和（含旧位置上的）
+func (s *LocalStore) removeSums(hash string) error {
+	for _, path := range s.sumPaths(hash) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,19 +1,77 @@
 package store
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
+var (
+	// ErrIntegrity 合并后的内容与客户端声明的 hash / 大小不一致
+	ErrIntegrity = errors.New("merged content does not match declared file")
+
+	// ErrHashCollision 内容与声明的 md5 一致，但 SHA-256 与已存储的同 hash 文件不同
+	ErrHashCollision = errors.New("content collides with a stored file of the same md5")
+)
+
+// CollisionError md5 碰撞时合并仍然完成：内容改存在自己的强摘要（"sha256:<hex>"）下，
+// 已有的同 md5 文件不受影响。errors.Is(err, ErrHashCollision) 为真
+type CollisionError struct {
+	FileHash string // 内容实际存放的标识
+	FilePath string
+	FileSize int64
+}
+
+func (e *CollisionError) Error() string {
+	return fmt.Sprintf("md5 collision, stored as %s", e.FileHash)
+}
+
+func (e *CollisionError) Is(target error) bool {
+	return target == ErrHashCollision
+}
+
+// collisionHash 合并校验只有强摘要不符（md5 碰撞）时返回内容的强摘要
+func collisionHash(err error) string {
+	var ie *IntegrityError
+	if errors.As(err, &ie) && ie.Field == HashSHA256 {
+		return ie.Actual
+	}
+	return ""
+}
+
+// IntegrityError 合并校验失败详情，errors.Is(err, ErrIntegrity) 为真；
+// 强摘要不符时 errors.Is(err, ErrHashCollision) 也为真
+type IntegrityError struct {
+	Field    string // "hash"、"size" 或 "sha256"
+	Expected string
+	Actual   string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("%s mismatch: expected %s, got %s", e.Field, e.Expected, e.Actual)
+}
+
+func (e *IntegrityError) Is(target error) bool {
+	return target == ErrIntegrity || (target == ErrHashCollision && e.Field == HashSHA256)
+}
+
 // Uploader 定义文件存储接口
 type Uploader interface {
-	WriteChunk(userID int, hash string, index int, content io.Reader) error
-	MergeChunks(userID int, hash string, totalChunks int) (filePath string, fileSize int64, err error)
+	// WriteChunk 写入分片，checksum 非空时边写边校验，不一致时丢弃分片并返回 *ChecksumError
+	WriteChunk(userID int, hash string, index int, content io.Reader, checksum *ChunkChecksum) error
+	// MergeChunks 合并分片，边拼接边计算摘要，与 hash / expectedSize 不符时
+	// 丢弃输出并返回 *IntegrityError。strongHash 非空时（md5 文件）还要求内容的 SHA-256 与之一致：
+	// 不一致是 MD5 碰撞，内容改存在自己的强摘要下并返回 *CollisionError，不会替换已有文件
+	MergeChunks(userID int, hash string, totalChunks int, expectedSize int64, strongHash string) (filePath string, fileSize int64, err error)
 	GetUploadedChunks(userID int, hash string) ([]int, error)
 	CleanupChunks(userID int, hash string) error
 	GetFile(hash string) (io.ReadCloser, int64, error)
@@ -22,10 +80,125 @@ type Uploader interface {
 	FileExists(hash string) bool
 }
 
+// Config 存储后端配置
+type Config struct {
+	Backend  string // local（默认）或 s3
+	BasePath string
+	TempPath string
+	S3       S3Config
+
+	// Preallocate 本地存储在上传会话建立时预分配目标文件，分片按偏移直接写入
+	Preallocate bool
+
+	// Layout 本地存储的目录布局，如 "2/2"，见 ParseShardLayout
+	Layout string
+
+	// Disks 非空时本地存储使用多磁盘模式（忽略 BasePath），见 MultiDiskStore
+	Disks     []string
+	Replicas  int    // 副本数，0 为默认值
+	Placement string // 放置策略：hash（默认）或 capacity
+
+	// EncryptionKeys 非空时开启静态加密，格式见 ParseKeyRing。开启后预分配不生效。
+	EncryptionKeys string
+
+	// Dedup 开启块级去重，见 DedupStore；DedupBlockSize 为平均块大小，0 为默认值
+	Dedup          bool
+	DedupBlockSize int
+}
+
+// New 根据配置创建存储后端
+func New(cfg Config) (Uploader, error) {
+	s, err := newBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Dedup {
+		return newDedupStore(cfg, s)
+	}
+	if cfg.EncryptionKeys == "" {
+		return s, nil
+	}
+	ring, err := ParseKeyRing(cfg.EncryptionKeys)
+	if err != nil {
+		return nil, fmt.Errorf("parse encryption keys failed: %w", err)
+	}
+	return NewEncryptedStore(s, ring)
+}
+
+// newDedupStore 块存放在与文件同类的独立存储中：本地为 <目录>/.blocks（默认按 2/2 分层），
+// S3 为 <S3_PREFIX>blocks/
+func newDedupStore(cfg Config, inner Uploader) (Uploader, error) {
+	if cfg.EncryptionKeys != "" {
+		return nil, errors.New("block deduplication cannot be combined with storage encryption")
+	}
+	blockCfg := cfg
+	blockCfg.BasePath = filepath.Join(cfg.BasePath, ".blocks")
+	blockCfg.TempPath = filepath.Join(cfg.TempPath, "blocks")
+	blockCfg.Preallocate = false
+	if blockCfg.Layout == "" {
+		blockCfg.Layout = "2/2"
+	}
+	blockCfg.Disks = nil
+	for _, d := range cfg.Disks {
+		blockCfg.Disks = append(blockCfg.Disks, filepath.Join(d, ".blocks"))
+	}
+	blockCfg.S3.Prefix = cfg.S3.Prefix
+	if blockCfg.S3.Prefix != "" && !strings.HasSuffix(blockCfg.S3.Prefix, "/") {
+		blockCfg.S3.Prefix += "/"
+	}
+	blockCfg.S3.Prefix += "blocks/"
+	blocks, err := newBackend(blockCfg)
+	if err != nil {
+		return nil, fmt.Errorf("create block storage failed: %w", err)
+	}
+
+	blockSize := cfg.DedupBlockSize
+	if blockSize == 0 {
+		blockSize = DefaultDedupBlockSize
+	}
+	return NewDedupStore(inner, blocks, blockSize)
+}
+
+func newBackend(cfg Config) (Uploader, error) {
+	switch cfg.Backend {
+	case "", "local":
+		layout, err := ParseShardLayout(cfg.Layout)
+		if err != nil {
+			return nil, err
+		}
+		if len(cfg.Disks) > 0 {
+			return NewMultiDiskStore(cfg.Disks, cfg.TempPath, cfg.Replicas, cfg.Placement, layout)
+		}
+		s := NewLocalStore(cfg.BasePath, cfg.TempPath)
+		s.PreallocateUploads = cfg.Preallocate
+		s.ShardLayout = layout
+		return s, nil
+	case "s3":
+		s3cfg := cfg.S3
+		if s3cfg.TempPath == "" {
+			s3cfg.TempPath = cfg.TempPath
+		}
+		return NewS3Store(s3cfg)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.Backend)
+	}
+}
+
 // LocalStore 本地文件系统实现
 type LocalStore struct {
 	BasePath string
 	TempPath string
+
+	// PreallocateUploads 开启预分配模式，见 Preallocate
+	PreallocateUploads bool
+
+	// ShardLayout 分层目录布局（每层目录名的字符数），为空时平铺，见 ParseShardLayout
+	ShardLayout []int
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex // "<user>/<hash>" -> 预分配上传的位图锁
+
+	newCheck contentChecker // 合并时的内容校验，nil 时直接比较 MD5
 }
 
 // NewLocalStore 创建本地存储
@@ -36,6 +209,7 @@ func NewLocalStore(basePath, tempPath string) *LocalStore {
 	return &LocalStore{
 		BasePath: basePath,
 		TempPath: tempPath,
+		locks:    make(map[string]*sync.Mutex),
 	}
 }
 
@@ -47,26 +221,42 @@ func (s *LocalStore) getChunkPath(userID int, hash string, index int) string {
 	return filepath.Join(s.getChunkDir(userID, hash), fmt.Sprintf("%d.part", index))
 }
 
-func (s *LocalStore) getFilePath(hash string) string {
-	return filepath.Join(s.BasePath, hash)
+// getChunkSumPath 分片摘要文件，存在即表示分片已完整写入并通过校验
+func (s *LocalStore) getChunkSumPath(userID int, hash string, index int) string {
+	return s.getChunkPath(userID, hash, index) + ".sum"
 }
 
-// WriteChunk 写入分片
-func (s *LocalStore) WriteChunk(userID int, hash string, index int, content io.Reader) error {
+// WriteChunk 写入分片，摘要写在 .part.sum 中
+func (s *LocalStore) WriteChunk(userID int, hash string, index int, content io.Reader, checksum *ChunkChecksum) error {
+	layout, err := s.loadLayout(userID, hash)
+	if err != nil {
+		return err
+	}
+	if layout != nil {
+		return s.writeChunkAt(userID, hash, index, layout, content, checksum)
+	}
+
 	chunkDir := s.getChunkDir(userID, hash)
 	if err := os.MkdirAll(chunkDir, 0755); err != nil {
 		return fmt.Errorf("create chunk dir failed: %w", err)
 	}
 
 	chunkPath := s.getChunkPath(userID, hash, index)
+	sumPath := s.getChunkSumPath(userID, hash, index)
 	tmpPath := chunkPath + ".tmp"
 
+	// 重写分片期间不再报告为已上传
+	if err := os.Remove(sumPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove chunk checksum failed: %w", err)
+	}
+
 	f, err := os.Create(tmpPath)
 	if err != nil {
 		return fmt.Errorf("create chunk file failed: %w", err)
 	}
 
-	written, err := io.Copy(f, content)
+	algorithm, digest := chunkDigest(checksum)
+	written, err := io.Copy(io.MultiWriter(f, digest), content)
 	if err != nil {
 		f.Close()
 		os.Remove(tmpPath)
@@ -79,16 +269,34 @@ func (s *LocalStore) WriteChunk(userID int, hash string, index int, content io.R
 		return fmt.Errorf("empty chunk data")
 	}
 
+	if err := verifyChunk(index, checksum, digest); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
 	if err := os.Rename(tmpPath, chunkPath); err != nil {
 		os.Remove(tmpPath)
 		return fmt.Errorf("rename chunk failed: %w", err)
 	}
 
+	sum := algorithm + ":" + hex.EncodeToString(digest.Sum(nil)) + "\n"
+	if err := writeFileAtomic(sumPath, []byte(sum)); err != nil {
+		return fmt.Errorf("write chunk checksum failed: %w", err)
+	}
+
 	return nil
 }
 
-// GetUploadedChunks 获取已上传的分片索引
+// GetUploadedChunks 获取已上传的分片索引，只报告有摘要文件（已通过校验）的分片
 func (s *LocalStore) GetUploadedChunks(userID int, hash string) ([]int, error) {
+	layout, err := s.loadLayout(userID, hash)
+	if err != nil {
+		return nil, err
+	}
+	if layout != nil {
+		return s.uploadedFromBitmap(userID, hash, layout)
+	}
+
 	chunkDir := s.getChunkDir(userID, hash)
 
 	entries, err := os.ReadDir(chunkDir)
@@ -99,13 +307,16 @@ func (s *LocalStore) GetUploadedChunks(userID int, hash string) ([]int, error) {
 		return nil, err
 	}
 
-	var chunks []int
+	parts := make(map[string]bool)
 	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
+		if !entry.IsDir() {
+			parts[entry.Name()] = true
 		}
-		name := entry.Name()
-		if strings.HasSuffix(name, ".part") {
+	}
+
+	var chunks []int
+	for name := range parts {
+		if strings.HasSuffix(name, ".part") && parts[name+".sum"] {
 			indexStr := strings.TrimSuffix(name, ".part")
 			if index, err := strconv.Atoi(indexStr); err == nil {
 				chunks = append(chunks, index)
@@ -118,12 +329,23 @@ func (s *LocalStore) GetUploadedChunks(userID int, hash string) ([]int, error) {
 }
 
 // MergeChunks 合并分片
-func (s *LocalStore) MergeChunks(userID int, hash string, totalChunks int) (string, int64, error) {
+func (s *LocalStore) MergeChunks(userID int, hash string, totalChunks int, expectedSize int64, strongHash string) (string, int64, error) {
 	if err := os.MkdirAll(s.BasePath, 0755); err != nil {
 		return "", 0, fmt.Errorf("create base dir failed: %w", err)
 	}
 
-	destPath := s.getFilePath(hash)
+	layout, err := s.loadLayout(userID, hash)
+	if err != nil {
+		return "", 0, err
+	}
+	if layout != nil {
+		return s.mergePreallocated(userID, hash, totalChunks, expectedSize, strongHash, layout)
+	}
+
+	destPath, err := s.prepareFilePath(hash)
+	if err != nil {
+		return "", 0, err
+	}
 	tmpDest := destPath + ".tmp"
 
 	out, err := os.Create(tmpDest)
@@ -132,6 +354,8 @@ func (s *LocalStore) MergeChunks(userID int, hash string, totalChunks int) (stri
 	}
 
 	var totalSize int64
+	check := s.contentCheck(hash, expectedSize, strongHash)
+	w := io.MultiWriter(out, check)
 
 	for i := 0; i < totalChunks; i++ {
 		chunkPath := s.getChunkPath(userID, hash, i)
@@ -143,7 +367,7 @@ func (s *LocalStore) MergeChunks(userID int, hash string, totalChunks int) (stri
 			return "", 0, fmt.Errorf("open chunk %d failed: %w", i, err)
 		}
 
-		written, err := io.Copy(out, pf)
+		written, err := io.Copy(w, pf)
 		pf.Close()
 
 		if err != nil {
@@ -160,10 +384,20 @@ func (s *LocalStore) MergeChunks(userID int, hash string, totalChunks int) (stri
 		return "", 0, fmt.Errorf("close dest file failed: %w", err)
 	}
 
+	// 校验失败时丢弃临时输出，不影响已存在的同 hash 文件
+	if err := check.Verify(totalSize); err != nil {
+		if strong := collisionHash(err); strong != "" {
+			return s.storeCollided(userID, hash, tmpDest, strong, totalSize)
+		}
+		os.Remove(tmpDest)
+		return "", 0, err
+	}
+
 	if err := os.Rename(tmpDest, destPath); err != nil {
 		os.Remove(tmpDest)
 		return "", 0, fmt.Errorf("rename to dest failed: %w", err)
 	}
+	s.removeLegacyCopy(hash)
 
 	// 清理分片
 	s.CleanupChunks(userID, hash)
@@ -171,23 +405,39 @@ func (s *LocalStore) MergeChunks(userID int, hash string, totalChunks int) (stri
 	return destPath, totalSize, nil
 }
 
-// CleanupChunks 清理分片临时文件
+// storeCollided 把 md5 碰撞的合并结果 src 改名为强摘要 strong 下的文件，并清理分片
+func (s *LocalStore) storeCollided(userID int, hash, src, strong string, size int64) (string, int64, error) {
+	destPath, err := s.prepareFilePath(strong)
+	if err != nil {
+		os.Remove(src)
+		return "", 0, err
+	}
+	if err := os.Rename(src, destPath); err != nil {
+		os.Remove(src)
+		return "", 0, fmt.Errorf("rename to dest failed: %w", err)
+	}
+	s.removeLegacyCopy(strong)
+	s.CleanupChunks(userID, hash)
+	return "", 0, &CollisionError{FileHash: strong, FilePath: destPath, FileSize: size}
+}
+
+// CleanupChunks 清理分片临时文件（含预分配的数据文件）
 func (s *LocalStore) CleanupChunks(userID int, hash string) error {
+	s.removePartial(userID, hash)
 	chunkDir := s.getChunkDir(userID, hash)
 	return os.RemoveAll(chunkDir)
 }
 
 // GetFile 获取文件
 func (s *LocalStore) GetFile(hash string) (io.ReadCloser, int64, error) {
-	filePath := s.getFilePath(hash)
-
-	fi, err := os.Stat(filePath)
+	f, err := s.openFile(hash)
 	if err != nil {
 		return nil, 0, err
 	}
 
-	f, err := os.Open(filePath)
+	fi, err := f.Stat()
 	if err != nil {
+		f.Close()
 		return nil, 0, err
 	}
 
@@ -196,9 +446,7 @@ func (s *LocalStore) GetFile(hash string) (io.ReadCloser, int64, error) {
 
 // GetFileRange 获取文件指定范围
 func (s *LocalStore) GetFileRange(hash string, start, end int64) (io.ReadCloser, error) {
-	filePath := s.getFilePath(hash)
-
-	f, err := os.Open(filePath)
+	f, err := s.openFile(hash)
 	if err != nil {
 		return nil, err
 	}
@@ -215,20 +463,118 @@ func (s *LocalStore) GetFileRange(hash string, start, end int64) (io.ReadCloser,
 	}, nil
 }
 
-// DeleteFile 删除文件
+// DeleteFile 删除文件（含旧布局位置上的副本）
 func (s *LocalStore) DeleteFile(hash string) error {
-	filePath := s.getFilePath(hash)
-	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
-		return err
+	for _, filePath := range s.filePaths(hash) {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
 	return nil
 }
 
 // FileExists 检查文件是否存在
 func (s *LocalStore) FileExists(hash string) bool {
-	filePath := s.getFilePath(hash)
-	_, err := os.Stat(filePath)
-	return err == nil
+	for _, filePath := range s.filePaths(hash) {
+		if _, err := os.Stat(filePath); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// contentCheck 合并时校验写入的内容：合并结果依次写入，最后调用 Verify
+type contentCheck interface {
+	io.Writer
+	Verify(written int64) error
+}
+
+// contentChecker 按 hash、声明大小与强摘要创建校验器。存储内容不是原文时（如加密存储）
+// 由包装方替换，见 setContentChecker
+type contentChecker func(fileHash string, expectedSize int64, strongHash string) contentCheck
+
+// plainCheck 存储内容即原文：比较摘要与大小
+type plainCheck struct {
+	*contentDigest
+	expectedSize int64
+}
+
+func (c *plainCheck) Verify(written int64) error {
+	return c.verify(c.expectedSize, written)
+}
+
+func newPlainCheck(fileHash string, expectedSize int64, strongHash string) contentCheck {
+	return &plainCheck{contentDigest: newContentDigest(fileHash, strongHash), expectedSize: expectedSize}
+}
+
+func (s *LocalStore) contentCheck(fileHash string, expectedSize int64, strongHash string) contentCheck {
+	if s.newCheck != nil {
+		return s.newCheck(fileHash, expectedSize, strongHash)
+	}
+	return newPlainCheck(fileHash, expectedSize, strongHash)
+}
+
+func (s *LocalStore) setContentChecker(c contentChecker) {
+	s.newCheck = c
+}
+
+// contentDigest 计算与文件 hash 算法对应的摘要，声明了强摘要时同时计算 SHA-256
+type contentDigest struct {
+	fileHash   string
+	strongHash string
+	digest     hash.Hash
+	strong     hash.Hash
+}
+
+func newContentDigest(fileHash, strongHash string) *contentDigest {
+	d := &contentDigest{fileHash: fileHash, strongHash: strongHash, digest: NewFileDigest(fileHash)}
+	if strongHash != "" && strongHash != fileHash {
+		d.strong = sha256.New()
+	}
+	return d
+}
+
+func (d *contentDigest) Write(p []byte) (int, error) {
+	d.digest.Write(p)
+	if d.strong != nil {
+		d.strong.Write(p)
+	}
+	return len(p), nil
+}
+
+func (d *contentDigest) verify(expectedSize, actualSize int64) error {
+	if err := verifyContent(d.fileHash, expectedSize, d.digest, actualSize); err != nil {
+		return err
+	}
+	if d.strong != nil {
+		return verifyStrongHash(d.strongHash, d.strong)
+	}
+	return nil
+}
+
+// verifyContent 比较实际写入的大小和摘要与声明值
+func verifyContent(fileHash string, expectedSize int64, digest hash.Hash, actualSize int64) error {
+	if expectedSize > 0 && actualSize != expectedSize {
+		return &IntegrityError{
+			Field:    "size",
+			Expected: fmt.Sprintf("%d", expectedSize),
+			Actual:   fmt.Sprintf("%d", actualSize),
+		}
+	}
+	actual := hex.EncodeToString(digest.Sum(nil))
+	if !strings.EqualFold(actual, fileHashDigest(fileHash)) {
+		return &IntegrityError{Field: "hash", Expected: fileHash, Actual: FormatFileHash(FileHashAlgorithm(fileHash), digest.Sum(nil))}
+	}
+	return nil
+}
+
+// verifyStrongHash 比较内容的 SHA-256 与记录的强摘要（"sha256:<hex>"）
+func verifyStrongHash(strongHash string, digest hash.Hash) error {
+	actual := FormatFileHash(HashSHA256, digest.Sum(nil))
+	if !strings.EqualFold(actual, strongHash) {
+		return &IntegrityError{Field: HashSHA256, Expected: strongHash, Actual: actual}
+	}
+	return nil
 }
 
 type limitedReadCloser struct {
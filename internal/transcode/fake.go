@@ -0,0 +1,67 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// fakeSteps 假执行器汇报进度的次数
+const fakeSteps = 4
+
+// FakeExecutor 确定性的假执行器，用于测试与无 ffmpeg 的开发环境。
+// 输出为 "fake-transcode:<preset>\n" 加上源文件内容，同一输入与规格总是得到相同的输出。
+type FakeExecutor struct {
+	StepDelay time.Duration // 每次进度之间的等待
+	Err       error         // 非 nil 时在汇报一半进度后返回该错误
+}
+
+func (e *FakeExecutor) Name() string { return "fake" }
+
+// Transcode 按固定步数汇报进度并写出输出
+func (e *FakeExecutor) Transcode(ctx context.Context, req Request, progress ProgressFunc) error {
+	for i := 0; i < fakeSteps; i++ {
+		if progress != nil {
+			progress(i * 100 / fakeSteps)
+		}
+		if e.Err != nil && i == fakeSteps/2 {
+			return e.Err
+		}
+		if e.StepDelay > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(e.StepDelay):
+			}
+		}
+	}
+
+	in, err := os.Open(req.Input)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(req.Output)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(out, "fake-transcode:%s\n", req.Preset.Name); err != nil {
+		out.Close()
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if progress != nil {
+		progress(100)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package transcode
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// stderrTail 失败时保留的 ffmpeg 错误输出长度
+const stderrTail = 2048
+
+// FFmpegExecutor 调用本机 ffmpeg 输出 H.264/AAC MP4
+type FFmpegExecutor struct {
+	Path string
+}
+
+func (e *FFmpegExecutor) Name() string { return "ffmpeg" }
+
+// Transcode 执行转码，通过 -progress 输出换算进度
+func (e *FFmpegExecutor) Transcode(ctx context.Context, req Request, progress ProgressFunc) error {
+	p := req.Preset
+	args := []string{
+		"-hide_banner", "-nostdin", "-y",
+		"-loglevel", "error",
+		"-i", req.Input,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-vf", fmt.Sprintf("scale=-2:%d", p.Height),
+		"-c:v", "libx264", "-preset", "veryfast", "-profile:v", "high",
+		"-b:v", fmt.Sprintf("%dk", p.VideoBitrate),
+		"-maxrate", fmt.Sprintf("%dk", p.VideoBitrate*3/2),
+		"-bufsize", fmt.Sprintf("%dk", p.VideoBitrate*2),
+		"-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", p.AudioBitrate),
+		"-movflags", "+faststart",
+		"-progress", "pipe:1", "-nostats",
+		"-f", "mp4", req.Output,
+	}
+
+	cmd := exec.CommandContext(ctx, e.Path, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start ffmpeg failed: %w", err)
+	}
+
+	// -progress 输出 key=value 行，out_time_us 为已编码时长（微秒）
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok || progress == nil {
+			continue
+		}
+		switch key {
+		case "out_time_us", "out_time_ms": // 老版本 ffmpeg 的 out_time_ms 实际也是微秒
+			us, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || req.Duration <= 0 {
+				continue
+			}
+			percent := int(float64(us) / 1e6 / req.Duration * 100)
+			if percent > 99 {
+				percent = 99
+			}
+			if percent >= 0 {
+				progress(percent)
+			}
+		case "progress":
+			if value == "end" {
+				progress(100)
+			}
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		msg := stderr.String()
+		if len(msg) > stderrTail {
+			msg = msg[len(msg)-stderrTail:]
+		}
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// Package transcode 定义转码执行器接口与输出规格。
+//
+// 执行器只负责把本地源文件编码为本地输出文件并汇报进度；任务调度、重试与
+// 产物入库由 logic 层完成。
+package transcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+)
+
+var (
+	ErrUnknownExecutor = errors.New("unknown transcode executor")
+	ErrFFmpegNotFound  = errors.New("ffmpeg not found")
+)
+
+// Preset 输出规格
+type Preset struct {
+	Name         string
+	Height       int // 输出高度，宽度按比例缩放
+	VideoBitrate int // kbps
+	AudioBitrate int // kbps
+}
+
+var presets = map[string]Preset{
+	"1080p": {Name: "1080p", Height: 1080, VideoBitrate: 5000, AudioBitrate: 192},
+	"720p":  {Name: "720p", Height: 720, VideoBitrate: 2800, AudioBitrate: 128},
+	"480p":  {Name: "480p", Height: 480, VideoBitrate: 1400, AudioBitrate: 128},
+	"360p":  {Name: "360p", Height: 360, VideoBitrate: 800, AudioBitrate: 96},
+}
+
+// LookupPreset 按名字查找输出规格
+func LookupPreset(name string) (Preset, bool) {
+	p, ok := presets[name]
+	return p, ok
+}
+
+// PresetNames 所有输出规格名，按分辨率从高到低
+func PresetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return presets[names[i]].Height > presets[names[j]].Height
+	})
+	return names
+}
+
+// Request 一次转码请求
+type Request struct {
+	Input    string // 本地源文件路径
+	Output   string // 本地输出路径（MP4）
+	Preset   Preset
+	Duration float64 // 源文件时长（秒），用于换算进度，未知时为 0
+}
+
+// ProgressFunc 进度回调，percent 取值 0-100
+type ProgressFunc func(percent int)
+
+// Executor 转码执行器
+type Executor interface {
+	Name() string
+	Transcode(ctx context.Context, req Request, progress ProgressFunc) error
+}
+
+// NewExecutor 按名字创建执行器：auto（有 ffmpeg 时启用）/ ffmpeg / fake / off。
+// 返回 nil 表示不启用转码。
+func NewExecutor(kind, ffmpegPath string) (Executor, error) {
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
+	}
+	switch kind {
+	case "", "auto":
+		path, err := exec.LookPath(ffmpegPath)
+		if err != nil {
+			return nil, nil
+		}
+		return &FFmpegExecutor{Path: path}, nil
+	case "ffmpeg":
+		path, err := exec.LookPath(ffmpegPath)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFFmpegNotFound, err)
+		}
+		return &FFmpegExecutor{Path: path}, nil
+	case "fake":
+		return &FakeExecutor{}, nil
+	case "off", "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownExecutor, kind)
+	}
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrSignatureInvalid = errors.New("invalid playback signature")
+	ErrSignatureExpired = errors.New("playback url expired")
+)
+
+// 播放签名的查询参数
+const (
+	PlaybackUserParam    = "uid"
+	PlaybackExpiresParam = "exp"
+	PlaybackSigParam     = "sig"
+)
+
+// playbackKey 由 JwtSecret 派生，避免与 JWT 共用同一把密钥
+func playbackKey() []byte {
+	mac := hmac.New(sha256.New, JwtSecret)
+	mac.Write([]byte("playback-url-v1"))
+	return mac.Sum(nil)
+}
+
+func playbackSignature(userID int, fileHash string, expires int64) string {
+	mac := hmac.New(sha256.New, playbackKey())
+	fmt.Fprintf(mac, "v1\n%d\n%s\n%d", userID, fileHash, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignPlayback 生成限定文件、用户与过期时间的签名查询参数
+func SignPlayback(userID int, fileHash string, expiresAt time.Time) url.Values {
+	exp := expiresAt.Unix()
+	q := url.Values{}
+	q.Set(PlaybackUserParam, strconv.Itoa(userID))
+	q.Set(PlaybackExpiresParam, strconv.FormatInt(exp, 10))
+	q.Set(PlaybackSigParam, playbackSignature(userID, fileHash, exp))
+	return q
+}
+
+// VerifyPlayback 校验签名查询参数，成功时返回签名中的用户 ID
+func VerifyPlayback(q url.Values, fileHash string) (int, error) {
+	userID, err := strconv.Atoi(q.Get(PlaybackUserParam))
+	if err != nil || userID <= 0 {
+		return 0, ErrSignatureInvalid
+	}
+	exp, err := strconv.ParseInt(q.Get(PlaybackExpiresParam), 10, 64)
+	if err != nil {
+		return 0, ErrSignatureInvalid
+	}
+
+	expected := playbackSignature(userID, fileHash, exp)
+	if !hmac.Equal([]byte(expected), []byte(q.Get(PlaybackSigParam))) {
+		return 0, ErrSignatureInvalid
+	}
+	if time.Now().Unix() > exp {
+		return 0, ErrSignatureExpired
+	}
+	return userID, nil
+}
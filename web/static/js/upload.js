@@ -42,35 +42,35 @@ async function handleFileSelect() {
     document.getElementById('fileInfo').style.display = 'block';
     document.getElementById('fileName').textContent = selectedFile.name;
     document.getElementById('fileSize').textContent = formatSize(selectedFile.size);
-    document.getElementById('fileMD5').textContent = '计算中...';
+    document.getElementById('fileDigest').textContent = '计算中...';
     document.getElementById('uploadBtn').style.display = 'none';
 
-    // 计算 MD5
+    // 计算 SHA-256
     try {
-        fileHash = await calculateMD5(selectedFile);
-        document.getElementById('fileMD5').textContent = fileHash;
+        fileHash = await calculateFileHash(selectedFile);
+        document.getElementById('fileDigest').textContent = fileHash;
         document.getElementById('uploadBtn').style.display = 'block';
     } catch (err) {
-        document.getElementById('fileMD5').textContent = '计算失败: ' + err.message;
+        document.getElementById('fileDigest').textContent = '计算失败: ' + err.message;
     }
 }
 
-function calculateMD5(file) {
+function calculateFileHash(file) {
     return new Promise((resolve, reject) => {
         const chunkSize = 2 * 1024 * 1024;
         const chunks = Math.ceil(file.size / chunkSize);
-        const spark = new SparkMD5.ArrayBuffer();
+        const digest = new Sha256();
         const reader = new FileReader();
         let currentChunk = 0;
 
         reader.onload = function (e) {
-            spark.append(e.target.result);
+            digest.append(e.target.result);
             currentChunk++;
 
             if (currentChunk < chunks) {
                 loadNext();
             } else {
-                resolve(spark.end());
+                resolve('sha256:' + digest.end());
             }
         };
 
@@ -127,8 +127,19 @@ async function startUpload() {
             return;
         }
 
-        // 2. 上传分片
-        const totalChunks = Math.ceil(selectedFile.size / CHUNK_SIZE);
+        // 秒传挑战：证明本地确实持有该文件
+        if (initData.status === 'fast_upload_challenge' && initData.challenge) {
+            progressText.textContent = '秒传校验中...';
+            if (await answerChallenge(initData.challenge)) {
+                showResult(true, '秒传成功！');
+                return;
+            }
+        }
+
+        // 2. 上传分片（分片大小与总数以服务端会话为准）
+        const sessionID = initData.session_id;
+        const chunkSize = initData.chunk_size || CHUNK_SIZE;
+        const totalChunks = initData.total_chunks || Math.ceil(selectedFile.size / chunkSize);
         const uploadedSet = new Set(uploadedChunks);
         let completed = uploadedChunks.length;
 
@@ -145,25 +156,28 @@ async function startUpload() {
         }
 
         const uploadChunk = async (index) => {
-            const start = index * CHUNK_SIZE;
-            const end = Math.min(start + CHUNK_SIZE, selectedFile.size);
+            const start = index * chunkSize;
+            const end = Math.min(start + chunkSize, selectedFile.size);
             const chunk = selectedFile.slice(start, end);
+            const chunkMD5 = SparkMD5.ArrayBuffer.hash(await chunk.arrayBuffer());
+
+            // 分片以原始请求体上传，服务端边写边校验；校验失败（传输中损坏）时重传
+            for (let attempt = 1; ; attempt++) {
+                const resp = await authFetch(`/api/v1/upload/chunk/${index}`, {
+                    method: 'PUT',
+                    headers: {
+                        'Content-Type': 'application/octet-stream',
+                        'X-Upload-Session': sessionID,
+                        'X-Chunk-Checksum': `md5:${chunkMD5}`
+                    },
+                    body: chunk
+                });
+                if (resp.ok) break;
 
-            const formData = new FormData();
-            formData.append('content_id', contentID);
-            formData.append('file_hash', fileHash);
-            formData.append('chunk_index', index);
-            formData.append('total_chunks', totalChunks);
-            formData.append('chunk', chunk, `chunk_${index}`);
-
-            const resp = await authFetch('/api/v1/upload/chunk', {
-                method: 'POST',
-                body: formData
-            });
-
-            if (!resp.ok) {
                 const data = await resp.json();
-                throw new Error(data.error || `分片 ${index} 上传失败`);
+                if (!data.retryable || attempt >= 3) {
+                    throw new Error(data.error || `分片 ${index} 上传失败`);
+                }
             }
 
             completed++;
@@ -194,6 +208,7 @@ async function startUpload() {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify({
+                session_id: sessionID,
                 content_id: contentID,
                 file_hash: fileHash,
                 file_name: selectedFile.name,
@@ -214,6 +229,27 @@ async function startUpload() {
     }
 }
 
+async function answerChallenge(challenge) {
+    const proofs = [];
+    for (const r of challenge.ranges) {
+        const buf = await selectedFile.slice(r.start, r.end + 1).arrayBuffer();
+        proofs.push(SparkMD5.ArrayBuffer.hash(buf));
+    }
+
+    const resp = await authFetch('/api/v1/upload/fast', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+            content_id: contentID,
+            file_name: selectedFile.name,
+            file_hash: fileHash,
+            challenge_id: challenge.challenge_id,
+            proofs: proofs
+        })
+    });
+    return resp.ok;
+}
+
 function showResult(success, message) {
     const resultDiv = document.getElementById('uploadResult');
     resultDiv.style.display = 'block';